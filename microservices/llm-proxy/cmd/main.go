@@ -20,15 +20,24 @@ func main() {
 	zerolog.TimeFieldFormat = time.RFC3339Nano
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid configuration")
+	}
 
 	log.Info().
 		Str("version", version.Version()).
 		Str("port", cfg.Port).
 		Str("env", cfg.Env).
+		Str("provider", cfg.LLMProvider).
+		Str("auth", cfg.Auth.Mode).
 		Msg("starting llm-proxy")
 
-	srv := httpserver.New(cfg)
+	srv, err := httpserver.New(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to initialise server")
+	}
+	defer srv.Close()
 
 	server := &http.Server{
 		Addr:              ":" + cfg.Port,
@@ -0,0 +1,127 @@
+// Package auth authenticates the services calling the proxy. LLM_PROXY_AUTH
+// selects how:
+//
+// "token" accepts a caller's shared secret as "Authorization: Bearer
+// <secret>".
+//
+// "hmac" accepts requests signed with a caller's shared secret:
+//
+//	X-Proxy-Caller:    orchestrator
+//	X-Proxy-Timestamp: 1760000000 (Unix seconds)
+//	X-Proxy-Nonce:     a random string, unique per request
+//	X-Proxy-Signature: hex(HMAC-SHA256(secret, METHOD "\n" PATH "\n" TIMESTAMP "\n" NONCE "\n" hex(SHA256(body))))
+//
+// PATH includes the query string. Requests outside the timestamp window or
+// repeating a nonce are rejected.
+//
+// "jwt" accepts Keycloak client-credentials access tokens as bearer tokens;
+// the caller is the client the token was issued to.
+//
+// A caller may have several secrets at once so they can be rotated without
+// downtime.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/llm-proxy/internal/config"
+)
+
+// Anonymous is the caller of requests served without authentication.
+const Anonymous = "anonymous"
+
+var errMissingCredentials = errors.New("missing credentials")
+
+// Authenticator identifies the caller of a request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (caller string, err error)
+}
+
+// New returns the authenticator for cfg.Mode, or nil when authentication is
+// disabled. Close it with Close.
+func New(ctx context.Context, cfg config.AuthConfig) (Authenticator, error) {
+	switch cfg.Mode {
+	case "", config.AuthNone:
+		return nil, nil
+	case config.AuthToken:
+		return tokenAuth(cfg.Secrets), nil
+	case config.AuthHMAC:
+		return newHMACAuth(cfg.Secrets, cfg.HMACWindow), nil
+	case config.AuthJWT:
+		return newJWTAuth(ctx, cfg.Keycloak, cfg.AllowedClients), nil
+	default:
+		return nil, errors.New("unknown authentication mode " + cfg.Mode)
+	}
+}
+
+// Close releases the background resources of a, if any.
+func Close(a Authenticator) {
+	if closer, ok := a.(interface{ close() }); ok {
+		closer.close()
+	}
+}
+
+type callerKey struct{}
+
+// Caller returns the authenticated caller of the request with ctx.
+func Caller(ctx context.Context) string {
+	if caller, ok := ctx.Value(callerKey{}).(string); ok {
+		return caller
+	}
+	return Anonymous
+}
+
+// Middleware rejects requests a does not authenticate and records the
+// caller of the others for Caller.
+func Middleware(a Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, err := a.Authenticate(r)
+			if err != nil {
+				log.Warn().Err(err).Str("path", r.URL.Path).Str("remote", r.RemoteAddr).Msg("rejected unauthenticated request")
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+		})
+	}
+}
+
+func bearerToken(r *http.Request) (string, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", errMissingCredentials
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// tokenAuth accepts any of a caller's secrets as a bearer token.
+type tokenAuth map[string][]string
+
+func (a tokenAuth) Authenticate(r *http.Request) (string, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return "", err
+	}
+	// Every secret is compared so the time taken does not reveal which
+	// caller came close.
+	found := ""
+	for caller, secrets := range a {
+		for _, secret := range secrets {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+				found = caller
+			}
+		}
+	}
+	if found == "" {
+		return "", errors.New("unknown token")
+	}
+	return found, nil
+}
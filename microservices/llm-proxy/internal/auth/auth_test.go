@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shopmindai/llm-proxy/internal/config"
+)
+
+func TestTokenAuth(t *testing.T) {
+	a := tokenAuth{"orchestrator": {"old", "new"}, "chat-service": {"chat"}}
+	for token, want := range map[string]string{"old": "orchestrator", "new": "orchestrator", "chat": "chat-service"} {
+		req := httptest.NewRequest("POST", "/v1/chat/stream", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		caller, err := a.Authenticate(req)
+		require.NoError(t, err)
+		assert.Equal(t, want, caller)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/chat/stream", nil)
+	_, err := a.Authenticate(req)
+	assert.ErrorIs(t, err, errMissingCredentials)
+	req.Header.Set("Authorization", "Bearer olde")
+	_, err = a.Authenticate(req)
+	assert.Error(t, err)
+}
+
+func signedRequest(secret, caller string, at time.Time, nonce, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/v1/chat/stream?trace=1", strings.NewReader(body))
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	req.Header.Set(HeaderCaller, caller)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, hex.EncodeToString(Signature(secret, "POST", "/v1/chat/stream?trace=1", timestamp, nonce, []byte(body))))
+	return req
+}
+
+func TestHMACAuth(t *testing.T) {
+	now := time.Unix(1760000000, 0)
+	a := newHMACAuth(map[string][]string{"orchestrator": {"old", "new"}}, 5*time.Minute)
+	a.now = func() time.Time { return now }
+
+	req := signedRequest("new", "orchestrator", now.Add(-time.Minute), "n1", `{"messages":[]}`)
+	caller, err := a.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "orchestrator", caller)
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, `{"messages":[]}`, string(body), "the body is still readable after verification")
+
+	_, err = a.Authenticate(signedRequest("new", "orchestrator", now.Add(-time.Minute), "n1", `{"messages":[]}`))
+	assert.ErrorContains(t, err, "replayed nonce")
+
+	_, err = a.Authenticate(signedRequest("old", "orchestrator", now, "n2", "{}"))
+	assert.NoError(t, err, "rotated-out secrets stay valid while configured")
+
+	_, err = a.Authenticate(signedRequest("new", "orchestrator", now.Add(-10*time.Minute), "n3", "{}"))
+	assert.ErrorContains(t, err, "outside the allowed window")
+
+	_, err = a.Authenticate(signedRequest("new", "chat-service", now, "n4", "{}"))
+	assert.ErrorContains(t, err, "unknown caller")
+
+	tampered := signedRequest("new", "orchestrator", now, "n5", `{"model":"gpt-4o-mini"}`)
+	tampered.Body = io.NopCloser(strings.NewReader(`{"model":"gpt-4o"}`))
+	_, err = a.Authenticate(tampered)
+	assert.ErrorContains(t, err, "invalid signature")
+
+	// A nonce is forgotten once its timestamp leaves the window.
+	now = now.Add(20 * time.Minute)
+	_, err = a.Authenticate(signedRequest("new", "orchestrator", now, "n1", "{}"))
+	assert.NoError(t, err)
+	assert.Len(t, a.nonces, 1)
+}
+
+func TestJWTAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding.EncodeToString
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig", "n": enc(key.N.Bytes()), "e": enc(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer jwks.Close()
+
+	const issuer = "http://keycloak.test/realms/ShopMindAI"
+	a, err := New(context.Background(), config.AuthConfig{
+		Mode:           config.AuthJWT,
+		Keycloak:       config.KeycloakConfig{Issuer: issuer, JWKSURL: jwks.URL, Audience: "llm-proxy"},
+		AllowedClients: []string{"orchestrator"},
+	})
+	require.NoError(t, err)
+	defer Close(a)
+
+	sign := func(claims map[string]any) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(input))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+		return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	claims := func(azp, aud string, exp time.Time) map[string]any {
+		return map[string]any{"iss": issuer, "sub": "service-account", "azp": azp, "aud": aud, "exp": exp.Unix()}
+	}
+	authenticate := func(token string) (string, error) {
+		req := httptest.NewRequest("POST", "/v1/chat/stream", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return a.Authenticate(req)
+	}
+
+	caller, err := authenticate(sign(claims("orchestrator", "llm-proxy", time.Now().Add(time.Minute))))
+	require.NoError(t, err)
+	assert.Equal(t, "orchestrator", caller)
+
+	_, err = authenticate(sign(claims("storefront", "llm-proxy", time.Now().Add(time.Minute))))
+	assert.ErrorContains(t, err, `client "storefront" is not allowed`)
+	_, err = authenticate(sign(claims("orchestrator", "account", time.Now().Add(time.Minute))))
+	assert.ErrorContains(t, err, "audience")
+	_, err = authenticate(sign(claims("orchestrator", "llm-proxy", time.Now().Add(-time.Hour))))
+	assert.ErrorContains(t, err, "expired")
+	_, err = authenticate("not.a.token")
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Signed request headers; see the package documentation.
+const (
+	HeaderCaller    = "X-Proxy-Caller"
+	HeaderTimestamp = "X-Proxy-Timestamp"
+	HeaderNonce     = "X-Proxy-Nonce"
+	HeaderSignature = "X-Proxy-Signature"
+)
+
+// maxSignedBody bounds the body read to check a signature.
+const maxSignedBody = 8 << 20
+
+// hmacAuth checks signed requests. Nonces are remembered until their
+// timestamp leaves the window, after which the timestamp alone rejects a
+// replay.
+type hmacAuth struct {
+	secrets map[string][]string
+	window  time.Duration
+	now     func() time.Time
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // caller + nonce -> expiry
+	pruned time.Time
+}
+
+func newHMACAuth(secrets map[string][]string, window time.Duration) *hmacAuth {
+	return &hmacAuth{
+		secrets: secrets,
+		window:  window,
+		now:     time.Now,
+		nonces:  make(map[string]time.Time),
+	}
+}
+
+func (a *hmacAuth) Authenticate(r *http.Request) (string, error) {
+	caller := r.Header.Get(HeaderCaller)
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	signature, err := hex.DecodeString(r.Header.Get(HeaderSignature))
+	if caller == "" || timestamp == "" || nonce == "" || err != nil || len(signature) == 0 {
+		return "", errMissingCredentials
+	}
+	secrets, ok := a.secrets[caller]
+	if !ok {
+		return "", fmt.Errorf("unknown caller %q", caller)
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", errors.New("malformed timestamp")
+	}
+	signedAt := time.Unix(unix, 0)
+	now := a.now()
+	if signedAt.Before(now.Add(-a.window)) || signedAt.After(now.Add(a.window)) {
+		return "", errors.New("timestamp outside the allowed window")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	if err != nil {
+		return "", fmt.Errorf("read body: %w", err)
+	}
+	if len(body) > maxSignedBody {
+		return "", errors.New("signed body too large")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	valid := false
+	for _, secret := range secrets {
+		if hmac.Equal(signature, Signature(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return "", errors.New("invalid signature")
+	}
+	// Only correctly signed nonces are remembered, so forged requests
+	// cannot fill the cache.
+	if !a.remember(caller+"\n"+nonce, signedAt.Add(a.window), now) {
+		return "", errors.New("replayed nonce")
+	}
+	return caller, nil
+}
+
+// remember records a nonce until expiry and reports whether it is new.
+func (a *hmacAuth) remember(key string, expiry, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Sub(a.pruned) > a.window {
+		for k, exp := range a.nonces {
+			if now.After(exp) {
+				delete(a.nonces, k)
+			}
+		}
+		a.pruned = now
+	}
+	if exp, seen := a.nonces[key]; seen && !now.After(exp) {
+		return false
+	}
+	a.nonces[key] = expiry
+	return true
+}
+
+// Signature is the HMAC of a request as sent in HeaderSignature.
+func Signature(secret, method, requestURI, timestamp, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", method, requestURI, timestamp, nonce, hex.EncodeToString(bodyHash[:]))
+	return mac.Sum(nil)
+}
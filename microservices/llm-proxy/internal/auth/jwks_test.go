@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The key set and signature checks are copied in the orchestrator's auth
+// package; the tests there pin the same behaviour, case for case.
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": enc(key.N.Bytes()), "e": enc(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	return map[string]string{"kty": "EC", "kid": kid, "use": "sig", "crv": "P-256", "x": enc(key.X.FillBytes(make([]byte, 32))), "y": enc(key.Y.FillBytes(make([]byte, 32)))}
+}
+
+func serveJWKS(t *testing.T, keys ...map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestKeySetKeepsOnlyUsableSigningKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	noUse := ecJWK("no-use", ecKey)
+	noUse["use"] = ""
+	encryption := rsaJWK("enc", rsaKey)
+	encryption["use"] = "enc"
+	symmetric := map[string]string{"kty": "oct", "kid": "oct", "k": "c2VjcmV0"}
+	p384 := ecJWK("p384", ecKey)
+	p384["crv"] = "P-384"
+	offCurve := ecJWK("off-curve", ecKey)
+	offCurve["y"] = offCurve["x"]
+	weakExponent := rsaJWK("weak", rsaKey)
+	weakExponent["e"] = "AQ"
+
+	jwks := serveJWKS(t, rsaJWK("rsa", rsaKey), ecJWK("ec", ecKey), noUse, encryption, symmetric, p384, offCurve, weakExponent)
+	ks := newKeySet(context.Background(), jwks.URL, jwks.Client(), 0)
+	t.Cleanup(ks.close)
+
+	for kid, want := range map[string]crypto.PublicKey{"rsa": rsaKey.Public(), "ec": ecKey.Public(), "no-use": ecKey.Public()} {
+		key, ok := ks.lookup(kid)
+		require.True(t, ok, kid)
+		assert.True(t, key.(interface{ Equal(crypto.PublicKey) bool }).Equal(want), kid)
+	}
+	for _, kid := range []string{"enc", "oct", "p384", "off-curve", "weak"} {
+		_, ok := ks.lookup(kid)
+		assert.False(t, ok, "key %q should have been skipped", kid)
+	}
+	// Tokens without a kid only match a single-key set.
+	_, ok := ks.lookup("")
+	assert.False(t, ok)
+
+	single := serveJWKS(t, rsaJWK("rsa", rsaKey))
+	ks = newKeySet(context.Background(), single.URL, single.Client(), 0)
+	t.Cleanup(ks.close)
+	key, ok := ks.lookup("")
+	require.True(t, ok)
+	assert.True(t, rsaKey.PublicKey.Equal(key))
+
+	unusable := serveJWKS(t, encryption, symmetric)
+	err = (&keySet{url: unusable.URL, client: unusable.Client()}).refresh(context.Background())
+	assert.ErrorContains(t, err, "no usable signing keys")
+}
+
+func TestKeySetRefreshesOnUnknownKeyIDs(t *testing.T) {
+	first, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	var fetches atomic.Int32
+	var keys atomic.Value
+	keys.Store([]map[string]string{rsaJWK("k1", first)})
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys.Load()})
+	}))
+	t.Cleanup(jwks.Close)
+	ks := newKeySet(context.Background(), jwks.URL, jwks.Client(), 0)
+	t.Cleanup(ks.close)
+	ctx := context.Background()
+
+	// A rotated key is fetched on demand once the last attempt is old enough.
+	keys.Store([]map[string]string{rsaJWK("k1", first), rsaJWK("k2", rotated)})
+	ks.lastAttempt = time.Now().Add(-minRefreshInterval)
+	_, err = ks.key(ctx, "k2")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, fetches.Load())
+
+	// Made-up kids do not trigger another fetch within the interval.
+	_, err = ks.key(ctx, "made-up")
+	assert.ErrorIs(t, err, errUnknownKey)
+	assert.EqualValues(t, 2, fetches.Load())
+	ks.lastAttempt = time.Now().Add(-minRefreshInterval)
+	_, err = ks.key(ctx, "made-up")
+	assert.ErrorIs(t, err, errUnknownKey)
+	assert.EqualValues(t, 3, fetches.Load())
+}
+
+func TestVerifySignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte("header.payload"))
+	rsaSignature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	require.NoError(t, err)
+	ecSignature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	tampered := append([]byte(nil), ecSignature...)
+	tampered[0] ^= 1
+
+	for _, tc := range []struct {
+		name      string
+		alg       string
+		key       crypto.PublicKey
+		signature []byte
+		wantErr   string
+	}{
+		{"rs256", "RS256", rsaKey.Public(), rsaSignature, ""},
+		{"es256", "ES256", ecKey.Public(), ecSignature, ""},
+		{"rs256 with an ec key", "RS256", ecKey.Public(), rsaSignature, "key type does not match RS256"},
+		{"es256 with an rsa key", "ES256", rsaKey.Public(), ecSignature, "key type does not match ES256"},
+		{"bad rs256 signature", "RS256", rsaKey.Public(), rsaSignature[1:], "invalid token signature"},
+		{"tampered es256 signature", "ES256", ecKey.Public(), tampered, "invalid token signature"},
+		{"asn.1 es256 signature", "ES256", ecKey.Public(), append(ecSignature, 0), "invalid token signature"},
+	} {
+		err := verifySignature(tc.alg, tc.key, digest[:], tc.signature)
+		if tc.wantErr == "" {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.EqualError(t, err, tc.wantErr, tc.name)
+		}
+	}
+}
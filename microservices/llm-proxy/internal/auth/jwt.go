@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shopmindai/llm-proxy/internal/config"
+)
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	ExpiresAt       *int64   `json:"exp"`
+	NotBefore       *int64   `json:"nbf"`
+}
+
+// audience accepts both forms of the aud claim: a string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = many
+	return nil
+}
+
+// jwtAuth checks RS256/ES256 client-credential access tokens issued by
+// Keycloak. The caller is the client the token was issued to (azp).
+type jwtAuth struct {
+	keys           *keySet
+	issuer         string
+	audience       string
+	allowedClients []string
+	clockSkew      time.Duration
+	now            func() time.Time
+}
+
+func newJWTAuth(ctx context.Context, keycloak config.KeycloakConfig, allowedClients []string) *jwtAuth {
+	return &jwtAuth{
+		keys:           newKeySet(ctx, keycloak.JWKSURL, &http.Client{Timeout: 5 * time.Second}, keycloak.JWKSRefresh),
+		issuer:         keycloak.Issuer,
+		audience:       keycloak.Audience,
+		allowedClients: allowedClients,
+		clockSkew:      keycloak.ClockSkew,
+		now:            time.Now,
+	}
+}
+
+func (a *jwtAuth) close() {
+	a.keys.close()
+}
+
+func (a *jwtAuth) Authenticate(r *http.Request) (string, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return "", err
+	}
+	claims, err := a.verify(r.Context(), token)
+	if err != nil {
+		return "", err
+	}
+	return claims.AuthorizedParty, nil
+}
+
+func (a *jwtAuth) verify(ctx context.Context, token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decode header: %w", err)
+	}
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode signature: %w", err)
+	}
+	key, err := a.keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(header.Alg, key, digest[:], signature); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decode claims: %w", err)
+	}
+	if err := a.validate(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (a *jwtAuth) validate(claims *jwtClaims) error {
+	now := a.now()
+	if claims.ExpiresAt == nil {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(*claims.ExpiresAt, 0).Add(a.clockSkew)) {
+		return errors.New("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(a.clockSkew).Before(time.Unix(*claims.NotBefore, 0)) {
+		return errors.New("token not yet valid")
+	}
+	if a.issuer != "" && strings.TrimRight(claims.Issuer, "/") != strings.TrimRight(a.issuer, "/") {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if a.audience != "" && !slices.Contains(claims.Audience, a.audience) {
+		return fmt.Errorf("token not issued for audience %q", a.audience)
+	}
+	if claims.AuthorizedParty == "" {
+		return errors.New("token names no client")
+	}
+	if len(a.allowedClients) > 0 && !slices.Contains(a.allowedClients, claims.AuthorizedParty) {
+		return fmt.Errorf("client %q is not allowed", claims.AuthorizedParty)
+	}
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, digest, signature []byte) error {
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match RS256")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest, signature); err != nil {
+			return errors.New("invalid token signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match ES256")
+		}
+		// JWS encodes ECDSA signatures as the fixed-size concatenation r||s.
+		if len(signature) != 64 {
+			return errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -1,35 +1,251 @@
 package config
 
-import "os"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopmindai/llm-proxy/internal/llm"
+)
 
 type Config struct {
-	Port           string
-	Env            string
-	AllowedOrigins string
-	
+	Port string
+	Env  string
+	// AllowedOrigins enables CORS for the listed browser origins. The proxy
+	// is called by other services, so it is empty by default.
+	AllowedOrigins []string
+
+	// Auth decides how callers authenticate.
+	Auth AuthConfig
+
 	// LLM API Configuration
-	LLMProvider    string // "openai", "anthropic", "ollama", etc.
-	LLMAPIKey      string // API key for the LLM provider
-	LLMBaseURL     string // Base URL for the LLM API
-	LLMModel       string // Model name (e.g., "gpt-4", "claude-3")
-	LLMMaxTokens   string // Maximum tokens to generate
-	LLMTemperature string // Temperature for generation
+	LLMProvider string // "openai", "anthropic", "ollama" or "gemini"
+	LLMAPIKey   string // API key for the LLM provider
+	LLMBaseURL  string // Base URL for the LLM API; empty uses the provider's default
+	LLMModel    string // Model name (e.g., "gpt-4", "claude-3")
+	// LLMEmbeddingModel serves embedding requests that do not name a model.
+	LLMEmbeddingModel string
+
+	// LLMDefaults are the sampling settings used when neither the request
+	// nor the model's entry in the routing table sets them.
+	LLMDefaults llm.GenerationParams
+	// LLMLimits bound the settings a request may ask for.
+	LLMLimits llm.Limits
+
+	// LLMAllowedModels lists the models callers may request per call. The
+	// default model is always allowed.
+	LLMAllowedModels []string
+	// LLMRoutesFile points to the JSON routing table of model aliases and
+	// provider fallbacks; see package routing.
+	LLMRoutesFile string
+	// LLMContextWindow is the context window, in tokens, assumed for models
+	// that neither the routing table nor the proxy knows.
+	LLMContextWindow int
+
+	// Stream tunes the SSE streams served to callers.
+	Stream StreamConfig
+}
+
+// StreamConfig keeps streams alive through proxies and cuts off stalled
+// ones. Zero disables either.
+type StreamConfig struct {
+	// Heartbeat is the interval of SSE comment frames sent while streaming,
+	// so intermediaries do not time out a silent connection.
+	Heartbeat time.Duration
+	// StallTimeout aborts a stream with an error event when the provider
+	// produces no output for this long.
+	StallTimeout time.Duration
 }
 
-func Load() Config {
-	return Config{
+// Authentication modes.
+const (
+	AuthNone  = "none"
+	AuthToken = "token"
+	AuthHMAC  = "hmac"
+	AuthJWT   = "jwt"
+)
+
+type AuthConfig struct {
+	// Mode is AuthToken, AuthHMAC, AuthJWT or AuthNone. The zero value
+	// disables authentication; Load defaults to AuthToken.
+	Mode string
+	// Secrets maps each calling service to its accepted shared secrets,
+	// used as bearer tokens or HMAC keys. Several secrets per caller allow
+	// rotating them without downtime.
+	Secrets map[string][]string
+	// HMACWindow is how far a signed request's timestamp may be from now.
+	// Nonces are remembered for as long, so a request cannot be replayed.
+	HMACWindow time.Duration
+	// Keycloak verifies client-credential access tokens in AuthJWT mode.
+	Keycloak KeycloakConfig
+	// AllowedClients restricts AuthJWT to these Keycloak client IDs; empty
+	// accepts any client whose token passes verification.
+	AllowedClients []string
+}
+
+type KeycloakConfig struct {
+	URL      string
+	Realm    string
+	Issuer   string
+	JWKSURL  string
+	Audience string // required aud entry, if set
+	// ClockSkew is tolerated when checking exp and nbf.
+	ClockSkew time.Duration
+	// JWKSRefresh is how often the signing keys are refetched.
+	JWKSRefresh time.Duration
+}
+
+// Load reads the configuration from the environment. Malformed or
+// out-of-range generation settings are reported as an error.
+func Load() (Config, error) {
+	cfg := Config{
 		Port:           getenv("APP_PORT", "9000"),
 		Env:            getenv("APP_ENV", "dev"),
-		AllowedOrigins: getenv("ALLOWED_ORIGINS", "*"),
-		
+		AllowedOrigins: splitList(getenv("ALLOWED_ORIGINS", "")),
+
 		// LLM Configuration
-		LLMProvider:    getenv("LLM_PROVIDER", "openai"),
-		LLMAPIKey:      getenv("LLM_API_KEY", ""),
-		LLMBaseURL:     getenv("LLM_BASE_URL", "https://api.openai.com/v1"),
-		LLMModel:       getenv("LLM_MODEL", "gpt-3.5-turbo"),
-		LLMMaxTokens:   getenv("LLM_MAX_TOKENS", "1000"),
-		LLMTemperature: getenv("LLM_TEMPERATURE", "0.7"),
+		LLMProvider: getenv("LLM_PROVIDER", "openai"),
+		LLMAPIKey:   getenv("LLM_API_KEY", ""),
+		LLMBaseURL:  getenv("LLM_BASE_URL", ""),
+		LLMModel:    getenv("LLM_MODEL", "gpt-3.5-turbo"),
+
+		LLMEmbeddingModel: getenv("LLM_EMBEDDING_MODEL", "text-embedding-3-small"),
+
+		LLMAllowedModels: splitList(getenv("LLM_ALLOWED_MODELS", "")),
+		LLMRoutesFile:    getenv("LLM_ROUTES_FILE", ""),
 	}
+
+	p := envParser{}
+	cfg.LLMDefaults = llm.GenerationParams{
+		MaxTokens:        p.int("LLM_MAX_TOKENS", "1000"),
+		Temperature:      p.float32("LLM_TEMPERATURE", "0.7"),
+		TopP:             p.float32("LLM_TOP_P", ""),
+		Stop:             splitList(getenv("LLM_STOP", "")),
+		PresencePenalty:  p.float32("LLM_PRESENCE_PENALTY", ""),
+		FrequencyPenalty: p.float32("LLM_FREQUENCY_PENALTY", ""),
+		Seed:             p.int("LLM_SEED", ""),
+	}
+	if window := p.int("LLM_CONTEXT_WINDOW", "8192"); window != nil {
+		cfg.LLMContextWindow = *window
+	}
+	if maxTokens := p.int("LLM_MAX_TOKENS_LIMIT", "4096"); maxTokens != nil {
+		cfg.LLMLimits.MaxTokens = *maxTokens
+	}
+	if maxTemperature := p.float32("LLM_TEMPERATURE_MAX", ""); maxTemperature != nil {
+		cfg.LLMLimits.MaxTemperature = *maxTemperature
+	}
+	cfg.Stream = StreamConfig{
+		Heartbeat:    p.duration("LLM_STREAM_HEARTBEAT", 15*time.Second),
+		StallTimeout: p.duration("LLM_STREAM_STALL_TIMEOUT", 90*time.Second),
+	}
+	cfg.Auth = AuthConfig{
+		Mode:       strings.ToLower(getenv("LLM_PROXY_AUTH", AuthToken)),
+		Secrets:    make(map[string][]string),
+		HMACWindow: p.duration("LLM_PROXY_HMAC_WINDOW", 5*time.Minute),
+		Keycloak: KeycloakConfig{
+			URL:         getenv("KEYCLOAK_URL", ""),
+			Realm:       getenv("KEYCLOAK_REALM", ""),
+			Issuer:      getenv("KEYCLOAK_ISSUER_URL", ""),
+			JWKSURL:     getenv("KEYCLOAK_JWKS_URL", ""),
+			Audience:    getenv("KEYCLOAK_AUDIENCE", ""),
+			ClockSkew:   p.duration("KEYCLOAK_CLOCK_SKEW", 30*time.Second),
+			JWKSRefresh: p.duration("KEYCLOAK_JWKS_REFRESH", 10*time.Minute),
+		},
+		AllowedClients: splitList(getenv("LLM_PROXY_ALLOWED_CLIENTS", "")),
+	}
+	for _, entry := range splitList(getenv("LLM_PROXY_SECRETS", "")) {
+		caller, secret, ok := strings.Cut(entry, ":")
+		if caller, secret = strings.TrimSpace(caller), strings.TrimSpace(secret); !ok || caller == "" || secret == "" {
+			p.errs = append(p.errs, errors.New("LLM_PROXY_SECRETS: entries must be caller:secret"))
+			continue
+		}
+		cfg.Auth.Secrets[caller] = append(cfg.Auth.Secrets[caller], secret)
+	}
+	cfg.Auth.Keycloak.populateDerived()
+
+	if err := errors.Join(p.errs...); err != nil {
+		return cfg, err
+	}
+	if err := cfg.LLMDefaults.Validate(cfg.LLMLimits); err != nil {
+		return cfg, fmt.Errorf("invalid LLM generation defaults: %w", err)
+	}
+	if err := cfg.Auth.validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// LLMConfigured reports whether requests can go to a real provider rather
+// than the dummy stream. Ollama runs locally and needs no API key, and a
+// routing table brings its own providers.
+func (c Config) LLMConfigured() bool {
+	return c.LLMAPIKey != "" || strings.EqualFold(c.LLMProvider, "ollama") || c.LLMRoutesFile != ""
+}
+
+// ModelAllowed reports whether a per-request model override may be served.
+func (c Config) ModelAllowed(model string) bool {
+	if model == "" || model == c.LLMModel {
+		return true
+	}
+	for _, allowed := range c.LLMAllowedModels {
+		if allowed == model {
+			return true
+		}
+	}
+	return false
+}
+
+// EmbeddingModelAllowed reports whether an embedding request may name
+// model. The embedding model serves embeddings only.
+func (c Config) EmbeddingModelAllowed(model string) bool {
+	return model == c.LLMEmbeddingModel || c.ModelAllowed(model)
+}
+
+func (a AuthConfig) validate() error {
+	switch a.Mode {
+	case AuthNone:
+	case AuthToken, AuthHMAC:
+		if len(a.Secrets) == 0 {
+			return fmt.Errorf("LLM_PROXY_AUTH=%s needs LLM_PROXY_SECRETS; set LLM_PROXY_AUTH=none to disable authentication", a.Mode)
+		}
+	case AuthJWT:
+		if a.Keycloak.JWKSURL == "" {
+			return errors.New("LLM_PROXY_AUTH=jwt needs KEYCLOAK_URL and KEYCLOAK_REALM, or KEYCLOAK_JWKS_URL")
+		}
+	default:
+		return fmt.Errorf("LLM_PROXY_AUTH: unknown mode %q", a.Mode)
+	}
+	return nil
+}
+
+func (kc *KeycloakConfig) populateDerived() {
+	if kc.URL == "" || kc.Realm == "" {
+		return
+	}
+	trimmed := strings.TrimRight(kc.URL, "/")
+	if kc.Issuer == "" {
+		if strings.Contains(trimmed, "/realms/") {
+			kc.Issuer = trimmed
+		} else {
+			kc.Issuer = fmt.Sprintf("%s/realms/%s", trimmed, kc.Realm)
+		}
+	}
+	if kc.JWKSURL == "" {
+		kc.JWKSURL = fmt.Sprintf("%s/protocol/openid-connect/certs", strings.TrimRight(kc.Issuer, "/"))
+	}
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 func getenv(key, def string) string {
@@ -38,3 +254,48 @@ func getenv(key, def string) string {
 	}
 	return def
 }
+
+// envParser collects parse errors so every bad variable is reported at once.
+type envParser struct {
+	errs []error
+}
+
+func (p *envParser) int(key, def string) *int {
+	value := getenv(key, def)
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %q is not an integer", key, value))
+		return nil
+	}
+	return &n
+}
+
+func (p *envParser) duration(key string, def time.Duration) time.Duration {
+	value := getenv(key, "")
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %q is not a duration", key, value))
+		return def
+	}
+	return d
+}
+
+func (p *envParser) float32(key, def string) *float32 {
+	value := getenv(key, def)
+	if value == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %q is not a number", key, value))
+		return nil
+	}
+	f32 := float32(f)
+	return &f32
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadGenerationSettings(t *testing.T) {
+	t.Setenv("LLM_MAX_TOKENS", "512")
+	t.Setenv("LLM_TEMPERATURE", "0.2")
+	t.Setenv("LLM_STOP", "###, END")
+	t.Setenv("LLM_SEED", "42")
+	t.Setenv("LLM_PROXY_SECRETS", "orchestrator:s3cret")
+
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, 512, *cfg.LLMDefaults.MaxTokens)
+	assert.Equal(t, float32(0.2), *cfg.LLMDefaults.Temperature)
+	assert.Equal(t, []string{"###", "END"}, cfg.LLMDefaults.Stop)
+	assert.Equal(t, 42, *cfg.LLMDefaults.Seed)
+	assert.Nil(t, cfg.LLMDefaults.TopP)
+	assert.Equal(t, 4096, cfg.LLMLimits.MaxTokens)
+}
+
+func TestLoadRejectsInvalidGenerationSettings(t *testing.T) {
+	t.Setenv("LLM_MAX_TOKENS", "lots")
+	t.Setenv("LLM_TEMPERATURE", "7")
+	_, err := Load()
+	assert.ErrorContains(t, err, `LLM_MAX_TOKENS: "lots" is not an integer`)
+
+	t.Setenv("LLM_MAX_TOKENS", "8192")
+	_, err = Load()
+	assert.ErrorContains(t, err, "max_tokens 8192 outside [1, 4096]")
+	assert.ErrorContains(t, err, "temperature 7")
+}
+
+func TestLoadAuth(t *testing.T) {
+	_, err := Load()
+	assert.ErrorContains(t, err, "LLM_PROXY_AUTH=token needs LLM_PROXY_SECRETS")
+
+	t.Setenv("LLM_PROXY_SECRETS", "orchestrator:old, orchestrator:new,chat-service:chat")
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, AuthToken, cfg.Auth.Mode)
+	assert.Equal(t, map[string][]string{"orchestrator": {"old", "new"}, "chat-service": {"chat"}}, cfg.Auth.Secrets)
+	assert.Empty(t, cfg.AllowedOrigins)
+
+	t.Setenv("LLM_PROXY_SECRETS", "no-secret")
+	_, err = Load()
+	assert.ErrorContains(t, err, "entries must be caller:secret")
+
+	t.Setenv("LLM_PROXY_AUTH", "jwt")
+	t.Setenv("LLM_PROXY_SECRETS", "")
+	t.Setenv("KEYCLOAK_URL", "http://keycloak:8080/auth/")
+	t.Setenv("KEYCLOAK_REALM", "ShopMindAI")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, "http://keycloak:8080/auth/realms/ShopMindAI/protocol/openid-connect/certs", cfg.Auth.Keycloak.JWKSURL)
+
+	t.Setenv("LLM_PROXY_AUTH", "basic")
+	_, err = Load()
+	assert.ErrorContains(t, err, `unknown mode "basic"`)
+}
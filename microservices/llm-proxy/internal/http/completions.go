@@ -0,0 +1,159 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/llm-proxy/internal/auth"
+	"github.com/shopmindai/llm-proxy/internal/llm"
+	"github.com/shopmindai/llm-proxy/internal/routing"
+	"github.com/shopmindai/llm-proxy/internal/stream"
+)
+
+type completionResponse struct {
+	Provider     string          `json:"provider"`
+	Model        string          `json:"model"`
+	Message      llm.ChatMessage `json:"message"`
+	FinishReason string          `json:"finish_reason"`
+	Usage        *llm.Usage      `json:"usage,omitempty"`
+}
+
+// handleChatCompletion answers a chat request with one JSON body. It routes
+// and falls back exactly like the stream, whose output it collects.
+func (s *Server) handleChatCompletion(w http.ResponseWriter, r *http.Request) {
+	var req chatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Messages) == 0 {
+		http.Error(w, "no messages provided", http.StatusBadRequest)
+		return
+	}
+	if err := req.ResponseFormat.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	targets, ok := s.routing.Resolve(req.Model)
+	if !ok {
+		http.Error(w, fmt.Sprintf("model %q is not allowed", req.Model), http.StatusBadRequest)
+		return
+	}
+
+	log.Info().Str("caller", auth.Caller(r.Context())).Int("message_count", len(req.Messages)).Str("model", req.Model).Bool("system_prompt", req.System != "").Int("tools", len(req.Tools)).Msg("starting LLM completion")
+
+	var out completionOutput
+	result, target, err := s.complete(r, "chat_completion", targets, req, &out)
+	if err != nil {
+		if r.Context().Err() != nil {
+			log.Info().Msg("LLM completion cancelled by caller")
+			return
+		}
+		log.Error().Err(err).Str("provider", target.Provider).Str("model", target.Model).Msg("LLM completion failed")
+		http.Error(w, "LLM request failed", http.StatusBadGateway)
+		return
+	}
+	resp := completionResponse{
+		Provider:     target.Provider,
+		Model:        target.Model,
+		Message:      out.message(),
+		FinishReason: result.FinishReason,
+		Usage:        result.Usage,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Warn().Err(err).Msg("failed to encode completion")
+	}
+}
+
+// complete routes req to out and records it under endpoint. Without a
+// configured provider the dummy answer is written instead.
+func (s *Server) complete(r *http.Request, endpoint string, targets []routing.Target, req chatRequest, out routing.Output) (llm.StreamResult, routing.Target, error) {
+	if !s.cfg.LLMConfigured() {
+		log.Warn().Msg("LLM API key not configured, using dummy response")
+		target := routing.Target{Provider: "dummy", Model: targets[0].Model}
+		if err := out.Route(target.Provider, target.Model); err != nil {
+			return llm.StreamResult{}, target, err
+		}
+		_, err := io.WriteString(out, "Hello, I am your LLM.")
+		return llm.StreamResult{FinishReason: "stop"}, target, err
+	}
+	return s.route(r, endpoint, targets, req, out)
+}
+
+// route streams req from the first target that answers into out and
+// records it under endpoint. A provider that produces no output for the
+// stall timeout is abandoned with stream.ErrStalled.
+func (s *Server) route(r *http.Request, endpoint string, targets []routing.Target, req chatRequest, out routing.Output) (llm.StreamResult, routing.Target, error) {
+	ctx, watchdog := stream.NewWatchdog(r.Context(), s.cfg.Stream.StallTimeout)
+	defer watchdog.Stop()
+	started := time.Now()
+	result, target, err := s.routing.Stream(ctx, targets, req.Messages, req.ChatOptions, watchedOutput{out, watchdog})
+	if err != nil && watchdog.Stalled() {
+		err = stream.ErrStalled
+	}
+	s.observe(r, endpoint, target, started, err, result.Usage)
+	return result, target, err
+}
+
+// watchedOutput counts everything written to the output as progress.
+type watchedOutput struct {
+	out      routing.Output
+	watchdog *stream.Watchdog
+}
+
+func (o watchedOutput) Write(p []byte) (int, error) {
+	o.watchdog.Touch()
+	return o.out.Write(p)
+}
+
+func (o watchedOutput) WriteToolCall(delta llm.ToolCallDelta) error {
+	o.watchdog.Touch()
+	return o.out.WriteToolCall(delta)
+}
+
+func (o watchedOutput) Route(provider, model string) error {
+	o.watchdog.Touch()
+	return o.out.Route(provider, model)
+}
+
+// completionOutput collects a streamed answer. Only the output of the
+// target that answered is kept, since fallback happens before any output.
+type completionOutput struct {
+	text      strings.Builder
+	toolCalls []llm.ToolCall
+}
+
+func (o *completionOutput) Write(p []byte) (int, error) {
+	return o.text.Write(p)
+}
+
+func (o *completionOutput) WriteToolCall(delta llm.ToolCallDelta) error {
+	for len(o.toolCalls) <= delta.Index {
+		o.toolCalls = append(o.toolCalls, llm.ToolCall{})
+	}
+	call := &o.toolCalls[delta.Index]
+	if delta.ID != "" {
+		call.ID = delta.ID
+	}
+	if delta.Name != "" {
+		call.Name = delta.Name
+	}
+	call.Arguments += delta.Arguments
+	return nil
+}
+
+func (o *completionOutput) Route(provider, model string) error {
+	return nil
+}
+
+func (o *completionOutput) message() llm.ChatMessage {
+	return llm.ChatMessage{Role: "assistant", Content: o.text.String(), ToolCalls: o.toolCalls}
+}
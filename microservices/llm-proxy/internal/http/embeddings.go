@@ -0,0 +1,158 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/llm-proxy/internal/auth"
+	"github.com/shopmindai/llm-proxy/internal/llm"
+	"github.com/shopmindai/llm-proxy/internal/routing"
+)
+
+const (
+	// maxEmbeddingInputs bounds one request's batch.
+	maxEmbeddingInputs = 256
+	// dummyEmbeddingDimensions is the size of the vectors served without a
+	// configured provider.
+	dummyEmbeddingDimensions = 256
+)
+
+// stringList accepts a single string or a list of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = stringList{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("expected a string or a list of strings")
+	}
+	*l = many
+	return nil
+}
+
+type embeddingRequest struct {
+	Input stringList `json:"input"`
+	Model string     `json:"model,omitempty"`
+}
+
+type embeddingData struct {
+	Index     int       `json:"index"`
+	Embedding []float32 `json:"embedding"`
+}
+
+type embeddingResponse struct {
+	Provider   string          `json:"provider"`
+	Model      string          `json:"model"`
+	Dimensions int             `json:"dimensions"`
+	Data       []embeddingData `json:"data"`
+	Usage      *llm.Usage      `json:"usage,omitempty"`
+}
+
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req embeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Input) == 0 {
+		http.Error(w, "no input provided", http.StatusBadRequest)
+		return
+	}
+	if len(req.Input) > maxEmbeddingInputs {
+		http.Error(w, fmt.Sprintf("at most %d inputs per request", maxEmbeddingInputs), http.StatusBadRequest)
+		return
+	}
+	target, ok := s.routing.ResolveEmbedding(req.Model)
+	if !ok {
+		http.Error(w, fmt.Sprintf("model %q is not allowed", req.Model), http.StatusBadRequest)
+		return
+	}
+
+	result, target, err := s.embed(r, "embeddings", target, req.Input)
+	if err != nil {
+		if errors.Is(err, llm.ErrEmbeddingsUnsupported) {
+			http.Error(w, fmt.Sprintf("provider %q does not support embeddings", target.Provider), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "embedding request failed", http.StatusBadGateway)
+		return
+	}
+
+	resp := embeddingResponse{
+		Provider: target.Provider,
+		Model:    target.Model,
+		Data:     make([]embeddingData, len(result.Vectors)),
+		Usage:    result.Usage,
+	}
+	for i, vector := range result.Vectors {
+		resp.Data[i] = embeddingData{Index: i, Embedding: vector}
+	}
+	if len(result.Vectors) > 0 {
+		resp.Dimensions = len(result.Vectors[0])
+	}
+	log.Info().Str("caller", auth.Caller(r.Context())).Int("inputs", len(req.Input)).Str("provider", resp.Provider).Str("model", resp.Model).Int("dimensions", resp.Dimensions).Msg("served embeddings")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Warn().Err(err).Msg("failed to encode embeddings")
+	}
+}
+
+// embed embeds input with target and records it under endpoint. Without a
+// configured provider the dummy embeddings are served instead.
+func (s *Server) embed(r *http.Request, endpoint string, target routing.Target, input []string) (llm.EmbeddingResult, routing.Target, error) {
+	var result llm.EmbeddingResult
+	if !s.cfg.LLMConfigured() {
+		log.Warn().Msg("LLM API key not configured, using dummy embeddings")
+		target.Provider = "dummy"
+		result.Vectors = make([][]float32, len(input))
+		for i, text := range input {
+			result.Vectors[i] = dummyEmbedding(text)
+		}
+		return result, target, nil
+	}
+	started := time.Now()
+	result, err := s.routing.Embed(r.Context(), target, input)
+	s.observe(r, endpoint, target, started, err, result.Usage)
+	if err != nil {
+		log.Error().Err(err).Str("provider", target.Provider).Str("model", target.Model).Msg("embedding request failed")
+	}
+	return result, target, err
+}
+
+// dummyEmbedding hashes the words of text into a unit vector so texts that
+// share words are similar, which keeps retrieval usable without a provider.
+func dummyEmbedding(text string) []float32 {
+	vector := make([]float32, dummyEmbeddingDimensions)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(word))
+		vector[h.Sum32()%dummyEmbeddingDimensions]++
+	}
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v * v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range vector {
+			vector[i] *= scale
+		}
+	}
+	return vector
+}
@@ -0,0 +1,502 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/llm-proxy/internal/auth"
+	"github.com/shopmindai/llm-proxy/internal/llm"
+	"github.com/shopmindai/llm-proxy/internal/routing"
+)
+
+// The handlers in this file speak the OpenAI API so off-the-shelf SDKs and
+// tools can use the proxy. Requests are translated to the proxy's own
+// types and routed like any other request, so the model may be an alias
+// served by any provider.
+
+type openAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// writeOpenAIError answers with the OpenAI error envelope.
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	errType := "invalid_request_error"
+	if status >= http.StatusInternalServerError {
+		errType = "api_error"
+	}
+	writeJSON(w, status, map[string]openAIError{"error": {Message: message, Type: errType}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Warn().Err(err).Msg("failed to encode response")
+	}
+}
+
+// openAIContent is a message's content: a string, null, or a list of parts
+// of which only text parts are supported.
+type openAIContent string
+
+func (c *openAIContent) UnmarshalJSON(data []byte) error {
+	var text *string
+	if err := json.Unmarshal(data, &text); err == nil {
+		if text != nil {
+			*c = openAIContent(*text)
+		}
+		return nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return errors.New("content must be a string or a list of content parts")
+	}
+	var joined string
+	for _, part := range parts {
+		if part.Type != "text" {
+			return fmt.Errorf("content parts of type %q are not supported", part.Type)
+		}
+		joined += part.Text
+	}
+	*c = openAIContent(joined)
+	return nil
+}
+
+type openAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type openAIToolCall struct {
+	// Index is only set on streamed deltas.
+	Index    *int               `json:"index,omitempty"`
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function openAIFunctionCall `json:"function"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    openAIContent    `json:"content"`
+	Name       string           `json:"name,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAITool struct {
+	Type     string   `json:"type"`
+	Function llm.Tool `json:"function"`
+}
+
+type openAIResponseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema *struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+		Strict bool            `json:"strict"`
+	} `json:"json_schema"`
+}
+
+type openAIChatRequest struct {
+	Model         string          `json:"model"`
+	Messages      []openAIMessage `json:"messages"`
+	Stream        bool            `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	Temperature         *float32              `json:"temperature"`
+	TopP                *float32              `json:"top_p"`
+	MaxTokens           *int                  `json:"max_tokens"`
+	MaxCompletionTokens *int                  `json:"max_completion_tokens"`
+	Stop                stringList            `json:"stop"`
+	PresencePenalty     *float32              `json:"presence_penalty"`
+	FrequencyPenalty    *float32              `json:"frequency_penalty"`
+	Seed                *int                  `json:"seed"`
+	N                   *int                  `json:"n"`
+	Tools               []openAITool          `json:"tools"`
+	ToolChoice          json.RawMessage       `json:"tool_choice"`
+	ResponseFormat      *openAIResponseFormat `json:"response_format"`
+}
+
+// chatRequest translates req to the proxy's request.
+func (req openAIChatRequest) chatRequest() (chatRequest, error) {
+	if req.N != nil && *req.N != 1 {
+		return chatRequest{}, errors.New("only n=1 is supported")
+	}
+	out := chatRequest{ChatOptions: llm.ChatOptions{
+		Model: req.Model,
+		Params: llm.GenerationParams{
+			Temperature:      req.Temperature,
+			TopP:             req.TopP,
+			MaxTokens:        req.MaxTokens,
+			Stop:             req.Stop,
+			PresencePenalty:  req.PresencePenalty,
+			FrequencyPenalty: req.FrequencyPenalty,
+			Seed:             req.Seed,
+		},
+	}}
+	if req.MaxCompletionTokens != nil {
+		out.Params.MaxTokens = req.MaxCompletionTokens
+	}
+
+	for _, msg := range req.Messages {
+		message := llm.ChatMessage{Role: msg.Role, Content: string(msg.Content), ToolCallID: msg.ToolCallID, Name: msg.Name}
+		switch msg.Role {
+		case "system", "user", "assistant", "tool":
+		case "developer":
+			message.Role = "system"
+		default:
+			return chatRequest{}, fmt.Errorf("unsupported message role %q", msg.Role)
+		}
+		for _, call := range msg.ToolCalls {
+			message.ToolCalls = append(message.ToolCalls, llm.ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+		}
+		out.Messages = append(out.Messages, message)
+	}
+
+	var toolChoice string
+	if len(req.ToolChoice) > 0 && string(req.ToolChoice) != "null" {
+		if err := json.Unmarshal(req.ToolChoice, &toolChoice); err != nil || (toolChoice != "auto" && toolChoice != "none") {
+			return chatRequest{}, errors.New(`only "auto" and "none" are supported for tool_choice`)
+		}
+	}
+	if toolChoice != "none" {
+		for _, tool := range req.Tools {
+			if tool.Type != "function" {
+				return chatRequest{}, fmt.Errorf("unsupported tool type %q", tool.Type)
+			}
+			out.Tools = append(out.Tools, tool.Function)
+		}
+	}
+
+	if format := req.ResponseFormat; format != nil && format.Type != "text" {
+		out.ResponseFormat = &llm.ResponseFormat{Type: format.Type}
+		if format.JSONSchema != nil {
+			out.ResponseFormat.Name = format.JSONSchema.Name
+			out.ResponseFormat.Schema = format.JSONSchema.Schema
+			out.ResponseFormat.Strict = format.JSONSchema.Strict
+		}
+		if err := out.ResponseFormat.Validate(); err != nil {
+			return chatRequest{}, err
+		}
+	}
+	return out, nil
+}
+
+type openAIResponseMessage struct {
+	Role string `json:"role"`
+	// Content is null when the answer only calls tools.
+	Content   *string          `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type openAIDelta struct {
+	Role      string           `json:"role,omitempty"`
+	Content   string           `json:"content,omitempty"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int                    `json:"index"`
+	Message      *openAIResponseMessage `json:"message,omitempty"`
+	Delta        *openAIDelta           `json:"delta,omitempty"`
+	FinishReason *string                `json:"finish_reason"`
+}
+
+type openAIChatCompletion struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *llm.Usage     `json:"usage,omitempty"`
+}
+
+func (s *Server) handleOpenAIChatCompletion(w http.ResponseWriter, r *http.Request) {
+	var body openAIChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	req, err := body.chatRequest()
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "no messages provided")
+		return
+	}
+	targets, ok := s.routing.Resolve(req.Model)
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("model %q is not allowed", req.Model))
+		return
+	}
+
+	log.Info().Str("caller", auth.Caller(r.Context())).Int("message_count", len(req.Messages)).Str("model", req.Model).Bool("stream", body.Stream).Int("tools", len(req.Tools)).Msg("starting OpenAI-compatible completion")
+
+	if body.Stream {
+		s.streamOpenAIChat(w, r, targets, req, body.StreamOptions != nil && body.StreamOptions.IncludeUsage)
+		return
+	}
+
+	var out completionOutput
+	result, target, err := s.complete(r, "openai_chat_completion", targets, req, &out)
+	if err != nil {
+		if r.Context().Err() != nil {
+			log.Info().Msg("LLM completion cancelled by caller")
+			return
+		}
+		log.Error().Err(err).Str("provider", target.Provider).Str("model", target.Model).Msg("LLM completion failed")
+		writeOpenAIError(w, http.StatusBadGateway, "LLM request failed")
+		return
+	}
+
+	message := &openAIResponseMessage{Role: "assistant"}
+	if text := out.text.String(); text != "" || len(out.toolCalls) == 0 {
+		message.Content = &text
+	}
+	for _, call := range out.toolCalls {
+		message.ToolCalls = append(message.ToolCalls, openAIToolCall{ID: call.ID, Type: "function", Function: openAIFunctionCall{Name: call.Name, Arguments: call.Arguments}})
+	}
+	writeJSON(w, http.StatusOK, openAIChatCompletion{
+		ID:      completionID(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   target.Model,
+		Choices: []openAIChoice{{Message: message, FinishReason: &result.FinishReason}},
+		Usage:   usageOrZero(result.Usage),
+	})
+}
+
+func (s *Server) streamOpenAIChat(w http.ResponseWriter, r *http.Request, targets []routing.Target, req chatRequest, includeUsage bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	out := &openAIStream{w: w, flusher: flusher, id: completionID(), created: time.Now().Unix()}
+	result, target, err := s.complete(r, "openai_chat_stream", targets, req, out)
+	if err != nil {
+		if r.Context().Err() != nil {
+			log.Info().Msg("LLM stream cancelled by caller")
+			return
+		}
+		log.Error().Err(err).Str("provider", target.Provider).Str("model", target.Model).Msg("LLM stream failed")
+		if !out.started {
+			writeOpenAIError(w, http.StatusBadGateway, "LLM request failed")
+			return
+		}
+		if err := out.send(map[string]openAIError{"error": {Message: err.Error(), Type: "api_error"}}); err != nil {
+			log.Warn().Err(err).Msg("failed to send error event")
+		}
+		return
+	}
+
+	if err := out.chunk(openAIChoice{Delta: &openAIDelta{}, FinishReason: &result.FinishReason}, nil); err != nil {
+		log.Warn().Err(err).Msg("failed to send completion signal")
+		return
+	}
+	if includeUsage {
+		if err := out.chunk(openAIChoice{}, usageOrZero(result.Usage)); err != nil {
+			log.Warn().Err(err).Msg("failed to send usage")
+			return
+		}
+	}
+	if err := out.frame("[DONE]"); err != nil {
+		log.Warn().Err(err).Msg("failed to send completion signal")
+	}
+}
+
+// openAIStream writes a routed stream as chat.completion.chunk events. The
+// response starts with the first chunk, announcing the assistant's role,
+// when the answering target is known.
+type openAIStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	id      string
+	created int64
+	model   string
+	started bool
+}
+
+func (o *openAIStream) Route(provider, model string) error {
+	o.model = model
+	o.started = true
+	o.w.Header().Set("Content-Type", "text/event-stream")
+	o.w.Header().Set("Cache-Control", "no-cache")
+	o.w.Header().Set("Connection", "keep-alive")
+	return o.chunk(openAIChoice{Delta: &openAIDelta{Role: "assistant"}}, nil)
+}
+
+func (o *openAIStream) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := o.chunk(openAIChoice{Delta: &openAIDelta{Content: string(p)}}, nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (o *openAIStream) WriteToolCall(delta llm.ToolCallDelta) error {
+	call := openAIToolCall{Index: &delta.Index, ID: delta.ID, Function: openAIFunctionCall{Name: delta.Name, Arguments: delta.Arguments}}
+	if delta.ID != "" {
+		call.Type = "function"
+	}
+	return o.chunk(openAIChoice{Delta: &openAIDelta{ToolCalls: []openAIToolCall{call}}}, nil)
+}
+
+// chunk sends one chunk. The usage chunk has no choices.
+func (o *openAIStream) chunk(choice openAIChoice, usage *llm.Usage) error {
+	chunk := openAIChatCompletion{
+		ID:      o.id,
+		Object:  "chat.completion.chunk",
+		Created: o.created,
+		Model:   o.model,
+		Choices: []openAIChoice{choice},
+		Usage:   usage,
+	}
+	if usage != nil {
+		chunk.Choices = []openAIChoice{}
+	}
+	return o.send(chunk)
+}
+
+func (o *openAIStream) send(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return o.frame(string(data))
+}
+
+func (o *openAIStream) frame(data string) error {
+	if _, err := fmt.Fprintf(o.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	o.flusher.Flush()
+	return nil
+}
+
+func completionID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
+
+// usageOrZero reports zero usage when the provider reported none; OpenAI
+// clients expect the field.
+func usageOrZero(usage *llm.Usage) *llm.Usage {
+	if usage == nil {
+		return &llm.Usage{}
+	}
+	return usage
+}
+
+type openAIModel struct {
+	ID     string `json:"id"`
+	Object string `json:"object"`
+	// Created is always 0: routed aliases have no release date.
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+func (s *Server) handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
+	models := s.routing.Models()
+	data := make([]openAIModel, len(models))
+	for i, model := range models {
+		data[i] = openAIModel{ID: model.ID, Object: "model", OwnedBy: model.Provider}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": data})
+}
+
+type openAIEmbeddingRequest struct {
+	Input          stringList `json:"input"`
+	Model          string     `json:"model"`
+	EncodingFormat string     `json:"encoding_format"`
+	Dimensions     *int       `json:"dimensions"`
+}
+
+type openAIEmbedding struct {
+	Object string `json:"object"`
+	Index  int    `json:"index"`
+	// Embedding is a list of floats, or a base64 string of little-endian
+	// float32 values.
+	Embedding any `json:"embedding"`
+}
+
+func (s *Server) handleOpenAIEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req openAIEmbeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	switch {
+	case len(req.Input) == 0:
+		writeOpenAIError(w, http.StatusBadRequest, "no input provided")
+		return
+	case len(req.Input) > maxEmbeddingInputs:
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("at most %d inputs per request", maxEmbeddingInputs))
+		return
+	case req.Dimensions != nil:
+		writeOpenAIError(w, http.StatusBadRequest, "dimensions is not supported")
+		return
+	case req.EncodingFormat != "" && req.EncodingFormat != "float" && req.EncodingFormat != "base64":
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("unsupported encoding_format %q", req.EncodingFormat))
+		return
+	}
+	target, ok := s.routing.ResolveEmbedding(req.Model)
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("model %q is not allowed", req.Model))
+		return
+	}
+
+	result, target, err := s.embed(r, "openai_embeddings", target, req.Input)
+	if err != nil {
+		if errors.Is(err, llm.ErrEmbeddingsUnsupported) {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("model %q does not support embeddings", target.Model))
+			return
+		}
+		writeOpenAIError(w, http.StatusBadGateway, "embedding request failed")
+		return
+	}
+
+	data := make([]openAIEmbedding, len(result.Vectors))
+	for i, vector := range result.Vectors {
+		data[i] = openAIEmbedding{Object: "embedding", Index: i, Embedding: vector}
+		if req.EncodingFormat == "base64" {
+			data[i].Embedding = encodeEmbedding(vector)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"object": "list",
+		"data":   data,
+		"model":  target.Model,
+		"usage":  usageOrZero(result.Usage),
+	})
+}
+
+func encodeEmbedding(vector []float32) string {
+	b := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(v))
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
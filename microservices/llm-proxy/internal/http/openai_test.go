@@ -0,0 +1,217 @@
+package httpserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shopmindai/llm-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAIChatCompletion(t *testing.T) {
+	var upstream map[string]any
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = nil
+		_ = json.NewDecoder(r.Body).Decode(&upstream)
+		w.Header().Set("Content-Type", "text/event-stream")
+		if upstream["tools"] != nil {
+			_, _ = w.Write([]byte(`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"search_products","arguments":"{\"query\":\"socks\"}"}}]},"finish_reason":"tool_calls"}]}` + "\n\n"))
+		} else {
+			_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"{\\\"sku\\\":\\\"SOCK-1\\\"}\"},\"finish_reason\":\"stop\"}]}\n\n"))
+			_, _ = w.Write([]byte("data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":4,\"total_tokens\":9}}\n\n"))
+		}
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer mockServer.Close()
+
+	proxyServer, err := New(config.Config{LLMAPIKey: "key", LLMBaseURL: mockServer.URL + "/v1", LLMModel: "gpt-4o-mini"})
+	require.NoError(t, err)
+
+	body := `{
+		"model": "gpt-4o-mini",
+		"messages": [
+			{"role": "developer", "content": "Answer in JSON."},
+			{"role": "user", "content": [{"type": "text", "text": "Which "}, {"type": "text", "text": "socks?"}]}
+		],
+		"stop": "END",
+		"max_completion_tokens": 50,
+		"response_format": {"type": "json_schema", "json_schema": {"name": "pick", "schema": {"type": "object"}, "strict": true}}
+	}`
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var resp openAIChatCompletion
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.True(t, strings.HasPrefix(resp.ID, "chatcmpl-"))
+	assert.Equal(t, "chat.completion", resp.Object)
+	assert.Equal(t, "gpt-4o-mini", resp.Model)
+	require.Len(t, resp.Choices, 1)
+	assert.Equal(t, `{"sku":"SOCK-1"}`, *resp.Choices[0].Message.Content)
+	assert.Equal(t, "stop", *resp.Choices[0].FinishReason)
+	assert.Equal(t, 9, resp.Usage.TotalTokens)
+
+	messages := upstream["messages"].([]any)
+	assert.Equal(t, map[string]any{"role": "system", "content": "Answer in JSON."}, messages[0])
+	assert.Equal(t, "Which socks?", messages[1].(map[string]any)["content"])
+	assert.Equal(t, []any{"END"}, upstream["stop"])
+	assert.EqualValues(t, 50, upstream["max_tokens"])
+	format := upstream["response_format"].(map[string]any)
+	assert.Equal(t, "json_schema", format["type"])
+	assert.Equal(t, "pick", format["json_schema"].(map[string]any)["name"])
+
+	body = `{"messages":[{"role":"user","content":"Find socks"}],"tools":[{"type":"function","function":{"name":"search_products","parameters":{"type":"object"}}}]}`
+	rr = httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var raw map[string]any
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &raw))
+	choice := raw["choices"].([]any)[0].(map[string]any)
+	assert.Equal(t, "tool_calls", choice["finish_reason"])
+	message := choice["message"].(map[string]any)
+	assert.Nil(t, message["content"], "content is null when the answer only calls tools")
+	assert.Equal(t, []any{map[string]any{
+		"id":       "call_1",
+		"type":     "function",
+		"function": map[string]any{"name": "search_products", "arguments": `{"query":"socks"}`},
+	}}, message["tool_calls"])
+
+	for body, status := range map[string]int{
+		`{"messages":[{"role":"user","content":"Hi"}],"n":2}`:                                    http.StatusBadRequest,
+		`{"messages":[{"role":"user","content":[{"type":"image_url"}]}]}`:                        http.StatusBadRequest,
+		`{"messages":[{"role":"user","content":"Hi"}],"tool_choice":"required"}`:                 http.StatusBadRequest,
+		`{"messages":[{"role":"user","content":"Hi"}],"response_format":{"type":"json_schema"}}`: http.StatusBadRequest,
+		`{"messages":[{"role":"user","content":"Hi"}],"model":"gpt-4o"}`:                         http.StatusNotFound,
+	} {
+		rr = httptest.NewRecorder()
+		proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(body)))
+		assert.Equal(t, status, rr.Code, body)
+		var errResp map[string]openAIError
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &errResp), body)
+		assert.Equal(t, "invalid_request_error", errResp["error"].Type)
+	}
+}
+
+func TestOpenAIChatCompletion_Stream(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"choices":[{"delta":{"content":"Wool"}}]}` + "\n\n"))
+		_, _ = w.Write([]byte(`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"search_products","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}` + "\n\n"))
+		_, _ = w.Write([]byte(`data: {"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}` + "\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer mockServer.Close()
+
+	proxyServer, err := New(config.Config{LLMAPIKey: "key", LLMBaseURL: mockServer.URL + "/v1", LLMModel: "gpt-4o-mini"})
+	require.NoError(t, err)
+
+	body := `{"messages":[{"role":"user","content":"Socks?"}],"stream":true,"stream_options":{"include_usage":true},"tools":[{"type":"function","function":{"name":"search_products"}}]}`
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+
+	frames := strings.Split(strings.TrimSpace(rr.Body.String()), "\n\n")
+	require.Len(t, frames, 6)
+	assert.Equal(t, "data: [DONE]", frames[5])
+
+	var chunks []openAIChatCompletion
+	for _, frame := range frames[:5] {
+		var chunk openAIChatCompletion
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(frame, "data: ")), &chunk))
+		assert.Equal(t, "chat.completion.chunk", chunk.Object)
+		assert.Equal(t, "gpt-4o-mini", chunk.Model)
+		chunks = append(chunks, chunk)
+	}
+	assert.Equal(t, "assistant", chunks[0].Choices[0].Delta.Role)
+	assert.Equal(t, "Wool", chunks[1].Choices[0].Delta.Content)
+	call := chunks[2].Choices[0].Delta.ToolCalls[0]
+	assert.Equal(t, 0, *call.Index)
+	assert.Equal(t, openAIFunctionCall{Name: "search_products", Arguments: "{}"}, call.Function)
+	assert.Equal(t, "tool_calls", *chunks[3].Choices[0].FinishReason)
+	assert.Empty(t, chunks[4].Choices)
+	assert.Equal(t, 7, chunks[4].Usage.TotalTokens)
+	assert.Equal(t, chunks[0].ID, chunks[4].ID)
+}
+
+func TestOpenAIChatCompletion_Dummy(t *testing.T) {
+	proxyServer, err := New(config.Config{LLMModel: "gpt-4o-mini"})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"messages":[{"role":"user","content":"Hi"}],"stream":true}`)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"content":"Hello, I am your LLM."`)
+	assert.True(t, strings.HasSuffix(rr.Body.String(), "data: [DONE]\n\n"))
+}
+
+func TestOpenAIModels(t *testing.T) {
+	proxyServer, err := New(config.Config{
+		LLMAPIKey:         "key",
+		LLMModel:          "gpt-4o-mini",
+		LLMAllowedModels:  []string{"gpt-4o"},
+		LLMEmbeddingModel: "text-embedding-3-small",
+	})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("GET", "/openai/v1/models", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp struct {
+		Object string        `json:"object"`
+		Data   []openAIModel `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "list", resp.Object)
+	assert.Equal(t, []openAIModel{
+		{ID: "gpt-4o", Object: "model", OwnedBy: "openai"},
+		{ID: "gpt-4o-mini", Object: "model", OwnedBy: "openai"},
+		{ID: "text-embedding-3-small", Object: "model", OwnedBy: "openai"},
+	}, resp.Data)
+}
+
+func TestOpenAIEmbeddings(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[{"index":0,"embedding":[0.5,1]}],"usage":{"prompt_tokens":2,"total_tokens":2}}`))
+	}))
+	defer mockServer.Close()
+
+	proxyServer, err := New(config.Config{LLMAPIKey: "key", LLMBaseURL: mockServer.URL + "/v1", LLMModel: "gpt-4o-mini", LLMEmbeddingModel: "text-embedding-3-small"})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/openai/v1/embeddings", strings.NewReader(`{"input":"wool socks","model":"text-embedding-3-small"}`)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.JSONEq(t, `{
+		"object": "list",
+		"data": [{"object": "embedding", "index": 0, "embedding": [0.5, 1]}],
+		"model": "text-embedding-3-small",
+		"usage": {"prompt_tokens": 2, "completion_tokens": 0, "total_tokens": 2}
+	}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/openai/v1/embeddings", strings.NewReader(`{"input":["wool socks"],"encoding_format":"base64"}`)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var resp struct {
+		Data []struct {
+			Embedding string `json:"embedding"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	decoded, err := base64.StdEncoding.DecodeString(resp.Data[0].Embedding)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0x3f, 0, 0, 0x80, 0x3f}, decoded, "little-endian float32 0.5 and 1")
+
+	for _, body := range []string{`{"input":"socks","dimensions":256}`, `{"input":[[1,2]]}`, `{"input":"socks","encoding_format":"int8"}`} {
+		rr = httptest.NewRecorder()
+		proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/openai/v1/embeddings", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
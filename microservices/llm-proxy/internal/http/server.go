@@ -1,47 +1,99 @@
 package httpserver
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 	"github.com/rs/zerolog/log"
 
+	"github.com/shopmindai/llm-proxy/internal/auth"
 	"github.com/shopmindai/llm-proxy/internal/config"
 	"github.com/shopmindai/llm-proxy/internal/llm"
+	"github.com/shopmindai/llm-proxy/internal/metrics"
+	"github.com/shopmindai/llm-proxy/internal/routing"
+	"github.com/shopmindai/llm-proxy/internal/stream"
 )
 
 type Server struct {
-	Router *chi.Mux
-	cfg    config.Config
-	llm    *llm.Client
+	Router  *chi.Mux
+	cfg     config.Config
+	routing *routing.Table
+	metrics *metrics.Metrics
+	auth    auth.Authenticator
 }
 
-func New(cfg config.Config) *Server {
+func New(cfg config.Config) (*Server, error) {
 	r := chi.NewRouter()
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{cfg.AllowedOrigins},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Requested-With"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	}))
+	// The proxy is meant for other services; browsers only get CORS for
+	// explicitly listed origins. Credentials travel in headers, never
+	// cookies.
+	if len(cfg.AllowedOrigins) > 0 {
+		r.Use(cors.Handler(cors.Options{
+			AllowedOrigins: cfg.AllowedOrigins,
+			AllowedMethods: []string{"GET", "POST", "OPTIONS"},
+			AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-Requested-With",
+				auth.HeaderCaller, auth.HeaderTimestamp, auth.HeaderNonce, auth.HeaderSignature},
+			ExposedHeaders: []string{"Link"},
+			MaxAge:         300,
+		}))
+	}
+
+	routes, err := routing.Load(cfg, llm.NewRegistry())
+	if err != nil {
+		return nil, err
+	}
+	authenticator, err := auth.New(context.Background(), cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
 
 	s := &Server{
-		Router: r,
-		cfg:    cfg,
-		llm:    llm.New(cfg),
+		Router:  r,
+		cfg:     cfg,
+		routing: routes,
+		metrics: metrics.New(),
+		auth:    authenticator,
 	}
 	s.routes()
-	return s
+	return s, nil
+}
+
+// Close stops the server's background work.
+func (s *Server) Close() {
+	auth.Close(s.auth)
 }
 
 func (s *Server) routes() {
 	s.Router.Get("/v1/healthz", s.handleHealthz)
-	s.Router.Post("/v1/chat/stream", s.handleChatStream)
+
+	// Everything else spends provider credit and needs an authenticated
+	// caller.
+	s.Router.Group(func(r chi.Router) {
+		if s.auth != nil {
+			r.Use(auth.Middleware(s.auth))
+		} else {
+			log.Warn().Msg("authentication disabled, any caller can use the proxy")
+		}
+		r.Handle("/metrics", s.metrics.Handler())
+		r.Post("/v1/chat/stream", s.handleChatStream)
+		r.Post("/v1/chat/completions", s.handleChatCompletion)
+		r.Post("/v1/embeddings", s.handleEmbeddings)
+		r.Post("/v1/tokens/count", s.handleCountTokens)
+		r.Get("/v1/models", s.handleModels)
+
+		// OpenAI-compatible facade for tools that speak the OpenAI API.
+		r.Route("/openai/v1", func(r chi.Router) {
+			r.Post("/chat/completions", s.handleOpenAIChatCompletion)
+			r.Get("/models", s.handleOpenAIModels)
+			r.Post("/embeddings", s.handleOpenAIEmbeddings)
+		})
+	})
 }
 
 func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
@@ -52,6 +104,7 @@ func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
 
 type chatRequest struct {
 	Messages []llm.ChatMessage `json:"messages"`
+	llm.ChatOptions
 }
 
 func (s *Server) handleChatStream(w http.ResponseWriter, r *http.Request) {
@@ -76,70 +129,103 @@ func (s *Server) handleChatStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Info().Int("message_count", len(req.Messages)).Msg("starting LLM stream")
+	if err := req.ResponseFormat.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if s.cfg.LLMAPIKey == "" {
+	version := stream.RequestedVersion(r)
+	if len(req.Tools) > 0 && version == 0 {
+		http.Error(w, stream.ErrLegacyToolCalls.Error(), http.StatusBadRequest)
+		return
+	}
+
+	targets, ok := s.routing.Resolve(req.Model)
+	if !ok {
+		http.Error(w, fmt.Sprintf("model %q is not allowed", req.Model), http.StatusBadRequest)
+		return
+	}
+
+	log.Info().Str("caller", auth.Caller(r.Context())).Int("message_count", len(req.Messages)).Str("model", req.Model).Bool("system_prompt", req.System != "").Int("tools", len(req.Tools)).Msg("starting LLM stream")
+
+	sw := stream.NewWriter(w, flusher, version)
+	if !s.cfg.LLMConfigured() {
 		log.Warn().Msg("LLM API key not configured, using dummy response")
 		// Fallback to dummy response
-		s.handleDummyStream(w, flusher)
+		s.handleDummyStream(sw)
 		return
 	}
 
-	// Use real LLM API
-	adapter := newSSEWriter(w, flusher)
-	if err := s.llm.StreamChat(req.Messages, adapter); err != nil {
-		log.Error().Err(err).Msg("LLM stream failed")
-		http.Error(w, "LLM request failed", http.StatusInternalServerError)
+	stopKeepAlive := sw.KeepAlive(s.cfg.Stream.Heartbeat)
+	result, target, err := s.route(r, "chat_stream", targets, req, streamOutput{sw})
+	stopKeepAlive()
+	if err != nil {
+		if r.Context().Err() != nil {
+			log.Info().Msg("LLM stream cancelled by caller")
+			return
+		}
+		log.Error().Err(err).Str("provider", target.Provider).Str("model", target.Model).Msg("LLM stream failed")
+		if !sw.Started() {
+			http.Error(w, "LLM request failed", http.StatusInternalServerError)
+			return
+		}
+		if err := sw.Error(err); err != nil {
+			log.Warn().Err(err).Msg("failed to send error event")
+		}
 		return
 	}
-	if err := adapter.Done(); err != nil {
+	if err := sw.Finish(result.FinishReason, (*stream.Usage)(result.Usage)); err != nil {
 		log.Warn().Err(err).Msg("failed to send completion signal")
 	}
 }
 
-func (s *Server) handleDummyStream(w http.ResponseWriter, flusher http.Flusher) {
-	tokens := []string{"Hello", ",", " I", " am", " your", " LLM", ".", " [DONE]"}
-	for _, t := range tokens {
-		fmt.Fprintf(w, "data: %s\n\n", t)
-		flusher.Flush()
+// observe records a finished provider request in the metrics and the usage
+// log, attributed to the calling service.
+func (s *Server) observe(r *http.Request, endpoint string, target routing.Target, started time.Time, err error, usage *llm.Usage) {
+	caller, result, elapsed := auth.Caller(r.Context()), outcome(r, err), time.Since(started)
+	s.metrics.Observe(caller, endpoint, target.Provider, target.Model, result, elapsed, usage)
+	evt := log.Info().
+		Str("caller", caller).
+		Str("endpoint", endpoint).
+		Str("provider", target.Provider).
+		Str("model", target.Model).
+		Str("outcome", result).
+		Dur("elapsed", elapsed)
+	if usage != nil {
+		evt = evt.Int("prompt_tokens", usage.PromptTokens).Int("completion_tokens", usage.CompletionTokens)
 	}
+	evt.Msg("LLM usage")
 }
 
-type sseWriter struct {
-	w       http.ResponseWriter
-	flusher http.Flusher
+// outcome classifies a finished request for metrics.
+func outcome(r *http.Request, err error) string {
+	switch {
+	case r.Context().Err() != nil:
+		return metrics.OutcomeCancelled
+	case errors.Is(err, stream.ErrStalled):
+		return metrics.OutcomeStalled
+	case err != nil:
+		return metrics.OutcomeError
+	default:
+		return metrics.OutcomeOK
+	}
 }
 
-func newSSEWriter(w http.ResponseWriter, flusher http.Flusher) *sseWriter {
-	return &sseWriter{w: w, flusher: flusher}
+// streamOutput adapts a stream.Writer to routing.Output.
+type streamOutput struct {
+	*stream.Writer
 }
 
-func (sw *sseWriter) Write(p []byte) (int, error) {
-	chunk := string(p)
-	if chunk == "" {
-		return len(p), nil
-	}
-	if err := sw.send(chunk); err != nil {
-		return 0, err
-	}
-	return len(p), nil
+func (o streamOutput) WriteToolCall(delta llm.ToolCallDelta) error {
+	return o.ToolCall(stream.ToolCall(delta))
 }
 
-func (sw *sseWriter) Done() error {
-	return sw.send("[DONE]")
-}
-
-func (sw *sseWriter) send(message string) error {
-	lines := strings.Split(message, "\n")
-	for _, line := range lines {
-		trimmed := line
-		if trimmed == "" {
-			continue
-		}
-		if _, err := fmt.Fprintf(sw.w, "data: %s\n\n", trimmed); err != nil {
-			return err
+func (s *Server) handleDummyStream(sw *stream.Writer) {
+	tokens := []string{"Hello", ",", " I", " am", " your", " LLM", "."}
+	for _, t := range tokens {
+		if err := sw.Delta(t); err != nil {
+			return
 		}
 	}
-	sw.flusher.Flush()
-	return nil
+	_ = sw.Finish("stop", nil)
 }
@@ -2,15 +2,20 @@ package httpserver
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/shopmindai/llm-proxy/internal/config"
 	"github.com/shopmindai/llm-proxy/internal/llm"
+	"github.com/shopmindai/llm-proxy/internal/routing"
+	"github.com/shopmindai/llm-proxy/internal/stream"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandleChatStream_ProxyLogic(t *testing.T) {
@@ -45,13 +50,14 @@ func TestHandleChatStream_ProxyLogic(t *testing.T) {
 
 	// 2. Configure the llm-proxy to use the mock server
 	testConfig := config.Config{
-		LLMAPIKey:    mockAPIToken,
-		LLMBaseURL:   mockServer.URL + "/v1", // The client adds /chat/completions
-		LLMModel:     "gpt-3.5-turbo",
+		LLMAPIKey:  mockAPIToken,
+		LLMBaseURL: mockServer.URL + "/v1", // The client adds /chat/completions
+		LLMModel:   "gpt-3.5-turbo",
 	}
 
 	// 3. Create an instance of our proxy server
-	proxyServer := New(testConfig)
+	proxyServer, err := New(testConfig)
+	assert.NoError(t, err)
 
 	// Define the request body for the test
 	type testChatRequest struct {
@@ -85,7 +91,8 @@ func TestHandleChatStream_ProxyLogic(t *testing.T) {
 
 func TestHandleHealthz(t *testing.T) {
 	// Configure a dummy server
-	proxyServer := New(config.Config{})
+	proxyServer, err := New(config.Config{})
+	assert.NoError(t, err)
 
 	// Create request
 	req, err := http.NewRequest("GET", "/v1/healthz", nil)
@@ -100,3 +107,423 @@ func TestHandleHealthz(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
 	assert.Equal(t, `{"status":"ok"}`, rr.Body.String(), "handler returned unexpected body")
 }
+
+func TestHandleChatStream_AppliesRequestOptions(t *testing.T) {
+	var upstream struct {
+		Model       string            `json:"model"`
+		Temperature float32           `json:"temperature"`
+		MaxTokens   int               `json:"max_tokens"`
+		Messages    []llm.ChatMessage `json:"messages"`
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&upstream)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"ok\"}}]}\n\n"))
+	}))
+	defer mockServer.Close()
+
+	proxyServer, err := New(config.Config{
+		LLMAPIKey:        "test-api-key",
+		LLMBaseURL:       mockServer.URL + "/v1",
+		LLMModel:         "gpt-3.5-turbo",
+		LLMAllowedModels: []string{"gpt-4o"},
+	})
+	assert.NoError(t, err)
+
+	body := `{"messages":[{"role":"user","content":"Hi"}],"system":"You are a shopping assistant.","model":"gpt-4o","params":{"temperature":0.2,"max_tokens":64}}`
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/stream", strings.NewReader(body)))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "gpt-4o", upstream.Model)
+	assert.InDelta(t, 0.2, upstream.Temperature, 0.0001)
+	assert.Equal(t, 64, upstream.MaxTokens)
+	if assert.Len(t, upstream.Messages, 2) {
+		assert.Equal(t, llm.ChatMessage{Role: "system", Content: "You are a shopping assistant."}, upstream.Messages[0])
+	}
+
+	rr = httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/stream",
+		strings.NewReader(`{"messages":[{"role":"user","content":"Hi"}],"model":"gpt-4-32k"}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestHandleChatStream_StructuredProtocol(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Deals:\\n- socks\"}}]}\n\n"))
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"  and shoes\"},\"finish_reason\":\"stop\"}]}\n\n"))
+		_, _ = w.Write([]byte("data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":4,\"total_tokens\":9}}\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer mockServer.Close()
+
+	proxyServer, err := New(config.Config{LLMAPIKey: "key", LLMBaseURL: mockServer.URL + "/v1", LLMModel: "gpt-3.5-turbo"})
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/v1/chat/stream", strings.NewReader(`{"messages":[{"role":"user","content":"Hi"}]}`))
+	req.Header.Set(stream.ProtocolHeader, "1")
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get(stream.ProtocolHeader))
+
+	var text strings.Builder
+	var events []stream.Event
+	for _, frame := range strings.Split(strings.TrimSpace(rr.Body.String()), "\n\n") {
+		var evt stream.Event
+		if assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(frame, "data: ")), &evt)) {
+			events = append(events, evt)
+			if evt.Type == stream.EventDelta {
+				text.WriteString(evt.Text)
+			}
+		}
+	}
+
+	assert.Equal(t, "Deals:\n- socks  and shoes", text.String())
+	if assert.Len(t, events, 5) {
+		assert.Equal(t, stream.Event{V: 1, Type: stream.EventRoute, Provider: "openai", Model: "gpt-3.5-turbo"}, events[0])
+		assert.Equal(t, stream.EventUsage, events[3].Type)
+		assert.Equal(t, &stream.Usage{PromptTokens: 5, CompletionTokens: 4, TotalTokens: 9}, events[3].Usage)
+		assert.Equal(t, stream.Event{V: 1, Type: stream.EventFinish, FinishReason: "stop"}, events[4])
+	}
+}
+
+func TestHandleChatStream_PropagatesCancellation(t *testing.T) {
+	providerCancelled := make(chan struct{})
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			close(providerCancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer mockServer.Close()
+
+	proxyServer, err := New(config.Config{LLMAPIKey: "key", LLMBaseURL: mockServer.URL + "/v1", LLMModel: "gpt-3.5-turbo"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/v1/chat/stream", strings.NewReader(`{"messages":[{"role":"user","content":"Hi"}]}`)).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		proxyServer.Router.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-providerCancelled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("provider request was not cancelled with the caller")
+	}
+	<-done
+}
+
+func TestHandleChatStream_HeartbeatsAndStalls(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hmm\"}}]}\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer mockServer.Close()
+
+	proxyServer, err := New(config.Config{
+		LLMAPIKey:  "key",
+		LLMBaseURL: mockServer.URL + "/v1",
+		LLMModel:   "gpt-3.5-turbo",
+		Stream:     config.StreamConfig{Heartbeat: 20 * time.Millisecond, StallTimeout: 200 * time.Millisecond},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/v1/chat/stream", strings.NewReader(`{"messages":[{"role":"user","content":"Hi"}]}`))
+	req.Header.Set(stream.ProtocolHeader, "1")
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	assert.Contains(t, body, ": keep-alive\n\n")
+	assert.Contains(t, body, `"type":"delta","text":"Hmm"`)
+	assert.True(t, strings.HasSuffix(body, `data: {"v":1,"type":"error","error":"stream stalled: the model stopped producing output"}`+"\n\n"), body)
+
+	rr = httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rr.Body.String(), `llm_proxy_stalled_streams_total{endpoint="chat_stream",model="gpt-3.5-turbo",provider="openai"} 1`)
+	assert.Contains(t, rr.Body.String(), `outcome="stalled"`)
+}
+
+func TestHandleChatStream_ToolCalls(t *testing.T) {
+	var upstream map[string]any
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&upstream)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"search_products","arguments":""}}]}}]}` + "\n\n"))
+		_, _ = w.Write([]byte(`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"query\":\"socks\"}"}}]},"finish_reason":"tool_calls"}]}` + "\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer mockServer.Close()
+
+	proxyServer, err := New(config.Config{LLMAPIKey: "key", LLMBaseURL: mockServer.URL + "/v1", LLMModel: "gpt-4o-mini"})
+	require.NoError(t, err)
+
+	body := `{"messages":[{"role":"user","content":"Find socks"}],"tools":[{"name":"search_products","description":"Search the catalog"}]}`
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/stream", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "the legacy protocol cannot carry tool calls")
+
+	req := httptest.NewRequest("POST", "/v1/chat/stream", strings.NewReader(body))
+	req.Header.Set(stream.ProtocolHeader, "1")
+	rr = httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var events []stream.Event
+	for _, frame := range strings.Split(strings.TrimSpace(rr.Body.String()), "\n\n") {
+		var evt stream.Event
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(frame, "data: ")), &evt))
+		events = append(events, evt)
+	}
+	require.Len(t, events, 4)
+	assert.Equal(t, stream.EventRoute, events[0].Type)
+	assert.Equal(t, &stream.ToolCall{Index: 0, ID: "call_1", Name: "search_products"}, events[1].ToolCall)
+	assert.Equal(t, &stream.ToolCall{Index: 0, Arguments: `{"query":"socks"}`}, events[2].ToolCall)
+	assert.Equal(t, stream.Event{V: 1, Type: stream.EventFinish, FinishReason: "tool_calls"}, events[3])
+
+	tools := upstream["tools"].([]any)
+	assert.Equal(t, "search_products", tools[0].(map[string]any)["function"].(map[string]any)["name"])
+}
+
+func TestHandleEmbeddings(t *testing.T) {
+	var upstream map[string]any
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&upstream)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[{"index":0,"embedding":[0.6,0.8]},{"index":1,"embedding":[1,0]}],"usage":{"prompt_tokens":3,"total_tokens":3}}`))
+	}))
+	defer mockServer.Close()
+
+	proxyServer, err := New(config.Config{
+		LLMAPIKey:         "key",
+		LLMBaseURL:        mockServer.URL + "/v1",
+		LLMModel:          "gpt-4o-mini",
+		LLMEmbeddingModel: "text-embedding-3-small",
+	})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`{"input":["wool socks","tents"]}`)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var resp embeddingResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "openai", resp.Provider)
+	assert.Equal(t, "text-embedding-3-small", resp.Model)
+	assert.Equal(t, 2, resp.Dimensions)
+	assert.Equal(t, []embeddingData{{Index: 0, Embedding: []float32{0.6, 0.8}}, {Index: 1, Embedding: []float32{1, 0}}}, resp.Data)
+	assert.Equal(t, &llm.Usage{PromptTokens: 3, TotalTokens: 3}, resp.Usage)
+	assert.Equal(t, "text-embedding-3-small", upstream["model"])
+
+	for _, body := range []string{`{"input":[]}`, `{"input":42}`, `{"input":"socks","model":"text-embedding-3-large"}`} {
+		rr = httptest.NewRecorder()
+		proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
+
+func TestHandleEmbeddings_Dummy(t *testing.T) {
+	proxyServer, err := New(config.Config{LLMEmbeddingModel: "text-embedding-3-small"})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`{"input":"Wool socks"}`)))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp embeddingResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "dummy", resp.Provider)
+	assert.Equal(t, dummyEmbeddingDimensions, resp.Dimensions)
+	require.Len(t, resp.Data, 1)
+	assert.Equal(t, dummyEmbedding("wool SOCKS!"), resp.Data[0].Embedding, "the dummy embedding ignores case and punctuation")
+}
+
+func TestHandleChatCompletion(t *testing.T) {
+	calls := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/event-stream")
+		if calls == 1 {
+			_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Wool\"}}]}\n\n"))
+			_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\" socks\"},\"finish_reason\":\"stop\"}]}\n\n"))
+			_, _ = w.Write([]byte("data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2,\"total_tokens\":7}}\n\n"))
+		} else {
+			_, _ = w.Write([]byte(`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"search_products","arguments":"{\"query\":"}}]}}]}` + "\n\n"))
+			_, _ = w.Write([]byte(`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"socks\"}"}}]},"finish_reason":"tool_calls"}]}` + "\n\n"))
+		}
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer mockServer.Close()
+
+	proxyServer, err := New(config.Config{LLMAPIKey: "key", LLMBaseURL: mockServer.URL + "/v1", LLMModel: "gpt-4o-mini"})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"messages":[{"role":"user","content":"Hi"}]}`)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var resp completionResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, completionResponse{
+		Provider:     "openai",
+		Model:        "gpt-4o-mini",
+		Message:      llm.ChatMessage{Role: "assistant", Content: "Wool socks"},
+		FinishReason: "stop",
+		Usage:        &llm.Usage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7},
+	}, resp)
+
+	rr = httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/completions",
+		strings.NewReader(`{"messages":[{"role":"user","content":"Find socks"}],"tools":[{"name":"search_products"}]}`)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	resp = completionResponse{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "tool_calls", resp.FinishReason)
+	assert.Equal(t, []llm.ToolCall{{ID: "call_1", Name: "search_products", Arguments: `{"query":"socks"}`}}, resp.Message.ToolCalls)
+
+	rr = httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"messages":[]}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestHandleCountTokens(t *testing.T) {
+	proxyServer, err := New(config.Config{LLMAPIKey: "key", LLMModel: "gpt-4o-mini", LLMLimits: llm.Limits{MaxTokens: 4096}})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/tokens/count", strings.NewReader(
+		`{"messages":[{"role":"user","content":"Find wool socks"},{"role":"assistant","content":"Here are three."}],"system":"Be brief.","params":{"max_tokens":500}}`)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var resp tokenCountResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, tokenCountResponse{
+		PromptTokens: 3 + 7 + 8 + 8,
+		Messages:     []int{8, 8},
+		Estimated:    true,
+		Limits:       routing.Limits{ContextWindow: 128000, MaxOutputTokens: 500},
+	}, resp)
+
+	rr = httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/tokens/count", strings.NewReader(`{"model":"gpt-5-secret","messages":[]}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestHandleModels(t *testing.T) {
+	proxyServer, err := New(config.Config{LLMAPIKey: "key", LLMModel: "gpt-4o-mini", LLMAllowedModels: []string{"acme-1"}, LLMContextWindow: 4096})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/models", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"models":[
+		{"id":"acme-1","provider":"openai","context_window":4096,"max_output_tokens":0},
+		{"id":"gpt-4o-mini","provider":"openai","context_window":128000,"max_output_tokens":0}
+	]}`, rr.Body.String())
+}
+
+func TestMetrics(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/embeddings" {
+			_, _ = w.Write([]byte(`{"data":[{"index":0,"embedding":[1]}],"usage":{"prompt_tokens":2,"total_tokens":2}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"ok\"},\"finish_reason\":\"stop\"}]}\n\n"))
+		_, _ = w.Write([]byte("data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":1,\"total_tokens\":4}}\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer mockServer.Close()
+
+	proxyServer, err := New(config.Config{
+		LLMAPIKey:         "key",
+		LLMBaseURL:        mockServer.URL + "/v1",
+		LLMModel:          "gpt-4o-mini",
+		LLMEmbeddingModel: "text-embedding-3-small",
+		Auth:              config.AuthConfig{Mode: config.AuthToken, Secrets: map[string][]string{"orchestrator": {"s3cret"}}},
+	})
+	require.NoError(t, err)
+
+	for path, body := range map[string]string{
+		"/v1/chat/stream":      `{"messages":[{"role":"user","content":"Hi"}]}`,
+		"/v1/chat/completions": `{"messages":[{"role":"user","content":"Hi"}]}`,
+		"/v1/embeddings":       `{"input":"socks"}`,
+	} {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rr := httptest.NewRecorder()
+		proxyServer.Router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, path)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	for _, want := range []string{
+		`llm_proxy_requests_total{caller="orchestrator",endpoint="chat_stream",model="gpt-4o-mini",outcome="ok",provider="openai"} 1`,
+		`llm_proxy_requests_total{caller="orchestrator",endpoint="chat_completion",model="gpt-4o-mini",outcome="ok",provider="openai"} 1`,
+		`llm_proxy_requests_total{caller="orchestrator",endpoint="embeddings",model="text-embedding-3-small",outcome="ok",provider="openai"} 1`,
+		`llm_proxy_tokens_total{caller="orchestrator",endpoint="chat_completion",model="gpt-4o-mini",provider="openai",type="prompt"} 3`,
+		`llm_proxy_tokens_total{caller="orchestrator",endpoint="embeddings",model="text-embedding-3-small",provider="openai",type="prompt"} 2`,
+		`llm_proxy_request_duration_seconds_count{endpoint="chat_stream",provider="openai"} 1`,
+	} {
+		assert.Contains(t, rr.Body.String(), want)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	proxyServer, err := New(config.Config{
+		Auth: config.AuthConfig{Mode: config.AuthToken, Secrets: map[string][]string{"orchestrator": {"old", "new"}}},
+	})
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{"GET", "/v1/healthz", "", http.StatusOK},
+		{"GET", "/metrics", "", http.StatusUnauthorized},
+		{"POST", "/v1/chat/completions", "", http.StatusUnauthorized},
+		{"POST", "/v1/chat/completions", "wrong", http.StatusUnauthorized},
+		{"POST", "/openai/v1/chat/completions", "wrong", http.StatusUnauthorized},
+		{"POST", "/v1/chat/completions", "old", http.StatusOK},
+		{"POST", "/v1/chat/completions", "new", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"messages":[{"role":"user","content":"Hi"}]}`))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rr := httptest.NewRecorder()
+		proxyServer.Router.ServeHTTP(rr, req)
+		assert.Equal(t, tt.status, rr.Code, "%s %s with %q", tt.method, tt.path, tt.token)
+	}
+
+	// No CORS unless origins are configured.
+	req := httptest.NewRequest("OPTIONS", "/v1/chat/completions", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr := httptest.NewRecorder()
+	proxyServer.Router.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,63 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/shopmindai/llm-proxy/internal/llm"
+	"github.com/shopmindai/llm-proxy/internal/routing"
+)
+
+// tokenCountResponse sizes a chat request against the limits of the
+// models it would be routed to. Counts are estimates.
+type tokenCountResponse struct {
+	Model        string `json:"model"`
+	PromptTokens int    `json:"prompt_tokens"`
+	// Messages holds each message's share of PromptTokens, in order; the
+	// rest is the system prompt, tools and per-request overhead.
+	Messages  []int `json:"messages"`
+	Estimated bool  `json:"estimated"`
+	routing.Limits
+}
+
+// handleCountTokens estimates the prompt tokens of a chat request, given in
+// the same shape as for /v1/chat/stream, so callers can fit their history
+// into the model's context window.
+func (s *Server) handleCountTokens(w http.ResponseWriter, r *http.Request) {
+	var req chatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	targets, ok := s.routing.Resolve(req.Model)
+	if !ok {
+		http.Error(w, fmt.Sprintf("model %q is not allowed", req.Model), http.StatusBadRequest)
+		return
+	}
+	count := llm.CountTokens(req.Messages, req.ChatOptions)
+	writeJSON(w, http.StatusOK, tokenCountResponse{
+		Model:        req.Model,
+		PromptTokens: count.Total,
+		Messages:     count.Messages,
+		Estimated:    true,
+		Limits:       s.routing.Limits(targets, req.Params),
+	})
+}
+
+type modelLimits struct {
+	routing.Model
+	routing.Limits
+}
+
+// handleModels lists the models callers may request with their token
+// limits under the default generation settings.
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	models := s.routing.Models()
+	data := make([]modelLimits, len(models))
+	for i, model := range models {
+		targets, _ := s.routing.Resolve(model.ID)
+		data[i] = modelLimits{Model: model, Limits: s.routing.Limits(targets, llm.GenerationParams{})}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"models": data})
+}
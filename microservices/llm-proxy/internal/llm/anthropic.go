@@ -0,0 +1,223 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	anthropicBaseURL = "https://api.anthropic.com/v1"
+	anthropicVersion = "2023-06-01"
+	// The Messages API requires max_tokens on every request.
+	anthropicDefaultMaxTokens = 1024
+)
+
+// Anthropic streams from the Anthropic Messages API.
+type Anthropic struct {
+	cfg ProviderConfig
+}
+
+func NewAnthropic(cfg ProviderConfig) Provider {
+	return &Anthropic{cfg: cfg}
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float32           `json:"temperature,omitempty"`
+	TopP          *float32           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	Stream        bool               `json:"stream"`
+}
+
+// anthropicMessage content is a plain string, or content blocks when the
+// message carries tool calls or results.
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+func (a *Anthropic) StreamChat(ctx context.Context, messages []ChatMessage, opts ChatOptions, writer io.Writer) (StreamResult, error) {
+	system, conversation := splitSystem(messages, opts)
+	// The Messages API has no JSON mode; the format is asked for instead.
+	if instruction := opts.ResponseFormat.instruction(); instruction != "" {
+		system = strings.TrimSpace(system + "\n\n" + instruction)
+	}
+	req := anthropicRequest{
+		Model:       a.cfg.model(opts),
+		System:      system,
+		Messages:    anthropicMessages(conversation),
+		MaxTokens:   anthropicDefaultMaxTokens,
+		Temperature: clampFloat(opts.Params.Temperature, 0, 1),
+		TopP:        opts.Params.TopP,
+		// Penalties and seed have no Messages API equivalent.
+		StopSequences: opts.Params.Stop,
+		Stream:        true,
+	}
+	if opts.Params.MaxTokens != nil {
+		req.MaxTokens = *opts.Params.MaxTokens
+	}
+	for _, tool := range opts.Tools {
+		req.Tools = append(req.Tools, anthropicTool{Name: tool.Name, Description: tool.Description, InputSchema: tool.schema()})
+	}
+
+	header := http.Header{}
+	header.Set("x-api-key", a.cfg.APIKey)
+	header.Set("anthropic-version", anthropicVersion)
+	header.Set("Accept", "text/event-stream")
+
+	var result StreamResult
+	resp, err := postJSON(ctx, a.cfg.baseURL(anthropicBaseURL)+"/messages", header, req)
+	if err != nil {
+		return result, fmt.Errorf("anthropic: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var usage anthropicUsage
+	// Content block indexes count text blocks too; tool calls are numbered
+	// on their own.
+	toolIndex := make(map[int]int)
+	err = readSSE(resp.Body, func(data []byte) error {
+		var evt anthropicEvent
+		if err := json.Unmarshal(data, &evt); err != nil {
+			return fmt.Errorf("decode event: %w", err)
+		}
+		switch evt.Type {
+		case "message_start":
+			usage.InputTokens = evt.Message.Usage.InputTokens
+		case "content_block_start":
+			if evt.ContentBlock.Type == "tool_use" {
+				toolIndex[evt.Index] = len(toolIndex)
+				return writeToolCall(writer, ToolCallDelta{Index: toolIndex[evt.Index], ID: evt.ContentBlock.ID, Name: evt.ContentBlock.Name})
+			}
+		case "content_block_delta":
+			switch {
+			case evt.Delta.Type == "text_delta" && evt.Delta.Text != "":
+				if _, err := io.WriteString(writer, evt.Delta.Text); err != nil {
+					return err
+				}
+			case evt.Delta.Type == "input_json_delta" && evt.Delta.PartialJSON != "":
+				return writeToolCall(writer, ToolCallDelta{Index: toolIndex[evt.Index], Arguments: evt.Delta.PartialJSON})
+			}
+		case "message_delta":
+			if evt.Delta.StopReason != "" {
+				result.FinishReason = anthropicFinishReason(evt.Delta.StopReason)
+			}
+			usage.OutputTokens = evt.Usage.OutputTokens
+		case "message_stop":
+			return errStreamDone
+		case "error":
+			return fmt.Errorf("%s: %s", evt.Error.Type, evt.Error.Message)
+		}
+		return nil
+	})
+	if err != nil && err != errStreamDone {
+		return result, fmt.Errorf("anthropic: %w", err)
+	}
+
+	if usage.InputTokens > 0 || usage.OutputTokens > 0 {
+		result.Usage = &Usage{
+			PromptTokens:     usage.InputTokens,
+			CompletionTokens: usage.OutputTokens,
+			TotalTokens:      usage.InputTokens + usage.OutputTokens,
+		}
+	}
+	return result, nil
+}
+
+// anthropicMessages converts the conversation, turning tool calls into
+// tool_use blocks and merging consecutive tool results into one user
+// message of tool_result blocks, as the Messages API expects.
+func anthropicMessages(conversation []ChatMessage) []anthropicMessage {
+	messages := make([]anthropicMessage, 0, len(conversation))
+	for _, msg := range conversation {
+		switch {
+		case msg.Role == "tool":
+			block := anthropicBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content}
+			if n := len(messages); n > 0 && messages[n-1].Role == "user" {
+				if blocks, ok := messages[n-1].Content.([]anthropicBlock); ok && len(blocks) > 0 && blocks[0].Type == "tool_result" {
+					messages[n-1].Content = append(blocks, block)
+					continue
+				}
+			}
+			messages = append(messages, anthropicMessage{Role: "user", Content: []anthropicBlock{block}})
+		case len(msg.ToolCalls) > 0:
+			var blocks []anthropicBlock
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: toolArguments(call.Arguments)})
+			}
+			messages = append(messages, anthropicMessage{Role: msg.Role, Content: blocks})
+		default:
+			messages = append(messages, anthropicMessage{Role: msg.Role, Content: msg.Content})
+		}
+	}
+	return messages
+}
+
+// anthropicFinishReason maps stop reasons onto the OpenAI names used by the
+// stream protocol.
+func anthropicFinishReason(reason string) string {
+	switch reason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	default:
+		return "stop"
+	}
+}
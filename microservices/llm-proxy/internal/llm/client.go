@@ -1,73 +1,37 @@
+// Package llm talks to the LLM providers behind the proxy.
 package llm
 
-import (
-	"context"
-	"fmt"
-	"io"
-
-	openai "github.com/sashabaranov/go-openai"
-	"github.com/shopmindai/llm-proxy/internal/config"
-)
-
-type Client struct {
-	cfg    config.Config
-	client *openai.Client
-}
-
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCalls are the calls made by an assistant message.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID and Name identify the call a "tool" message answers.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	Name       string `json:"name,omitempty"`
 }
 
-func New(cfg config.Config) *Client {
-	config := openai.DefaultConfig(cfg.LLMAPIKey)
-	if cfg.LLMBaseURL != "" {
-		config.BaseURL = cfg.LLMBaseURL
-	}
-	client := openai.NewClientWithConfig(config)
-	return &Client{
-		cfg:    cfg,
-		client: client,
-	}
+// ChatOptions carries the per-request overrides sent by the orchestrator.
+// Zero values fall back to the proxy configuration.
+type ChatOptions struct {
+	System string           `json:"system,omitempty"`
+	Model  string           `json:"model,omitempty"`
+	Params GenerationParams `json:"params"`
+	// Tools the model may call. Calls are streamed as ToolCallDelta values
+	// and the stream finishes with reason "tool_calls".
+	Tools []Tool `json:"tools,omitempty"`
+	// ResponseFormat asks for a JSON answer; nil means free text.
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
 }
 
-func (c *Client) StreamChat(messages []ChatMessage, writer io.Writer) error {
-	// Mapare mesaje la tipul oficial
-	openaiMessages := make([]openai.ChatCompletionMessage, len(messages))
-	for i, msg := range messages {
-		openaiMessages[i] = openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		}
-	}
-
-	req := openai.ChatCompletionRequest{
-		Model:    c.cfg.LLMModel,
-		Messages: openaiMessages,
-		Stream:   true,
-	}
-
-	stream, err := c.client.CreateChatCompletionStream(context.Background(), req)
-	if err != nil {
-		return fmt.Errorf("failed to start chat completion stream: %w", err)
-	}
-	defer stream.Close()
+// StreamResult describes how a completed stream ended.
+type StreamResult struct {
+	FinishReason string
+	Usage        *Usage
+}
 
-	for {
-		response, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("error reading from stream: %w", err)
-		}
-		if len(response.Choices) > 0 {
-			if content := response.Choices[0].Delta.Content; content != "" {
-				if _, err := fmt.Fprint(writer, content); err != nil {
-					return err
-				}
-			}
-		}
-	}
-	return nil
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
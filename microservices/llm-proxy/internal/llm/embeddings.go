@@ -0,0 +1,137 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ErrEmbeddingsUnsupported is returned for providers without an embeddings
+// API, such as Anthropic.
+var ErrEmbeddingsUnsupported = errors.New("provider does not support embeddings")
+
+// Embedder is implemented by providers that can embed text.
+type Embedder interface {
+	// Embed returns one vector per input, in input order.
+	Embed(ctx context.Context, input []string, model string) (EmbeddingResult, error)
+}
+
+type EmbeddingResult struct {
+	Vectors [][]float32
+	Usage   *Usage
+}
+
+// Embed embeds input with provider, or fails with ErrEmbeddingsUnsupported.
+func Embed(ctx context.Context, provider Provider, input []string, model string) (EmbeddingResult, error) {
+	embedder, ok := provider.(Embedder)
+	if !ok {
+		return EmbeddingResult{}, ErrEmbeddingsUnsupported
+	}
+	result, err := embedder.Embed(ctx, input, model)
+	if err != nil {
+		return result, err
+	}
+	if len(result.Vectors) != len(input) {
+		return result, fmt.Errorf("provider returned %d embeddings for %d inputs", len(result.Vectors), len(input))
+	}
+	return result, nil
+}
+
+func (c *OpenAI) Embed(ctx context.Context, input []string, model string) (EmbeddingResult, error) {
+	resp, err := c.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: input,
+		Model: openai.EmbeddingModel(model),
+	})
+	if err != nil {
+		return EmbeddingResult{}, fmt.Errorf("failed to create embeddings: %w", err)
+	}
+	result := EmbeddingResult{
+		Vectors: make([][]float32, len(input)),
+		Usage:   &Usage{PromptTokens: resp.Usage.PromptTokens, TotalTokens: resp.Usage.TotalTokens},
+	}
+	for _, data := range resp.Data {
+		if data.Index < 0 || data.Index >= len(input) {
+			return EmbeddingResult{}, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		result.Vectors[data.Index] = data.Embedding
+	}
+	return result, nil
+}
+
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+func (o *Ollama) Embed(ctx context.Context, input []string, model string) (EmbeddingResult, error) {
+	header := http.Header{}
+	if o.cfg.APIKey != "" {
+		header.Set("Authorization", "Bearer "+o.cfg.APIKey)
+	}
+	resp, err := postJSON(ctx, o.cfg.baseURL(ollamaBaseURL)+"/api/embed", header, ollamaEmbedRequest{Model: model, Input: input})
+	if err != nil {
+		return EmbeddingResult{}, fmt.Errorf("failed to create embeddings: %w", err)
+	}
+	defer resp.Body.Close()
+	var body ollamaEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return EmbeddingResult{}, fmt.Errorf("decode embeddings: %w", err)
+	}
+	return EmbeddingResult{
+		Vectors: body.Embeddings,
+		Usage:   &Usage{PromptTokens: body.PromptEvalCount, TotalTokens: body.PromptEvalCount},
+	}, nil
+}
+
+type geminiEmbedRequest struct {
+	Requests []geminiEmbedContentRequest `json:"requests"`
+}
+
+type geminiEmbedContentRequest struct {
+	Model   string        `json:"model"`
+	Content geminiContent `json:"content"`
+}
+
+type geminiEmbedResponse struct {
+	Embeddings []struct {
+		Values []float32 `json:"values"`
+	} `json:"embeddings"`
+}
+
+// Embed uses batchEmbedContents, which reports no token usage.
+func (g *Gemini) Embed(ctx context.Context, input []string, model string) (EmbeddingResult, error) {
+	req := geminiEmbedRequest{Requests: make([]geminiEmbedContentRequest, len(input))}
+	for i, text := range input {
+		req.Requests[i] = geminiEmbedContentRequest{
+			Model:   "models/" + model,
+			Content: geminiContent{Parts: []geminiPart{{Text: text}}},
+		}
+	}
+	header := http.Header{}
+	header.Set("x-goog-api-key", g.cfg.APIKey)
+	endpoint := fmt.Sprintf("%s/models/%s:batchEmbedContents", g.cfg.baseURL(geminiBaseURL), url.PathEscape(model))
+	resp, err := postJSON(ctx, endpoint, header, req)
+	if err != nil {
+		return EmbeddingResult{}, fmt.Errorf("failed to create embeddings: %w", err)
+	}
+	defer resp.Body.Close()
+	var body geminiEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return EmbeddingResult{}, fmt.Errorf("decode embeddings: %w", err)
+	}
+	result := EmbeddingResult{Vectors: make([][]float32, len(body.Embeddings))}
+	for i, embedding := range body.Embeddings {
+		result.Vectors[i] = embedding.Values
+	}
+	return result, nil
+}
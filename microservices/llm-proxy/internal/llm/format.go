@@ -0,0 +1,61 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Response format types, as named by the OpenAI API.
+const (
+	FormatJSONObject = "json_object"
+	FormatJSONSchema = "json_schema"
+)
+
+// ResponseFormat constrains an answer to JSON, optionally matching a JSON
+// Schema. Providers without native support are instructed through the
+// system prompt instead.
+type ResponseFormat struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name,omitempty"`
+	Schema json.RawMessage `json:"schema,omitempty"`
+	Strict bool            `json:"strict,omitempty"`
+}
+
+// Validate checks f. A nil format is valid and means free text.
+func (f *ResponseFormat) Validate() error {
+	if f == nil {
+		return nil
+	}
+	switch f.Type {
+	case FormatJSONObject:
+		return nil
+	case FormatJSONSchema:
+		if len(f.Schema) == 0 || !json.Valid(f.Schema) {
+			return errors.New("response_format json_schema needs a valid schema")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported response_format type %q", f.Type)
+	}
+}
+
+// jsonSchema returns the schema of a json_schema format, or nil.
+func (f *ResponseFormat) jsonSchema() json.RawMessage {
+	if f == nil || f.Type != FormatJSONSchema {
+		return nil
+	}
+	return f.Schema
+}
+
+// instruction describes f for providers that take it as a prompt.
+func (f *ResponseFormat) instruction() string {
+	if f == nil {
+		return ""
+	}
+	text := "Respond with a single JSON object and nothing else: no prose and no code fences."
+	if schema := f.jsonSchema(); schema != nil {
+		text += " The object must match this JSON Schema:\n" + string(schema)
+	}
+	return text
+}
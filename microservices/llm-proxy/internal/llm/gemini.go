@@ -0,0 +1,235 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const geminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+
+// Gemini streams from the Gemini API's streamGenerateContent method.
+type Gemini struct {
+	cfg ProviderConfig
+}
+
+func NewGemini(cfg ProviderConfig) Provider {
+	return &Gemini{cfg: cfg}
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent        `json:"contents"`
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool           `json:"tools,omitempty"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []Tool `json:"functionDeclarations"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"topP,omitempty"`
+	MaxOutputTokens  *int     `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	PresencePenalty  *float32 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequencyPenalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	// ResponseMimeType "application/json" constrains the answer to JSON,
+	// matching ResponseJSONSchema when set.
+	ResponseMimeType   string          `json:"responseMimeType,omitempty"`
+	ResponseJSONSchema json.RawMessage `json:"responseJsonSchema,omitempty"`
+}
+
+type geminiChunk struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+func (g *Gemini) StreamChat(ctx context.Context, messages []ChatMessage, opts ChatOptions, writer io.Writer) (StreamResult, error) {
+	system, conversation := splitSystem(messages, opts)
+	req := geminiRequest{
+		GenerationConfig: geminiGenerationConfig{
+			Temperature:      opts.Params.Temperature,
+			TopP:             opts.Params.TopP,
+			MaxOutputTokens:  opts.Params.MaxTokens,
+			StopSequences:    opts.Params.Stop,
+			PresencePenalty:  opts.Params.PresencePenalty,
+			FrequencyPenalty: opts.Params.FrequencyPenalty,
+			Seed:             opts.Params.Seed,
+		},
+	}
+	if opts.ResponseFormat != nil {
+		req.GenerationConfig.ResponseMimeType = "application/json"
+		req.GenerationConfig.ResponseJSONSchema = opts.ResponseFormat.jsonSchema()
+	}
+	if system != "" {
+		req.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: system}}}
+	}
+	req.Contents = geminiContents(conversation)
+	if len(opts.Tools) > 0 {
+		// Gemini rejects object schemas without properties, so
+		// parameterless tools are declared without a schema.
+		req.Tools = []geminiTool{{FunctionDeclarations: opts.Tools}}
+	}
+
+	header := http.Header{}
+	header.Set("x-goog-api-key", g.cfg.APIKey)
+	header.Set("Accept", "text/event-stream")
+	endpoint := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", g.cfg.baseURL(geminiBaseURL), url.PathEscape(g.cfg.model(opts)))
+
+	var result StreamResult
+	resp, err := postJSON(ctx, endpoint, header, req)
+	if err != nil {
+		return result, fmt.Errorf("gemini: %w", err)
+	}
+	// Function calls arrive complete and without IDs, so they are numbered
+	// here.
+	calls := 0
+	defer resp.Body.Close()
+
+	err = readSSE(resp.Body, func(data []byte) error {
+		var chunk geminiChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("decode chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("%s: %s", chunk.Error.Status, chunk.Error.Message)
+		}
+		if chunk.UsageMetadata != nil {
+			result.Usage = &Usage{
+				PromptTokens:     chunk.UsageMetadata.PromptTokenCount,
+				CompletionTokens: chunk.UsageMetadata.CandidatesTokenCount,
+				TotalTokens:      chunk.UsageMetadata.TotalTokenCount,
+			}
+		}
+		if len(chunk.Candidates) == 0 {
+			return nil
+		}
+		candidate := chunk.Candidates[0]
+		for _, part := range candidate.Content.Parts {
+			if part.FunctionCall != nil {
+				delta := ToolCallDelta{
+					Index:     calls,
+					ID:        fmt.Sprintf("call_%d", calls),
+					Name:      part.FunctionCall.Name,
+					Arguments: string(toolArguments(string(part.FunctionCall.Args))),
+				}
+				calls++
+				if err := writeToolCall(writer, delta); err != nil {
+					return err
+				}
+				continue
+			}
+			if part.Text == "" {
+				continue
+			}
+			if _, err := io.WriteString(writer, part.Text); err != nil {
+				return err
+			}
+		}
+		if candidate.FinishReason != "" {
+			result.FinishReason = geminiFinishReason(candidate.FinishReason)
+			if calls > 0 && result.FinishReason == "stop" {
+				result.FinishReason = "tool_calls"
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("gemini: %w", err)
+	}
+	return result, nil
+}
+
+// geminiContents converts the conversation. Tool calls become functionCall
+// parts of the model turn; consecutive tool results are merged into one
+// user turn of functionResponse parts.
+func geminiContents(conversation []ChatMessage) []geminiContent {
+	contents := make([]geminiContent, 0, len(conversation))
+	for _, msg := range conversation {
+		switch {
+		case msg.Role == "tool":
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{Name: msg.Name, Response: geminiResponse(msg.Content)}}
+			if n := len(contents); n > 0 && contents[n-1].Role == "user" && contents[n-1].Parts[0].FunctionResponse != nil {
+				contents[n-1].Parts = append(contents[n-1].Parts, part)
+				continue
+			}
+			contents = append(contents, geminiContent{Role: "user", Parts: []geminiPart{part}})
+		case msg.Role == "assistant":
+			var parts []geminiPart
+			if msg.Content != "" || len(msg.ToolCalls) == 0 {
+				parts = append(parts, geminiPart{Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: call.Name, Args: toolArguments(call.Arguments)}})
+			}
+			contents = append(contents, geminiContent{Role: "model", Parts: parts})
+		default:
+			contents = append(contents, geminiContent{Role: "user", Parts: []geminiPart{{Text: msg.Content}}})
+		}
+	}
+	return contents
+}
+
+// geminiResponse wraps a tool result in the object functionResponse
+// requires, unless it already is one.
+func geminiResponse(content string) json.RawMessage {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	wrapped, _ := json.Marshal(map[string]string{"result": content})
+	return wrapped
+}
+
+// geminiFinishReason maps finish reasons onto the OpenAI names used by the
+// stream protocol.
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
@@ -0,0 +1,180 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const ollamaBaseURL = "http://localhost:11434"
+
+// Ollama streams from a local Ollama server's /api/chat endpoint. It needs
+// no API key; one set is still sent as a bearer token for proxied setups.
+type Ollama struct {
+	cfg ProviderConfig
+}
+
+func NewOllama(cfg ProviderConfig) Provider {
+	return &Ollama{cfg: cfg}
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []ollamaTool    `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	// Format is "json" or a JSON Schema.
+	Format  json.RawMessage `json:"format,omitempty"`
+	Options ollamaOptions   `json:"options"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+// ollamaToolCall carries structured arguments and no call ID.
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaTool struct {
+	Type     string `json:"type"`
+	Function Tool   `json:"function"`
+}
+
+type ollamaOptions struct {
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+}
+
+type ollamaChunk struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+func (o *Ollama) StreamChat(ctx context.Context, messages []ChatMessage, opts ChatOptions, writer io.Writer) (StreamResult, error) {
+	system, conversation := splitSystem(messages, opts)
+	if system != "" {
+		conversation = append([]ChatMessage{{Role: "system", Content: system}}, conversation...)
+	}
+	req := ollamaRequest{
+		Model:    o.cfg.model(opts),
+		Messages: make([]ollamaMessage, 0, len(conversation)),
+		Stream:   true,
+		Options: ollamaOptions{
+			Temperature:      opts.Params.Temperature,
+			TopP:             opts.Params.TopP,
+			NumPredict:       opts.Params.MaxTokens,
+			Stop:             opts.Params.Stop,
+			PresencePenalty:  opts.Params.PresencePenalty,
+			FrequencyPenalty: opts.Params.FrequencyPenalty,
+			Seed:             opts.Params.Seed,
+		},
+	}
+
+	if format := opts.ResponseFormat; format != nil {
+		req.Format = json.RawMessage(`"json"`)
+		if schema := format.jsonSchema(); schema != nil {
+			req.Format = schema
+		}
+	}
+
+	for _, msg := range conversation {
+		message := ollamaMessage{Role: msg.Role, Content: msg.Content, ToolName: msg.Name}
+		for _, call := range msg.ToolCalls {
+			var oc ollamaToolCall
+			oc.Function.Name = call.Name
+			oc.Function.Arguments = toolArguments(call.Arguments)
+			message.ToolCalls = append(message.ToolCalls, oc)
+		}
+		req.Messages = append(req.Messages, message)
+	}
+	for _, tool := range opts.Tools {
+		tool.Parameters = tool.schema()
+		req.Tools = append(req.Tools, ollamaTool{Type: "function", Function: tool})
+	}
+
+	header := http.Header{}
+	if o.cfg.APIKey != "" {
+		header.Set("Authorization", "Bearer "+o.cfg.APIKey)
+	}
+
+	var result StreamResult
+	resp, err := postJSON(ctx, o.cfg.baseURL(ollamaBaseURL)+"/api/chat", header, req)
+	if err != nil {
+		return result, fmt.Errorf("ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Ollama streams newline-delimited JSON objects rather than SSE. Tool
+	// calls arrive complete and without IDs, so they are numbered here.
+	calls := 0
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var chunk ollamaChunk
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			return result, fmt.Errorf("ollama: decode chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return result, fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			if _, err := io.WriteString(writer, chunk.Message.Content); err != nil {
+				return result, err
+			}
+		}
+		for _, call := range chunk.Message.ToolCalls {
+			delta := ToolCallDelta{
+				Index:     calls,
+				ID:        fmt.Sprintf("call_%d", calls),
+				Name:      call.Function.Name,
+				Arguments: string(toolArguments(string(call.Function.Arguments))),
+			}
+			calls++
+			if err := writeToolCall(writer, delta); err != nil {
+				return result, err
+			}
+		}
+		if chunk.Done {
+			result.FinishReason = "stop"
+			switch {
+			case calls > 0:
+				result.FinishReason = "tool_calls"
+			case chunk.DoneReason == "length":
+				result.FinishReason = "length"
+			}
+			result.Usage = &Usage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+				TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+			}
+			return result, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("ollama: %w", err)
+	}
+	return result, fmt.Errorf("ollama: stream ended before done: %w", io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,155 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// OpenAI streams from the Chat Completions API, or any server compatible
+// with it.
+type OpenAI struct {
+	cfg    ProviderConfig
+	client *openai.Client
+}
+
+func NewOpenAI(cfg ProviderConfig) Provider {
+	config := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		config.BaseURL = cfg.BaseURL
+	}
+	return &OpenAI{
+		cfg:    cfg,
+		client: openai.NewClientWithConfig(config),
+	}
+}
+
+// StreamChat streams the completion text to writer, one Write per delta.
+// Cancelling ctx closes the provider connection and stops generation.
+func (c *OpenAI) StreamChat(ctx context.Context, messages []ChatMessage, opts ChatOptions, writer io.Writer) (StreamResult, error) {
+	// Mapare mesaje la tipul oficial
+	openaiMessages := make([]openai.ChatCompletionMessage, 0, len(messages)+1)
+	if opts.System != "" {
+		openaiMessages = append(openaiMessages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: opts.System,
+		})
+	}
+	for _, msg := range messages {
+		openaiMessage := openai.ChatCompletionMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			ToolCallID: msg.ToolCallID,
+			Name:       msg.Name,
+		}
+		for _, call := range msg.ToolCalls {
+			openaiMessage.ToolCalls = append(openaiMessage.ToolCalls, openai.ToolCall{
+				ID:       call.ID,
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+		openaiMessages = append(openaiMessages, openaiMessage)
+	}
+
+	req := openai.ChatCompletionRequest{
+		Model:    c.cfg.model(opts),
+		Messages: openaiMessages,
+		Stream:   true,
+		StreamOptions: &openai.StreamOptions{
+			IncludeUsage: true,
+		},
+	}
+	if opts.Params.Temperature != nil {
+		req.Temperature = *opts.Params.Temperature
+		// The field is omitempty, so a zero would fall back to the
+		// provider default; go-openai's advice is to send the smallest
+		// non-zero value instead.
+		if req.Temperature == 0 {
+			req.Temperature = math.SmallestNonzeroFloat32
+		}
+	}
+	if opts.Params.TopP != nil {
+		req.TopP = *opts.Params.TopP
+	}
+	if opts.Params.MaxTokens != nil {
+		req.MaxTokens = *opts.Params.MaxTokens
+	}
+	if opts.Params.PresencePenalty != nil {
+		req.PresencePenalty = *opts.Params.PresencePenalty
+	}
+	if opts.Params.FrequencyPenalty != nil {
+		req.FrequencyPenalty = *opts.Params.FrequencyPenalty
+	}
+	req.Stop = opts.Params.Stop
+	req.Seed = opts.Params.Seed
+	for _, tool := range opts.Tools {
+		req.Tools = append(req.Tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.schema(),
+			},
+		})
+	}
+
+	if format := opts.ResponseFormat; format != nil {
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatType(format.Type)}
+		if schema := format.jsonSchema(); schema != nil {
+			name := format.Name
+			if name == "" {
+				name = "response"
+			}
+			req.ResponseFormat.JSONSchema = &openai.ChatCompletionResponseFormatJSONSchema{Name: name, Schema: schema, Strict: format.Strict}
+		}
+	}
+
+	var result StreamResult
+	stream, err := c.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return result, fmt.Errorf("failed to start chat completion stream: %w", err)
+	}
+	defer stream.Close()
+
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("error reading from stream: %w", err)
+		}
+		if response.Usage != nil {
+			result.Usage = &Usage{
+				PromptTokens:     response.Usage.PromptTokens,
+				CompletionTokens: response.Usage.CompletionTokens,
+				TotalTokens:      response.Usage.TotalTokens,
+			}
+		}
+		if len(response.Choices) > 0 {
+			choice := response.Choices[0]
+			if choice.FinishReason != "" {
+				result.FinishReason = string(choice.FinishReason)
+			}
+			if content := choice.Delta.Content; content != "" {
+				if _, err := io.WriteString(writer, content); err != nil {
+					return result, err
+				}
+			}
+			for i, call := range choice.Delta.ToolCalls {
+				delta := ToolCallDelta{Index: i, ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments}
+				if call.Index != nil {
+					delta.Index = *call.Index
+				}
+				if err := writeToolCall(writer, delta); err != nil {
+					return result, err
+				}
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Valid ranges shared by the supported providers.
+const (
+	MaxTemperature = 2
+	MaxPenalty     = 2
+	MaxStop        = 4
+)
+
+// GenerationParams are the sampling settings of a request. Nil fields are
+// left to the next layer: request overrides, then per-model defaults, then
+// the proxy defaults, then the provider's own defaults.
+type GenerationParams struct {
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	MaxTokens        *int     `json:"max_tokens,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+}
+
+// Limits bound what a request may ask for. Zero values mean the provider
+// ranges above.
+type Limits struct {
+	MaxTokens      int
+	MaxTemperature float32
+}
+
+// Merge returns p with every field set in overrides replaced.
+func (p GenerationParams) Merge(overrides GenerationParams) GenerationParams {
+	if overrides.Temperature != nil {
+		p.Temperature = overrides.Temperature
+	}
+	if overrides.TopP != nil {
+		p.TopP = overrides.TopP
+	}
+	if overrides.MaxTokens != nil {
+		p.MaxTokens = overrides.MaxTokens
+	}
+	if overrides.Stop != nil {
+		p.Stop = overrides.Stop
+	}
+	if overrides.PresencePenalty != nil {
+		p.PresencePenalty = overrides.PresencePenalty
+	}
+	if overrides.FrequencyPenalty != nil {
+		p.FrequencyPenalty = overrides.FrequencyPenalty
+	}
+	if overrides.Seed != nil {
+		p.Seed = overrides.Seed
+	}
+	return p
+}
+
+// Validate reports out-of-range settings. It is used for configuration,
+// where a mistake should stop the proxy rather than be silently corrected.
+func (p GenerationParams) Validate(limits Limits) error {
+	var errs []error
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > limits.maxTemperature()) {
+		errs = append(errs, fmt.Errorf("temperature %v outside [0, %v]", *p.Temperature, limits.maxTemperature()))
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		errs = append(errs, fmt.Errorf("top_p %v outside (0, 1]", *p.TopP))
+	}
+	if p.MaxTokens != nil && (*p.MaxTokens <= 0 || (limits.MaxTokens > 0 && *p.MaxTokens > limits.MaxTokens)) {
+		errs = append(errs, fmt.Errorf("max_tokens %d outside [1, %d]", *p.MaxTokens, limits.MaxTokens))
+	}
+	if len(p.Stop) > MaxStop {
+		errs = append(errs, fmt.Errorf("%d stop sequences, at most %d allowed", len(p.Stop), MaxStop))
+	}
+	if p.PresencePenalty != nil && (*p.PresencePenalty < -MaxPenalty || *p.PresencePenalty > MaxPenalty) {
+		errs = append(errs, fmt.Errorf("presence_penalty %v outside [-2, 2]", *p.PresencePenalty))
+	}
+	if p.FrequencyPenalty != nil && (*p.FrequencyPenalty < -MaxPenalty || *p.FrequencyPenalty > MaxPenalty) {
+		errs = append(errs, fmt.Errorf("frequency_penalty %v outside [-2, 2]", *p.FrequencyPenalty))
+	}
+	return errors.Join(errs...)
+}
+
+// Clamp brings request settings into range instead of rejecting them.
+func (p GenerationParams) Clamp(limits Limits) GenerationParams {
+	p.Temperature = clampFloat(p.Temperature, 0, limits.maxTemperature())
+	p.TopP = clampFloat(p.TopP, 0.01, 1)
+	p.PresencePenalty = clampFloat(p.PresencePenalty, -MaxPenalty, MaxPenalty)
+	p.FrequencyPenalty = clampFloat(p.FrequencyPenalty, -MaxPenalty, MaxPenalty)
+	if p.MaxTokens != nil {
+		n := max(*p.MaxTokens, 1)
+		if limits.MaxTokens > 0 {
+			n = min(n, limits.MaxTokens)
+		}
+		p.MaxTokens = &n
+	}
+	if len(p.Stop) > MaxStop {
+		p.Stop = p.Stop[:MaxStop]
+	}
+	return p
+}
+
+func (l Limits) maxTemperature() float32 {
+	if l.MaxTemperature > 0 && l.MaxTemperature < MaxTemperature {
+		return l.MaxTemperature
+	}
+	return MaxTemperature
+}
+
+func clampFloat(v *float32, lo, hi float32) *float32 {
+	if v == nil {
+		return nil
+	}
+	clamped := min(max(*v, lo), hi)
+	return &clamped
+}
@@ -0,0 +1,34 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerationParamsMergeAndClamp(t *testing.T) {
+	defaults := GenerationParams{Temperature: float32Ptr(0.7), MaxTokens: intPtr(1000), Stop: []string{"###"}}
+	request := GenerationParams{Temperature: float32Ptr(3), MaxTokens: intPtr(100000), TopP: float32Ptr(0), FrequencyPenalty: float32Ptr(-5)}
+
+	params := defaults.Merge(request).Clamp(Limits{MaxTokens: 4096, MaxTemperature: 1.5})
+	assert.Equal(t, float32(1.5), *params.Temperature)
+	assert.Equal(t, 4096, *params.MaxTokens)
+	assert.Equal(t, float32(0.01), *params.TopP)
+	assert.Equal(t, float32(-2), *params.FrequencyPenalty)
+	assert.Equal(t, []string{"###"}, params.Stop, "unset overrides keep the defaults")
+	assert.Nil(t, params.Seed)
+}
+
+func TestGenerationParamsValidate(t *testing.T) {
+	limits := Limits{MaxTokens: 4096}
+	assert.NoError(t, GenerationParams{Temperature: float32Ptr(0.7), MaxTokens: intPtr(1000)}.Validate(limits))
+
+	err := GenerationParams{
+		Temperature: float32Ptr(2.5),
+		MaxTokens:   intPtr(8192),
+		Stop:        []string{"a", "b", "c", "d", "e"},
+	}.Validate(limits)
+	assert.ErrorContains(t, err, "temperature 2.5")
+	assert.ErrorContains(t, err, "max_tokens 8192")
+	assert.ErrorContains(t, err, "5 stop sequences")
+}
@@ -0,0 +1,161 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Provider streams chat completions from one LLM backend. Implementations
+// write each text delta to writer and stop when ctx is cancelled.
+type Provider interface {
+	StreamChat(ctx context.Context, messages []ChatMessage, opts ChatOptions, writer io.Writer) (StreamResult, error)
+}
+
+// ProviderConfig holds the connection settings of a provider. An empty
+// BaseURL selects the provider's public endpoint.
+type ProviderConfig struct {
+	APIKey  string
+	BaseURL string
+	Model   string // used when a request does not name a model
+}
+
+type Factory func(ProviderConfig) Provider
+
+// Registry maps provider names, as used in LLM_PROVIDER, to factories.
+type Registry struct {
+	factories map[string]Factory
+}
+
+// NewRegistry returns a registry with the built-in providers.
+func NewRegistry() *Registry {
+	r := &Registry{factories: make(map[string]Factory)}
+	r.Register("openai", NewOpenAI)
+	r.Register("anthropic", NewAnthropic)
+	r.Register("ollama", NewOllama)
+	r.Register("gemini", NewGemini)
+	return r
+}
+
+func (r *Registry) Register(name string, factory Factory) {
+	r.factories[strings.ToLower(name)] = factory
+}
+
+// Names returns the registered provider names in sorted order.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Registry) New(name string, cfg ProviderConfig) (Provider, error) {
+	factory, ok := r.factories[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider %q (available: %s)", name, strings.Join(r.Names(), ", "))
+	}
+	return factory(cfg), nil
+}
+
+func (c ProviderConfig) baseURL(def string) string {
+	if c.BaseURL == "" {
+		return def
+	}
+	return strings.TrimRight(c.BaseURL, "/")
+}
+
+func (c ProviderConfig) model(opts ChatOptions) string {
+	if opts.Model != "" {
+		return opts.Model
+	}
+	return c.Model
+}
+
+// splitSystem separates system messages, which most native APIs take as a
+// top-level field, from the conversation. opts.System comes first.
+func splitSystem(messages []ChatMessage, opts ChatOptions) (string, []ChatMessage) {
+	var system []string
+	if opts.System != "" {
+		system = append(system, opts.System)
+	}
+	conversation := make([]ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
+		conversation = append(conversation, msg)
+	}
+	return strings.Join(system, "\n\n"), conversation
+}
+
+// postJSON sends body to url and returns the response of a successful
+// request. Error responses are turned into errors carrying the body.
+func postJSON(ctx context.Context, url string, header http.Header, body any) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// readSSE calls fn with the data of each server-sent event in r.
+func readSSE(r io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var data []string
+	flush := func() error {
+		if len(data) == 0 {
+			return nil
+		}
+		payload := strings.Join(data, "\n")
+		data = data[:0]
+		return fn([]byte(payload))
+	}
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			if err := flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, strings.TrimPrefix(value, " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// errStreamDone stops readSSE early without reporting an error.
+var errStreamDone = errors.New("stream done")
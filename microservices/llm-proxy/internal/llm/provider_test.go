@@ -0,0 +1,406 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func float32Ptr(v float32) *float32 { return &v }
+func intPtr(v int) *int             { return &v }
+
+var testMessages = []ChatMessage{
+	{Role: "user", Content: "Hi"},
+	{Role: "assistant", Content: "Hello!"},
+	{Role: "user", Content: "Find socks"},
+}
+
+var testOptions = ChatOptions{
+	System: "You are a shopping assistant.",
+	Params: GenerationParams{Temperature: float32Ptr(0.2), MaxTokens: intPtr(64)},
+}
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	assert.Equal(t, []string{"anthropic", "gemini", "ollama", "openai"}, reg.Names())
+
+	provider, err := reg.New("Anthropic", ProviderConfig{})
+	require.NoError(t, err)
+	assert.IsType(t, &Anthropic{}, provider)
+
+	_, err = reg.New("mistral", ProviderConfig{})
+	assert.ErrorContains(t, err, `unknown LLM provider "mistral"`)
+}
+
+func TestAnthropicStreamChat(t *testing.T) {
+	var got anthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/messages", r.URL.Path)
+		assert.Equal(t, "key", r.Header.Get("x-api-key"))
+		assert.Equal(t, anthropicVersion, r.Header.Get("anthropic-version"))
+		_ = json.NewDecoder(r.Body).Decode(&got)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: message_start\n" +
+			`data: {"type":"message_start","message":{"usage":{"input_tokens":12,"output_tokens":1}}}` + "\n\n" +
+			"event: ping\ndata: {\"type\":\"ping\"}\n\n" +
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Wool"}}` + "\n\n" +
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" socks\n"}}` + "\n\n" +
+			`data: {"type":"message_delta","delta":{"stop_reason":"max_tokens"},"usage":{"output_tokens":5}}` + "\n\n" +
+			`data: {"type":"message_stop"}` + "\n\n"))
+	}))
+	defer server.Close()
+
+	var out strings.Builder
+	provider := NewAnthropic(ProviderConfig{APIKey: "key", BaseURL: server.URL + "/v1", Model: "claude-3-5-haiku-latest"})
+	result, err := provider.StreamChat(context.Background(), append([]ChatMessage{{Role: "system", Content: "Be brief."}}, testMessages...), testOptions, &out)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Wool socks\n", out.String())
+	assert.Equal(t, "length", result.FinishReason)
+	assert.Equal(t, &Usage{PromptTokens: 12, CompletionTokens: 5, TotalTokens: 17}, result.Usage)
+
+	assert.Equal(t, "claude-3-5-haiku-latest", got.Model)
+	assert.Equal(t, "You are a shopping assistant.\n\nBe brief.", got.System)
+	assert.Equal(t, []anthropicMessage{
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello!"},
+		{Role: "user", Content: "Find socks"},
+	}, got.Messages)
+	assert.Equal(t, 64, got.MaxTokens)
+	assert.True(t, got.Stream)
+}
+
+func TestAnthropicStreamChatError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"Hi"}}` + "\n\n" +
+			`data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}` + "\n\n"))
+	}))
+	defer server.Close()
+
+	var out strings.Builder
+	_, err := NewAnthropic(ProviderConfig{BaseURL: server.URL}).StreamChat(context.Background(), testMessages, ChatOptions{}, &out)
+	assert.ErrorContains(t, err, "overloaded_error: Overloaded")
+	assert.Equal(t, "Hi", out.String())
+}
+
+func TestOllamaStreamChat(t *testing.T) {
+	var got ollamaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/chat", r.URL.Path)
+		assert.Empty(t, r.Header.Get("Authorization"))
+		_ = json.NewDecoder(r.Body).Decode(&got)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"Wool"},"done":false}` + "\n" +
+			`{"message":{"role":"assistant","content":" socks"},"done":false}` + "\n" +
+			`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":20,"eval_count":3}` + "\n"))
+	}))
+	defer server.Close()
+
+	var out strings.Builder
+	provider := NewOllama(ProviderConfig{BaseURL: server.URL, Model: "llama3.1"})
+	result, err := provider.StreamChat(context.Background(), testMessages, testOptions, &out)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Wool socks", out.String())
+	assert.Equal(t, "stop", result.FinishReason)
+	assert.Equal(t, &Usage{PromptTokens: 20, CompletionTokens: 3, TotalTokens: 23}, result.Usage)
+
+	assert.Equal(t, "llama3.1", got.Model)
+	if assert.Len(t, got.Messages, 4) {
+		assert.Equal(t, ollamaMessage{Role: "system", Content: "You are a shopping assistant."}, got.Messages[0])
+	}
+	assert.Equal(t, 64, *got.Options.NumPredict)
+	assert.InDelta(t, 0.2, *got.Options.Temperature, 0.0001)
+}
+
+func TestOllamaStreamChatErrors(t *testing.T) {
+	missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"model \"nope\" not found"}`, http.StatusNotFound)
+	}))
+	defer missing.Close()
+	_, err := NewOllama(ProviderConfig{BaseURL: missing.URL, Model: "nope"}).StreamChat(context.Background(), testMessages, ChatOptions{}, &strings.Builder{})
+	assert.ErrorContains(t, err, "404 Not Found")
+
+	truncated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"Hi"},"done":false}` + "\n"))
+	}))
+	defer truncated.Close()
+	_, err = NewOllama(ProviderConfig{BaseURL: truncated.URL}).StreamChat(context.Background(), testMessages, ChatOptions{}, &strings.Builder{})
+	assert.ErrorContains(t, err, "stream ended before done")
+}
+
+func TestGeminiStreamChat(t *testing.T) {
+	var got geminiRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1beta/models/gemini-1.5-flash:streamGenerateContent", r.URL.Path)
+		assert.Equal(t, "sse", r.URL.Query().Get("alt"))
+		assert.Equal(t, "key", r.Header.Get("x-goog-api-key"))
+		_ = json.NewDecoder(r.Body).Decode(&got)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Wool"}]}}]}` + "\r\n\r\n" +
+			`data: {"candidates":[{"content":{"role":"model","parts":[{"text":" socks"}]},"finishReason":"MAX_TOKENS"}],` +
+			`"usageMetadata":{"promptTokenCount":9,"candidatesTokenCount":2,"totalTokenCount":11}}` + "\r\n\r\n"))
+	}))
+	defer server.Close()
+
+	var out strings.Builder
+	provider := NewGemini(ProviderConfig{APIKey: "key", BaseURL: server.URL + "/v1beta", Model: "gemini-1.5-flash"})
+	result, err := provider.StreamChat(context.Background(), testMessages, testOptions, &out)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Wool socks", out.String())
+	assert.Equal(t, "length", result.FinishReason)
+	assert.Equal(t, &Usage{PromptTokens: 9, CompletionTokens: 2, TotalTokens: 11}, result.Usage)
+
+	if assert.NotNil(t, got.SystemInstruction) {
+		assert.Equal(t, "You are a shopping assistant.", got.SystemInstruction.Parts[0].Text)
+	}
+	if assert.Len(t, got.Contents, 3) {
+		assert.Equal(t, "model", got.Contents[1].Role)
+		assert.Equal(t, "Find socks", got.Contents[2].Parts[0].Text)
+	}
+	assert.Equal(t, 64, *got.GenerationConfig.MaxOutputTokens)
+}
+
+// toolRecorder collects text and tool call deltas like a stream output.
+type toolRecorder struct {
+	strings.Builder
+	calls []ToolCallDelta
+}
+
+func (r *toolRecorder) WriteToolCall(delta ToolCallDelta) error {
+	r.calls = append(r.calls, delta)
+	return nil
+}
+
+var toolConversation = []ChatMessage{
+	{Role: "user", Content: "Find socks"},
+	{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_a", Name: "search", Arguments: `{"q":"socks"}`}}},
+	{Role: "tool", ToolCallID: "call_a", Name: "search", Content: `{"hits":2}`},
+}
+
+func TestAnthropicToolCalls(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"type":"content_block_start","index":0,"content_block":{"type":"text"}}` + "\n\n" +
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking."}}` + "\n\n" +
+			`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"price"}}` + "\n\n" +
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"sku\":"}}` + "\n\n" +
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"A1\"}"}}` + "\n\n" +
+			`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"}}` + "\n\n"))
+	}))
+	defer server.Close()
+
+	var out toolRecorder
+	opts := ChatOptions{Tools: []Tool{{Name: "price", Description: "Look up a price"}}}
+	result, err := NewAnthropic(ProviderConfig{BaseURL: server.URL}).StreamChat(context.Background(), toolConversation, opts, &out)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Checking.", out.String())
+	assert.Equal(t, "tool_calls", result.FinishReason)
+	assert.Equal(t, []ToolCallDelta{
+		{Index: 0, ID: "toolu_1", Name: "price"},
+		{Index: 0, Arguments: `{"sku":`},
+		{Index: 0, Arguments: `"A1"}`},
+	}, out.calls)
+
+	tools := got["tools"].([]any)
+	assert.Equal(t, map[string]any{"type": "object", "properties": map[string]any{}}, tools[0].(map[string]any)["input_schema"])
+	messages := got["messages"].([]any)
+	require.Len(t, messages, 3)
+	assert.Equal(t, []any{map[string]any{"type": "tool_use", "id": "call_a", "name": "search", "input": map[string]any{"q": "socks"}}},
+		messages[1].(map[string]any)["content"])
+	assert.Equal(t, map[string]any{"role": "user", "content": []any{map[string]any{"type": "tool_result", "tool_use_id": "call_a", "content": `{"hits":2}`}}},
+		messages[2])
+}
+
+func TestGeminiToolCalls(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"price","args":{"sku":"A1"}}}]},"finishReason":"STOP"}]}` + "\n\n"))
+	}))
+	defer server.Close()
+
+	var out toolRecorder
+	opts := ChatOptions{Tools: []Tool{{Name: "price"}}}
+	result, err := NewGemini(ProviderConfig{BaseURL: server.URL}).StreamChat(context.Background(), toolConversation, opts, &out)
+	require.NoError(t, err)
+
+	assert.Equal(t, "tool_calls", result.FinishReason)
+	assert.Equal(t, []ToolCallDelta{{Index: 0, ID: "call_0", Name: "price", Arguments: `{"sku":"A1"}`}}, out.calls)
+
+	assert.Equal(t, []any{map[string]any{"functionDeclarations": []any{map[string]any{"name": "price"}}}}, got["tools"])
+	contents := got["contents"].([]any)
+	require.Len(t, contents, 3)
+	assert.Equal(t, map[string]any{"role": "model", "parts": []any{map[string]any{"functionCall": map[string]any{"name": "search", "args": map[string]any{"q": "socks"}}}}},
+		contents[1])
+	assert.Equal(t, map[string]any{"role": "user", "parts": []any{map[string]any{"functionResponse": map[string]any{"name": "search", "response": map[string]any{"hits": float64(2)}}}}},
+		contents[2])
+}
+
+func TestToolCallsNeedToolCallWriter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"price","arguments":{}}}]},"done":false}` + "\n"))
+	}))
+	defer server.Close()
+
+	_, err := NewOllama(ProviderConfig{BaseURL: server.URL}).StreamChat(context.Background(), testMessages, ChatOptions{}, &strings.Builder{})
+	assert.ErrorIs(t, err, ErrToolsUnsupported)
+}
+
+func TestOpenAISendsZeroTemperature(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":"stop"}]}` + "\n\n" +
+			"data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	var out strings.Builder
+	provider := NewOpenAI(ProviderConfig{APIKey: "key", BaseURL: server.URL + "/v1", Model: "gpt-4o-mini"})
+	_, err := provider.StreamChat(context.Background(), testMessages, ChatOptions{Params: GenerationParams{Temperature: float32Ptr(0)}}, &out)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hi", out.String())
+	require.Contains(t, got, "temperature")
+	assert.InDelta(t, 0, got["temperature"], 1e-6)
+}
+
+func TestOpenAIEmbed(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/embeddings", r.URL.Path)
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"object":"list","data":[` +
+			`{"object":"embedding","index":1,"embedding":[0,1]},` +
+			`{"object":"embedding","index":0,"embedding":[1,0]}],` +
+			`"usage":{"prompt_tokens":4,"total_tokens":4}}`))
+	}))
+	defer server.Close()
+
+	provider := NewOpenAI(ProviderConfig{APIKey: "key", BaseURL: server.URL + "/v1"})
+	result, err := Embed(context.Background(), provider, []string{"wool socks", "tents"}, "text-embedding-3-small")
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]float32{{1, 0}, {0, 1}}, result.Vectors)
+	assert.Equal(t, &Usage{PromptTokens: 4, TotalTokens: 4}, result.Usage)
+	assert.Equal(t, "text-embedding-3-small", got["model"])
+}
+
+func TestOllamaEmbed(t *testing.T) {
+	var got ollamaEmbedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/embed", r.URL.Path)
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"embeddings":[[0.5,0.5]],"prompt_eval_count":3}`))
+	}))
+	defer server.Close()
+
+	result, err := Embed(context.Background(), NewOllama(ProviderConfig{BaseURL: server.URL}), []string{"socks"}, "nomic-embed-text")
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]float32{{0.5, 0.5}}, result.Vectors)
+	assert.Equal(t, &Usage{PromptTokens: 3, TotalTokens: 3}, result.Usage)
+	assert.Equal(t, ollamaEmbedRequest{Model: "nomic-embed-text", Input: []string{"socks"}}, got)
+}
+
+func TestGeminiEmbed(t *testing.T) {
+	var got geminiEmbedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/models/text-embedding-004:batchEmbedContents", r.URL.Path)
+		assert.Equal(t, "key", r.Header.Get("x-goog-api-key"))
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"embeddings":[{"values":[1,2]},{"values":[3,4]}]}`))
+	}))
+	defer server.Close()
+
+	provider := NewGemini(ProviderConfig{APIKey: "key", BaseURL: server.URL})
+	result, err := Embed(context.Background(), provider, []string{"socks", "boots"}, "text-embedding-004")
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]float32{{1, 2}, {3, 4}}, result.Vectors)
+	if assert.Len(t, got.Requests, 2) {
+		assert.Equal(t, "models/text-embedding-004", got.Requests[0].Model)
+		assert.Equal(t, "boots", got.Requests[1].Content.Parts[0].Text)
+	}
+}
+
+func TestEmbedErrors(t *testing.T) {
+	_, err := Embed(context.Background(), NewAnthropic(ProviderConfig{APIKey: "key"}), []string{"socks"}, "claude")
+	assert.ErrorIs(t, err, ErrEmbeddingsUnsupported)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"embeddings":[[1]]}`))
+	}))
+	defer server.Close()
+	_, err = Embed(context.Background(), NewOllama(ProviderConfig{BaseURL: server.URL}), []string{"a", "b"}, "m")
+	assert.ErrorContains(t, err, "returned 1 embeddings for 2 inputs")
+}
+
+func TestResponseFormat(t *testing.T) {
+	schema := json.RawMessage(`{"type":"object","properties":{"sku":{"type":"string"}}}`)
+	format := &ResponseFormat{Type: FormatJSONSchema, Schema: schema}
+	opts := testOptions
+	opts.ResponseFormat = format
+
+	assert.NoError(t, (*ResponseFormat)(nil).Validate())
+	assert.NoError(t, (&ResponseFormat{Type: FormatJSONObject}).Validate())
+	assert.Error(t, (&ResponseFormat{Type: FormatJSONSchema}).Validate())
+	assert.Error(t, (&ResponseFormat{Type: "yaml"}).Validate())
+
+	var body map[string]json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch {
+		case r.URL.Path == "/api/chat":
+			_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"{}"},"done":true,"done_reason":"stop"}` + "\n"))
+		case r.URL.Path == "/v1/messages":
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"}}` + "\n\n"))
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"{}"}]},"finishReason":"STOP"}]}` + "\n\n"))
+		}
+	}))
+	defer server.Close()
+
+	_, err := NewOllama(ProviderConfig{BaseURL: server.URL, Model: "llama3.1"}).StreamChat(context.Background(), testMessages, opts, io.Discard)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(schema), string(body["format"]))
+
+	_, err = NewGemini(ProviderConfig{APIKey: "key", BaseURL: server.URL + "/v1beta", Model: "gemini-1.5-flash"}).StreamChat(context.Background(), testMessages, opts, io.Discard)
+	require.NoError(t, err)
+	var config geminiGenerationConfig
+	require.NoError(t, json.Unmarshal(body["generationConfig"], &config))
+	assert.Equal(t, "application/json", config.ResponseMimeType)
+	assert.JSONEq(t, string(schema), string(config.ResponseJSONSchema))
+
+	_, err = NewAnthropic(ProviderConfig{APIKey: "key", BaseURL: server.URL + "/v1", Model: "claude-3-5-haiku-latest"}).StreamChat(context.Background(), testMessages, opts, io.Discard)
+	require.NoError(t, err)
+	var system string
+	require.NoError(t, json.Unmarshal(body["system"], &system))
+	assert.True(t, strings.HasPrefix(system, "You are a shopping assistant.\n\nRespond with a single JSON object"), system)
+	assert.Contains(t, system, string(schema))
+}
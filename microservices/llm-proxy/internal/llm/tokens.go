@@ -0,0 +1,92 @@
+package llm
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Token estimates stand in for the providers' tokenizers, which are not
+// available offline for every provider. They err on the high side: ASCII
+// text averages about four characters per token, other scripts about one
+// character per token.
+const (
+	// messageOverhead covers the role and delimiters of each message.
+	messageOverhead = 4
+	// replyOverhead primes the assistant's reply.
+	replyOverhead = 3
+)
+
+// TokenCount is the estimated size of a chat request.
+type TokenCount struct {
+	// Total covers everything sent: system prompt, tools, messages and
+	// reply priming.
+	Total int
+	// Messages holds each message's share of Total, in order.
+	Messages []int
+}
+
+// EstimateTokens estimates the tokens of text.
+func EstimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// CountTokens estimates the prompt tokens of a chat request.
+func CountTokens(messages []ChatMessage, opts ChatOptions) TokenCount {
+	count := TokenCount{Total: replyOverhead, Messages: make([]int, len(messages))}
+	if opts.System != "" {
+		count.Total += messageOverhead + EstimateTokens(opts.System)
+	}
+	for _, tool := range opts.Tools {
+		count.Total += EstimateTokens(tool.Name) + EstimateTokens(tool.Description) + EstimateTokens(string(tool.Parameters))
+	}
+	for i, msg := range messages {
+		n := messageOverhead + EstimateTokens(msg.Content)
+		for _, call := range msg.ToolCalls {
+			n += EstimateTokens(call.Name) + EstimateTokens(call.Arguments)
+		}
+		count.Messages[i] = n
+		count.Total += n
+	}
+	return count
+}
+
+// knownContextWindows are the context windows, in tokens, of common model
+// families, matched by model name prefix; the longest prefix wins.
+var knownContextWindows = map[string]int{
+	"gpt-3.5-turbo":     16385,
+	"gpt-4":             8192,
+	"gpt-4-turbo":       128000,
+	"gpt-4o":            128000,
+	"gpt-4.1":           1047576,
+	"o1":                200000,
+	"o3":                200000,
+	"o4-mini":           200000,
+	"claude-":           200000,
+	"gemini-1.5":        1048576,
+	"gemini-2":          1048576,
+	"llama3":            8192,
+	"llama3.1":          131072,
+	"llama3.2":          131072,
+	"mistral":           32768,
+	"qwen2.5":           32768,
+	"text-embedding-3-": 8191,
+}
+
+// ContextWindow returns the context window of a well-known model, or 0.
+func ContextWindow(model string) int {
+	best, window := 0, 0
+	for prefix, size := range knownContextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best, window = len(prefix), size
+		}
+	}
+	return window
+}
@@ -0,0 +1,27 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountTokens(t *testing.T) {
+	assert.Equal(t, 0, EstimateTokens(""))
+	assert.Equal(t, 3, EstimateTokens("wool socks"), "about four ASCII characters per token, rounded up")
+	assert.Equal(t, 2, EstimateTokens("靴下"), "non-ASCII characters count one token each")
+
+	count := CountTokens([]ChatMessage{
+		{Role: "user", Content: "Find wool socks"},
+		{Role: "assistant", ToolCalls: []ToolCall{{Name: "search", Arguments: `{"q":"wool socks"}`}}},
+	}, ChatOptions{System: "Be brief."})
+	assert.Equal(t, []int{4 + 4, 4 + 2 + 5}, count.Messages)
+	assert.Equal(t, 3+(4+3)+8+11, count.Total)
+}
+
+func TestContextWindow(t *testing.T) {
+	assert.Equal(t, 8192, ContextWindow("gpt-4"))
+	assert.Equal(t, 128000, ContextWindow("gpt-4o-mini"), "the longest prefix wins")
+	assert.Equal(t, 131072, ContextWindow("llama3.1:8b"))
+	assert.Equal(t, 0, ContextWindow("acme-1"))
+}
@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Tool is a function the model may call. Parameters is a JSON Schema object
+// describing the arguments.
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ToolCall is a call the model made, as carried by the assistant message
+// that precedes the tool results. Arguments is a JSON object.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolCallDelta is a streamed fragment of the Index-th tool call of a turn.
+// The first fragment of a call carries its ID and name; the Arguments of
+// all fragments concatenate to the call's JSON arguments.
+type ToolCallDelta struct {
+	Index     int    `json:"index"`
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// ToolCallWriter is implemented by stream outputs that accept tool calls
+// alongside text deltas.
+type ToolCallWriter interface {
+	WriteToolCall(delta ToolCallDelta) error
+}
+
+var ErrToolsUnsupported = errors.New("output does not accept tool calls")
+
+func writeToolCall(w io.Writer, delta ToolCallDelta) error {
+	tw, ok := w.(ToolCallWriter)
+	if !ok {
+		return ErrToolsUnsupported
+	}
+	return tw.WriteToolCall(delta)
+}
+
+// emptySchema is sent for tools without parameters; several APIs reject a
+// missing schema.
+var emptySchema = json.RawMessage(`{"type":"object","properties":{}}`)
+
+func (t Tool) schema() json.RawMessage {
+	if len(t.Parameters) == 0 {
+		return emptySchema
+	}
+	return t.Parameters
+}
+
+// toolArguments returns a call's arguments as a JSON object for APIs that
+// take them structured rather than as a string.
+func toolArguments(arguments string) json.RawMessage {
+	if !json.Valid([]byte(arguments)) || arguments == "" {
+		return json.RawMessage(`{}`)
+	}
+	return json.RawMessage(arguments)
+}
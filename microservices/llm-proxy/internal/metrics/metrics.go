@@ -0,0 +1,80 @@
+// Package metrics exposes llm-proxy's Prometheus metrics.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/shopmindai/llm-proxy/internal/llm"
+)
+
+// Outcomes of a request.
+const (
+	OutcomeOK        = "ok"
+	OutcomeError     = "error"
+	OutcomeCancelled = "cancelled"
+	// OutcomeStalled is a stream abandoned because the provider stopped
+	// producing output.
+	OutcomeStalled = "stalled"
+)
+
+// Metrics counts the requests served by each endpoint, per calling service,
+// provider and model. Every server gets its own registry, so tests can create several.
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	tokens   *prometheus.CounterVec
+	stalls   *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "llm_proxy_requests_total",
+			Help: "LLM requests by caller, endpoint, provider, model and outcome.",
+		}, []string{"caller", "endpoint", "provider", "model", "outcome"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "llm_proxy_request_duration_seconds",
+			Help:    "Time to serve an LLM request, streams included.",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, []string{"endpoint", "provider"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "llm_proxy_tokens_total",
+			Help: "Tokens reported by providers, by caller, endpoint, provider, model and type (prompt or completion).",
+		}, []string{"caller", "endpoint", "provider", "model", "type"}),
+		stalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "llm_proxy_stalled_streams_total",
+			Help: "Streams aborted because the provider stopped producing output, by endpoint, provider and model.",
+		}, []string{"endpoint", "provider", "model"}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.duration, m.tokens, m.stalls,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Observe records a finished request. usage may be nil.
+func (m *Metrics) Observe(caller, endpoint, provider, model, outcome string, elapsed time.Duration, usage *llm.Usage) {
+	m.requests.WithLabelValues(caller, endpoint, provider, model, outcome).Inc()
+	m.duration.WithLabelValues(endpoint, provider).Observe(elapsed.Seconds())
+	if outcome == OutcomeStalled {
+		m.stalls.WithLabelValues(endpoint, provider, model).Inc()
+	}
+	if usage != nil {
+		m.tokens.WithLabelValues(caller, endpoint, provider, model, "prompt").Add(float64(usage.PromptTokens))
+		m.tokens.WithLabelValues(caller, endpoint, provider, model, "completion").Add(float64(usage.CompletionTokens))
+	}
+}
@@ -0,0 +1,333 @@
+// Package routing maps the model a caller asks for onto an ordered list of
+// provider/model targets and falls back along that list when a target fails
+// before producing any output.
+//
+// Routes are loaded from the JSON file named by LLM_ROUTES_FILE:
+//
+//	{
+//	  "providers": {
+//	    "openai": {"type": "openai", "api_key_env": "OPENAI_API_KEY"},
+//	    "local":  {"type": "ollama", "base_url": "http://ollama:11434"}
+//	  },
+//	  "routes": {
+//	    "shop-fast":  [{"provider": "local", "model": "llama3.1"}, {"provider": "openai", "model": "gpt-4o-mini"}],
+//	    "shop-smart": [{"provider": "openai", "model": "gpt-4o"}]
+//	  },
+//	  "models": {
+//	    "llama3.1": {"temperature": 0.3, "max_tokens": 512, "context_window": 8192}
+//	  }
+//	}
+//
+// The provider configured through LLM_PROVIDER/LLM_API_KEY/LLM_BASE_URL is
+// always available under its type name unless the file redefines it. A
+// route named "default" serves requests that do not name a model. "models"
+// holds per-model generation defaults, layered between the LLM_* defaults
+// and the request's own settings, and context windows for models the proxy
+// does not know.
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/llm-proxy/internal/config"
+	"github.com/shopmindai/llm-proxy/internal/llm"
+)
+
+const defaultRoute = "default"
+
+type Target struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+type ProviderSettings struct {
+	Type      string `json:"type"`
+	BaseURL   string `json:"base_url"`
+	APIKeyEnv string `json:"api_key_env"`
+}
+
+// ModelSettings are a model's generation defaults and context window in
+// tokens.
+type ModelSettings struct {
+	llm.GenerationParams
+	ContextWindow int `json:"context_window,omitempty"`
+}
+
+type File struct {
+	Providers map[string]ProviderSettings `json:"providers"`
+	Routes    map[string][]Target         `json:"routes"`
+	Models    map[string]ModelSettings    `json:"models"`
+}
+
+// Output receives a routed stream. Route is called once, before the first
+// write or tool call, with the target that is answering.
+type Output interface {
+	io.Writer
+	llm.ToolCallWriter
+	Route(provider, model string) error
+}
+
+type Table struct {
+	cfg             config.Config
+	providers       map[string]llm.Provider
+	routes          map[string][]Target
+	models          map[string]ModelSettings
+	defaultProvider string
+}
+
+// Load builds the routing table for cfg, reading cfg.LLMRoutesFile if set.
+func Load(cfg config.Config, registry *llm.Registry) (*Table, error) {
+	var file File
+	if cfg.LLMRoutesFile != "" {
+		data, err := os.ReadFile(cfg.LLMRoutesFile)
+		if err != nil {
+			return nil, fmt.Errorf("read routes file: %w", err)
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("parse routes file %s: %w", cfg.LLMRoutesFile, err)
+		}
+	}
+	return New(cfg, registry, file)
+}
+
+// New validates file and builds its providers. Every target must name a
+// known provider and every route must have at least one target.
+func New(cfg config.Config, registry *llm.Registry, file File) (*Table, error) {
+	t := &Table{
+		cfg:             cfg,
+		providers:       make(map[string]llm.Provider),
+		routes:          file.Routes,
+		models:          file.Models,
+		defaultProvider: cfg.LLMProvider,
+	}
+	if t.defaultProvider == "" {
+		t.defaultProvider = "openai"
+	}
+
+	if _, ok := file.Providers[t.defaultProvider]; !ok {
+		provider, err := registry.New(t.defaultProvider, llm.ProviderConfig{
+			APIKey:  cfg.LLMAPIKey,
+			BaseURL: cfg.LLMBaseURL,
+			Model:   cfg.LLMModel,
+		})
+		if err != nil {
+			return nil, err
+		}
+		t.providers[t.defaultProvider] = provider
+	}
+	for name, settings := range file.Providers {
+		providerType := settings.Type
+		if providerType == "" {
+			providerType = name
+		}
+		provider, err := registry.New(providerType, llm.ProviderConfig{
+			APIKey:  os.Getenv(settings.APIKeyEnv),
+			BaseURL: settings.BaseURL,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", name, err)
+		}
+		t.providers[name] = provider
+	}
+
+	for alias, targets := range file.Routes {
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("route %q has no targets", alias)
+		}
+		for _, target := range targets {
+			if _, ok := t.providers[target.Provider]; !ok {
+				return nil, fmt.Errorf("route %q: unknown provider %q", alias, target.Provider)
+			}
+			if target.Model == "" {
+				return nil, fmt.Errorf("route %q: target %q has no model", alias, target.Provider)
+			}
+		}
+	}
+	for model, settings := range file.Models {
+		if err := settings.Validate(cfg.LLMLimits); err != nil {
+			return nil, fmt.Errorf("model %q defaults: %w", model, err)
+		}
+		if settings.ContextWindow < 0 {
+			return nil, fmt.Errorf("model %q: context_window must not be negative", model)
+		}
+	}
+	return t, nil
+}
+
+// Params layers the generation settings for model: proxy defaults, the
+// model's defaults, then the request's overrides clamped to the limits.
+func (t *Table) Params(model string, overrides llm.GenerationParams) llm.GenerationParams {
+	return t.cfg.LLMDefaults.Merge(t.models[model].GenerationParams).Merge(overrides).Clamp(t.cfg.LLMLimits)
+}
+
+// Limits are the token limits of a request routed to some targets.
+type Limits struct {
+	// ContextWindow is the smallest context window among the targets, so
+	// that a request fitting it also fits every fallback. It is 0 when
+	// unknown.
+	ContextWindow int `json:"context_window"`
+	// MaxOutputTokens is the largest completion the targets may produce
+	// with the request's settings, or 0 when unbounded.
+	MaxOutputTokens int `json:"max_output_tokens"`
+}
+
+// Limits returns the token limits of a request to targets with the given
+// generation overrides. A model's context window comes from the routes
+// file, then the well-known models, then LLM_CONTEXT_WINDOW.
+func (t *Table) Limits(targets []Target, overrides llm.GenerationParams) Limits {
+	var limits Limits
+	for _, target := range targets {
+		window := t.models[target.Model].ContextWindow
+		if window == 0 {
+			window = llm.ContextWindow(target.Model)
+		}
+		if window == 0 {
+			window = t.cfg.LLMContextWindow
+		}
+		if window > 0 && (limits.ContextWindow == 0 || window < limits.ContextWindow) {
+			limits.ContextWindow = window
+		}
+		if maxTokens := t.Params(target.Model, overrides).MaxTokens; maxTokens != nil && *maxTokens > limits.MaxOutputTokens {
+			limits.MaxOutputTokens = *maxTokens
+		}
+	}
+	return limits
+}
+
+// Resolve returns the targets serving model: an alias's route, or the
+// default provider for an allowed model. ok is false for unknown models.
+func (t *Table) Resolve(model string) (targets []Target, ok bool) {
+	if model == "" {
+		model = defaultRoute
+	}
+	if route, ok := t.routes[model]; ok {
+		return route, true
+	}
+	if model == defaultRoute {
+		return []Target{{Provider: t.defaultProvider, Model: t.cfg.LLMModel}}, true
+	}
+	if !t.cfg.ModelAllowed(model) {
+		return nil, false
+	}
+	return []Target{{Provider: t.defaultProvider, Model: model}}, true
+}
+
+// Model is a model callers may request, with the provider serving it
+// first.
+type Model struct {
+	ID       string `json:"id"`
+	Provider string `json:"provider"`
+}
+
+// Models lists the models and aliases callers may request, sorted by ID.
+func (t *Table) Models() []Model {
+	byID := make(map[string]string)
+	for _, model := range append([]string{t.cfg.LLMModel, t.cfg.LLMEmbeddingModel}, t.cfg.LLMAllowedModels...) {
+		if model != "" {
+			byID[model] = t.defaultProvider
+		}
+	}
+	for alias, targets := range t.routes {
+		byID[alias] = targets[0].Provider
+	}
+	models := make([]Model, 0, len(byID))
+	for id, provider := range byID {
+		models = append(models, Model{ID: id, Provider: provider})
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models
+}
+
+// ResolveEmbedding returns the target serving embeddings for model. Unlike
+// chat, embeddings never fall back: vectors from different models cannot
+// be compared, so only the first target of an alias is used.
+func (t *Table) ResolveEmbedding(model string) (Target, bool) {
+	if model == "" {
+		return Target{Provider: t.defaultProvider, Model: t.cfg.LLMEmbeddingModel}, true
+	}
+	if route, ok := t.routes[model]; ok {
+		return route[0], true
+	}
+	if !t.cfg.EmbeddingModelAllowed(model) {
+		return Target{}, false
+	}
+	return Target{Provider: t.defaultProvider, Model: model}, true
+}
+
+// Embed embeds input with target.
+func (t *Table) Embed(ctx context.Context, target Target, input []string) (llm.EmbeddingResult, error) {
+	return llm.Embed(ctx, t.providers[target.Provider], input, target.Model)
+}
+
+// Stream tries targets in order. A target failing with a retryable error
+// before writing any output is skipped for the next one; once output has
+// started, or for any other error, the error is returned as is.
+func (t *Table) Stream(ctx context.Context, targets []Target, messages []llm.ChatMessage, opts llm.ChatOptions, out Output) (llm.StreamResult, Target, error) {
+	var result llm.StreamResult
+	var err error
+	overrides := opts.Params
+	for i, target := range targets {
+		opts.Model = target.Model
+		opts.Params = t.Params(target.Model, overrides)
+		w := &targetWriter{out: out, target: target}
+		result, err = t.providers[target.Provider].StreamChat(ctx, messages, opts, w)
+		if err == nil {
+			if !w.started {
+				err = out.Route(target.Provider, target.Model)
+			}
+			return result, target, err
+		}
+
+		last := i == len(targets)-1
+		if w.started || last || ctx.Err() != nil || !llm.Retryable(err) {
+			return result, target, err
+		}
+		log.Warn().Err(err).
+			Str("provider", target.Provider).
+			Str("model", target.Model).
+			Str("next_provider", targets[i+1].Provider).
+			Str("next_model", targets[i+1].Model).
+			Msg("LLM target failed, falling back")
+	}
+	return result, Target{}, errors.New("no targets to route to")
+}
+
+// targetWriter announces the target on the first write, which also marks
+// the point after which falling back is no longer possible.
+type targetWriter struct {
+	out     Output
+	target  Target
+	started bool
+}
+
+func (w *targetWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+	}
+	return w.out.Write(p)
+}
+
+func (w *targetWriter) WriteToolCall(delta llm.ToolCallDelta) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	return w.out.WriteToolCall(delta)
+}
+
+func (w *targetWriter) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	return w.out.Route(w.target.Provider, w.target.Model)
+}
@@ -0,0 +1,247 @@
+package routing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shopmindai/llm-proxy/internal/config"
+	"github.com/shopmindai/llm-proxy/internal/llm"
+)
+
+// fakeProvider writes its chunks and tool calls, then fails with err if set.
+type fakeProvider struct {
+	chunks    []string
+	toolCalls []llm.ToolCallDelta
+	err       error
+	calls     int
+}
+
+func (f *fakeProvider) StreamChat(ctx context.Context, messages []llm.ChatMessage, opts llm.ChatOptions, writer io.Writer) (llm.StreamResult, error) {
+	f.calls++
+	for _, chunk := range f.chunks {
+		if _, err := io.WriteString(writer, chunk); err != nil {
+			return llm.StreamResult{}, err
+		}
+	}
+	for _, delta := range f.toolCalls {
+		if err := writer.(llm.ToolCallWriter).WriteToolCall(delta); err != nil {
+			return llm.StreamResult{}, err
+		}
+	}
+	if f.err != nil {
+		return llm.StreamResult{}, f.err
+	}
+	return llm.StreamResult{FinishReason: "stop"}, nil
+}
+
+type recordingOutput struct {
+	strings.Builder
+	routes []string
+	calls  []llm.ToolCallDelta
+}
+
+func (o *recordingOutput) WriteToolCall(delta llm.ToolCallDelta) error {
+	o.calls = append(o.calls, delta)
+	return nil
+}
+
+func (o *recordingOutput) Route(provider, model string) error {
+	o.routes = append(o.routes, provider+"/"+model)
+	return nil
+}
+
+func newTestTable(t *testing.T, providers map[string]*fakeProvider, routes map[string][]Target) *Table {
+	t.Helper()
+	registry := llm.NewRegistry()
+	file := File{Providers: map[string]ProviderSettings{}, Routes: routes}
+	for name, provider := range providers {
+		provider := provider
+		registry.Register(name, func(llm.ProviderConfig) llm.Provider { return provider })
+		file.Providers[name] = ProviderSettings{}
+	}
+	table, err := New(config.Config{LLMProvider: "primary", LLMModel: "base", LLMEmbeddingModel: "embed"}, registry, file)
+	require.NoError(t, err)
+	return table
+}
+
+func TestStreamFallsBackBeforeFirstToken(t *testing.T) {
+	primary := &fakeProvider{err: &llm.StatusError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"}}
+	secondary := &fakeProvider{chunks: []string{"Hello"}}
+	table := newTestTable(t, map[string]*fakeProvider{"primary": primary, "secondary": secondary}, map[string][]Target{
+		"shop-fast": {{Provider: "primary", Model: "small"}, {Provider: "secondary", Model: "medium"}},
+	})
+
+	targets, ok := table.Resolve("shop-fast")
+	require.True(t, ok)
+
+	var out recordingOutput
+	result, target, err := table.Stream(context.Background(), targets, nil, llm.ChatOptions{}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, Target{Provider: "secondary", Model: "medium"}, target)
+	assert.Equal(t, "stop", result.FinishReason)
+	assert.Equal(t, "Hello", out.String())
+	assert.Equal(t, []string{"secondary/medium"}, out.routes)
+}
+
+func TestStreamDoesNotFallBack(t *testing.T) {
+	cases := map[string]*fakeProvider{
+		"after first token":   {chunks: []string{"Hel"}, err: &llm.StatusError{StatusCode: http.StatusBadGateway}},
+		"after tool call":     {toolCalls: []llm.ToolCallDelta{{ID: "call_0", Name: "search"}}, err: &llm.StatusError{StatusCode: http.StatusBadGateway}},
+		"non-retryable error": {err: &llm.StatusError{StatusCode: http.StatusBadRequest}},
+	}
+	for name, primary := range cases {
+		t.Run(name, func(t *testing.T) {
+			secondary := &fakeProvider{chunks: []string{"Hello"}}
+			table := newTestTable(t, map[string]*fakeProvider{"primary": primary, "secondary": secondary}, map[string][]Target{
+				"shop-fast": {{Provider: "primary", Model: "small"}, {Provider: "secondary", Model: "medium"}},
+			})
+			targets, _ := table.Resolve("shop-fast")
+
+			var out recordingOutput
+			_, target, err := table.Stream(context.Background(), targets, nil, llm.ChatOptions{}, &out)
+			assert.Error(t, err)
+			assert.Equal(t, "primary", target.Provider)
+			assert.Zero(t, secondary.calls)
+			assert.Equal(t, primary.toolCalls, out.calls)
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	table := newTestTable(t, map[string]*fakeProvider{"primary": {}}, map[string][]Target{
+		"shop-smart": {{Provider: "primary", Model: "large"}},
+	})
+
+	targets, ok := table.Resolve("")
+	assert.True(t, ok)
+	assert.Equal(t, []Target{{Provider: "primary", Model: "base"}}, targets)
+
+	targets, ok = table.Resolve("shop-smart")
+	assert.True(t, ok)
+	assert.Equal(t, []Target{{Provider: "primary", Model: "large"}}, targets)
+
+	_, ok = table.Resolve("gpt-4-32k")
+	assert.False(t, ok)
+	_, ok = table.Resolve("embed")
+	assert.False(t, ok, "the embedding model does not serve chat")
+}
+
+func TestResolveEmbedding(t *testing.T) {
+	table := newTestTable(t, map[string]*fakeProvider{"primary": {}, "backup": {}}, map[string][]Target{
+		"shop-embed": {{Provider: "backup", Model: "embed-large"}, {Provider: "primary", Model: "embed-small"}},
+	})
+
+	target, ok := table.ResolveEmbedding("shop-embed")
+	assert.True(t, ok)
+	assert.Equal(t, Target{Provider: "backup", Model: "embed-large"}, target, "embeddings never fall back")
+
+	target, ok = table.ResolveEmbedding("base")
+	assert.True(t, ok)
+	assert.Equal(t, Target{Provider: "primary", Model: "base"}, target)
+
+	target, ok = table.ResolveEmbedding("embed")
+	assert.True(t, ok)
+	assert.Equal(t, Target{Provider: "primary", Model: "embed"}, target)
+
+	_, ok = table.ResolveEmbedding("text-embedding-3-large")
+	assert.False(t, ok)
+
+	_, err := table.Embed(context.Background(), target, []string{"socks"})
+	assert.ErrorIs(t, err, llm.ErrEmbeddingsUnsupported)
+}
+
+func TestParamsLayering(t *testing.T) {
+	maxTokens, temperature := 1000, float32(0.7)
+	table, err := New(config.Config{
+		LLMProvider: "openai",
+		LLMDefaults: llm.GenerationParams{MaxTokens: &maxTokens, Temperature: &temperature},
+		LLMLimits:   llm.Limits{MaxTokens: 2048},
+	}, llm.NewRegistry(), File{Models: map[string]ModelSettings{
+		"llama3.1": {GenerationParams: llm.GenerationParams{MaxTokens: intPtr(512)}},
+	}})
+	require.NoError(t, err)
+
+	params := table.Params("llama3.1", llm.GenerationParams{Temperature: float32Ptr(0.1)})
+	assert.Equal(t, 512, *params.MaxTokens)
+	assert.Equal(t, float32(0.1), *params.Temperature)
+
+	params = table.Params("gpt-4o", llm.GenerationParams{MaxTokens: intPtr(10000)})
+	assert.Equal(t, 2048, *params.MaxTokens, "request overrides are clamped")
+	assert.Equal(t, float32(0.7), *params.Temperature)
+
+	_, err = New(config.Config{LLMProvider: "openai"}, llm.NewRegistry(), File{Models: map[string]ModelSettings{
+		"llama3.1": {GenerationParams: llm.GenerationParams{TopP: float32Ptr(1.5)}},
+	}})
+	assert.ErrorContains(t, err, `model "llama3.1" defaults: top_p 1.5`)
+}
+
+func TestLimits(t *testing.T) {
+	maxTokens := 1000
+	table, err := New(config.Config{
+		LLMProvider:      "openai",
+		LLMModel:         "gpt-4o",
+		LLMDefaults:      llm.GenerationParams{MaxTokens: &maxTokens},
+		LLMContextWindow: 4096,
+	}, llm.NewRegistry(), File{
+		Models: map[string]ModelSettings{
+			"llama3.1": {GenerationParams: llm.GenerationParams{MaxTokens: intPtr(2000)}, ContextWindow: 16384},
+		},
+		Providers: map[string]ProviderSettings{"ollama": {}},
+		Routes: map[string][]Target{
+			"shop": {{Provider: "openai", Model: "gpt-4o"}, {Provider: "ollama", Model: "llama3.1"}},
+		},
+	})
+	require.NoError(t, err)
+
+	targets, ok := table.Resolve("gpt-4o")
+	require.True(t, ok)
+	assert.Equal(t, Limits{ContextWindow: 128000, MaxOutputTokens: 1000}, table.Limits(targets, llm.GenerationParams{}))
+
+	targets, ok = table.Resolve("shop")
+	require.True(t, ok)
+	assert.Equal(t, Limits{ContextWindow: 16384, MaxOutputTokens: 2000}, table.Limits(targets, llm.GenerationParams{}),
+		"the smallest window and the largest completion of the route")
+	assert.Equal(t, Limits{ContextWindow: 16384, MaxOutputTokens: 500}, table.Limits(targets, llm.GenerationParams{MaxTokens: intPtr(500)}))
+
+	assert.Equal(t, Limits{ContextWindow: 4096, MaxOutputTokens: 1000}, table.Limits([]Target{{Provider: "openai", Model: "acme-1"}}, llm.GenerationParams{}),
+		"unknown models fall back to LLM_CONTEXT_WINDOW")
+}
+
+func float32Ptr(v float32) *float32 { return &v }
+func intPtr(v int) *int             { return &v }
+
+func TestLoadValidatesRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	cfg := config.Config{LLMProvider: "openai", LLMModel: "gpt-4o-mini", LLMRoutesFile: path}
+
+	write(`{"providers":{"local":{"type":"ollama","base_url":"http://ollama:11434"}},
+		"routes":{"shop-fast":[{"provider":"local","model":"llama3.1"},{"provider":"openai","model":"gpt-4o-mini"}]}}`)
+	table, err := Load(cfg, llm.NewRegistry())
+	require.NoError(t, err)
+	targets, ok := table.Resolve("shop-fast")
+	assert.True(t, ok)
+	assert.Len(t, targets, 2)
+
+	write(`{"routes":{"shop-fast":[{"provider":"mistral","model":"small"}]}}`)
+	_, err = Load(cfg, llm.NewRegistry())
+	assert.ErrorContains(t, err, `unknown provider "mistral"`)
+
+	write(`{"providers":{"local":{"type":"llamafile"}}}`)
+	_, err = Load(cfg, llm.NewRegistry())
+	assert.ErrorContains(t, err, `provider "local"`)
+
+	write(`{"routes":{"shop-fast":[]}}`)
+	_, err = Load(cfg, llm.NewRegistry())
+	assert.ErrorContains(t, err, "has no targets")
+}
@@ -0,0 +1,251 @@
+// Package stream implements the SSE protocol llm-proxy speaks to its callers.
+//
+// Version 1 frames carry one JSON Event per "data:" line, so model output
+// (including newlines and leading spaces) round-trips byte-for-byte:
+//
+//	data: {"v":1,"type":"route","provider":"openai","model":"gpt-4o-mini"}
+//	data: {"v":1,"type":"delta","text":"Hello"}
+//	data: {"v":1,"type":"usage","usage":{"prompt_tokens":9,"completion_tokens":2,"total_tokens":11}}
+//	data: {"v":1,"type":"finish","finish_reason":"stop"}
+//
+// A "route" event names the provider and model that answered; it precedes the
+// first delta. When the request offers tools, "tool_call" events carry the
+// model's calls as fragments; fragments with the same index concatenate, and
+// the first one names the call:
+//
+//	data: {"v":1,"type":"tool_call","tool_call":{"index":0,"id":"call_0","name":"search_products"}}
+//	data: {"v":1,"type":"tool_call","tool_call":{"index":0,"arguments":"{\"query\":\"socks\"}"}}
+//	data: {"v":1,"type":"finish","finish_reason":"tool_calls"}
+//
+// A stream ends with exactly one "finish" or "error" event. Callers opt in by
+// sending the X-Stream-Protocol request header; without it the legacy
+// plain-text frames terminated by "data: [DONE]" are written instead.
+//
+// Under either protocol, SSE comment frames (": keep-alive") may appear
+// between events to keep idle connections open; callers ignore them.
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProtocolHeader  = "X-Stream-Protocol"
+	ProtocolVersion = 1
+
+	legacyDone = "[DONE]"
+)
+
+type EventType string
+
+const (
+	EventRoute    EventType = "route"
+	EventDelta    EventType = "delta"
+	EventToolCall EventType = "tool_call"
+	EventUsage    EventType = "usage"
+	EventFinish   EventType = "finish"
+	EventError    EventType = "error"
+)
+
+type Event struct {
+	V            int       `json:"v"`
+	Type         EventType `json:"type"`
+	Text         string    `json:"text,omitempty"`
+	FinishReason string    `json:"finish_reason,omitempty"`
+	Usage        *Usage    `json:"usage,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Provider     string    `json:"provider,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	ToolCall     *ToolCall `json:"tool_call,omitempty"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ToolCall is a fragment of the Index-th tool call of a response.
+type ToolCall struct {
+	Index     int    `json:"index"`
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// ErrLegacyToolCalls is returned for tool calls on the legacy protocol,
+// which cannot carry them.
+var ErrLegacyToolCalls = errors.New("tool calls require stream protocol version 1")
+
+// RequestedVersion returns the protocol version the caller asked for, or 0
+// for the legacy plain-text frames. Versions newer than ours are served at
+// ProtocolVersion.
+func RequestedVersion(r *http.Request) int {
+	v, err := strconv.Atoi(strings.TrimSpace(r.Header.Get(ProtocolHeader)))
+	if err != nil || v <= 0 {
+		return 0
+	}
+	if v > ProtocolVersion {
+		return ProtocolVersion
+	}
+	return v
+}
+
+// Writer emits stream events to an SSE response. Writes through the
+// io.Writer interface are sent as delta events. It is safe to use from the
+// KeepAlive goroutine and one other.
+type Writer struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	version int
+
+	mu      sync.Mutex
+	started bool
+}
+
+func NewWriter(w http.ResponseWriter, flusher http.Flusher, version int) *Writer {
+	if version > 0 {
+		w.Header().Set(ProtocolHeader, strconv.Itoa(version))
+	}
+	return &Writer{w: w, flusher: flusher, version: version}
+}
+
+// Started reports whether any frame has been written, after which errors
+// can no longer be reported through the HTTP status code.
+func (sw *Writer) Started() bool {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.started
+}
+
+// KeepAlive sends a comment frame every interval until stop is called, so
+// that proxies do not time out the connection while the model is thinking.
+// stop waits for the last frame to be written. A zero interval sends none.
+func (sw *Writer) KeepAlive(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	done, finished := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := sw.write(": keep-alive\n\n"); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func (sw *Writer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := sw.Delta(string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Route reports the provider and model serving the request. The legacy
+// protocol cannot carry it.
+func (sw *Writer) Route(provider, model string) error {
+	if sw.version == 0 {
+		return nil
+	}
+	return sw.send(Event{Type: EventRoute, Provider: provider, Model: model})
+}
+
+func (sw *Writer) Delta(text string) error {
+	if sw.version == 0 {
+		return sw.legacy(text)
+	}
+	return sw.send(Event{Type: EventDelta, Text: text})
+}
+
+func (sw *Writer) ToolCall(call ToolCall) error {
+	if sw.version == 0 {
+		return ErrLegacyToolCalls
+	}
+	return sw.send(Event{Type: EventToolCall, ToolCall: &call})
+}
+
+// Finish ends the stream. Usage is optional and dropped by the legacy protocol.
+func (sw *Writer) Finish(reason string, usage *Usage) error {
+	if sw.version == 0 {
+		return sw.legacy(legacyDone)
+	}
+	if usage != nil {
+		if err := sw.send(Event{Type: EventUsage, Usage: usage}); err != nil {
+			return err
+		}
+	}
+	if reason == "" {
+		reason = "stop"
+	}
+	return sw.send(Event{Type: EventFinish, FinishReason: reason})
+}
+
+// Error ends the stream with an error event. The legacy protocol has no
+// error frame, so the stream is simply cut short.
+func (sw *Writer) Error(err error) error {
+	if sw.version == 0 {
+		return nil
+	}
+	return sw.send(Event{Type: EventError, Error: err.Error()})
+}
+
+func (sw *Writer) send(evt Event) error {
+	evt.V = sw.version
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	return sw.frame(string(data))
+}
+
+// legacy writes the pre-v1 frames: one "data:" line per non-empty line of
+// text. Newlines cannot be represented and are dropped.
+func (sw *Writer) legacy(message string) error {
+	for _, line := range strings.Split(message, "\n") {
+		if line == "" {
+			continue
+		}
+		if err := sw.frame(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sw *Writer) frame(data string) error {
+	return sw.write("data: " + data + "\n\n")
+}
+
+func (sw *Writer) write(frame string) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.started = true
+	if _, err := io.WriteString(sw.w, frame); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
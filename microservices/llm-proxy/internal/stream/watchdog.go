@@ -0,0 +1,50 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrStalled aborts a stream whose provider stopped producing output.
+var ErrStalled = errors.New("stream stalled: the model stopped producing output")
+
+// Watchdog cancels a stream that makes no progress for its timeout.
+type Watchdog struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+// NewWatchdog returns a context derived from parent that is cancelled with
+// ErrStalled unless Touch is called at least once every timeout. A zero
+// timeout never cancels it.
+func NewWatchdog(parent context.Context, timeout time.Duration) (context.Context, *Watchdog) {
+	ctx, cancel := context.WithCancelCause(parent)
+	wd := &Watchdog{ctx: ctx, cancel: cancel, timeout: timeout}
+	if timeout > 0 {
+		wd.timer = time.AfterFunc(timeout, func() { cancel(ErrStalled) })
+	}
+	return ctx, wd
+}
+
+// Touch records progress.
+func (wd *Watchdog) Touch() {
+	if wd.timer != nil {
+		wd.timer.Reset(wd.timeout)
+	}
+}
+
+// Stop disarms the watchdog and releases its context.
+func (wd *Watchdog) Stop() {
+	if wd.timer != nil {
+		wd.timer.Stop()
+	}
+	wd.cancel(nil)
+}
+
+// Stalled reports whether the watchdog cancelled the stream.
+func (wd *Watchdog) Stalled() bool {
+	return errors.Is(context.Cause(wd.ctx), ErrStalled)
+}
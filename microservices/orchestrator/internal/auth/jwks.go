@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// minRefreshInterval rate-limits refreshes triggered by unknown key IDs, so
+// tokens with made-up kids cannot hammer the identity provider.
+const minRefreshInterval = 30 * time.Second
+
+var errUnknownKey = errors.New("unknown signing key")
+
+// keySet caches the JWKS of the identity provider. Keys are refreshed in the
+// background and on demand when a token names a key ID we have not seen,
+// which is how key rotation shows up.
+type keySet struct {
+	url    string
+	client *http.Client
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	lastAttempt time.Time
+	refreshing  sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newKeySet(ctx context.Context, url string, client *http.Client, interval time.Duration) *keySet {
+	ks := &keySet{
+		url:    url,
+		client: client,
+		keys:   make(map[string]crypto.PublicKey),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	// Startup does not depend on the identity provider being reachable; a
+	// failed first fetch is retried on the first token.
+	if err := ks.refresh(ctx); err != nil {
+		log.Warn().Err(err).Str("jwksUrl", url).Msg("initial JWKS fetch failed")
+	}
+	go ks.run(interval)
+	return ks
+}
+
+func (ks *keySet) run(interval time.Duration) {
+	defer close(ks.done)
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := ks.refresh(ctx); err != nil {
+				log.Warn().Err(err).Str("jwksUrl", ks.url).Msg("JWKS refresh failed, keeping cached keys")
+			}
+			cancel()
+		case <-ks.stop:
+			return
+		}
+	}
+}
+
+func (ks *keySet) close() {
+	close(ks.stop)
+	<-ks.done
+}
+
+// key returns the public key for kid, refreshing the set once if it is
+// missing and the last attempt is old enough.
+func (ks *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+
+	ks.mu.RLock()
+	recent := time.Since(ks.lastAttempt) < minRefreshInterval
+	ks.mu.RUnlock()
+	if !recent {
+		if err := ks.refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w %q", errUnknownKey, kid)
+}
+
+func (ks *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[kid]
+	if !ok && kid == "" && len(ks.keys) == 1 {
+		// A single-key set may be used by tokens without a kid.
+		for _, only := range ks.keys {
+			return only, true
+		}
+	}
+	return key, ok
+}
+
+func (ks *keySet) refresh(ctx context.Context) error {
+	ks.refreshing.Lock()
+	defer ks.refreshing.Unlock()
+
+	ks.mu.Lock()
+	ks.lastAttempt = time.Now()
+	ks.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return fmt.Errorf("build JWKS request: %w", err)
+	}
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch JWKS: %s", resp.Status)
+	}
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Debug().Err(err).Str("kid", k.Kid).Msg("skipping JWKS key")
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS contains no usable signing keys")
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != 32 {
+			return nil, errors.New("invalid x coordinate")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil || len(y) != 32 {
+			return nil, errors.New("invalid y coordinate")
+		}
+		// ecdh validates that the point is on the curve.
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
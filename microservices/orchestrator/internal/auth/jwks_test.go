@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The key set and signature checks are copied in llm-proxy's auth package;
+// the tests there pin the same behaviour, case for case.
+
+func TestKeySetKeepsOnlyUsableSigningKeys(t *testing.T) {
+	rsaKey, ecKey := newRSAKey(t, "rsa"), newECKey(t, "ec")
+	noUse := ecKey.jwk()
+	noUse["kid"], noUse["use"] = "no-use", ""
+	encryption := rsaKey.jwk()
+	encryption["kid"], encryption["use"] = "enc", "enc"
+	symmetric := map[string]string{"kty": "oct", "kid": "oct", "k": "c2VjcmV0"}
+	p384 := ecKey.jwk()
+	p384["kid"], p384["crv"] = "p384", "P-384"
+	offCurve := ecKey.jwk()
+	offCurve["kid"], offCurve["y"] = "off-curve", offCurve["x"]
+	weakExponent := rsaKey.jwk()
+	weakExponent["kid"], weakExponent["e"] = "weak", "AQ"
+
+	jwks := serveJWKS(t, rsaKey.jwk(), ecKey.jwk(), noUse, encryption, symmetric, p384, offCurve, weakExponent)
+	ks := newKeySet(context.Background(), jwks.URL, jwks.Client(), 0)
+	t.Cleanup(ks.close)
+
+	for kid, want := range map[string]crypto.PublicKey{"rsa": rsaKey.signer.Public(), "ec": ecKey.signer.Public(), "no-use": ecKey.signer.Public()} {
+		key, ok := ks.lookup(kid)
+		if !ok || !key.(interface{ Equal(crypto.PublicKey) bool }).Equal(want) {
+			t.Errorf("key %q: got %v, %v", kid, key, ok)
+		}
+	}
+	for _, kid := range []string{"enc", "oct", "p384", "off-curve", "weak"} {
+		if _, ok := ks.lookup(kid); ok {
+			t.Errorf("key %q should have been skipped", kid)
+		}
+	}
+	// Tokens without a kid only match a single-key set.
+	if _, ok := ks.lookup(""); ok {
+		t.Error("a kid-less token matched a multi-key set")
+	}
+
+	single := serveJWKS(t, rsaKey.jwk())
+	ks = newKeySet(context.Background(), single.URL, single.Client(), 0)
+	t.Cleanup(ks.close)
+	if key, ok := ks.lookup(""); !ok || !rsaKey.signer.Public().(*rsa.PublicKey).Equal(key) {
+		t.Errorf("a kid-less token should match the only key, got %v, %v", key, ok)
+	}
+
+	unusable := serveJWKS(t, encryption, symmetric)
+	if err := (&keySet{url: unusable.URL, client: unusable.Client()}).refresh(context.Background()); err == nil || !strings.Contains(err.Error(), "no usable signing keys") {
+		t.Errorf("expected an error for a set without signing keys, got %v", err)
+	}
+}
+
+func TestKeySetRefreshesOnUnknownKeyIDs(t *testing.T) {
+	first, rotated := newRSAKey(t, "k1"), newRSAKey(t, "k2")
+	var fetches atomic.Int32
+	var keys atomic.Value
+	keys.Store([]map[string]string{first.jwk()})
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys.Load()})
+	}))
+	t.Cleanup(jwks.Close)
+	ks := newKeySet(context.Background(), jwks.URL, jwks.Client(), 0)
+	t.Cleanup(ks.close)
+	ctx := context.Background()
+
+	// A rotated key is fetched on demand once the last attempt is old enough.
+	keys.Store([]map[string]string{first.jwk(), rotated.jwk()})
+	ks.lastAttempt = time.Now().Add(-minRefreshInterval)
+	if _, err := ks.key(ctx, "k2"); err != nil || fetches.Load() != 2 {
+		t.Fatalf("expected the rotated key after a refresh (%d fetches): %v", fetches.Load(), err)
+	}
+
+	// Made-up kids do not trigger another fetch within the interval.
+	if _, err := ks.key(ctx, "made-up"); !errors.Is(err, errUnknownKey) || fetches.Load() != 2 {
+		t.Fatalf("expected an unknown key without a fetch (%d fetches): %v", fetches.Load(), err)
+	}
+	ks.lastAttempt = time.Now().Add(-minRefreshInterval)
+	if _, err := ks.key(ctx, "made-up"); !errors.Is(err, errUnknownKey) || fetches.Load() != 3 {
+		t.Fatalf("expected an unknown key after a fetch (%d fetches): %v", fetches.Load(), err)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	rsaKey, ecKey := newRSAKey(t, "rsa"), newECKey(t, "ec")
+	digest := sha256.Sum256([]byte("header.payload"))
+	rsaSignature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey.signer.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey.signer.(*ecdsa.PrivateKey), digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSignature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	tampered := append([]byte(nil), ecSignature...)
+	tampered[0] ^= 1
+
+	for _, tc := range []struct {
+		name      string
+		alg       string
+		key       crypto.PublicKey
+		signature []byte
+		wantErr   string
+	}{
+		{"rs256", "RS256", rsaKey.signer.Public(), rsaSignature, ""},
+		{"es256", "ES256", ecKey.signer.Public(), ecSignature, ""},
+		{"rs256 with an ec key", "RS256", ecKey.signer.Public(), rsaSignature, "key type does not match RS256"},
+		{"es256 with an rsa key", "ES256", rsaKey.signer.Public(), ecSignature, "key type does not match ES256"},
+		{"bad rs256 signature", "RS256", rsaKey.signer.Public(), rsaSignature[1:], "invalid token signature"},
+		{"tampered es256 signature", "ES256", ecKey.signer.Public(), tampered, "invalid token signature"},
+		{"asn.1 es256 signature", "ES256", ecKey.signer.Public(), append(ecSignature, 0), "invalid token signature"},
+	} {
+		err := verifySignature(tc.alg, tc.key, digest[:], tc.signature)
+		if tc.wantErr == "" && err != nil || tc.wantErr != "" && (err == nil || err.Error() != tc.wantErr) {
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func serveJWKS(t *testing.T, keys ...map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// errKeysUnavailable marks verification failures caused by the key set
+// rather than the token, which is when the profile fallback may be used.
+var errKeysUnavailable = errors.New("signing keys unavailable")
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         *int64   `json:"exp"`
+	NotBefore         *int64   `json:"nbf"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email"`
+	Scope             string   `json:"scope"`
+	RealmAccess       struct {
+		Roles []string `json:"roles"`
+	} `json:"realm_access"`
+	ResourceAccess map[string]struct {
+		Roles []string `json:"roles"`
+	} `json:"resource_access"`
+}
+
+// audience accepts both forms of the aud claim: a string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = many
+	return nil
+}
+
+// jwtVerifier checks RS256/ES256 access tokens issued by Keycloak.
+type jwtVerifier struct {
+	keys      *keySet
+	issuer    string
+	audience  string // required aud entry, if set
+	clientID  string // accepted as aud or azp when audience is not set
+	clockSkew time.Duration
+	now       func() time.Time
+}
+
+func (v *jwtVerifier) verify(ctx context.Context, token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decode header: %w", err)
+	}
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode signature: %w", err)
+	}
+	key, err := v.keys.key(ctx, header.Kid)
+	if err != nil {
+		if errors.Is(err, errUnknownKey) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", errKeysUnavailable, err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(header.Alg, key, digest[:], signature); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decode claims: %w", err)
+	}
+	if err := v.validate(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (v *jwtVerifier) validate(claims *jwtClaims) error {
+	now := v.now()
+	if claims.ExpiresAt == nil {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(*claims.ExpiresAt, 0).Add(v.clockSkew)) {
+		return errors.New("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(v.clockSkew).Before(time.Unix(*claims.NotBefore, 0)) {
+		return errors.New("token not yet valid")
+	}
+	if v.issuer != "" && strings.TrimRight(claims.Issuer, "/") != strings.TrimRight(v.issuer, "/") {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	switch {
+	case v.audience != "":
+		if !slices.Contains(claims.Audience, v.audience) {
+			return fmt.Errorf("token not issued for audience %q", v.audience)
+		}
+	case v.clientID != "":
+		// Keycloak access tokens name the client in azp; aud often only
+		// lists resource servers such as "account".
+		if claims.AuthorizedParty != v.clientID && !slices.Contains(claims.Audience, v.clientID) {
+			return fmt.Errorf("token not issued for client %q", v.clientID)
+		}
+	}
+	if claims.Subject == "" {
+		return errors.New("token has no subject")
+	}
+	return nil
+}
+
+func (c *jwtClaims) toClaims() *Claims {
+	claims := &Claims{
+		Subject:  c.Subject,
+		Username: c.PreferredUsername,
+		Email:    c.Email,
+		Roles:    c.RealmAccess.Roles,
+		Scopes:   strings.Fields(c.Scope),
+	}
+	if len(c.ResourceAccess) > 0 {
+		claims.ClientRoles = make(map[string][]string, len(c.ResourceAccess))
+		for client, access := range c.ResourceAccess {
+			claims.ClientRoles[client] = access.Roles
+		}
+	}
+	return claims
+}
+
+func verifySignature(alg string, key crypto.PublicKey, digest, signature []byte) error {
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match RS256")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest, signature); err != nil {
+			return errors.New("invalid token signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match ES256")
+		}
+		// JWS encodes ECDSA signatures as the fixed-size concatenation r||s.
+		if len(signature) != 64 {
+			return errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
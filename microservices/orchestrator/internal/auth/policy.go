@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Policy maps routes, agent endpoints and model aliases to the roles and
+// scopes allowed to use them. It is loaded from the JSON file named by
+// AUTH_POLICY_FILE:
+//
+//	{
+//	  "routes":    {"DELETE /api/convos/{conversationId}": {"roles": ["admin"]}},
+//	  "endpoints": {"agents": {"roles": ["customer", "premium"]}},
+//	  "models":    {"shop-smart": {"roles": ["premium", "shopmind-client:premium"]}}
+//	}
+//
+// Route keys are chi route patterns, optionally prefixed by a method. Roles
+// are alternatives; scopes are all required. Anything without a rule is
+// open to every authenticated user.
+type Policy struct {
+	Routes    map[string]Rule `json:"routes"`
+	Endpoints map[string]Rule `json:"endpoints"`
+	Models    map[string]Rule `json:"models"`
+}
+
+type Rule struct {
+	Roles  []string `json:"roles"`
+	Scopes []string `json:"scopes"`
+}
+
+// Allows reports whether claims satisfy the rule. Missing claims satisfy
+// only rules that require nothing.
+func (r Rule) Allows(claims *Claims) bool {
+	for _, scope := range r.Scopes {
+		if !claims.HasScope(scope) {
+			return false
+		}
+	}
+	if len(r.Roles) == 0 {
+		return true
+	}
+	for _, role := range r.Roles {
+		if claims.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Denial describes why a request was refused.
+type Denial struct {
+	Resource string // "route", "endpoint" or "model"
+	Name     string
+	Rule     Rule
+}
+
+func (d *Denial) Error() string {
+	var needs []string
+	if len(d.Rule.Roles) > 0 {
+		needs = append(needs, "one of roles "+strings.Join(d.Rule.Roles, ", "))
+	}
+	if len(d.Rule.Scopes) > 0 {
+		needs = append(needs, "scopes "+strings.Join(d.Rule.Scopes, ", "))
+	}
+	return fmt.Sprintf("%s %q requires %s", d.Resource, d.Name, strings.Join(needs, " and "))
+}
+
+// LoadPolicy reads the policy file at path. An empty path yields a nil
+// policy, which allows everything.
+func LoadPolicy(path string) (*Policy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy file: %w", err)
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse policy file %s: %w", path, err)
+	}
+	for key := range p.Routes {
+		pattern := key
+		if _, rest, ok := strings.Cut(key, " "); ok {
+			pattern = rest
+		}
+		if !strings.HasPrefix(pattern, "/") {
+			return nil, fmt.Errorf("policy route %q: pattern must start with /", key)
+		}
+	}
+	return &p, nil
+}
+
+// CheckRoute checks the rule for a route pattern. A "METHOD pattern" rule
+// takes precedence over one for the bare pattern.
+func (p *Policy) CheckRoute(claims *Claims, method, pattern string) *Denial {
+	if p == nil {
+		return nil
+	}
+	if rule, ok := p.Routes[method+" "+pattern]; ok {
+		return check(claims, rule, "route", method+" "+pattern)
+	}
+	if rule, ok := p.Routes[pattern]; ok {
+		return check(claims, rule, "route", pattern)
+	}
+	return nil
+}
+
+func (p *Policy) CheckEndpoint(claims *Claims, endpoint string) *Denial {
+	if p == nil {
+		return nil
+	}
+	if rule, ok := p.Endpoints[endpoint]; ok {
+		return check(claims, rule, "endpoint", endpoint)
+	}
+	return nil
+}
+
+func (p *Policy) CheckModel(claims *Claims, model string) *Denial {
+	if p == nil {
+		return nil
+	}
+	if rule, ok := p.Models[model]; ok {
+		return check(claims, rule, "model", model)
+	}
+	return nil
+}
+
+func check(claims *Claims, rule Rule, resource, name string) *Denial {
+	if rule.Allows(claims) {
+		return nil
+	}
+	return &Denial{Resource: resource, Name: name, Rule: rule}
+}
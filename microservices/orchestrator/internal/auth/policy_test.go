@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyChecks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(`{
+		"routes": {
+			"/api/convos": {"roles": ["customer"]},
+			"DELETE /api/convos/{conversationId}": {"roles": ["admin"]}
+		},
+		"models": {"shop-smart": {"roles": ["premium"], "scopes": ["chat"]}}
+	}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+
+	customer := &Claims{Roles: []string{"customer"}}
+	premium := &Claims{Roles: []string{"premium"}, Scopes: []string{"openid", "chat"}}
+
+	if d := policy.CheckRoute(customer, "GET", "/api/convos"); d != nil {
+		t.Errorf("customer denied list: %v", d)
+	}
+	if d := policy.CheckRoute(nil, "GET", "/api/convos"); d == nil {
+		t.Error("anonymous user allowed a gated route")
+	}
+	if d := policy.CheckRoute(customer, "DELETE", "/api/convos/{conversationId}"); d == nil || d.Name != "DELETE /api/convos/{conversationId}" {
+		t.Errorf("expected method-specific denial, got %v", d)
+	}
+	if d := policy.CheckRoute(customer, "GET", "/api/convos/{conversationId}"); d != nil {
+		t.Errorf("ungated route denied: %v", d)
+	}
+
+	if d := policy.CheckModel(premium, "shop-smart"); d != nil {
+		t.Errorf("premium denied: %v", d)
+	}
+	d := policy.CheckModel(&Claims{Roles: []string{"premium"}}, "shop-smart")
+	if d == nil || !strings.Contains(d.Error(), `model "shop-smart" requires one of roles premium and scopes chat`) {
+		t.Errorf("expected missing scope denial, got %v", d)
+	}
+
+	var none *Policy
+	if d := none.CheckModel(nil, "shop-smart"); d != nil {
+		t.Errorf("nil policy denied: %v", d)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"routes":{"GET api/convos":{}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPolicy(path); err == nil {
+		t.Error("expected invalid route pattern to be rejected")
+	}
+}
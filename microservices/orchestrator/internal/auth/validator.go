@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -18,27 +19,79 @@ type Claims struct {
 	Subject  string
 	Username string
 	Email    string
+	// Roles are the user's Keycloak realm roles.
+	Roles []string
+	// ClientRoles are the user's roles per Keycloak client.
+	ClientRoles map[string][]string
+	// Scopes are the OAuth scopes granted to the token.
+	Scopes []string
 }
 
+// HasRole reports whether the user holds role. Plain names refer to realm
+// roles; "client:role" refers to a role of the named client.
+func (c *Claims) HasRole(role string) bool {
+	if c == nil {
+		return false
+	}
+	if client, name, ok := strings.Cut(role, ":"); ok {
+		return slices.Contains(c.ClientRoles[client], name)
+	}
+	return slices.Contains(c.Roles, role)
+}
+
+func (c *Claims) HasScope(scope string) bool {
+	return c != nil && slices.Contains(c.Scopes, scope)
+}
+
+// Validator verifies bearer tokens. Tokens are checked locally against the
+// Keycloak JWKS; the auth service's profile endpoint is only consulted when
+// AUTH_PROFILE_FALLBACK is set and a token cannot be checked locally.
 type Validator struct {
 	client     *http.Client
-	profileURL string
+	jwt        *jwtVerifier
+	profileURL string // empty unless the profile fallback is enabled
 }
 
-func NewValidator(_ context.Context, cfg config.AuthServiceConfig) (*Validator, error) {
-	if !cfg.Enabled() {
+// NewValidator returns nil when neither Keycloak nor the profile fallback is
+// configured, which disables authentication.
+func NewValidator(ctx context.Context, keycloak config.KeycloakConfig, authService config.AuthServiceConfig) (*Validator, error) {
+	v := &Validator{client: &http.Client{Timeout: 5 * time.Second}}
+	if authService.ProfileFallback {
+		if !authService.Enabled() {
+			return nil, errors.New("AUTH_PROFILE_FALLBACK requires AUTH_SERVICE_URL")
+		}
+		v.profileURL = authService.ProfileURL
+	}
+
+	if !keycloak.Enabled() {
+		if v.profileURL != "" {
+			return v, nil
+		}
+		if authService.Enabled() {
+			// Refuse to start rather than silently serving without auth.
+			return nil, errors.New("AUTH_SERVICE_URL is set but Keycloak is not configured; set KEYCLOAK_URL and KEYCLOAK_REALM or AUTH_PROFILE_FALLBACK=true")
+		}
 		return nil, nil
 	}
 
-	return &Validator{
-		client:     &http.Client{Timeout: 5 * time.Second},
-		profileURL: cfg.ProfileURL,
-	}, nil
+	v.jwt = &jwtVerifier{
+		keys:      newKeySet(ctx, keycloak.JWKSURL, v.client, keycloak.JWKSRefresh),
+		issuer:    keycloak.Issuer,
+		audience:  keycloak.Audience,
+		clientID:  keycloak.ClientID,
+		clockSkew: keycloak.ClockSkew,
+		now:       time.Now,
+	}
+	return v, nil
 }
 
-func (v *Validator) Close() {}
+func (v *Validator) Close() {
+	if v != nil && v.jwt != nil {
+		v.jwt.keys.close()
+	}
+}
 
-func (v *Validator) Verify(token string) (*Claims, error) {
+func (v *Validator) Verify(ctx context.Context, token string) (*Claims, error) {
 	if v == nil {
 		return nil, errors.New("validator not configured")
 	}
@@ -48,7 +101,25 @@ func (v *Validator) Verify(token string) (*Claims, error) {
 		return nil, errors.New("empty token")
 	}
 
-	req, err := http.NewRequest(http.MethodGet, v.profileURL, nil)
+	if v.jwt != nil {
+		claims, err := v.jwt.verify(ctx, token)
+		if err == nil {
+			return claims.toClaims(), nil
+		}
+		// Only fall back when the token could not be checked at all; a bad
+		// signature or an expired token is final.
+		if v.profileURL == "" || !(errors.Is(err, errKeysUnavailable) || errors.Is(err, errUnknownKey)) {
+			return nil, err
+		}
+		log.Debug().Err(err).Msg("local token verification unavailable, using profile fallback")
+	}
+	return v.verifyProfile(ctx, token)
+}
+
+// verifyProfile resolves the token through the auth service's profile
+// endpoint.
+func (v *Validator) verifyProfile(ctx context.Context, token string) (*Claims, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.profileURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("build profile request: %w", err)
 	}
@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+const testIssuer = "http://keycloak.test/realms/ShopMindAI"
+
+type testKey struct {
+	kid    string
+	signer crypto.Signer
+}
+
+func newRSAKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return testKey{kid: kid, signer: key}
+}
+
+func newECKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %v", err)
+	}
+	return testKey{kid: kid, signer: key}
+}
+
+func (k testKey) jwk() map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch pub := k.signer.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": k.kid, "use": "sig", "n": enc(pub.N.Bytes()), "e": enc(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": k.kid, "use": "sig", "crv": "P-256", "x": enc(pub.X.FillBytes(make([]byte, 32))), "y": enc(pub.Y.FillBytes(make([]byte, 32)))}
+	}
+	return nil
+}
+
+func (k testKey) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	alg := "RS256"
+	if _, ok := k.signer.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": k.kid})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch key := k.signer.(type) {
+	case *rsa.PrivateKey:
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		signature = sig
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// jwksServer serves whatever keys are currently set and counts fetches.
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []testKey
+	fetches int
+}
+
+func newJWKSServer(t *testing.T, keys ...testKey) *jwksServer {
+	t.Helper()
+	js := &jwksServer{keys: keys}
+	js.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		js.mu.Lock()
+		defer js.mu.Unlock()
+		js.fetches++
+		var doc struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		for _, k := range js.keys {
+			doc.Keys = append(doc.Keys, k.jwk())
+		}
+		_ = json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(js.Close)
+	return js
+}
+
+func (js *jwksServer) fetchCount() int {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return js.fetches
+}
+
+func (js *jwksServer) setKeys(keys ...testKey) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.keys = keys
+}
+
+func newTestValidator(t *testing.T, jwksURL string, authService config.AuthServiceConfig) *Validator {
+	t.Helper()
+	v, err := NewValidator(context.Background(), config.KeycloakConfig{
+		URL:       "http://keycloak.test",
+		Realm:     "ShopMindAI",
+		ClientID:  "shopmind-client",
+		Issuer:    testIssuer,
+		JWKSURL:   jwksURL,
+		ClockSkew: 30 * time.Second,
+	}, authService)
+	if err != nil {
+		t.Fatalf("NewValidator: %v", err)
+	}
+	t.Cleanup(v.Close)
+	return v
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":                testIssuer,
+		"sub":                "user-1",
+		"aud":                "account",
+		"azp":                "shopmind-client",
+		"exp":                time.Now().Add(5 * time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"preferred_username": "ana",
+		"email":              "ana@example.com",
+		"scope":              "openid profile email",
+		"realm_access":       map[string]any{"roles": []string{"customer"}},
+		"resource_access":    map[string]any{"shopmind-client": map[string]any{"roles": []string{"premium"}}},
+	}
+}
+
+func TestVerifyAcceptsValidTokens(t *testing.T) {
+	rsaKey, ecKey := newRSAKey(t, "rsa-1"), newECKey(t, "ec-1")
+	jwks := newJWKSServer(t, rsaKey, ecKey)
+	v := newTestValidator(t, jwks.URL, config.AuthServiceConfig{})
+
+	for _, key := range []testKey{rsaKey, ecKey} {
+		claims, err := v.Verify(context.Background(), key.sign(t, validClaims()))
+		if err != nil {
+			t.Fatalf("%s: Verify: %v", key.kid, err)
+		}
+		if claims.Subject != "user-1" || claims.Username != "ana" || claims.Email != "ana@example.com" {
+			t.Fatalf("%s: unexpected claims %+v", key.kid, claims)
+		}
+		if !claims.HasRole("customer") || !claims.HasRole("shopmind-client:premium") || claims.HasRole("premium") || !claims.HasScope("email") {
+			t.Fatalf("%s: unexpected roles or scopes %+v", key.kid, claims)
+		}
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	jwks := newJWKSServer(t, key)
+	v := newTestValidator(t, jwks.URL, config.AuthServiceConfig{})
+
+	with := func(field string, value any) map[string]any {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, field)
+		} else {
+			claims[field] = value
+		}
+		return claims
+	}
+	cases := map[string]struct {
+		token string
+		want  string
+	}{
+		"wrong issuer":    {key.sign(t, with("iss", "http://evil.test/realms/ShopMindAI")), "unexpected issuer"},
+		"wrong client":    {key.sign(t, with("azp", "other-client")), "not issued for client"},
+		"expired":         {key.sign(t, with("exp", time.Now().Add(-time.Minute).Unix())), "token expired"},
+		"not yet valid":   {key.sign(t, with("nbf", time.Now().Add(time.Minute).Unix())), "not yet valid"},
+		"no expiry":       {key.sign(t, with("exp", nil)), "no expiry"},
+		"bad signature":   {newRSAKey(t, "rsa-1").sign(t, validClaims()), "invalid token signature"},
+		"unsupported alg": {"eyJhbGciOiJub25lIn0." + strings.Split(key.sign(t, validClaims()), ".")[1] + ".", "unsupported algorithm"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := v.Verify(context.Background(), tc.token)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+
+	// Expiry within the clock skew is still accepted.
+	if _, err := v.Verify(context.Background(), key.sign(t, with("exp", time.Now().Add(-10*time.Second).Unix()))); err != nil {
+		t.Fatalf("token within clock skew rejected: %v", err)
+	}
+}
+
+func TestVerifyAudience(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	jwks := newJWKSServer(t, key)
+	v, err := NewValidator(context.Background(), config.KeycloakConfig{
+		URL:      "http://keycloak.test",
+		Realm:    "ShopMindAI",
+		ClientID: "shopmind-client",
+		Issuer:   testIssuer,
+		JWKSURL:  jwks.URL,
+		Audience: "orchestrator",
+	}, config.AuthServiceConfig{})
+	if err != nil {
+		t.Fatalf("NewValidator: %v", err)
+	}
+	defer v.Close()
+
+	claims := validClaims()
+	if _, err := v.Verify(context.Background(), key.sign(t, claims)); err == nil {
+		t.Fatal("expected token without the configured audience to be rejected")
+	}
+	claims["aud"] = []string{"account", "orchestrator"}
+	if _, err := v.Verify(context.Background(), key.sign(t, claims)); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+}
+
+func TestVerifyPicksUpRotatedKeys(t *testing.T) {
+	oldKey, newKey := newRSAKey(t, "old"), newECKey(t, "new")
+	jwks := newJWKSServer(t, oldKey)
+	v := newTestValidator(t, jwks.URL, config.AuthServiceConfig{})
+
+	if _, err := v.Verify(context.Background(), oldKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("Verify with old key: %v", err)
+	}
+
+	jwks.setKeys(oldKey, newKey)
+	// Pretend the startup fetch happened long enough ago to allow a refresh.
+	v.jwt.keys.mu.Lock()
+	v.jwt.keys.lastAttempt = time.Now().Add(-minRefreshInterval)
+	v.jwt.keys.mu.Unlock()
+	if _, err := v.Verify(context.Background(), newKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("Verify with rotated key: %v", err)
+	}
+
+	// Unknown kids refresh the set at most once per minRefreshInterval.
+	before := jwks.fetchCount()
+	for range 3 {
+		if _, err := v.Verify(context.Background(), newRSAKey(t, "bogus").sign(t, validClaims())); err == nil {
+			t.Fatal("expected unknown key to be rejected")
+		}
+	}
+	if fetches := jwks.fetchCount(); fetches != before {
+		t.Fatalf("expected no extra JWKS fetches, got %d", fetches-before)
+	}
+}
+
+func TestVerifyProfileFallback(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	token := key.sign(t, validClaims())
+	profile := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"id":"user-2","username":"bob","email":"bob@example.com"}}`))
+	}))
+	defer profile.Close()
+	authService := config.AuthServiceConfig{BaseURL: profile.URL, ProfileURL: profile.URL, ProfileFallback: true}
+
+	// With the JWKS unreachable a well-formed token is checked by the auth service.
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	v := newTestValidator(t, unreachable.URL, authService)
+	claims, err := v.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.Subject != "user-2" {
+		t.Fatalf("expected claims from the auth service, got %+v", claims)
+	}
+
+	// Tokens that fail local checks never fall back.
+	jwks := newJWKSServer(t, key)
+	v = newTestValidator(t, jwks.URL, authService)
+	forged := newRSAKey(t, "rsa-1").sign(t, validClaims())
+	if _, err := v.Verify(context.Background(), forged); err == nil {
+		t.Fatal("expected forged token to be rejected")
+	}
+	if _, err := v.Verify(context.Background(), "opaque-token"); err == nil {
+		t.Fatal("expected malformed token to be rejected")
+	}
+
+	if _, err := NewValidator(context.Background(), config.KeycloakConfig{}, config.AuthServiceConfig{BaseURL: profile.URL}); err == nil {
+		t.Fatal("expected AUTH_SERVICE_URL without Keycloak or fallback to be rejected")
+	}
+	if v, err := NewValidator(context.Background(), config.KeycloakConfig{}, config.AuthServiceConfig{}); v != nil || err != nil {
+		t.Fatalf("expected auth to be disabled, got %v, %v", v, err)
+	}
+}
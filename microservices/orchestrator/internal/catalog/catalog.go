@@ -0,0 +1,233 @@
+// Package catalog keeps the product catalog the assistant answers from. It
+// ingests product feeds (CSV/TSV, JSON Lines and Google Merchant XML) from a
+// directory into a Store and serves full-text search with attribute filters
+// from an in-memory index.
+//
+// Ingestion is incremental: a feed whose file has not changed since the last
+// run is skipped, and a changed feed only rewrites the products that differ.
+// Products of a feed whose file disappears are removed.
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+// Availability follows the Google Merchant availability values.
+type Availability string
+
+const (
+	InStock    Availability = "in_stock"
+	OutOfStock Availability = "out_of_stock"
+	Preorder   Availability = "preorder"
+	Backorder  Availability = "backorder"
+)
+
+// Product is one offer of a feed. IDs are unique within their feed; the
+// same item sold by two merchants appears once per feed.
+type Product struct {
+	Feed        string  `json:"feed"`
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	Description string  `json:"description,omitempty"`
+	Brand       string  `json:"brand,omitempty"`
+	Category    string  `json:"category,omitempty"`
+	GTIN        string  `json:"gtin,omitempty"`
+	Merchant    string  `json:"merchant"`
+	Price       float64 `json:"price"`
+	Currency    string  `json:"currency,omitempty"`
+	// RegularPrice is the price before a sale, set only when Price is a
+	// sale price.
+	RegularPrice float64      `json:"regularPrice,omitempty"`
+	Availability Availability `json:"availability,omitempty"`
+	URL          string       `json:"url,omitempty"`
+	ImageURL     string       `json:"imageUrl,omitempty"`
+	UpdatedAt    time.Time    `json:"updatedAt"`
+}
+
+func (p Product) key() string {
+	return productKey(p.Feed, p.ID)
+}
+
+func productKey(feed, id string) string {
+	return feed + "\x00" + id
+}
+
+// Catalog is the searchable product catalog. Searches may run concurrently
+// with ingestion; they see each feed either before or after it is applied.
+type Catalog struct {
+	store   Store
+	feedDir string
+
+	mu       sync.RWMutex
+	products map[string]Product
+	index    *index
+	reports  map[string]FeedReport
+	onChange []func(context.Context, []Product)
+
+	ingesting sync.Mutex
+	now       func() time.Time
+
+	running bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Open opens the catalog store, ingests the feed directory and keeps
+// re-ingesting it every cfg.Refresh. It returns nil when no feed directory
+// is configured.
+func Open(ctx context.Context, cfg config.CatalogConfig) (*Catalog, error) {
+	if cfg.FeedDir == "" {
+		return nil, nil
+	}
+	store, err := OpenStore(cfg.Store)
+	if err != nil {
+		return nil, fmt.Errorf("open catalog store: %w", err)
+	}
+	c, err := New(ctx, store, cfg.FeedDir)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	reports, err := c.Ingest(ctx)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	for _, report := range reports {
+		report.log()
+	}
+	c.running = true
+	go c.run(cfg.Refresh)
+	return c, nil
+}
+
+// New loads the products already in store. It does not ingest.
+func New(ctx context.Context, store Store, feedDir string) (*Catalog, error) {
+	products, err := store.Products(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load catalog: %w", err)
+	}
+	c := &Catalog{
+		store:    store,
+		feedDir:  feedDir,
+		products: make(map[string]Product, len(products)),
+		index:    newIndex(),
+		reports:  make(map[string]FeedReport),
+		now:      time.Now,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, p := range products {
+		c.products[p.key()] = p
+		c.index.add(p)
+	}
+	return c, nil
+}
+
+func (c *Catalog) run(interval time.Duration) {
+	defer close(c.done)
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reports, err := c.Ingest(context.Background())
+			if err != nil {
+				log.Warn().Err(err).Str("feedDir", c.feedDir).Msg("catalog ingestion failed")
+			}
+			for _, report := range reports {
+				if !report.Unchanged {
+					report.log()
+				}
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close stops background ingestion and closes the store.
+func (c *Catalog) Close() error {
+	close(c.stop)
+	if c.running {
+		<-c.done
+	}
+	return c.store.Close()
+}
+
+// OnChange registers fn to be called after each feed ingestion with the
+// products it added or changed. Calls are serialized with ingestion.
+func (c *Catalog) OnChange(fn func(ctx context.Context, products []Product)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onChange = append(c.onChange, fn)
+}
+
+// Get returns a product by feed and ID.
+func (c *Catalog) Get(feed, id string) (Product, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok := c.products[productKey(feed, id)]
+	return p, ok
+}
+
+// Products returns the products matching keep, ordered by feed and ID.
+func (c *Catalog) Products(keep func(Product) bool) []Product {
+	c.mu.RLock()
+	var products []Product
+	for _, p := range c.products {
+		if keep == nil || keep(p) {
+			products = append(products, p)
+		}
+	}
+	c.mu.RUnlock()
+	sort.Slice(products, func(i, j int) bool {
+		if products[i].Feed != products[j].Feed {
+			return products[i].Feed < products[j].Feed
+		}
+		return products[i].ID < products[j].ID
+	})
+	return products
+}
+
+// Reports returns the latest ingestion report of every feed, by feed name.
+func (c *Catalog) Reports() []FeedReport {
+	c.mu.RLock()
+	reports := make([]FeedReport, 0, len(c.reports))
+	for _, report := range c.reports {
+		reports = append(reports, report)
+	}
+	c.mu.RUnlock()
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Feed < reports[j].Feed })
+	return reports
+}
+
+func (r FeedReport) log() {
+	event := log.Info()
+	if r.Error != "" || r.ErrorCount > 0 {
+		event = log.Warn().Str("error", r.Error).Int("row_errors", r.ErrorCount)
+	}
+	event.Str("feed", r.Feed).
+		Bool("unchanged", r.Unchanged).
+		Int("products", r.Products).
+		Int("added", r.Added).
+		Int("updated", r.Updated).
+		Int("removed", r.Removed).
+		Msg("catalog feed ingested")
+}
+
+func normalize(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
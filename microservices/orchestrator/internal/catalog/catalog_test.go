@@ -0,0 +1,253 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCatalog serves a copy of the fixture feeds.
+func newTestCatalog(t *testing.T, store Store) (*Catalog, string) {
+	t.Helper()
+	dir := copyFixtures(t)
+	c, err := New(context.Background(), store, dir)
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c, dir
+}
+
+// copyFixtures copies the fixture feeds into a temporary directory so tests
+// can change them.
+func copyFixtures(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	fixtures, err := os.ReadDir("testdata/feeds")
+	if err != nil {
+		t.Fatalf("read fixtures: %v", err)
+	}
+	for _, fixture := range fixtures {
+		data, err := os.ReadFile(filepath.Join("testdata/feeds", fixture.Name()))
+		if err != nil {
+			t.Fatalf("read fixture: %v", err)
+		}
+		writeFeed(t, dir, fixture.Name(), string(data))
+	}
+	return dir
+}
+
+func writeFeed(t *testing.T, dir, name, data string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+		t.Fatalf("write feed: %v", err)
+	}
+}
+
+func ingest(t *testing.T, c *Catalog) map[string]FeedReport {
+	t.Helper()
+	reports, err := c.Ingest(context.Background())
+	if err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+	byFeed := make(map[string]FeedReport, len(reports))
+	for _, report := range reports {
+		byFeed[report.Feed] = report
+	}
+	return byFeed
+}
+
+func TestIngestFixtureFeeds(t *testing.T) {
+	c, _ := newTestCatalog(t, NewMemoryStore())
+	reports := ingest(t, c)
+
+	outdoor := reports["outdoor"]
+	if outdoor.Format != FormatCSV || outdoor.Products != 3 || outdoor.Added != 3 || outdoor.ErrorCount != 1 {
+		t.Errorf("unexpected outdoor report %+v", outdoor)
+	}
+	if len(outdoor.Errors) != 1 || outdoor.Errors[0].Line != 5 || outdoor.Errors[0].ID != "BAD-1" ||
+		!strings.Contains(outdoor.Errors[0].Message, "invalid price") {
+		t.Errorf("unexpected outdoor errors %+v", outdoor.Errors)
+	}
+
+	footwear := reports["footwear"]
+	if footwear.Products != 2 || footwear.ErrorCount != 2 || footwear.Errors[0].Line != 3 || footwear.Errors[1].Line != 4 {
+		t.Errorf("unexpected footwear report %+v", footwear)
+	}
+	if merchant := reports["merchant"]; merchant.Format != FormatXML || merchant.Products != 2 || merchant.ErrorCount != 0 {
+		t.Errorf("unexpected merchant report %+v", merchant)
+	}
+
+	sock, ok := c.Get("outdoor", "SOCK-2")
+	if !ok || sock.Price != 7.99 || sock.RegularPrice != 9.99 || sock.Currency != "USD" || sock.Availability != InStock || sock.Merchant != "outdoor" {
+		t.Errorf("unexpected sale product %+v", sock)
+	}
+	boot, _ := c.Get("footwear", "BOOT-1")
+	if boot.Price != 129.5 || boot.Currency != "USD" || boot.GTIN != "00012345600012" || boot.Category != "Footwear > Boots" {
+		t.Errorf("unexpected JSON product %+v", boot)
+	}
+	jacket, _ := c.Get("merchant", "JKT-1")
+	if jacket.Title != "Insulated Down Jacket" || jacket.URL != "https://peak.example/jkt-1" || jacket.Price != 199 || jacket.Category != "Apparel > Jackets" {
+		t.Errorf("unexpected XML product %+v", jacket)
+	}
+}
+
+func TestIngestIsIncremental(t *testing.T) {
+	c, dir := newTestCatalog(t, NewMemoryStore())
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	ingest(t, c)
+
+	now = now.Add(time.Hour)
+	for name, report := range ingest(t, c) {
+		if !report.Unchanged || report.Added+report.Updated+report.Removed != 0 {
+			t.Errorf("%s: expected an unchanged feed, got %+v", name, report)
+		}
+	}
+	if report := ingest(t, c)["outdoor"]; report.ErrorCount != 1 {
+		t.Errorf("unchanged feeds should keep their row errors, got %+v", report)
+	}
+
+	writeFeed(t, dir, "outdoor.csv", "id,title,brand,price,availability\n"+
+		"SOCK-1,Merino Hiking Socks,Trailwise,16.00 USD,in stock\n"+
+		"SOCK-2,Cotton Crew Socks 3-Pack,Basics,9.99 USD,in stock\n"+
+		"CUP-1,Camp Mug,Trailwise,12.00 USD,in stock\n")
+	if err := os.Remove(filepath.Join(dir, "footwear.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	reports := ingest(t, c)
+
+	if outdoor := reports["outdoor"]; outdoor.Added != 1 || outdoor.Updated != 2 || outdoor.Removed != 1 || outdoor.Products != 3 {
+		t.Errorf("unexpected outdoor report %+v", outdoor)
+	}
+	if footwear := reports["footwear"]; footwear.Removed != 2 {
+		t.Errorf("unexpected footwear report %+v", footwear)
+	}
+	if sock, _ := c.Get("outdoor", "SOCK-1"); sock.Price != 16 || !sock.UpdatedAt.Equal(now) {
+		t.Errorf("SOCK-1 not updated: %+v", sock)
+	}
+	if _, ok := c.Get("outdoor", "TENT-1"); ok {
+		t.Error("TENT-1 should have been removed")
+	}
+	if _, ok := c.Get("footwear", "BOOT-1"); ok {
+		t.Error("products of a deleted feed should be removed")
+	}
+	if res := c.Search(Query{Text: "tent"}); res.Total != 0 {
+		t.Errorf("removed product still indexed: %+v", res)
+	}
+
+	// A feed without a single valid row is rejected as a whole.
+	writeFeed(t, dir, "outdoor.csv", "id,title\nX,\n")
+	if outdoor := ingest(t, c)["outdoor"]; outdoor.Error == "" {
+		t.Errorf("expected the broken feed to be rejected, got %+v", outdoor)
+	}
+	if _, ok := c.Get("outdoor", "CUP-1"); !ok {
+		t.Error("a rejected feed must keep its products")
+	}
+}
+
+func TestSQLiteStorePersistsCatalog(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "catalog.db")
+	store, err := OpenSQLiteStore(dsn)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	dir := copyFixtures(t)
+	c, err := New(context.Background(), store, dir)
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	ingest(t, c)
+	if err := c.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	store, err = OpenSQLiteStore(dsn)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	reopened, err := New(context.Background(), store, dir)
+	if err != nil {
+		t.Fatalf("reopen catalog: %v", err)
+	}
+	defer reopened.Close()
+
+	if p, ok := reopened.Get("merchant", "SOCK-9"); !ok || p.Availability != Backorder {
+		t.Errorf("product not persisted: %+v", p)
+	}
+	if res := reopened.Search(Query{Text: "boots"}); res.Total != 1 {
+		t.Errorf("index not rebuilt from the store: %+v", res)
+	}
+	for name, report := range ingest(t, reopened) {
+		if !report.Unchanged {
+			t.Errorf("%s: feed state not persisted: %+v", name, report)
+		}
+	}
+}
+
+func ids(res Result) []string {
+	var ids []string
+	for _, hit := range res.Products {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	c, _ := newTestCatalog(t, NewMemoryStore())
+	ingest(t, c)
+	price := func(v float64) *float64 { return &v }
+
+	cases := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"stemmed terms", Query{Text: "sock"}, "SOCK-2,SOCK-1,SOCK-9"},
+		{"all terms must match", Query{Text: "merino socks"}, "SOCK-1,SOCK-9"},
+		{"falls back to any term", Query{Text: "merino boots"}, "BOOT-1,SOCK-1,SOCK-9"},
+		{"brand filter", Query{Text: "socks", Brand: "peak"}, "SOCK-9"},
+		{"price range", Query{Text: "socks", MinPrice: price(10), MaxPrice: price(20)}, "SOCK-1"},
+		{"category and availability", Query{Category: "apparel", Availability: InStock, Sort: SortPriceAsc}, "SOCK-2,SOCK-1,JKT-1"},
+		{"price order", Query{Text: "trailwise", Sort: SortPriceDesc}, "TENT-1,BOOT-1,SOCK-1"},
+		{"paging", Query{Category: "apparel", Sort: SortPriceAsc, Limit: 2, Offset: 1}, "SOCK-1,SOCK-9"},
+		{"no match", Query{Text: "kayak"}, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := strings.Join(ids(c.Search(tc.query)), ","); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearchTool(t *testing.T) {
+	c, _ := newTestCatalog(t, NewMemoryStore())
+	ingest(t, c)
+	tool := SearchTool(c)
+
+	out, err := tool.Run(context.Background(), json.RawMessage(`{"query":"socks","in_stock_only":true,"sort":"price_asc","limit":1}`))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	data, _ := json.Marshal(out)
+	var result struct {
+		Total    int
+		Products []toolProduct
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if result.Total != 2 || len(result.Products) != 1 || result.Products[0].ID != "SOCK-2" || result.Products[0].Price != 7.99 {
+		t.Errorf("unexpected tool result %s", data)
+	}
+
+	if _, err := tool.Run(context.Background(), json.RawMessage(`{"sort":"cheapest"}`)); err == nil {
+		t.Error("expected an error for an unknown sort")
+	}
+}
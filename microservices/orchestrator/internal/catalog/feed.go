@@ -0,0 +1,315 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Format is a product feed format, chosen by file extension.
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+	FormatXML   Format = "xml"
+)
+
+// formatOf returns the feed format of a file name, or "" for files that are
+// not feeds.
+func formatOf(name string) Format {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv", ".tsv":
+		return FormatCSV
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	case ".xml":
+		return FormatXML
+	default:
+		return ""
+	}
+}
+
+// record is one feed row with attribute names normalised to the Google
+// Merchant names: lower case, without the "g:" prefix.
+type record struct {
+	line   int
+	fields map[string]string
+}
+
+func (r record) get(names ...string) string {
+	for _, name := range names {
+		if v := strings.TrimSpace(r.fields[name]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func fieldName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.TrimPrefix(name, "g:")
+}
+
+// readRecords splits a feed into records. An error means the feed as a
+// whole could not be read.
+func readRecords(format Format, data []byte) ([]record, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(data)
+	case FormatJSONL:
+		return readJSONL(data)
+	case FormatXML:
+		return readXML(data)
+	default:
+		return nil, fmt.Errorf("unsupported feed format %q", format)
+	}
+}
+
+// readCSV reads a delimited feed with a header row. Tab-separated files,
+// as exported by Merchant Center, are detected from the header.
+func readCSV(data []byte) ([]record, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+
+	r := csv.NewReader(bytes.NewReader(data))
+	if bytes.Count(header, []byte("\t")) > bytes.Count(header, []byte(",")) {
+		r.Comma = '\t'
+		r.LazyQuotes = true
+	}
+	r.FieldsPerRecord = -1
+
+	columns, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	for i, column := range columns {
+		columns[i] = fieldName(column)
+	}
+
+	var records []record
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				// Keep going: a malformed row only loses that row.
+				records = append(records, record{line: parseErr.Line, fields: map[string]string{"": parseErr.Err.Error()}})
+				continue
+			}
+			return records, err
+		}
+		line, _ := r.FieldPos(0)
+		rec := record{line: line, fields: make(map[string]string, len(columns))}
+		for i, value := range row {
+			if i < len(columns) {
+				rec.fields[columns[i]] = value
+			}
+		}
+		records = append(records, rec)
+	}
+}
+
+// readJSONL reads one JSON object per line. Non-string values are kept in
+// their JSON form.
+func readJSONL(data []byte) ([]record, error) {
+	var records []record
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(text, &object); err != nil {
+			records = append(records, record{line: line, fields: map[string]string{"": "invalid JSON: " + err.Error()}})
+			continue
+		}
+		rec := record{line: line, fields: make(map[string]string, len(object))}
+		for name, raw := range object {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				s = string(raw)
+			}
+			rec.fields[fieldName(name)] = s
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+type xmlItem struct {
+	Fields []xmlField `xml:",any"`
+}
+
+type xmlField struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// readXML reads a Google Merchant RSS 2.0 (<item>) or Atom (<entry>) feed.
+// Attributes in the g: namespace and plain elements of the same name are
+// treated alike.
+func readXML(data []byte) ([]record, error) {
+	var records []record
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		line, _ := decoder.InputPos()
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || (start.Name.Local != "item" && start.Name.Local != "entry") {
+			continue
+		}
+		var item xmlItem
+		if err := decoder.DecodeElement(&item, &start); err != nil {
+			return records, err
+		}
+		rec := record{line: line, fields: make(map[string]string, len(item.Fields))}
+		for _, field := range item.Fields {
+			name := fieldName(field.XMLName.Local)
+			// Plain elements never override their g: counterpart.
+			if _, seen := rec.fields[name]; !seen || field.XMLName.Space == googleNamespace {
+				rec.fields[name] = field.Value
+			}
+		}
+		records = append(records, rec)
+	}
+}
+
+const googleNamespace = "http://base.google.com/ns/1.0"
+
+// parseProduct validates a record of the named feed.
+func parseProduct(feed string, rec record) (Product, error) {
+	if problem := rec.fields[""]; problem != "" {
+		return Product{}, errors.New(problem)
+	}
+	p := Product{
+		Feed:        feed,
+		ID:          rec.get("id", "sku", "offer_id"),
+		Title:       rec.get("title", "name"),
+		Description: rec.get("description"),
+		Brand:       rec.get("brand"),
+		Category:    rec.get("product_type", "category", "google_product_category"),
+		GTIN:        rec.get("gtin", "ean", "upc"),
+		Merchant:    rec.get("merchant", "seller"),
+		URL:         rec.get("link", "url"),
+		ImageURL:    rec.get("image_link", "image_url", "image"),
+	}
+	if p.ID == "" {
+		return p, errors.New("missing id")
+	}
+	if p.Title == "" {
+		return p, errors.New("missing title")
+	}
+	if p.Merchant == "" {
+		p.Merchant = feed
+	}
+
+	price, currency, err := parsePrice(rec.get("price"))
+	if err != nil {
+		return p, fmt.Errorf("invalid price: %w", err)
+	}
+	p.Price, p.Currency = price, currency
+	if p.Currency == "" {
+		p.Currency = strings.ToUpper(rec.get("currency"))
+	}
+	if sale := rec.get("sale_price"); sale != "" {
+		salePrice, _, err := parsePrice(sale)
+		if err != nil {
+			return p, fmt.Errorf("invalid sale_price: %w", err)
+		}
+		if salePrice < p.Price {
+			p.RegularPrice, p.Price = p.Price, salePrice
+		}
+	}
+
+	p.Availability, err = parseAvailability(rec.get("availability"))
+	if err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// parsePrice accepts "19.99", "19.99 USD", "USD 19.99" and symbols such as
+// "$19.99". A comma is read as the decimal separator only when there is no
+// dot.
+func parsePrice(s string) (float64, string, error) {
+	if s == "" {
+		return 0, "", errors.New("missing")
+	}
+	var amount, currency string
+	for _, part := range strings.Fields(s) {
+		if isCurrencyCode(part) {
+			currency = strings.ToUpper(part)
+		} else {
+			amount += part
+		}
+	}
+	amount = strings.TrimFunc(amount, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' && r != ',' && r != '-' })
+	if strings.Contains(amount, ".") {
+		amount = strings.ReplaceAll(amount, ",", "")
+	} else {
+		amount = strings.ReplaceAll(amount, ",", ".")
+	}
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("%q is not a number", s)
+	}
+	if value < 0 {
+		return 0, "", fmt.Errorf("%q is negative", s)
+	}
+	return value, currency, nil
+}
+
+func isCurrencyCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseAvailability normalises the spellings seen in feeds ("in stock",
+// "in_stock", "InStock", schema.org URLs). An empty value is unknown.
+func parseAvailability(value string) (Availability, error) {
+	s := normalize(value)
+	s = s[strings.LastIndex(s, "/")+1:]
+	s = strings.NewReplacer(" ", "", "_", "", "-", "").Replace(s)
+	switch s {
+	case "":
+		return "", nil
+	case "instock":
+		return InStock, nil
+	case "outofstock", "soldout":
+		return OutOfStock, nil
+	case "preorder":
+		return Preorder, nil
+	case "backorder":
+		return Backorder, nil
+	default:
+		return "", fmt.Errorf("unknown availability %q", value)
+	}
+}
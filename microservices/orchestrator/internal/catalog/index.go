@@ -0,0 +1,268 @@
+package catalog
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Field weights: a query term in the title counts more than one in the
+// description.
+const (
+	titleWeight       = 3
+	brandWeight       = 2
+	categoryWeight    = 2
+	descriptionWeight = 1
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "for": true, "in": true, "of": true,
+	"on": true, "or": true, "the": true, "to": true, "with": true,
+}
+
+// index is an inverted index from terms to the weighted term frequency per
+// product key. It is guarded by the catalog's lock.
+type index struct {
+	postings map[string]map[string]int
+	docs     int
+}
+
+func newIndex() *index {
+	return &index{postings: make(map[string]map[string]int)}
+}
+
+func (ix *index) add(p Product) {
+	ix.docs++
+	key := p.key()
+	for term, weight := range productTerms(p) {
+		docs := ix.postings[term]
+		if docs == nil {
+			docs = make(map[string]int)
+			ix.postings[term] = docs
+		}
+		docs[key] = weight
+	}
+}
+
+func (ix *index) remove(p Product) {
+	ix.docs--
+	key := p.key()
+	for term := range productTerms(p) {
+		delete(ix.postings[term], key)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+}
+
+// scored is a product's relevance: the number of distinct query terms it
+// contains, then the tf-idf score of those terms.
+type scored struct {
+	terms int
+	score float64
+}
+
+// match scores the products containing every term. When no product does,
+// products containing any term are returned, ranked by how many they hold.
+func (ix *index) match(terms []string) map[string]scored {
+	seen := make(map[string]bool, len(terms))
+	unique := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			unique = append(unique, term)
+		}
+	}
+	terms = unique
+	if matches := ix.score(terms, true); len(matches) > 0 {
+		return matches
+	}
+	return ix.score(terms, false)
+}
+
+func (ix *index) score(terms []string, all bool) map[string]scored {
+	matches := make(map[string]scored)
+	for _, term := range terms {
+		docs := ix.postings[term]
+		if len(docs) == 0 {
+			if all {
+				return nil
+			}
+			continue
+		}
+		idf := math.Log(1 + float64(ix.docs)/float64(len(docs)))
+		for key, weight := range docs {
+			m := matches[key]
+			m.terms++
+			m.score += (1 + math.Log(float64(weight))) * idf
+			matches[key] = m
+		}
+	}
+	if all {
+		for key, m := range matches {
+			if m.terms < len(terms) {
+				delete(matches, key)
+			}
+		}
+	}
+	return matches
+}
+
+func productTerms(p Product) map[string]int {
+	terms := make(map[string]int)
+	for _, field := range []struct {
+		text   string
+		weight int
+	}{
+		{p.Title, titleWeight},
+		{p.Brand, brandWeight},
+		{p.Category, categoryWeight},
+		{p.Description, descriptionWeight},
+	} {
+		for _, term := range tokenize(field.text) {
+			terms[term] += field.weight
+		}
+	}
+	return terms
+}
+
+// tokenize splits text into lower-case terms, dropping stop words and
+// reducing plurals so "socks" finds "sock".
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := words[:0]
+	for _, word := range words {
+		if stopWords[word] {
+			continue
+		}
+		terms = append(terms, stem(word))
+	}
+	return terms
+}
+
+func stem(word string) string {
+	switch {
+	case len(word) <= 3:
+		return word
+	case strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"), strings.HasSuffix(word, "xes"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"):
+		return word
+	case strings.HasSuffix(word, "s"):
+		return word[:len(word)-1]
+	default:
+		return word
+	}
+}
+
+// Sort orders search results.
+type Sort string
+
+const (
+	SortRelevance Sort = "relevance"
+	SortPriceAsc  Sort = "price_asc"
+	SortPriceDesc Sort = "price_desc"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// Query is a catalog search. Text is matched against title, brand, category
+// and description; the other fields filter. Zero values do not filter.
+type Query struct {
+	Text         string
+	Brand        string
+	Category     string // matches any product whose category path contains it
+	MinPrice     *float64
+	MaxPrice     *float64
+	Availability Availability
+	Sort         Sort
+	Limit        int
+	Offset       int
+}
+
+func (q Query) keeps(p Product) bool {
+	switch {
+	case q.Brand != "" && !strings.EqualFold(p.Brand, strings.TrimSpace(q.Brand)):
+		return false
+	case q.Category != "" && !strings.Contains(strings.ToLower(p.Category), normalize(q.Category)):
+		return false
+	case q.MinPrice != nil && p.Price < *q.MinPrice:
+		return false
+	case q.MaxPrice != nil && p.Price > *q.MaxPrice:
+		return false
+	case q.Availability != "" && p.Availability != q.Availability:
+		return false
+	}
+	return true
+}
+
+type Hit struct {
+	Product
+	Score float64 `json:"score,omitempty"`
+	terms int
+}
+
+type Result struct {
+	Total    int   `json:"total"`
+	Products []Hit `json:"products"`
+}
+
+// Search runs q. Results without query text are ordered by title unless q
+// asks for a price order.
+func (c *Catalog) Search(q Query) Result {
+	terms := tokenize(q.Text)
+
+	c.mu.RLock()
+	var hits []Hit
+	if len(terms) > 0 {
+		for key, m := range c.index.match(terms) {
+			if p := c.products[key]; q.keeps(p) {
+				hits = append(hits, Hit{Product: p, Score: math.Round(m.score*1000) / 1000, terms: m.terms})
+			}
+		}
+	} else {
+		for _, p := range c.products {
+			if q.keeps(p) {
+				hits = append(hits, Hit{Product: p})
+			}
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		switch {
+		case q.Sort == SortPriceAsc && a.Price != b.Price:
+			return a.Price < b.Price
+		case q.Sort == SortPriceDesc && a.Price != b.Price:
+			return a.Price > b.Price
+		case a.terms != b.terms:
+			return a.terms > b.terms
+		case a.Score != b.Score:
+			return a.Score > b.Score
+		case a.Title != b.Title:
+			return a.Title < b.Title
+		default:
+			return a.key() < b.key()
+		}
+	})
+
+	result := Result{Total: len(hits), Products: []Hit{}}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+	if q.Offset < len(hits) {
+		result.Products = hits[max(q.Offset, 0):min(max(q.Offset, 0)+limit, len(hits))]
+	}
+	return result
+}
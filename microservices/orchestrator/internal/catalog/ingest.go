@@ -0,0 +1,228 @@
+package catalog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxReportedErrors bounds the row errors kept per report; ErrorCount has
+// the full count.
+const maxReportedErrors = 100
+
+type RowError struct {
+	Line    int    `json:"line"`
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message"`
+}
+
+// FeedReport describes the last ingestion of a feed. Rows with errors are
+// skipped; an Error means the feed as a whole was rejected and its products
+// from the previous ingestion were kept.
+type FeedReport struct {
+	Feed       string    `json:"feed"`
+	File       string    `json:"file,omitempty"`
+	Format     Format    `json:"format,omitempty"`
+	IngestedAt time.Time `json:"ingestedAt"`
+	// Unchanged is set when the file was identical to the last ingestion
+	// and was not parsed again.
+	Unchanged  bool       `json:"unchanged,omitempty"`
+	Products   int        `json:"products"`
+	Added      int        `json:"added"`
+	Updated    int        `json:"updated"`
+	Removed    int        `json:"removed"`
+	ErrorCount int        `json:"errorCount"`
+	Errors     []RowError `json:"errors,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+func (r *FeedReport) rowError(line int, id string, err error) {
+	r.ErrorCount++
+	if len(r.Errors) < maxReportedErrors {
+		r.Errors = append(r.Errors, RowError{Line: line, ID: id, Message: err.Error()})
+	}
+}
+
+// Ingest applies every feed in the feed directory, one report per feed in
+// name order. Feeds are named after their file without the extension.
+func (c *Catalog) Ingest(ctx context.Context) ([]FeedReport, error) {
+	c.ingesting.Lock()
+	defer c.ingesting.Unlock()
+
+	entries, err := os.ReadDir(c.feedDir)
+	if err != nil {
+		return nil, fmt.Errorf("read feed directory: %w", err)
+	}
+	states, err := c.store.Feeds(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load feed states: %w", err)
+	}
+
+	var reports []FeedReport
+	files := make(map[string]string)
+	for _, entry := range entries {
+		format := formatOf(entry.Name())
+		if entry.IsDir() || format == "" {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if other, ok := files[name]; ok {
+			reports = append(reports, FeedReport{
+				Feed:       name,
+				File:       entry.Name(),
+				IngestedAt: c.now().UTC(),
+				Error:      fmt.Sprintf("feed %q is already read from %s", name, other),
+			})
+			continue
+		}
+		files[name] = entry.Name()
+		reports = append(reports, c.ingestFeed(ctx, name, entry.Name(), format, states[name]))
+	}
+	for name := range states {
+		if _, ok := files[name]; !ok {
+			reports = append(reports, c.removeFeed(ctx, name))
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool { return reports[i].Feed < reports[j].Feed })
+
+	c.mu.Lock()
+	for _, report := range reports {
+		if _, ok := files[report.Feed]; ok || report.Error != "" {
+			c.reports[report.Feed] = report
+		} else {
+			delete(c.reports, report.Feed)
+		}
+	}
+	c.mu.Unlock()
+	return reports, nil
+}
+
+func (c *Catalog) ingestFeed(ctx context.Context, name, file string, format Format, state FeedState) FeedReport {
+	now := c.now().UTC()
+	report := FeedReport{Feed: name, File: file, Format: format, IngestedAt: now}
+
+	data, err := os.ReadFile(filepath.Join(c.feedDir, file))
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if hash == state.Hash {
+		c.mu.RLock()
+		if previous, ok := c.reports[name]; ok {
+			report.ErrorCount, report.Errors = previous.ErrorCount, previous.Errors
+		}
+		c.mu.RUnlock()
+		report.Unchanged = true
+		report.Products = len(c.Products(func(p Product) bool { return p.Feed == name }))
+		return report
+	}
+
+	records, err := readRecords(format, data)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	parsed := make(map[string]Product, len(records))
+	firstLine := make(map[string]int, len(records))
+	for _, rec := range records {
+		p, err := parseProduct(name, rec)
+		if err != nil {
+			report.rowError(rec.line, p.ID, err)
+			continue
+		}
+		if line, ok := firstLine[p.ID]; ok {
+			report.rowError(rec.line, p.ID, fmt.Errorf("duplicate id, first seen on line %d", line))
+			continue
+		}
+		firstLine[p.ID] = rec.line
+		parsed[p.ID] = p
+	}
+	if len(parsed) == 0 && report.ErrorCount > 0 {
+		// Most likely a broken export: keep what we have.
+		report.Error = "no valid products"
+		return report
+	}
+
+	var upserts []Product
+	var removed []string
+	c.mu.RLock()
+	for id, p := range parsed {
+		existing, ok := c.products[productKey(name, id)]
+		if ok {
+			p.UpdatedAt = existing.UpdatedAt
+			if p == existing {
+				continue
+			}
+			report.Updated++
+		} else {
+			report.Added++
+		}
+		p.UpdatedAt = now
+		upserts = append(upserts, p)
+	}
+	for _, p := range c.products {
+		if _, ok := parsed[p.ID]; p.Feed == name && !ok {
+			removed = append(removed, p.ID)
+		}
+	}
+	c.mu.RUnlock()
+	sort.Slice(upserts, func(i, j int) bool { return upserts[i].ID < upserts[j].ID })
+	report.Removed = len(removed)
+	report.Products = len(parsed)
+
+	if err := c.store.ApplyFeed(ctx, FeedState{Name: name, Hash: hash, IngestedAt: now}, upserts, removed); err != nil {
+		report.Error = fmt.Sprintf("save feed: %v", err)
+		report.Added, report.Updated, report.Removed = 0, 0, 0
+		return report
+	}
+
+	c.mu.Lock()
+	for _, p := range upserts {
+		if existing, ok := c.products[p.key()]; ok {
+			c.index.remove(existing)
+		}
+		c.products[p.key()] = p
+		c.index.add(p)
+	}
+	for _, id := range removed {
+		key := productKey(name, id)
+		c.index.remove(c.products[key])
+		delete(c.products, key)
+	}
+	listeners := c.onChange
+	c.mu.Unlock()
+
+	if len(upserts) > 0 {
+		for _, fn := range listeners {
+			fn(ctx, upserts)
+		}
+	}
+	return report
+}
+
+// removeFeed drops the products of a feed whose file is gone.
+func (c *Catalog) removeFeed(ctx context.Context, name string) FeedReport {
+	report := FeedReport{Feed: name, IngestedAt: c.now().UTC()}
+	if err := c.store.DeleteFeed(ctx, name); err != nil {
+		report.Error = fmt.Sprintf("remove feed: %v", err)
+		return report
+	}
+	c.mu.Lock()
+	for key, p := range c.products {
+		if p.Feed == name {
+			c.index.remove(p)
+			delete(c.products, key)
+			report.Removed++
+		}
+	}
+	c.mu.Unlock()
+	return report
+}
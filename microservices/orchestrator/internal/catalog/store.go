@@ -0,0 +1,232 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+// FeedState is what the store remembers of a feed between ingestions.
+type FeedState struct {
+	Name       string
+	Hash       string // SHA-256 of the feed file last applied
+	IngestedAt time.Time
+}
+
+// Store persists the catalog. Writes are per feed so a failing feed never
+// leaves another half-applied.
+type Store interface {
+	Products(ctx context.Context) ([]Product, error)
+	Feeds(ctx context.Context) (map[string]FeedState, error)
+	// ApplyFeed saves the feed state together with its changed and removed
+	// products (by ID within the feed).
+	ApplyFeed(ctx context.Context, state FeedState, upserts []Product, removed []string) error
+	// DeleteFeed removes a feed and all of its products.
+	DeleteFeed(ctx context.Context, name string) error
+	Close() error
+}
+
+// OpenStore returns the store selected by cfg.Driver.
+func OpenStore(cfg config.StoreConfig) (Store, error) {
+	switch strings.ToLower(cfg.Driver) {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return OpenSQLiteStore(cfg.DSN)
+	default:
+		return nil, fmt.Errorf("unsupported catalog store driver %q", cfg.Driver)
+	}
+}
+
+type MemoryStore struct {
+	mu       sync.Mutex
+	products map[string]Product
+	feeds    map[string]FeedState
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{products: make(map[string]Product), feeds: make(map[string]FeedState)}
+}
+
+func (m *MemoryStore) Products(_ context.Context) ([]Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	products := make([]Product, 0, len(m.products))
+	for _, p := range m.products {
+		products = append(products, p)
+	}
+	return products, nil
+}
+
+func (m *MemoryStore) Feeds(_ context.Context) (map[string]FeedState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	feeds := make(map[string]FeedState, len(m.feeds))
+	for name, state := range m.feeds {
+		feeds[name] = state
+	}
+	return feeds, nil
+}
+
+func (m *MemoryStore) ApplyFeed(_ context.Context, state FeedState, upserts []Product, removed []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range upserts {
+		m.products[p.key()] = p
+	}
+	for _, id := range removed {
+		delete(m.products, productKey(state.Name, id))
+	}
+	m.feeds[state.Name] = state
+	return nil
+}
+
+func (m *MemoryStore) DeleteFeed(_ context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, p := range m.products {
+		if p.Feed == name {
+			delete(m.products, key)
+		}
+	}
+	delete(m.feeds, name)
+	return nil
+}
+
+func (m *MemoryStore) Close() error { return nil }
+
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS catalog_products (
+	feed TEXT NOT NULL,
+	id   TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (feed, id)
+);
+
+CREATE TABLE IF NOT EXISTS catalog_feeds (
+	name        TEXT PRIMARY KEY,
+	hash        TEXT NOT NULL,
+	ingested_at INTEGER NOT NULL
+);
+`
+
+// SQLStore keeps the catalog in SQLite. Products are stored as JSON since
+// they are only ever loaded whole into the search index.
+type SQLStore struct {
+	db *sql.DB
+}
+
+func OpenSQLiteStore(dsn string) (*SQLStore, error) {
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqlSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate catalog schema: %w", err)
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Products(ctx context.Context) ([]Product, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM catalog_products`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var products []Product
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var p Product
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			return nil, fmt.Errorf("decode product: %w", err)
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
+
+func (s *SQLStore) Feeds(ctx context.Context) (map[string]FeedState, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, hash, ingested_at FROM catalog_feeds`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	feeds := make(map[string]FeedState)
+	for rows.Next() {
+		var state FeedState
+		var ingested int64
+		if err := rows.Scan(&state.Name, &state.Hash, &ingested); err != nil {
+			return nil, err
+		}
+		state.IngestedAt = time.Unix(0, ingested).UTC()
+		feeds[state.Name] = state
+	}
+	return feeds, rows.Err()
+}
+
+func (s *SQLStore) ApplyFeed(ctx context.Context, state FeedState, upserts []Product, removed []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, p := range upserts {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO catalog_products (feed, id, data) VALUES (?, ?, ?)
+			ON CONFLICT (feed, id) DO UPDATE SET data = excluded.data`,
+			p.Feed, p.ID, string(data),
+		); err != nil {
+			return err
+		}
+	}
+	for _, id := range removed {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM catalog_products WHERE feed = ? AND id = ?`, state.Name, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO catalog_feeds (name, hash, ingested_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET hash = excluded.hash, ingested_at = excluded.ingested_at`,
+		state.Name, state.Hash, state.IngestedAt.UnixNano(),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) DeleteFeed(ctx context.Context, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM catalog_products WHERE feed = ?`, name); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM catalog_feeds WHERE name = ?`, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
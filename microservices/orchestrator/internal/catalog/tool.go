@@ -0,0 +1,133 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/shopmindai/orchestrator/internal/tools"
+)
+
+const (
+	defaultToolResults = 5
+	maxToolResults     = 10
+	// toolDescriptionChars keeps tool results small enough not to crowd
+	// out the conversation.
+	toolDescriptionChars = 200
+)
+
+var searchToolSchema = json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"query": {"type": "string", "description": "Keywords describing the product, e.g. \"merino hiking socks\""},
+		"brand": {"type": "string", "description": "Only products of this brand"},
+		"category": {"type": "string", "description": "Only products whose category contains this text"},
+		"min_price": {"type": "number", "description": "Lowest price to include"},
+		"max_price": {"type": "number", "description": "Highest price to include"},
+		"in_stock_only": {"type": "boolean", "description": "Only products that are in stock"},
+		"sort": {"type": "string", "enum": ["relevance", "price_asc", "price_desc"]},
+		"limit": {"type": "integer", "minimum": 1, "maximum": 10}
+	}
+}`)
+
+type searchToolArgs struct {
+	Query       string   `json:"query"`
+	Brand       string   `json:"brand"`
+	Category    string   `json:"category"`
+	MinPrice    *float64 `json:"min_price"`
+	MaxPrice    *float64 `json:"max_price"`
+	InStockOnly bool     `json:"in_stock_only"`
+	Sort        Sort     `json:"sort"`
+	Limit       int      `json:"limit"`
+}
+
+type toolProduct struct {
+	Feed         string       `json:"feed"`
+	ID           string       `json:"id"`
+	Title        string       `json:"title"`
+	Brand        string       `json:"brand,omitempty"`
+	Category     string       `json:"category,omitempty"`
+	Description  string       `json:"description,omitempty"`
+	Merchant     string       `json:"merchant"`
+	Price        float64      `json:"price"`
+	Currency     string       `json:"currency,omitempty"`
+	RegularPrice float64      `json:"regularPrice,omitempty"`
+	Availability Availability `json:"availability,omitempty"`
+	URL          string       `json:"url,omitempty"`
+}
+
+// SearchTool lets the agent chat search the catalog.
+func SearchTool(c *Catalog) tools.Tool {
+	return tools.Tool{
+		Definition: tools.Definition{
+			Name: "search_products",
+			Description: "Search the store's product catalog. Use it whenever the user asks about products, " +
+				"prices or availability, and only recommend products it returns.",
+			Parameters: searchToolSchema,
+		},
+		Run: func(_ context.Context, arguments json.RawMessage) (any, error) {
+			var args searchToolArgs
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return nil, fmt.Errorf("invalid arguments: %w", err)
+			}
+			query, err := args.query()
+			if err != nil {
+				return nil, err
+			}
+
+			result := c.Search(query)
+			products := make([]toolProduct, 0, len(result.Products))
+			for _, hit := range result.Products {
+				products = append(products, toolProduct{
+					Feed:         hit.Feed,
+					ID:           hit.ID,
+					Title:        hit.Title,
+					Brand:        hit.Brand,
+					Category:     hit.Category,
+					Description:  truncate(hit.Description, toolDescriptionChars),
+					Merchant:     hit.Merchant,
+					Price:        hit.Price,
+					Currency:     hit.Currency,
+					RegularPrice: hit.RegularPrice,
+					Availability: hit.Availability,
+					URL:          hit.URL,
+				})
+			}
+			return map[string]any{"total": result.Total, "products": products}, nil
+		},
+	}
+}
+
+func (a searchToolArgs) query() (Query, error) {
+	q := Query{
+		Text:     a.Query,
+		Brand:    a.Brand,
+		Category: a.Category,
+		MinPrice: a.MinPrice,
+		MaxPrice: a.MaxPrice,
+		Limit:    a.Limit,
+	}
+	if a.InStockOnly {
+		q.Availability = InStock
+	}
+	switch a.Sort {
+	case "", SortRelevance, SortPriceAsc, SortPriceDesc:
+		q.Sort = a.Sort
+	default:
+		return q, fmt.Errorf("unknown sort %q", a.Sort)
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultToolResults
+	}
+	q.Limit = min(q.Limit, maxToolResults)
+	return q, nil
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n]) + "…"
+}
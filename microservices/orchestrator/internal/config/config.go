@@ -3,7 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -11,9 +13,18 @@ type Config struct {
 	Env            string
 	AllowedOrigins string
 	LLMProxyURL    string // ex: http://localhost:9000
-	LLMProxyToken  string // optional: Authorization Bearer
+	LLMProxyAuth   LLMProxyAuthConfig
+	LLMProxy       LLMProxyClientConfig
 	Keycloak       KeycloakConfig
 	AuthService    AuthServiceConfig
+	AuthPolicyFile string // optional: JSON role policy, see auth.Policy
+	Store          StoreConfig
+	Chat           ChatConfig
+	Quota          QuotaConfig
+	RateLimit      RateLimitConfig
+	Catalog        CatalogConfig
+	Prices         PricesConfig
+	RAG            RAGConfig
 }
 
 type KeycloakConfig struct {
@@ -22,6 +33,14 @@ type KeycloakConfig struct {
 	ClientID string
 	Issuer   string
 	JWKSURL  string
+	// Audience, when set, must appear in the token's aud claim. Otherwise
+	// tokens must have been issued to ClientID (azp or aud).
+	Audience string
+	// ClockSkew is the leeway allowed when checking exp and nbf.
+	ClockSkew time.Duration
+	// JWKSRefresh is how often the signing keys are refetched in the
+	// background; unknown key IDs also trigger a refetch.
+	JWKSRefresh time.Duration
 }
 
 func Load() Config {
@@ -30,21 +49,113 @@ func Load() Config {
 		Env:            getenv("APP_ENV", "dev"),
 		AllowedOrigins: getenv("ALLOWED_ORIGINS", "*"),
 		LLMProxyURL:    getenv("LLM_PROXY_URL", ""),
-		LLMProxyToken:  getenv("LLM_PROXY_TOKEN", ""),
+		LLMProxyAuth: LLMProxyAuthConfig{
+			Mode:         strings.ToLower(getenv("LLM_PROXY_AUTH", "token")),
+			Secret:       getenv("LLM_PROXY_TOKEN", ""),
+			Caller:       getenv("LLM_PROXY_CALLER", "orchestrator"),
+			ClientID:     getenv("LLM_PROXY_CLIENT_ID", ""),
+			ClientSecret: getenv("LLM_PROXY_CLIENT_SECRET", ""),
+			TokenURL:     getenv("LLM_PROXY_TOKEN_URL", ""),
+		},
+		LLMProxy: LLMProxyClientConfig{
+			ConnectTimeout:   getenvDuration("LLM_PROXY_CONNECT_TIMEOUT", 5*time.Second),
+			FirstByteTimeout: getenvDuration("LLM_PROXY_FIRST_BYTE_TIMEOUT", 60*time.Second),
+			IdleTimeout:      getenvDuration("LLM_PROXY_IDLE_TIMEOUT", 60*time.Second),
+			Retries:          getenvInt("LLM_PROXY_RETRIES", 2),
+			RetryBackoff:     getenvDuration("LLM_PROXY_RETRY_BACKOFF", 250*time.Millisecond),
+			BreakerThreshold: getenvInt("LLM_PROXY_BREAKER_THRESHOLD", 5),
+			BreakerCooldown:  getenvDuration("LLM_PROXY_BREAKER_COOLDOWN", 30*time.Second),
+		},
 		Keycloak: KeycloakConfig{
-			URL:      getenv("KEYCLOAK_URL", ""),
-			Realm:    getenv("KEYCLOAK_REALM", ""),
-			ClientID: getenv("KEYCLOAK_CLIENT_ID", ""),
-			Issuer:   getenv("KEYCLOAK_ISSUER_URL", ""),
-			JWKSURL:  getenv("KEYCLOAK_JWKS_URL", ""),
+			URL:         getenv("KEYCLOAK_URL", ""),
+			Realm:       getenv("KEYCLOAK_REALM", ""),
+			ClientID:    getenv("KEYCLOAK_CLIENT_ID", ""),
+			Issuer:      getenv("KEYCLOAK_ISSUER_URL", ""),
+			JWKSURL:     getenv("KEYCLOAK_JWKS_URL", ""),
+			Audience:    getenv("KEYCLOAK_AUDIENCE", ""),
+			ClockSkew:   getenvDuration("KEYCLOAK_CLOCK_SKEW", 30*time.Second),
+			JWKSRefresh: getenvDuration("KEYCLOAK_JWKS_REFRESH", 10*time.Minute),
 		},
 		AuthService: AuthServiceConfig{
-			BaseURL: getenv("AUTH_SERVICE_URL", getenv("AUTH_SERVICE_BASE_URL", "")),
+			BaseURL:         getenv("AUTH_SERVICE_URL", getenv("AUTH_SERVICE_BASE_URL", "")),
+			ProfileFallback: getenvBool("AUTH_PROFILE_FALLBACK", false),
+		},
+		AuthPolicyFile: getenv("AUTH_POLICY_FILE", ""),
+		Store: StoreConfig{
+			Driver: getenv("STORE_DRIVER", "memory"),
+			DSN:    getenv("STORE_DSN", "file:orchestrator.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"),
+		},
+		Chat: ChatConfig{
+			AllowClientHistory: getenvBool("CHAT_ALLOW_CLIENT_HISTORY", false),
+			StreamBufferEvents: getenvInt("CHAT_STREAM_BUFFER_EVENTS", 1024),
+			ResumeGrace:        getenvDuration("CHAT_RESUME_GRACE", 30*time.Second),
+			ResumeRetention:    getenvDuration("CHAT_RESUME_RETENTION", 2*time.Minute),
+			MaxToolSteps:       getenvInt("CHAT_MAX_TOOL_STEPS", 5),
+			ToolTimeout:        getenvDuration("CHAT_TOOL_TIMEOUT", 30*time.Second),
+			StreamHeartbeat:    getenvDuration("CHAT_STREAM_HEARTBEAT", 15*time.Second),
+			StreamStallTimeout: getenvDuration("CHAT_STREAM_STALL_TIMEOUT", 2*time.Minute),
+			TitleGeneration:    getenvBool("CHAT_TITLE_GENERATION", true),
+			TitleModel:         getenv("CHAT_TITLE_MODEL", ""),
+			TitleTimeout:       getenvDuration("CHAT_TITLE_TIMEOUT", 15*time.Second),
+			ContextFitting:     getenvBool("CHAT_CONTEXT_FITTING", true),
+			HistorySummary:     getenvBool("CHAT_HISTORY_SUMMARY", false),
+			SummaryModel:       getenv("CHAT_SUMMARY_MODEL", ""),
+			SummaryMaxTokens:   getenvInt("CHAT_SUMMARY_MAX_TOKENS", 400),
+			SummaryTimeout:     getenvDuration("CHAT_SUMMARY_TIMEOUT", 30*time.Second),
+		},
+		Quota: QuotaConfig{
+			Default: TokenBudget{
+				Daily:   getenvInt("QUOTA_DAILY_TOKENS", 0),
+				Monthly: getenvInt("QUOTA_MONTHLY_TOKENS", 0),
+			},
+			Roles: parseRoleBudgets(getenv("QUOTA_ROLE_BUDGETS", "")),
+		},
+		RateLimit: RateLimitConfig{
+			RPS:        getenvFloat("RATE_LIMIT_RPS", 1),
+			Burst:      getenvInt("RATE_LIMIT_BURST", 10),
+			MaxStreams: getenvInt("RATE_LIMIT_MAX_STREAMS", 3),
+			RedisURL:   getenv("RATE_LIMIT_REDIS_URL", ""),
+			TrustProxy: getenvBool("RATE_LIMIT_TRUST_PROXY", false),
+		},
+		Catalog: CatalogConfig{
+			FeedDir: getenv("CATALOG_FEED_DIR", ""),
+			Refresh: getenvDuration("CATALOG_REFRESH", 15*time.Minute),
+			Store: StoreConfig{
+				Driver: getenv("CATALOG_STORE_DRIVER", "memory"),
+				DSN:    getenv("CATALOG_STORE_DSN", "file:catalog.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"),
+			},
+		},
+		Prices: PricesConfig{
+			Store: StoreConfig{
+				Driver: getenv("PRICES_STORE_DRIVER", "memory"),
+				DSN:    getenv("PRICES_STORE_DSN", "file:prices.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"),
+			},
+			WebhookURL:    getenv("PRICE_ALERT_WEBHOOK_URL", ""),
+			WebhookSecret: getenv("PRICE_ALERT_WEBHOOK_SECRET", ""),
+			SMTP: SMTPConfig{
+				Addr:     getenv("SMTP_ADDR", ""),
+				From:     getenv("SMTP_FROM", "alerts@shopmind.local"),
+				Username: getenv("SMTP_USERNAME", ""),
+				Password: getenv("SMTP_PASSWORD", ""),
+			},
+		},
+		RAG: RAGConfig{
+			Endpoints:      splitList(getenv("RAG_ENDPOINTS", "")),
+			HelpDir:        getenv("RAG_HELP_DIR", ""),
+			HelpBaseURL:    getenv("RAG_HELP_BASE_URL", ""),
+			IndexPath:      getenv("RAG_INDEX_PATH", "rag-index.gob"),
+			EmbeddingModel: getenv("RAG_EMBEDDING_MODEL", ""),
+			TopK:           getenvInt("RAG_TOP_K", 4),
+			MinScore:       getenvFloat("RAG_MIN_SCORE", 0.2),
+			Refresh:        getenvDuration("RAG_REFRESH", 15*time.Minute),
 		},
 	}
 
 	cfg.Keycloak.populateDerived()
 	cfg.AuthService.populateDerived()
+	if cfg.LLMProxyAuth.TokenURL == "" && cfg.Keycloak.Issuer != "" {
+		cfg.LLMProxyAuth.TokenURL = strings.TrimRight(cfg.Keycloak.Issuer, "/") + "/protocol/openid-connect/token"
+	}
 	return cfg
 }
 
@@ -71,9 +182,44 @@ func (kc KeycloakConfig) Enabled() bool {
 	return kc.URL != "" && kc.Realm != ""
 }
 
+// LLMProxyAuthConfig is how the orchestrator authenticates to llm-proxy;
+// Mode must match the proxy's LLM_PROXY_AUTH.
+type LLMProxyAuthConfig struct {
+	// Mode is "token" (Secret as a bearer token), "hmac" (requests signed
+	// with Secret as Caller) or "jwt" (Keycloak client-credentials tokens).
+	Mode   string
+	Secret string
+	Caller string
+	// ClientID and ClientSecret obtain tokens from TokenURL in "jwt" mode.
+	ClientID     string
+	ClientSecret string
+	TokenURL     string
+}
+
+// LLMProxyClientConfig tunes the orchestrator's calls to llm-proxy. A zero
+// timeout disables it; a zero BreakerThreshold disables the breaker.
+type LLMProxyClientConfig struct {
+	ConnectTimeout time.Duration
+	// FirstByteTimeout bounds the wait for the first byte of a response,
+	// IdleTimeout the wait between chunks of a stream.
+	FirstByteTimeout time.Duration
+	IdleTimeout      time.Duration
+	// Retries is how many times a request that failed before any response
+	// bytes arrived is retried, after RetryBackoff doubling each time.
+	Retries      int
+	RetryBackoff time.Duration
+	// BreakerThreshold consecutive failures open the circuit breaker, which
+	// fails requests fast for BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
 type AuthServiceConfig struct {
 	BaseURL    string
 	ProfileURL string
+	// ProfileFallback verifies tokens through the auth service's profile
+	// endpoint when they cannot be checked locally against the JWKS.
+	ProfileFallback bool
 }
 
 func (ac *AuthServiceConfig) populateDerived() {
@@ -88,9 +234,222 @@ func (ac AuthServiceConfig) Enabled() bool {
 	return ac.BaseURL != ""
 }
 
+type StoreConfig struct {
+	Driver string // "memory" or "sqlite"
+	DSN    string // sqlite DSN, ignored by the memory driver
+}
+
+type ChatConfig struct {
+	// AllowClientHistory lets ephemeral requests supply their own message
+	// history instead of having it rebuilt from the conversation store.
+	AllowClientHistory bool
+	// StreamBufferEvents bounds the SSE events kept per generation for
+	// Last-Event-ID replay.
+	StreamBufferEvents int
+	// ResumeGrace is how long a generation keeps running after its last
+	// client disconnects, waiting for a resume.
+	ResumeGrace time.Duration
+	// ResumeRetention is how long a finished generation can still be replayed.
+	ResumeRetention time.Duration
+	// MaxToolSteps bounds the tool-calling rounds of one answer; the model
+	// must answer without tools after that many. Zero disables tools.
+	MaxToolSteps int
+	// ToolTimeout bounds a single tool call. Zero means no limit.
+	ToolTimeout time.Duration
+	// StreamHeartbeat is the interval of SSE comment frames sent to clients
+	// while no events arrive. Zero disables them.
+	StreamHeartbeat time.Duration
+	// StreamStallTimeout ends an answer with an error event when llm-proxy
+	// sends no events for this long. Zero disables it.
+	StreamStallTimeout time.Duration
+	// TitleGeneration names new conversations after their first exchange,
+	// asking TitleModel (empty: llm-proxy's default) for at most
+	// TitleTimeout before falling back to the start of the user's message.
+	TitleGeneration bool
+	TitleModel      string
+	TitleTimeout    time.Duration
+	// ContextFitting drops the oldest turns of a history that would not
+	// fit the model's context window, as reported by llm-proxy.
+	ContextFitting bool
+	// HistorySummary replaces dropped turns with a running summary written
+	// by SummaryModel (empty: llm-proxy's default) in at most
+	// SummaryMaxTokens tokens and SummaryTimeout. Summaries are cached per
+	// conversation and extended as more turns are dropped.
+	HistorySummary   bool
+	SummaryModel     string
+	SummaryMaxTokens int
+	SummaryTimeout   time.Duration
+}
+
+type QuotaConfig struct {
+	// Default applies to users without a role listed in Roles.
+	Default TokenBudget
+	// Roles maps role names (as in the auth policy) to their budgets. Users
+	// holding several listed roles get the most generous budget per period.
+	Roles map[string]TokenBudget
+}
+
+// TokenBudget limits prompt plus completion tokens per UTC day and calendar
+// month. Zero means unlimited.
+type TokenBudget struct {
+	Daily   int
+	Monthly int
+}
+
+// parseRoleBudgets parses "role=daily/monthly" pairs separated by commas,
+// e.g. "premium=200000/5000000,admin=0/0". Malformed entries are skipped.
+func parseRoleBudgets(value string) map[string]TokenBudget {
+	budgets := make(map[string]TokenBudget)
+	for _, entry := range strings.Split(value, ",") {
+		role, limits, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || role == "" {
+			continue
+		}
+		daily, monthly, ok := strings.Cut(limits, "/")
+		if !ok {
+			continue
+		}
+		d, err1 := strconv.Atoi(strings.TrimSpace(daily))
+		m, err2 := strconv.Atoi(strings.TrimSpace(monthly))
+		if err1 != nil || err2 != nil || d < 0 || m < 0 {
+			continue
+		}
+		budgets[strings.TrimSpace(role)] = TokenBudget{Daily: d, Monthly: m}
+	}
+	return budgets
+}
+
+type RateLimitConfig struct {
+	// RPS and Burst size the per-caller token bucket on chat routes; RPS 0
+	// disables it.
+	RPS   float64
+	Burst int
+	// MaxStreams caps concurrent chat streams per caller; 0 disables it.
+	MaxStreams int
+	// RedisURL (redis:// or rediss://[user:password@]host:port[/db]) shares
+	// limiter state across instances. Empty keeps it in memory.
+	RedisURL string
+	// TrustProxy keys anonymous callers by X-Forwarded-For / X-Real-IP
+	// instead of the connection address.
+	TrustProxy bool
+}
+
+type CatalogConfig struct {
+	// FeedDir holds the product feeds (.csv, .tsv, .jsonl, .ndjson, .xml);
+	// empty disables the catalog.
+	FeedDir string
+	// Refresh is how often the feeds are re-ingested; 0 ingests once at
+	// startup (and on demand through the API).
+	Refresh time.Duration
+	Store   StoreConfig
+}
+
+// PricesConfig configures price history and price-drop alerts, which are
+// available whenever the catalog is. In-app alerts are always enabled;
+// webhook and email delivery are enabled by their settings.
+type PricesConfig struct {
+	Store StoreConfig
+	// WebhookURL receives every alert of subscriptions on the webhook
+	// channel, signed with WebhookSecret when set.
+	WebhookURL    string
+	WebhookSecret string
+	SMTP          SMTPConfig
+}
+
+// SMTPConfig is the mail relay used for email alerts; empty Addr disables
+// email. A local catcher such as MailHog works for development.
+type SMTPConfig struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// RAGConfig configures retrieval-augmented answers: passages from the
+// catalog and the help center are embedded into a local vector index, and
+// the best matches for the latest user message are given to the model as
+// sources it can cite.
+type RAGConfig struct {
+	// Endpoints lists the agent endpoints that retrieve; empty disables
+	// retrieval.
+	Endpoints []string
+	// HelpDir holds the help-center markdown; HelpBaseURL, when set, is
+	// where those pages are published, for citation links.
+	HelpDir     string
+	HelpBaseURL string
+	// IndexPath is where the vector index is persisted; empty keeps it in
+	// memory only.
+	IndexPath string
+	// EmbeddingModel is passed to llm-proxy; empty uses its default.
+	EmbeddingModel string
+	// TopK bounds the passages given to the model; passages scoring below
+	// MinScore (cosine similarity) are left out.
+	TopK     int
+	MinScore float64
+	// Refresh is how often the help center is re-indexed; catalog changes
+	// are indexed as they are ingested.
+	Refresh time.Duration
+}
+
+// RetrievalEnabled reports whether endpoint answers with retrieved sources.
+func (c RAGConfig) RetrievalEnabled(endpoint string) bool {
+	for _, e := range c.Endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getenv(key, def string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
 	}
 	return def
 }
+
+func getenvBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+func getenvInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func getenvFloat(key string, def float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
@@ -0,0 +1,243 @@
+// Package generation tracks agent chat generations. A generation runs
+// independently of the request that started it: its SSE events are kept in
+// a bounded log so clients can detach, resume from a Last-Event-ID and be
+// looked up (or aborted) from other requests.
+package generation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrAborted is the cancellation cause of a generation stopped through Abort.
+	ErrAborted = errors.New("generation aborted")
+	// ErrAbandoned is the cancellation cause of a generation nobody was
+	// listening to for longer than the detach grace period.
+	ErrAbandoned = errors.New("generation abandoned")
+)
+
+type Options struct {
+	// BufferEvents bounds each generation's event log; older events are
+	// dropped first.
+	BufferEvents int
+	// DetachGrace is how long a generation keeps running without any
+	// attached client before it is cancelled. Zero cancels immediately.
+	DetachGrace time.Duration
+	// Retention is how long a finished generation stays available for
+	// replay.
+	Retention time.Duration
+}
+
+// Event is one SSE event of a generation. IDs increase monotonically from 1.
+type Event struct {
+	ID   int64
+	Data json.RawMessage
+}
+
+type Generation struct {
+	UserID            string
+	ConversationID    string
+	RequestMessageID  string
+	ResponseMessageID string
+
+	opts      Options
+	startedAt time.Time
+	cancel    context.CancelCauseFunc
+	done      chan struct{}
+
+	mu          sync.Mutex
+	text        strings.Builder
+	events      []Event
+	nextID      int64
+	notify      chan struct{}
+	finished    bool
+	subscribers int
+	detachTimer *time.Timer
+}
+
+// Append adds streamed text and returns the text generated so far.
+func (g *Generation) Append(text string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.text.WriteString(text)
+	return g.text.String()
+}
+
+func (g *Generation) Text() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.text.String()
+}
+
+// Emit appends an event with the next ID to the log and wakes up readers.
+func (g *Generation) Emit(payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.nextID++
+	g.events = append(g.events, Event{ID: g.nextID, Data: data})
+	if limit := g.opts.BufferEvents; limit > 0 && len(g.events) > limit {
+		g.events = append(g.events[:0:0], g.events[len(g.events)-limit:]...)
+	}
+	close(g.notify)
+	g.notify = make(chan struct{})
+	return nil
+}
+
+// EventsAfter returns the retained events with an ID greater than lastID, a
+// channel closed when more events arrive, and whether the log is complete.
+func (g *Generation) EventsAfter(lastID int64) ([]Event, <-chan struct{}, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	i := sort.Search(len(g.events), func(i int) bool { return g.events[i].ID > lastID })
+	events := make([]Event, len(g.events)-i)
+	copy(events, g.events[i:])
+	return events, g.notify, g.finished
+}
+
+// Attach registers a client reading the event log.
+func (g *Generation) Attach() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.subscribers++
+	if g.detachTimer != nil {
+		g.detachTimer.Stop()
+		g.detachTimer = nil
+	}
+}
+
+// Detach unregisters a client. Once the last one leaves, the generation is
+// cancelled with ErrAbandoned unless a client attaches within DetachGrace.
+func (g *Generation) Detach() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.subscribers--
+	if g.subscribers > 0 || g.finished {
+		return
+	}
+	if g.opts.DetachGrace <= 0 {
+		g.cancel(ErrAbandoned)
+		return
+	}
+	g.detachTimer = time.AfterFunc(g.opts.DetachGrace, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.subscribers == 0 && !g.finished {
+			g.cancel(ErrAbandoned)
+		}
+	})
+}
+
+// Running reports whether the generation is still producing events.
+func (g *Generation) Running() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !g.finished
+}
+
+// Abort cancels the generation's context with ErrAborted.
+func (g *Generation) Abort() {
+	g.cancel(ErrAborted)
+}
+
+// Done is closed once the generation has finished, including saving its
+// final message.
+func (g *Generation) Done() <-chan struct{} {
+	return g.done
+}
+
+type Registry struct {
+	opts        Options
+	mu          sync.Mutex
+	generations map[string]*Generation // keyed by response message ID
+}
+
+func NewRegistry(opts Options) *Registry {
+	return &Registry{opts: opts, generations: make(map[string]*Generation)}
+}
+
+// Start registers a generation and returns a context derived from parent
+// that is cancelled by Abort or abandonment. Finish must be called when done.
+func (r *Registry) Start(parent context.Context, g *Generation) context.Context {
+	ctx, cancel := context.WithCancelCause(parent)
+	g.opts = r.opts
+	g.startedAt = time.Now()
+	g.cancel = cancel
+	g.done = make(chan struct{})
+	g.notify = make(chan struct{})
+
+	r.mu.Lock()
+	r.generations[g.ResponseMessageID] = g
+	r.mu.Unlock()
+	return ctx
+}
+
+// Finish completes the event log, releases the generation's context and
+// keeps it available for replay during the retention period.
+func (r *Registry) Finish(g *Generation) {
+	g.mu.Lock()
+	g.finished = true
+	if g.detachTimer != nil {
+		g.detachTimer.Stop()
+		g.detachTimer = nil
+	}
+	close(g.notify)
+	g.notify = make(chan struct{})
+	g.mu.Unlock()
+
+	g.cancel(nil)
+	close(g.done)
+
+	time.AfterFunc(r.opts.Retention, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.generations[g.ResponseMessageID] == g {
+			delete(r.generations, g.ResponseMessageID)
+		}
+	})
+}
+
+// Lookup finds the user's generation by message ID (request or response)
+// or, when messageID is empty, by conversation ID. Running generations are
+// preferred over finished ones, then the most recently started.
+func (r *Registry) Lookup(userID, conversationID, messageID string) *Generation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var best *Generation
+	for _, g := range r.generations {
+		if g.UserID != userID {
+			continue
+		}
+		if messageID != "" {
+			if g.ResponseMessageID != messageID && g.RequestMessageID != messageID {
+				continue
+			}
+		} else if conversationID == "" || g.ConversationID != conversationID {
+			continue
+		}
+		if best == nil || better(g, best) {
+			best = g
+		}
+	}
+	return best
+}
+
+func better(a, b *Generation) bool {
+	aRunning, bRunning := a.Running(), b.Running()
+	if aRunning != bRunning {
+		return aRunning
+	}
+	return a.startedAt.After(b.startedAt)
+}
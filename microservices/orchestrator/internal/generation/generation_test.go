@@ -0,0 +1,67 @@
+package generation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEventLogIsBounded(t *testing.T) {
+	reg := NewRegistry(Options{BufferEvents: 2, DetachGrace: time.Minute, Retention: time.Minute})
+	g := &Generation{ResponseMessageID: "r1"}
+	reg.Start(context.Background(), g)
+
+	for i := 0; i < 3; i++ {
+		if err := g.Emit(map[string]int{"n": i}); err != nil {
+			t.Fatalf("emit: %v", err)
+		}
+	}
+	events, _, finished := g.EventsAfter(0)
+	if len(events) != 2 || events[0].ID != 2 || events[1].ID != 3 || finished {
+		t.Fatalf("unexpected log %+v finished=%v", events, finished)
+	}
+	if events, _, _ = g.EventsAfter(2); len(events) != 1 || string(events[0].Data) != `{"n":2}` {
+		t.Errorf("unexpected replay after 2: %+v", events)
+	}
+
+	_, more, _ := g.EventsAfter(3)
+	reg.Finish(g)
+	select {
+	case <-more:
+	default:
+		t.Fatal("finish did not wake readers")
+	}
+	if _, _, finished = g.EventsAfter(3); !finished {
+		t.Error("log not marked finished")
+	}
+}
+
+func TestDetachedGenerationIsAbandoned(t *testing.T) {
+	reg := NewRegistry(Options{DetachGrace: 20 * time.Millisecond, Retention: time.Minute})
+	g := &Generation{UserID: "u1", ConversationID: "c1", ResponseMessageID: "r1"}
+	ctx := reg.Start(context.Background(), g)
+
+	g.Attach()
+	g.Detach()
+	g.Attach() // reattached within the grace period
+	time.Sleep(40 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("generation cancelled while a client was attached")
+	}
+
+	g.Detach()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("generation not cancelled after the grace period")
+	}
+	if !errors.Is(context.Cause(ctx), ErrAbandoned) {
+		t.Errorf("unexpected cause %v", context.Cause(ctx))
+	}
+
+	reg.Finish(g)
+	if got := reg.Lookup("u1", "c1", ""); got != g || got.Running() {
+		t.Errorf("finished generation not retained for replay: %+v", got)
+	}
+}
@@ -0,0 +1,75 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/generation"
+)
+
+// abortWait bounds how long the abort endpoint waits for the generation to
+// save the partial answer.
+const abortWait = 5 * time.Second
+
+type abortPayload struct {
+	ConversationID string `json:"conversationId"`
+	// AbortKey is the conversation ID, as sent by the LibreChat client.
+	AbortKey  string `json:"abortKey"`
+	MessageID string `json:"messageId"`
+}
+
+func (s *Server) handleAgentChatAbort(w http.ResponseWriter, r *http.Request) {
+	var payload abortPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	conversationID := payload.ConversationID
+	if conversationID == "" {
+		conversationID = payload.AbortKey
+	}
+	if conversationID == "" && payload.MessageID == "" {
+		http.Error(w, "missing conversationId or messageId", http.StatusBadRequest)
+		return
+	}
+
+	gen := s.generations.Lookup(subjectFromContext(r.Context()), conversationID, payload.MessageID)
+	if gen == nil || !gen.Running() {
+		http.Error(w, "no active generation", http.StatusNotFound)
+		return
+	}
+
+	gen.Abort()
+	select {
+	case <-gen.Done():
+	case <-time.After(abortWait):
+	case <-r.Context().Done():
+		return
+	}
+
+	writeJSON(w, http.StatusOK, abortResponse(gen))
+}
+
+func abortResponse(gen *generation.Generation) map[string]any {
+	return map[string]any{
+		"final":   true,
+		"aborted": true,
+		"conversation": map[string]any{
+			"conversationId": gen.ConversationID,
+		},
+		"requestMessage": map[string]any{
+			"messageId":      gen.RequestMessageID,
+			"conversationId": gen.ConversationID,
+		},
+		"responseMessage": map[string]any{
+			"messageId":       gen.ResponseMessageID,
+			"conversationId":  gen.ConversationID,
+			"parentMessageId": gen.RequestMessageID,
+			"sender":          "Assistant",
+			"text":            gen.Text(),
+			"isCreatedByUser": false,
+			"unfinished":      true,
+		},
+	}
+}
@@ -0,0 +1,51 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/orchestrator/internal/auth"
+)
+
+// claimsFromContext returns the authenticated user's claims, or nil when
+// authentication is disabled.
+func claimsFromContext(ctx context.Context) *auth.Claims {
+	claims, _ := ctx.Value(claimsContextKey{}).(*auth.Claims)
+	return claims
+}
+
+// authorizeRoute applies the policy's route rules to the matched route.
+func (s *Server) authorizeRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pattern := chi.RouteContext(r.Context()).RoutePattern()
+		if denial := s.policy.CheckRoute(claimsFromContext(r.Context()), r.Method, pattern); denial != nil {
+			writeForbidden(w, denial)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type forbiddenResponse struct {
+	Error          string   `json:"error"`
+	Message        string   `json:"message"`
+	Resource       string   `json:"resource"`
+	Name           string   `json:"name"`
+	RequiredRoles  []string `json:"requiredRoles,omitempty"`
+	RequiredScopes []string `json:"requiredScopes,omitempty"`
+}
+
+func writeForbidden(w http.ResponseWriter, denial *auth.Denial) {
+	log.Info().Str("resource", denial.Resource).Str("name", denial.Name).Msg("request denied by policy")
+	writeJSON(w, http.StatusForbidden, forbiddenResponse{
+		Error:          "forbidden",
+		Message:        denial.Error(),
+		Resource:       denial.Resource,
+		Name:           denial.Name,
+		RequiredRoles:  denial.Rule.Roles,
+		RequiredScopes: denial.Rule.Scopes,
+	})
+}
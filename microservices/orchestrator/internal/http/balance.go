@@ -0,0 +1,138 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/orchestrator/internal/generation"
+	"github.com/shopmindai/orchestrator/internal/llmproxy"
+	"github.com/shopmindai/orchestrator/internal/quota"
+	"github.com/shopmindai/orchestrator/internal/store"
+)
+
+// balanceResponse follows LibreChat's balance shape; tokenCredits is the
+// budget left in the tightest period and is omitted for unlimited users.
+type balanceResponse struct {
+	TokenCredits      *int         `json:"tokenCredits,omitempty"`
+	AutoRefillEnabled bool         `json:"autoRefillEnabled"`
+	Daily             quota.Period `json:"daily"`
+	Monthly           quota.Period `json:"monthly"`
+}
+
+func (s *Server) handleBalance(w http.ResponseWriter, r *http.Request) {
+	status, err := s.quotas.Status(r.Context(), subjectFromContext(r.Context()), claimsFromContext(r.Context()))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read token usage")
+		http.Error(w, "usage ledger unavailable", http.StatusInternalServerError)
+		return
+	}
+	resp := balanceResponse{Daily: status.Daily, Monthly: status.Monthly}
+	if remaining := status.Remaining(); remaining != quota.Unlimited {
+		resp.TokenCredits = &remaining
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type quotaExceededResponse struct {
+	Error   string       `json:"error"`
+	Message string       `json:"message"`
+	Period  string       `json:"period"`
+	Usage   quota.Period `json:"usage"`
+}
+
+func writeQuotaExceeded(w http.ResponseWriter, err error) {
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	writeJSON(w, http.StatusTooManyRequests, quotaExceededResponse{
+		Error:   "quota_exceeded",
+		Message: exceeded.Error(),
+		Period:  exceeded.Period.Name,
+		Usage:   exceeded.Period,
+	})
+}
+
+// recordUsage adds the token usage of one upstream request to the ledger
+// and charges it to the turn's budget. Usage that llm-proxy did not
+// report, e.g. because the stream was cut short, is estimated from the
+// request body and the streamed text.
+func (s *Server) recordUsage(ctx context.Context, turn agentTurn, gen *generation.Generation, body []byte, result upstreamResult) {
+	usage := store.Usage{
+		UserID:         turn.userID,
+		ConversationID: turn.conversationID,
+		MessageID:      gen.ResponseMessageID,
+		Model:          result.Model,
+	}
+	if usage.Model == "" {
+		usage.Model = turn.payload.Model
+	}
+	if result.Usage != nil {
+		usage.PromptTokens = result.Usage.PromptTokens
+		usage.CompletionTokens = result.Usage.CompletionTokens
+	} else {
+		usage.PromptTokens = estimateTokens(string(body))
+		usage.CompletionTokens = estimateTokens(result.Text)
+		for _, call := range result.ToolCalls {
+			usage.CompletionTokens += estimateTokens(call.Name + call.Arguments)
+		}
+	}
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		return
+	}
+	turn.budget.Charge(usage.PromptTokens + usage.CompletionTokens)
+	if err := s.quotas.Record(ctx, &usage); err != nil {
+		log.Error().Err(err).Str("messageId", usage.MessageID).Msg("failed to record token usage")
+	}
+}
+
+// recordStreamUsage adds the usage of a stream piped to the client
+// unchanged to the ledger. Usage that llm-proxy did not report is estimated
+// from the request body and the streamed text.
+func (s *Server) recordStreamUsage(ctx context.Context, usage store.Usage, body []byte, stream *llmproxy.StreamReader) {
+	var text strings.Builder
+	var reported *llmproxy.Usage
+	for {
+		evt, err := stream.Next()
+		if err != nil {
+			break
+		}
+		switch evt.Type {
+		case llmproxy.EventRoute:
+			if evt.Model != "" {
+				usage.Model = evt.Model
+			}
+		case llmproxy.EventDelta:
+			text.WriteString(evt.Text)
+		case llmproxy.EventToolCall:
+			if evt.ToolCall != nil {
+				text.WriteString(evt.ToolCall.Name + evt.ToolCall.Arguments)
+			}
+		case llmproxy.EventUsage:
+			reported = evt.Usage
+		}
+	}
+	if reported != nil {
+		usage.PromptTokens = reported.PromptTokens
+		usage.CompletionTokens = reported.CompletionTokens
+	} else {
+		usage.PromptTokens = estimateTokens(string(body))
+		usage.CompletionTokens = estimateTokens(text.String())
+	}
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		return
+	}
+	if err := s.quotas.Record(ctx, &usage); err != nil {
+		log.Error().Err(err).Str("conversationId", usage.ConversationID).Msg("failed to record token usage")
+	}
+}
+
+// estimateTokens approximates a token count at four bytes per token.
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
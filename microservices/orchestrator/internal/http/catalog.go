@@ -0,0 +1,88 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/orchestrator/internal/catalog"
+)
+
+func (s *Server) handleCatalogSearch(w http.ResponseWriter, r *http.Request) {
+	query, err := catalogQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.catalog.Search(query))
+}
+
+// catalogQuery reads a search from q, min_price, max_price, brand, category,
+// availability, sort, limit and offset.
+func catalogQuery(values url.Values) (catalog.Query, error) {
+	query := catalog.Query{
+		Text:         values.Get("q"),
+		Brand:        values.Get("brand"),
+		Category:     values.Get("category"),
+		Availability: catalog.Availability(values.Get("availability")),
+		Sort:         catalog.Sort(values.Get("sort")),
+	}
+	switch query.Availability {
+	case "", catalog.InStock, catalog.OutOfStock, catalog.Preorder, catalog.Backorder:
+	default:
+		return query, fmt.Errorf("unknown availability %q", query.Availability)
+	}
+	switch query.Sort {
+	case "", catalog.SortRelevance, catalog.SortPriceAsc, catalog.SortPriceDesc:
+	default:
+		return query, fmt.Errorf("unknown sort %q", query.Sort)
+	}
+	for name, target := range map[string]**float64{"min_price": &query.MinPrice, "max_price": &query.MaxPrice} {
+		if v := values.Get(name); v != "" {
+			price, err := strconv.ParseFloat(v, 64)
+			if err != nil || price < 0 {
+				return query, fmt.Errorf("invalid %s", name)
+			}
+			*target = &price
+		}
+	}
+	for name, target := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		if v := values.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return query, fmt.Errorf("invalid %s", name)
+			}
+			*target = n
+		}
+	}
+	return query, nil
+}
+
+func (s *Server) handleCatalogProduct(w http.ResponseWriter, r *http.Request) {
+	product, ok := s.catalog.Get(chi.URLParam(r, "feed"), chi.URLParam(r, "productId"))
+	if !ok {
+		http.Error(w, "product not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, product)
+}
+
+func (s *Server) handleCatalogFeeds(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"feeds": s.catalog.Reports()})
+}
+
+// handleCatalogIngest re-reads the feed directory now instead of waiting for
+// the next refresh.
+func (s *Server) handleCatalogIngest(w http.ResponseWriter, r *http.Request) {
+	reports, err := s.catalog.Ingest(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("catalog ingestion failed")
+		http.Error(w, "catalog ingestion failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"feeds": reports})
+}
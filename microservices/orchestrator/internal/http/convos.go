@@ -0,0 +1,177 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/orchestrator/internal/store"
+)
+
+type conversationPayload struct {
+	ConversationID string  `json:"conversationId"`
+	Title          *string `json:"title"`
+	Endpoint       *string `json:"endpoint"`
+	EndpointType   *string `json:"endpointType"`
+	Model          *string `json:"model"`
+	PromptPrefix   *string `json:"promptPrefix"`
+}
+
+func (p conversationPayload) apply(conv *store.Conversation) {
+	if p.Title != nil {
+		conv.Title = strings.TrimSpace(*p.Title)
+	}
+	if p.Endpoint != nil {
+		conv.Endpoint = *p.Endpoint
+	}
+	if p.EndpointType != nil {
+		conv.EndpointType = *p.EndpointType
+	}
+	if p.Model != nil {
+		conv.Model = *p.Model
+	}
+	if p.PromptPrefix != nil {
+		conv.PromptPrefix = *p.PromptPrefix
+	}
+}
+
+func (s *Server) handleListConversations(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	convs, next, err := s.store.ListConversations(r.Context(), subjectFromContext(r.Context()), store.ListOptions{
+		Limit:  limit,
+		Cursor: r.URL.Query().Get("cursor"),
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to list conversations")
+		http.Error(w, "failed to list conversations", http.StatusBadRequest)
+		return
+	}
+	if convs == nil {
+		convs = []store.Conversation{}
+	}
+
+	resp := map[string]any{"conversations": convs}
+	if next != "" {
+		resp["nextCursor"] = next
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleGetConversation(w http.ResponseWriter, r *http.Request) {
+	conv, err := s.store.GetConversation(r.Context(), subjectFromContext(r.Context()), chi.URLParam(r, "conversationId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, conv)
+}
+
+func (s *Server) handleCreateConversation(w http.ResponseWriter, r *http.Request) {
+	var payload conversationPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	conv := store.Conversation{
+		ConversationID: normalizeConversationID(payload.ConversationID),
+		UserID:         subjectFromContext(r.Context()),
+	}
+	payload.apply(&conv)
+	if err := s.store.SaveConversation(r.Context(), &conv); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, conv)
+}
+
+func (s *Server) handleUpdateConversation(w http.ResponseWriter, r *http.Request) {
+	var payload conversationPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	conv, err := s.store.GetConversation(r.Context(), subjectFromContext(r.Context()), chi.URLParam(r, "conversationId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	payload.apply(conv)
+	if err := s.store.SaveConversation(r.Context(), conv); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, conv)
+}
+
+func (s *Server) handleDeleteConversation(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.DeleteConversation(r.Context(), subjectFromContext(r.Context()), chi.URLParam(r, "conversationId")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Conversation deleted successfully"})
+}
+
+func (s *Server) handleListMessages(w http.ResponseWriter, r *http.Request) {
+	conversationID := chi.URLParam(r, "conversationId")
+	if _, err := s.store.GetConversation(r.Context(), subjectFromContext(r.Context()), conversationID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	messages, err := s.store.ListMessages(r.Context(), conversationID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, messages)
+}
+
+func (s *Server) handleMessageTree(w http.ResponseWriter, r *http.Request) {
+	conversationID := chi.URLParam(r, "conversationId")
+	if _, err := s.store.GetConversation(r.Context(), subjectFromContext(r.Context()), conversationID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	messages, err := s.store.ListMessages(r.Context(), conversationID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, store.BuildTree(messages))
+}
+
+// subjectFromContext returns the authenticated user's subject, or "" when
+// authentication is disabled.
+func subjectFromContext(ctx context.Context) string {
+	if claims := claimsFromContext(ctx); claims != nil {
+		return claims.Subject
+	}
+	return ""
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound), errors.Is(err, store.ErrForbidden):
+		http.Error(w, "conversation not found", http.StatusNotFound)
+	default:
+		log.Error().Err(err).Msg("conversation store failure")
+		http.Error(w, "conversation store unavailable", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		log.Warn().Err(err).Msg("failed to encode response")
+	}
+}
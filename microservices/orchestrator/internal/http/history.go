@@ -0,0 +1,209 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/orchestrator/internal/generation"
+	"github.com/shopmindai/orchestrator/internal/store"
+)
+
+const (
+	// contextHeadroom is the percentage of the context budget left unused,
+	// as llm-proxy's token counts are estimates.
+	contextHeadroom = 10
+	// defaultReplyReserve is kept free for the answer when llm-proxy does
+	// not bound its length.
+	defaultReplyReserve = 1024
+	summaryInstructions = "Summarize the conversation below between a shopper and a shopping assistant, " +
+		"extending the summary so far if there is one. Keep the products, preferences, budgets, sizes and " +
+		"decisions mentioned, in the language of the conversation. Reply with the summary only."
+	summaryHeading = "Summary of the earlier conversation:"
+)
+
+// tokenCount is llm-proxy's estimate of a chat request's size and the
+// limits of the models serving it.
+type tokenCount struct {
+	PromptTokens    int   `json:"prompt_tokens"`
+	Messages        []int `json:"messages"`
+	ContextWindow   int   `json:"context_window"`
+	MaxOutputTokens int   `json:"max_output_tokens"`
+}
+
+// fitHistory drops the oldest turns of a history that would not fit the
+// model's context window, leaving room for the answer. With HistorySummary
+// the dropped turns are replaced by a running summary in the system prompt.
+// The system prompt and the latest user message, with the sources retrieved
+// for it, are always kept. When the size or the limits are unknown the
+// history is sent as is.
+func (s *Server) fitHistory(ctx context.Context, gen *generation.Generation, turn agentTurn, upstream upstreamChatRequest) upstreamChatRequest {
+	count, err := s.countTokens(ctx, upstream)
+	if err != nil {
+		log.Warn().Err(err).Str("conversationId", turn.conversationID).Msg("token count failed, sending the full history")
+		return upstream
+	}
+	if count.ContextWindow <= 0 || len(count.Messages) != len(upstream.Messages) {
+		return upstream
+	}
+	reserve := count.MaxOutputTokens
+	if reserve <= 0 {
+		reserve = defaultReplyReserve
+	}
+	budget := (count.ContextWindow - reserve) * (100 - contextHeadroom) / 100
+	if count.PromptTokens <= budget {
+		return upstream
+	}
+	summarize := s.cfg.Chat.HistorySummary && s.cfg.Chat.SummaryMaxTokens > 0
+	if summarize {
+		budget -= s.cfg.Chat.SummaryMaxTokens
+	}
+
+	keep := len(upstream.Messages) - 1
+	for keep > 0 && upstream.Messages[keep-1].Role == "system" {
+		keep--
+	}
+	// Whole turns go, so that no answer is kept without its question.
+	total, drop := count.PromptTokens, 0
+	for drop < keep && total > budget {
+		total -= count.Messages[drop]
+		drop++
+		for drop < keep && upstream.Messages[drop].Role != "user" {
+			total -= count.Messages[drop]
+			drop++
+		}
+	}
+	logger := log.With().Str("conversationId", turn.conversationID).Int("dropped", drop).
+		Int("promptTokens", total).Int("budget", budget).Logger()
+	if total > budget {
+		logger.Warn().Msg("the latest message alone exceeds the context budget")
+	}
+	if drop == 0 {
+		return upstream
+	}
+	dropped := upstream.Messages[:drop]
+	upstream.Messages = upstream.Messages[drop:]
+	logger.Info().Msg("dropped the oldest turns to fit the context window")
+
+	if summarize {
+		summary, err := s.historySummary(ctx, gen, turn, dropped)
+		if err != nil {
+			log.Warn().Err(err).Str("conversationId", turn.conversationID).Msg("history summary failed, sending the history without it")
+			return upstream
+		}
+		if upstream.System != "" {
+			upstream.System += "\n\n"
+		}
+		upstream.System += summaryHeading + "\n" + summary
+	}
+	return upstream
+}
+
+// countTokens asks llm-proxy to size upstream.
+func (s *Server) countTokens(ctx context.Context, upstream upstreamChatRequest) (tokenCount, error) {
+	var count tokenCount
+	body, err := json.Marshal(upstream)
+	if err != nil {
+		return count, err
+	}
+	data, err := s.llmProxy.Post(ctx, "/v1/tokens/count", body)
+	if err != nil {
+		return count, err
+	}
+	if err := json.Unmarshal(data, &count); err != nil {
+		return count, fmt.Errorf("decode token count: %w", err)
+	}
+	return count, nil
+}
+
+// historySummary summarizes the dropped messages. The conversation's cached
+// summary is reused, and extended with the messages after it, when it
+// covers some of them; otherwise they are summarized from scratch. Stored
+// threads cache the new summary; ephemeral histories, whose messages have
+// no IDs, do not.
+func (s *Server) historySummary(ctx context.Context, gen *generation.Generation, turn agentTurn, dropped []upstreamChatMessage) (string, error) {
+	through := dropped[len(dropped)-1].messageID
+	previous, pending := "", dropped
+	if through != "" {
+		cached, err := s.store.GetSummary(ctx, turn.conversationID)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+		case err != nil:
+			return "", err
+		default:
+			for i, msg := range dropped {
+				if msg.messageID == cached.ThroughMessageID {
+					previous, pending = cached.Text, dropped[i+1:]
+					break
+				}
+			}
+		}
+	}
+	if len(pending) == 0 {
+		return previous, nil
+	}
+
+	summary, err := s.summarize(ctx, gen, turn, previous, pending)
+	if err != nil {
+		return "", err
+	}
+	if through != "" {
+		if err := s.store.SaveSummary(context.WithoutCancel(ctx), &store.Summary{
+			ConversationID:   turn.conversationID,
+			ThroughMessageID: through,
+			Text:             summary,
+		}); err != nil {
+			log.Warn().Err(err).Str("conversationId", turn.conversationID).Msg("failed to cache history summary")
+		}
+	}
+	return summary, nil
+}
+
+// summarize asks llm-proxy's summary model to extend previous with messages.
+func (s *Server) summarize(ctx context.Context, gen *generation.Generation, turn agentTurn, previous string, messages []upstreamChatMessage) (string, error) {
+	if s.cfg.Chat.SummaryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.Chat.SummaryTimeout)
+		defer cancel()
+	}
+	var b strings.Builder
+	if previous != "" {
+		fmt.Fprintf(&b, "Summary so far:\n%s\n\nLater messages:\n\n", previous)
+	}
+	for _, msg := range messages {
+		fmt.Fprintf(&b, "%s: %s\n\n", speaker(msg.Role), msg.Content)
+	}
+	maxTokens, temperature := s.cfg.Chat.SummaryMaxTokens, 0.2
+	summary, err := s.complete(ctx, gen, turn, upstreamChatRequest{
+		Messages: []upstreamChatMessage{{Role: "user", Content: strings.TrimSpace(b.String())}},
+		System:   summaryInstructions,
+		Model:    s.cfg.Chat.SummaryModel,
+		Params:   upstreamGenerationParams{MaxTokens: &maxTokens, Temperature: &temperature},
+	})
+	if err != nil {
+		return "", err
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return "", errors.New("model wrote an empty summary")
+	}
+	return summary, nil
+}
+
+// speaker labels a message's role in a transcript.
+func speaker(role string) string {
+	switch role {
+	case "user":
+		return "User"
+	case "system":
+		return "System"
+	case "tool":
+		return "Tool"
+	default:
+		return "Assistant"
+	}
+}
@@ -0,0 +1,120 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// streamSlotTTL bounds how long a shared store keeps a stream slot whose
+// instance died before releasing it.
+const streamSlotTTL = 15 * time.Minute
+
+// rateLimit applies the per-caller token bucket. Store failures let the
+// request through: the limiter protects the LLM budget, not correctness.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.cfg.RateLimit
+		if cfg.RPS <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := s.callerKey(r)
+		decision, err := s.limits.Take(r.Context(), key, cfg.RPS, max(cfg.Burst, 1))
+		if err != nil {
+			log.Warn().Err(err).Str("key", key).Msg("rate limiter unavailable, allowing request")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+		if !decision.Allowed {
+			writeRateLimited(w, decision.RetryAfter, "rate limit exceeded, slow down")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquireStream takes one of the caller's concurrent stream slots for a
+// generation, which holds it until it finishes, even after its client has
+// gone. When the caller has no slot left it answers 429 and ok is false.
+// Store failures let the generation run: the limiter protects the LLM
+// budget, not correctness.
+func (s *Server) acquireStream(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	release = func() {}
+	limit := s.cfg.RateLimit.MaxStreams
+	if limit <= 0 {
+		return release, true
+	}
+
+	key := s.callerKey(r)
+	slot, acquired, err := s.limits.Acquire(r.Context(), key, limit, streamSlotTTL)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("stream limiter unavailable, allowing request")
+		return release, true
+	}
+	if !acquired {
+		writeRateLimited(w, time.Second, fmt.Sprintf("too many concurrent streams (limit %d)", limit))
+		return nil, false
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			// The generation outlives the request and its context.
+			if err := s.limits.Release(context.Background(), key, slot); err != nil {
+				log.Warn().Err(err).Str("key", key).Msg("failed to release stream slot")
+			}
+		})
+	}, true
+}
+
+// callerKey identifies the caller: the token subject, or the client IP for
+// anonymous requests.
+func (s *Server) callerKey(r *http.Request) string {
+	if subject := subjectFromContext(r.Context()); subject != "" {
+		return "user:" + subject
+	}
+	return "ip:" + s.clientIP(r)
+}
+
+func (s *Server) clientIP(r *http.Request) string {
+	if s.cfg.RateLimit.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+		if real := r.Header.Get("X-Real-IP"); real != "" {
+			return strings.TrimSpace(real)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeRateLimited(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(retryAfter), 1)))
+	writeJSON(w, http.StatusTooManyRequests, map[string]string{
+		"error":   "rate_limited",
+		"message": message,
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
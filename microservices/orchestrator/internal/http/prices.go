@@ -0,0 +1,171 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/orchestrator/internal/prices"
+)
+
+const (
+	defaultPriceHistoryDays = 90
+	maxPriceHistoryDays     = 730
+)
+
+func (s *Server) handlePriceHistory(w http.ResponseWriter, r *http.Request) {
+	days := defaultPriceHistoryDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxPriceHistoryDays {
+			http.Error(w, "invalid days", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	since := time.Now().UTC().AddDate(0, 0, -days)
+	history, err := s.prices.History(r.Context(), chi.URLParam(r, "feed"), chi.URLParam(r, "productId"), since)
+	if err != nil {
+		writePricesError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (s *Server) handlePriceOffers(w http.ResponseWriter, r *http.Request) {
+	offers, err := s.prices.Offers(chi.URLParam(r, "feed"), chi.URLParam(r, "productId"))
+	if err != nil {
+		writePricesError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, offers)
+}
+
+type subscriptionPayload struct {
+	Feed        string         `json:"feed"`
+	ProductID   string         `json:"productId"`
+	TargetPrice float64        `json:"targetPrice"`
+	Channel     prices.Channel `json:"channel"`
+}
+
+func (s *Server) handleListPriceSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSubject(w, r)
+	if !ok {
+		return
+	}
+	subs, err := s.prices.Subscriptions(r.Context(), userID)
+	if err != nil {
+		writePricesError(w, err)
+		return
+	}
+	if subs == nil {
+		subs = []prices.Subscription{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"subscriptions": subs, "channels": s.prices.Channels()})
+}
+
+func (s *Server) handleCreatePriceSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSubject(w, r)
+	if !ok {
+		return
+	}
+	var payload subscriptionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	sub, err := s.prices.Subscribe(r.Context(), prices.Subscription{
+		UserID:      userID,
+		Email:       claimsFromContext(r.Context()).Email,
+		Feed:        payload.Feed,
+		ProductID:   payload.ProductID,
+		TargetPrice: payload.TargetPrice,
+		Channel:     payload.Channel,
+	})
+	if err != nil {
+		writePricesError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+func (s *Server) handleDeletePriceSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSubject(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "subscriptionId"), 10, 64)
+	if err != nil {
+		http.Error(w, "subscription not found", http.StatusNotFound)
+		return
+	}
+	if err := s.prices.Unsubscribe(r.Context(), userID, id); err != nil {
+		writePricesError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSubject(w, r)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	unread, _ := strconv.ParseBool(r.URL.Query().Get("unread"))
+	notifications, err := s.prices.Notifications(r.Context(), userID, unread, limit)
+	if err != nil {
+		writePricesError(w, err)
+		return
+	}
+	if notifications == nil {
+		notifications = []prices.Notification{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"notifications": notifications})
+}
+
+func (s *Server) handleReadNotification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSubject(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "notificationId"), 10, 64)
+	if err != nil {
+		http.Error(w, "notification not found", http.StatusNotFound)
+		return
+	}
+	if err := s.prices.MarkRead(r.Context(), userID, id); err != nil {
+		writePricesError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireSubject returns the authenticated user's subject. Price alerts
+// belong to a user, so they are unavailable when authentication is
+// disabled.
+func requireSubject(w http.ResponseWriter, r *http.Request) (string, bool) {
+	claims := claimsFromContext(r.Context())
+	if claims == nil || claims.Subject == "" {
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return "", false
+	}
+	return claims.Subject, true
+}
+
+func writePricesError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, prices.ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, prices.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Error().Err(err).Msg("price store failure")
+		http.Error(w, "price store unavailable", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,59 @@
+package httpserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/orchestrator/internal/generation"
+	"github.com/shopmindai/orchestrator/internal/rag"
+)
+
+// retrievalTimeout bounds embedding the user's message; answers are not
+// held up for long by a slow retrieval.
+const retrievalTimeout = 10 * time.Second
+
+// withSources retrieves the passages relevant to query and gives them to
+// the model as a system message right before the latest user message. A
+// failed retrieval only costs the answer its sources.
+func (s *Server) withSources(ctx context.Context, query string, messages []upstreamChatMessage) ([]upstreamChatMessage, []rag.Result) {
+	ctx, cancel := context.WithTimeout(ctx, retrievalTimeout)
+	defer cancel()
+	results, err := s.rag.Retrieve(ctx, query)
+	if err != nil {
+		log.Warn().Err(err).Msg("retrieval failed, answering without sources")
+		return messages, nil
+	}
+	if len(results) == 0 {
+		return messages, nil
+	}
+
+	at := len(messages)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			at = i
+			break
+		}
+	}
+	out := make([]upstreamChatMessage, 0, len(messages)+1)
+	out = append(out, messages[:at]...)
+	out = append(out, upstreamChatMessage{Role: "system", Content: rag.ContextMessage(results)})
+	out = append(out, messages[at:]...)
+	return out, results
+}
+
+// emitCitations reports the sources the answer cites, one citation event
+// per cited span and source.
+func emitCitations(gen *generation.Generation, text string, sources []rag.Result) {
+	for _, citation := range rag.Citations(text, sources) {
+		if err := gen.Emit(map[string]any{
+			"messageId":       gen.ResponseMessageID,
+			"conversationId":  gen.ConversationID,
+			"parentMessageId": gen.RequestMessageID,
+			"citation":        citation,
+		}); err != nil {
+			log.Warn().Err(err).Str("messageId", gen.ResponseMessageID).Msg("failed to record stream event")
+		}
+	}
+}
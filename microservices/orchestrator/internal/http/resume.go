@@ -0,0 +1,50 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// handleAgentChatResume reattaches a client to a generation of the
+// conversation: events after Last-Event-ID (header or lastEventId query, for
+// clients that cannot set headers) are replayed, then the live tail follows.
+// messageId selects a generation by request or response message ID.
+func (s *Server) handleAgentChatResume(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID, err := parseLastEventID(r)
+	if err != nil {
+		http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "conversationId")
+	gen := s.generations.Lookup(subjectFromContext(r.Context()), conversationID, r.URL.Query().Get("messageId"))
+	if gen == nil || gen.ConversationID != conversationID {
+		http.Error(w, "no generation to resume", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	s.streamGeneration(r.Context(), w, flusher, gen, lastEventID)
+}
+
+func parseLastEventID(r *http.Request) (int64, error) {
+	value := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if value == "" {
+		value = strings.TrimSpace(r.URL.Query().Get("lastEventId"))
+	}
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
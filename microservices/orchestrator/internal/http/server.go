@@ -1,7 +1,6 @@
 package httpserver
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/rand"
@@ -10,8 +9,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -19,13 +22,34 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/shopmindai/orchestrator/internal/auth"
+	"github.com/shopmindai/orchestrator/internal/catalog"
 	"github.com/shopmindai/orchestrator/internal/config"
+	"github.com/shopmindai/orchestrator/internal/generation"
+	"github.com/shopmindai/orchestrator/internal/llmproxy"
+	"github.com/shopmindai/orchestrator/internal/prices"
+	"github.com/shopmindai/orchestrator/internal/quota"
+	"github.com/shopmindai/orchestrator/internal/rag"
+	"github.com/shopmindai/orchestrator/internal/ratelimit"
+	"github.com/shopmindai/orchestrator/internal/store"
+	"github.com/shopmindai/orchestrator/internal/tools"
 )
 
 type Server struct {
 	Router        *chi.Mux
 	cfg           config.Config
 	authValidator *auth.Validator
+	// llmProxy streams chat completions from llm-proxy.
+	llmProxy    *llmproxy.Client
+	policy      *auth.Policy
+	store       store.Repository
+	quotas      *quota.Tracker
+	limits      ratelimit.Store
+	tools       *tools.Registry
+	catalog     *catalog.Catalog
+	prices      *prices.Tracker
+	rag         *rag.Retriever
+	generations *generation.Registry
+	titles      pendingTitles
 }
 
 type claimsContextKey struct{}
@@ -34,19 +58,103 @@ func New(cfg config.Config) (*Server, error) {
 	r := chi.NewRouter()
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{cfg.AllowedOrigins},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Requested-With"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Last-Event-ID", "X-Requested-With"},
+		ExposedHeaders:   []string{"Link", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
-	validator, err := auth.NewValidator(context.Background(), cfg.AuthService)
+	policy, err := auth.LoadPolicy(cfg.AuthPolicyFile)
 	if err != nil {
 		return nil, err
 	}
+	// Without a proxy no request is ever sent, so no credentials are needed.
+	var proxyCredentials *llmproxy.Credentials
+	if cfg.LLMProxyURL != "" {
+		if proxyCredentials, err = llmproxy.NewCredentials(cfg.LLMProxyAuth); err != nil {
+			return nil, err
+		}
+	}
+	validator, err := auth.NewValidator(context.Background(), cfg.Keycloak, cfg.AuthService)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil && validator == nil {
+		log.Warn().Msg("authorization policy configured without authentication; gated resources will be denied")
+	}
+
+	repo, err := store.Open(cfg.Store)
+	if err != nil {
+		validator.Close()
+		return nil, fmt.Errorf("open conversation store: %w", err)
+	}
+	limits, err := ratelimit.Open(cfg.RateLimit)
+	if err != nil {
+		validator.Close()
+		_ = repo.Close()
+		return nil, err
+	}
+	products, err := catalog.Open(context.Background(), cfg.Catalog)
+	if err != nil {
+		validator.Close()
+		_ = repo.Close()
+		_ = limits.Close()
+		return nil, fmt.Errorf("open catalog: %w", err)
+	}
+	var tracker *prices.Tracker
+	if products != nil {
+		if tracker, err = prices.Open(context.Background(), cfg.Prices, products); err != nil {
+			validator.Close()
+			_ = repo.Close()
+			_ = limits.Close()
+			_ = products.Close()
+			return nil, fmt.Errorf("open price tracker: %w", err)
+		}
+	}
+	llmProxy := llmproxy.NewClient(cfg.LLMProxyURL, proxyCredentials, cfg.LLMProxy)
+	var retriever *rag.Retriever
+	if cfg.LLMProxyURL != "" {
+		embedder := llmproxy.NewEmbedder(llmProxy, cfg.RAG.EmbeddingModel)
+		if retriever, err = rag.Open(context.Background(), cfg.RAG, products, embedder); err != nil {
+			validator.Close()
+			_ = repo.Close()
+			_ = limits.Close()
+			if products != nil {
+				_ = products.Close()
+				_ = tracker.Close()
+			}
+			return nil, fmt.Errorf("open retrieval index: %w", err)
+		}
+	} else if len(cfg.RAG.Endpoints) > 0 {
+		log.Warn().Msg("retrieval needs the LLM proxy for embeddings; answering without sources")
+	}
 
-	s := &Server{Router: r, cfg: cfg, authValidator: validator}
+	s := &Server{
+		Router:        r,
+		cfg:           cfg,
+		authValidator: validator,
+		llmProxy:      llmProxy,
+		policy:        policy,
+		store:         repo,
+		quotas:        quota.New(cfg.Quota, repo),
+		limits:        limits,
+		tools:         tools.NewRegistry(),
+		catalog:       products,
+		prices:        tracker,
+		rag:           retriever,
+		generations: generation.NewRegistry(generation.Options{
+			BufferEvents: cfg.Chat.StreamBufferEvents,
+			DetachGrace:  cfg.Chat.ResumeGrace,
+			Retention:    cfg.Chat.ResumeRetention,
+		}),
+	}
+	if products != nil {
+		if err := s.tools.Register(catalog.SearchTool(products)); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
 	s.routes()
 	return s, nil
 }
@@ -55,6 +163,27 @@ func (s *Server) Close() {
 	if s.authValidator != nil {
 		s.authValidator.Close()
 	}
+	// Titles still being generated are saved first.
+	s.titles.wait()
+	if err := s.store.Close(); err != nil {
+		log.Warn().Err(err).Msg("failed to close conversation store")
+	}
+	if err := s.limits.Close(); err != nil {
+		log.Warn().Err(err).Msg("failed to close rate limit store")
+	}
+	if s.rag != nil {
+		_ = s.rag.Close()
+	}
+	if s.catalog != nil {
+		if err := s.catalog.Close(); err != nil {
+			log.Warn().Err(err).Msg("failed to close catalog store")
+		}
+	}
+	if s.prices != nil {
+		if err := s.prices.Close(); err != nil {
+			log.Warn().Err(err).Msg("failed to close price store")
+		}
+	}
 }
 
 func (s *Server) routes() {
@@ -64,15 +193,57 @@ func (s *Server) routes() {
 		if s.authValidator != nil {
 			r.Use(s.requireAuth)
 		}
-		r.Post("/orchestrator/v1/sessions/{sessionId}/messages/stream", s.handleChatStream)
-		r.Post("/api/agents/chat/{endpoint}", s.handleAgentChat)
+		r.Use(s.authorizeRoute)
+		// Chat routes are rate limited per caller; new generations also
+		// count against the concurrent stream cap until they finish.
+		limited := r.With(s.rateLimit)
+		limited.Post("/orchestrator/v1/sessions/{sessionId}/messages/stream", s.handleChatStream)
+		limited.Post("/api/agents/chat/abort", s.handleAgentChatAbort)
+		limited.Get("/api/agents/chat/resume/{conversationId}", s.handleAgentChatResume)
+		limited.Post("/api/agents/chat/{endpoint}", s.handleAgentChat)
+		r.Get("/api/balance", s.handleBalance)
+
+		r.Get("/api/convos", s.handleListConversations)
+		r.Post("/api/convos", s.handleCreateConversation)
+		r.Get("/api/convos/gen_title/{conversationId}", s.handleGenerateTitle)
+		r.Get("/api/convos/{conversationId}", s.handleGetConversation)
+		r.Put("/api/convos/{conversationId}", s.handleUpdateConversation)
+		r.Delete("/api/convos/{conversationId}", s.handleDeleteConversation)
+		r.Get("/api/messages/{conversationId}", s.handleListMessages)
+		r.Get("/api/messages/{conversationId}/tree", s.handleMessageTree)
+
+		if s.catalog != nil {
+			r.Get("/api/catalog/search", s.handleCatalogSearch)
+			r.Get("/api/catalog/products/{feed}/{productId}", s.handleCatalogProduct)
+			r.Get("/api/catalog/feeds", s.handleCatalogFeeds)
+			r.Post("/api/catalog/ingest", s.handleCatalogIngest)
+		}
+		if s.prices != nil {
+			r.Get("/api/prices/products/{feed}/{productId}/history", s.handlePriceHistory)
+			r.Get("/api/prices/products/{feed}/{productId}/offers", s.handlePriceOffers)
+			r.Get("/api/prices/subscriptions", s.handleListPriceSubscriptions)
+			r.Post("/api/prices/subscriptions", s.handleCreatePriceSubscription)
+			r.Delete("/api/prices/subscriptions/{subscriptionId}", s.handleDeletePriceSubscription)
+			r.Get("/api/notifications", s.handleListNotifications)
+			r.Post("/api/notifications/{notificationId}/read", s.handleReadNotification)
+		}
 	})
 }
 
 func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(`{"status":"ok"}`))
+	health := map[string]any{"status": "ok"}
+	if s.cfg.LLMProxyURL != "" {
+		// The orchestrator stays up while llm-proxy is failing; the breaker
+		// shows whether chat requests are currently failing fast.
+		health["llmProxy"] = map[string]any{"circuit": s.llmProxy.Breaker().State()}
+	}
+	writeJSON(w, http.StatusOK, health)
+}
+
+// legacyChatRequest holds the fields of a legacy stream request that are
+// checked before its body is forwarded to llm-proxy unchanged.
+type legacyChatRequest struct {
+	Model string `json:"model"`
 }
 
 func (s *Server) handleChatStream(w http.ResponseWriter, r *http.Request) {
@@ -99,37 +270,75 @@ func (s *Server) handleChatStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Forward body to llm-proxy (expects POST streaming SSE)
-	req, err := http.NewRequestWithContext(r.Context(), "POST", s.cfg.LLMProxyURL+"/v1/chat/stream", r.Body)
+	// Forward body to llm-proxy (expects POST streaming SSE). It is read
+	// in full so the request can be signed.
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "failed to build upstream request", http.StatusInternalServerError)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
-	req.Header.Set("Accept", "text/event-stream")
-	if s.cfg.LLMProxyToken != "" {
-		req.Header.Set("Authorization", "Bearer "+s.cfg.LLMProxyToken)
+	// The body goes to llm-proxy as is, so its model and size are held to
+	// the same policy and budget as agent chat.
+	var req legacyChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
 	}
-
-	resp, err := http.DefaultClient.Do(req)
+	claims := claimsFromContext(r.Context())
+	if denial := s.policy.CheckModel(claims, req.Model); denial != nil {
+		writeForbidden(w, denial)
+		return
+	}
+	userID := subjectFromContext(r.Context())
+	budget, err := s.quotas.Status(r.Context(), userID, claims)
 	if err != nil {
-		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		log.Error().Err(err).Msg("failed to read token usage")
+		http.Error(w, "usage ledger unavailable", http.StatusInternalServerError)
 		return
 	}
-	defer resp.Body.Close()
+	if err := budget.Exceeded(); err != nil {
+		writeQuotaExceeded(w, err)
+		return
+	}
+	if err := budget.Afford(estimateTokens(string(body))); err != nil {
+		writeQuotaExceeded(w, err)
+		return
+	}
+	releaseStream, ok := s.acquireStream(w, r)
+	if !ok {
+		return
+	}
+	defer releaseStream()
 
-	if resp.StatusCode != http.StatusOK {
-		http.Error(w, fmt.Sprintf("upstream error: %s", resp.Status), http.StatusBadGateway)
+	header := http.Header{}
+	header.Set("Content-Type", r.Header.Get("Content-Type"))
+	header.Set("Accept", "text/event-stream")
+	resp, err := s.llmProxy.Stream(r.Context(), "/v1/chat/stream", body, header)
+	var circuitOpen *llmproxy.CircuitOpenError
+	var status *llmproxy.StatusError
+	switch {
+	case errors.As(err, &circuitOpen):
+		writeCircuitOpen(w, flusher, circuitOpen)
+		return
+	case errors.As(err, &status):
+		http.Error(w, fmt.Sprintf("upstream error: %s", status.Status), http.StatusBadGateway)
+		return
+	case err != nil:
+		log.Warn().Err(err).Str("sessionId", sessionID).Msg("llm proxy request failed")
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
 		return
 	}
+	defer resp.Body.Close()
 
-	// Pipe SSE 1:1
+	// Pipe SSE 1:1, keeping a copy to account for its usage.
+	var streamed bytes.Buffer
 	buf := make([]byte, 16*1024)
 	for {
 		n, readErr := resp.Body.Read(buf)
 		if n > 0 {
+			streamed.Write(buf[:n])
 			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
-				return
+				break
 			}
 			flusher.Flush()
 		}
@@ -137,6 +346,12 @@ func (s *Server) handleChatStream(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 	}
+	version := llmproxy.ResponseVersion(resp.Header.Get(llmproxy.ProtocolHeader))
+	s.recordStreamUsage(context.WithoutCancel(r.Context()), store.Usage{
+		UserID:         userID,
+		ConversationID: sessionID,
+		Model:          req.Model,
+	}, body, llmproxy.NewStreamReader(&streamed, version))
 }
 
 const (
@@ -152,8 +367,25 @@ type agentChatPayload struct {
 	Text              string                 `json:"text"`
 	PromptPrefix      string                 `json:"promptPrefix"`
 	Model             string                 `json:"model"`
+	Temperature       *float64               `json:"temperature"`
+	TopP              *float64               `json:"top_p"`
+	MaxTokens         *int                   `json:"max_tokens"`
+	Stop              []string               `json:"stop"`
+	PresencePenalty   *float64               `json:"presence_penalty"`
+	FrequencyPenalty  *float64               `json:"frequency_penalty"`
+	Seed              *int                   `json:"seed"`
 	Messages          []agentMessage         `json:"messages"`
 	AdditionalContext map[string]interface{} `json:"additionalContext"`
+	// Ephemeral requests are not persisted and take their history from
+	// Messages; only honoured when CHAT_ALLOW_CLIENT_HISTORY is enabled.
+	Ephemeral bool `json:"ephemeral"`
+	// IsRegenerate asks for a new sibling response to an existing user
+	// message, identified by OverrideParentMessageID (or MessageID).
+	IsRegenerate            bool   `json:"isRegenerate"`
+	OverrideParentMessageID string `json:"overrideParentMessageId"`
+	// IsEdited marks a resubmitted user message; it always gets a fresh ID
+	// so the original stays in place and the thread forks at ParentMessageID.
+	IsEdited bool `json:"isEdited"`
 }
 
 type agentMessage struct {
@@ -179,12 +411,41 @@ type agentContentValue struct {
 }
 
 type upstreamChatRequest struct {
-	Messages []upstreamChatMessage `json:"messages"`
+	Messages []upstreamChatMessage    `json:"messages"`
+	System   string                   `json:"system,omitempty"`
+	Model    string                   `json:"model,omitempty"`
+	Params   upstreamGenerationParams `json:"params"`
+	Tools    []tools.Definition       `json:"tools,omitempty"`
+}
+
+// upstreamGenerationParams are per-request overrides; llm-proxy fills in its
+// configured defaults and clamps them to its limits.
+type upstreamGenerationParams struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	MaxTokens        *int     `json:"max_tokens,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
 }
 
 type upstreamChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCalls are the calls made by an assistant message; ToolCallID and
+	// Name identify the call a "tool" message answers.
+	ToolCalls  []upstreamToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string             `json:"tool_call_id,omitempty"`
+	Name       string             `json:"name,omitempty"`
+	// messageID is the stored message this one replays, if any.
+	messageID string
+}
+
+type upstreamToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
 func (s *Server) handleAgentChat(w http.ResponseWriter, r *http.Request) {
@@ -212,6 +473,18 @@ func (s *Server) handleAgentChat(w http.ResponseWriter, r *http.Request) {
 	if payload.Endpoint == "" {
 		payload.Endpoint = chi.URLParam(r, "endpoint")
 	}
+	claims := claimsFromContext(r.Context())
+	for _, endpoint := range []string{chi.URLParam(r, "endpoint"), payload.Endpoint} {
+		if denial := s.policy.CheckEndpoint(claims, endpoint); denial != nil {
+			writeForbidden(w, denial)
+			return
+		}
+	}
+	requestedModel := payload.Model
+	if denial := s.policy.CheckModel(claims, requestedModel); denial != nil {
+		writeForbidden(w, denial)
+		return
+	}
 
 	conversationID := normalizeConversationID(payload.ConversationID)
 	requestMessageID := ensureID(payload.MessageID)
@@ -224,129 +497,656 @@ func (s *Server) handleAgentChat(w http.ResponseWriter, r *http.Request) {
 	if userText == "" {
 		userText = extractLatestUserText(payload.Messages)
 	}
-	if userText == "" {
+	if userText == "" && !payload.IsRegenerate {
 		http.Error(w, "missing message text", http.StatusBadRequest)
 		return
 	}
 
-	upstreamMessages := buildUpstreamMessages(payload.Messages, userText)
+	userID := subjectFromContext(r.Context())
+	budget, err := s.quotas.Status(r.Context(), userID, claims)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read token usage")
+		http.Error(w, "usage ledger unavailable", http.StatusInternalServerError)
+		return
+	}
+	if err := budget.Exceeded(); err != nil {
+		writeQuotaExceeded(w, err)
+		return
+	}
+	// The stream slot passes to the generation once it starts; requests
+	// rejected before that give it back.
+	releaseStream, ok := s.acquireStream(w, r)
+	if !ok {
+		return
+	}
+	defer func() {
+		if releaseStream != nil {
+			releaseStream()
+		}
+	}()
+	var upstreamMessages []upstreamChatMessage
+	switch {
+	case payload.Ephemeral:
+		if !s.cfg.Chat.AllowClientHistory {
+			http.Error(w, "client-supplied history is disabled", http.StatusForbidden)
+			return
+		}
+		upstreamMessages = buildUpstreamMessages(payload.Messages, userText)
+
+	case payload.IsRegenerate:
+		targetID := payload.OverrideParentMessageID
+		if targetID == "" {
+			targetID = payload.MessageID
+		}
+		if targetID == "" || targetID == noParentMessageID {
+			http.Error(w, "missing message to regenerate", http.StatusBadRequest)
+			return
+		}
+		thread, err := s.loadThread(r.Context(), userID, conversationID, targetID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "message to regenerate not found", http.StatusNotFound)
+				return
+			}
+			writeStoreError(w, err)
+			return
+		}
+		request := thread[len(thread)-1]
+		if !request.IsCreatedByUser {
+			http.Error(w, "only responses to user messages can be regenerated", http.StatusBadRequest)
+			return
+		}
+		requestMessageID, parentMessageID, userText = request.MessageID, request.ParentMessageID, request.Text
+		upstreamMessages = buildThreadMessages(thread[:len(thread)-1], userText)
+
+		if err := s.saveAgentConversation(r.Context(), userID, conversationID, &payload); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+	default:
+		thread, err := s.loadThread(r.Context(), userID, conversationID, parentMessageID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "parent message not found", http.StatusNotFound)
+				return
+			}
+			writeStoreError(w, err)
+			return
+		}
+		upstreamMessages = buildThreadMessages(thread, userText)
+
+		// Never overwrite a stored message: edits fork the thread under a new ID.
+		if payload.IsEdited {
+			requestMessageID = generateID()
+		} else if _, err := s.store.GetMessage(r.Context(), conversationID, requestMessageID); err == nil {
+			requestMessageID = generateID()
+		}
+
+		if err := s.saveAgentConversation(r.Context(), userID, conversationID, &payload); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if err := s.store.SaveMessage(r.Context(), &store.Message{
+			MessageID:       requestMessageID,
+			ConversationID:  conversationID,
+			ParentMessageID: parentMessageID,
+			Sender:          "User",
+			Text:            userText,
+			IsCreatedByUser: true,
+			Endpoint:        payload.Endpoint,
+			Model:           payload.Model,
+		}); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
 	if len(upstreamMessages) == 0 {
 		http.Error(w, "no messages available for LLM request", http.StatusBadRequest)
 		return
 	}
+	// The conversation may have supplied the model.
+	if payload.Model != requestedModel {
+		if denial := s.policy.CheckModel(claims, payload.Model); denial != nil {
+			writeForbidden(w, denial)
+			return
+		}
+	}
 
-	body, err := json.Marshal(upstreamChatRequest{Messages: upstreamMessages})
-	if err != nil {
-		http.Error(w, "failed to encode upstream request", http.StatusInternalServerError)
-		return
+	upstream := upstreamChatRequest{
+		Messages: upstreamMessages,
+		System:   buildSystemPrompt(payload.PromptPrefix, payload.AdditionalContext),
+		Model:    payload.Model,
+		Params: upstreamGenerationParams{
+			Temperature:      payload.Temperature,
+			TopP:             payload.TopP,
+			MaxTokens:        payload.MaxTokens,
+			Stop:             payload.Stop,
+			PresencePenalty:  payload.PresencePenalty,
+			FrequencyPenalty: payload.FrequencyPenalty,
+			Seed:             payload.Seed,
+		},
+	}
+	if s.cfg.Chat.MaxToolSteps > 0 {
+		upstream.Tools = s.tools.Definitions()
 	}
 
-	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, strings.TrimRight(s.cfg.LLMProxyURL, "/")+"/v1/chat/stream", bytes.NewReader(body))
-	if err != nil {
-		http.Error(w, "failed to build upstream request", http.StatusInternalServerError)
-		return
+	// The generation runs detached from this request so a dropped connection
+	// can resume it. It stops when aborted or when no client has been
+	// attached for the resume grace period; either way llm-proxy is cancelled.
+	gen := &generation.Generation{
+		UserID:            userID,
+		ConversationID:    conversationID,
+		RequestMessageID:  requestMessageID,
+		ResponseMessageID: generateID(),
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if s.cfg.LLMProxyToken != "" {
-		req.Header.Set("Authorization", "Bearer "+s.cfg.LLMProxyToken)
+	ctx := s.generations.Start(context.WithoutCancel(r.Context()), gen)
+	turn := agentTurn{
+		payload:          payload,
+		conversationID:   conversationID,
+		requestMessageID: requestMessageID,
+		parentMessageID:  parentMessageID,
+		userText:         userText,
+		upstream:         upstream,
+		userID:           userID,
+		budget:           &budget,
+		releaseStream:    releaseStream,
 	}
+	releaseStream = nil
+	go s.runAgentGeneration(ctx, gen, turn)
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		sendErrorEvent(w, flusher, conversationID, requestMessageID, parentMessageID, userText, fmt.Errorf("upstream unavailable: %w", err))
-		return
+	s.streamGeneration(r.Context(), w, flusher, gen, 0)
+}
+
+// agentTurn is a validated agent chat request whose request message has
+// already been stored.
+type agentTurn struct {
+	payload          agentChatPayload
+	conversationID   string
+	requestMessageID string
+	parentMessageID  string
+	userText         string
+	upstream         upstreamChatRequest
+	userID           string
+	// budget is the user's quota status, charged with every llm-proxy call
+	// of the turn: answer and tool rounds, the history summary and the
+	// title.
+	budget *quota.Status
+	// releaseStream frees the caller's stream slot once the generation is
+	// over.
+	releaseStream func()
+}
+
+// runAgentGeneration streams the answer from llm-proxy into gen's event log
+// and saves the response message.
+func (s *Server) runAgentGeneration(ctx context.Context, gen *generation.Generation, turn agentTurn) {
+	defer turn.releaseStream()
+	defer s.generations.Finish(gen)
+
+	// The response must be saved even when the generation is cancelled.
+	persistCtx := context.WithoutCancel(ctx)
+	payload, conversationID, requestMessageID, responseMessageID := turn.payload, turn.conversationID, turn.requestMessageID, gen.ResponseMessageID
+	// answer is saved with the model that actually answered, which llm-proxy
+	// reports when it resolves an alias or falls back to another target.
+	answer := payload
+
+	emit := func(event any) {
+		if err := gen.Emit(event); err != nil {
+			log.Warn().Err(err).Str("messageId", responseMessageID).Msg("failed to record stream event")
+		}
+	}
+	fail := func(err error) {
+		text := gen.Text()
+		switch {
+		case errors.Is(context.Cause(ctx), generation.ErrAborted):
+			// An abort keeps whatever was generated as the final answer.
+			if text != "" {
+				s.saveResponseMessage(persistCtx, answer, conversationID, requestMessageID, responseMessageID, text, false)
+			}
+			finalEvent := buildFinalEvent(payload, conversationID, requestMessageID, turn.parentMessageID, responseMessageID, turn.userText, text)
+			finalEvent["aborted"] = true
+			emit(finalEvent)
+		case ctx.Err() != nil:
+			// Nobody resumed the stream in time; keep the partial answer, if any.
+			if text != "" {
+				s.saveResponseMessage(persistCtx, answer, conversationID, requestMessageID, responseMessageID, text, false)
+			}
+		default:
+			s.saveResponseMessage(persistCtx, answer, conversationID, requestMessageID, responseMessageID, err.Error(), true)
+			errorEvent := buildErrorEvent(conversationID, requestMessageID, turn.parentMessageID, responseMessageID, turn.userText, err)
+			var circuitOpen *llmproxy.CircuitOpenError
+			if errors.As(err, &circuitOpen) {
+				errorEvent["retryAfter"] = retryAfterSeconds(circuitOpen)
+			}
+			emit(errorEvent)
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		sendErrorEvent(w, flusher, conversationID, requestMessageID, parentMessageID, userText, fmt.Errorf("llm proxy error: %s %s", resp.Status, strings.TrimSpace(string(bodyBytes))))
-		return
+	// Each round streams one model response. Rounds that end in tool calls
+	// run the tools and feed their results back until the model answers;
+	// the last allowed round is sent without tools so that it must.
+	upstream := turn.upstream
+	var sources []rag.Result
+	if s.rag.Enabled(payload.Endpoint) {
+		upstream.Messages, sources = s.withSources(ctx, turn.userText, upstream.Messages)
+	}
+	if s.cfg.Chat.ContextFitting {
+		upstream = s.fitHistory(ctx, gen, turn, upstream)
 	}
+	for step := 1; ; step++ {
+		if step > s.cfg.Chat.MaxToolSteps {
+			upstream.Tools = nil
+		}
+		body, err := json.Marshal(upstream)
+		if err != nil {
+			fail(fmt.Errorf("failed to encode upstream request: %w", err))
+			return
+		}
+		promptTokens := estimateTokens(string(body))
+		if err := turn.budget.Afford(promptTokens); err != nil {
+			fail(err)
+			return
+		}
+		resp, err := s.openUpstream(ctx, body)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if step == 1 {
+			emit(map[string]any{
+				"created": true,
+				"message": map[string]any{
+					"messageId":       responseMessageID,
+					"parentMessageId": requestMessageID,
+					"conversationId":  conversationID,
+				},
+			})
+		}
 
-	responseMessageID := generateID()
-	createdEvent := map[string]any{
-		"created": true,
-		"message": map[string]any{
-			"messageId":       responseMessageID,
-			"parentMessageId": requestMessageID,
-			"conversationId":  conversationID,
-		},
+		version := llmproxy.ResponseVersion(resp.Header.Get(llmproxy.ProtocolHeader))
+		result, err := s.pipeUpstreamStream(resp.Body, version, gen, *turn.budget, promptTokens)
+		resp.Body.Close()
+		s.recordUsage(persistCtx, turn, gen, body, result)
+		if result.Model != "" {
+			answer.Model = result.Model
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+		if result.FinishReason != "tool_calls" || len(result.ToolCalls) == 0 || len(upstream.Tools) == 0 {
+			break
+		}
+
+		upstream.Messages = append(upstream.Messages, upstreamChatMessage{Role: "assistant", Content: result.Text, ToolCalls: result.ToolCalls})
+		for _, call := range result.ToolCalls {
+			upstream.Messages = append(upstream.Messages, s.runTool(ctx, gen, step, call))
+		}
+		if ctx.Err() != nil {
+			fail(context.Cause(ctx))
+			return
+		}
 	}
-	if err := writeSSEEvent(w, flusher, createdEvent); err != nil {
-		log.Warn().Err(err).Msg("failed to dispatch created event")
-		return
+
+	assistantText := gen.Text()
+	s.saveResponseMessage(persistCtx, answer, conversationID, requestMessageID, responseMessageID, assistantText, false)
+	emitCitations(gen, assistantText, sources)
+	// A new conversation is titled after its first exchange, in the
+	// background so the stream ends with the answer. gen_title requests
+	// prompted by the final event wait for the title.
+	if s.cfg.Chat.TitleGeneration && !payload.Ephemeral && turn.parentMessageID == noParentMessageID {
+		done := s.titles.start(conversationID)
+		go func() {
+			defer done()
+			s.nameConversation(persistCtx, gen, turn, assistantText)
+		}()
 	}
+	emit(buildFinalEvent(payload, conversationID, requestMessageID, turn.parentMessageID, responseMessageID, turn.userText, assistantText))
+}
+
+// openUpstream starts an llm-proxy stream. Non-200 responses are returned
+// as errors, and a *llmproxy.CircuitOpenError while llm-proxy is failing.
+func (s *Server) openUpstream(ctx context.Context, body []byte) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "text/event-stream")
+	header.Set(llmproxy.ProtocolHeader, strconv.Itoa(llmproxy.ProtocolVersion))
+	return s.llmProxy.Stream(ctx, "/v1/chat/stream", body, header)
+}
 
-	assistantText, err := s.pipeUpstreamStream(resp.Body, w, flusher, conversationID, requestMessageID, responseMessageID)
+// complete sends a non-streaming chat request to llm-proxy on behalf of
+// turn, records its usage and returns the reply.
+func (s *Server) complete(ctx context.Context, gen *generation.Generation, turn agentTurn, request upstreamChatRequest) (string, error) {
+	body, err := json.Marshal(request)
 	if err != nil {
-		if errors.Is(err, context.Canceled) {
+		return "", err
+	}
+	if err := turn.budget.Afford(estimateTokens(string(body))); err != nil {
+		return "", err
+	}
+	data, err := s.llmProxy.Post(ctx, "/v1/chat/completions", body)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Model   string `json:"model"`
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Usage *llmproxy.Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("decode completion: %w", err)
+	}
+	s.recordUsage(context.WithoutCancel(ctx), turn, gen, body, upstreamResult{Text: resp.Message.Content, Usage: resp.Usage, Model: resp.Model})
+	return resp.Message.Content, nil
+}
+
+// runTool runs one tool call of the given step and returns the "tool"
+// message answering it. The call and its result are shown to the client as
+// toolCall and toolResult events; failures are reported to the model so it
+// can recover.
+func (s *Server) runTool(ctx context.Context, gen *generation.Generation, step int, call upstreamToolCall) upstreamChatMessage {
+	emit := func(key string, value map[string]any) {
+		value["id"] = call.ID
+		value["name"] = call.Name
+		value["step"] = step
+		event := map[string]any{
+			"messageId":       gen.ResponseMessageID,
+			"conversationId":  gen.ConversationID,
+			"parentMessageId": gen.RequestMessageID,
+			key:               value,
+		}
+		if err := gen.Emit(event); err != nil {
+			log.Warn().Err(err).Str("messageId", gen.ResponseMessageID).Msg("failed to record stream event")
+		}
+	}
+	emit("toolCall", map[string]any{"args": call.Arguments})
+
+	if timeout := s.cfg.Chat.ToolTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	started := time.Now()
+	output, err := s.tools.Call(ctx, call.Name, call.Arguments)
+	if err != nil {
+		log.Warn().Err(err).Str("tool", call.Name).Str("messageId", gen.ResponseMessageID).Msg("tool call failed")
+		data, _ := json.Marshal(map[string]string{"error": err.Error()})
+		output = string(data)
+		emit("toolResult", map[string]any{"error": err.Error()})
+	} else {
+		emit("toolResult", map[string]any{"output": json.RawMessage(output), "durationMs": time.Since(started).Milliseconds()})
+	}
+	return upstreamChatMessage{Role: "tool", Content: output, ToolCallID: call.ID, Name: call.Name}
+}
+
+// streamGeneration writes gen's events after lastEventID to the client, then
+// follows the live tail until the generation finishes or the client leaves.
+// Comment frames keep the connection open through proxies while no events
+// arrive.
+func (s *Server) streamGeneration(ctx context.Context, w http.ResponseWriter, flusher http.Flusher, gen *generation.Generation, lastEventID int64) {
+	gen.Attach()
+	defer gen.Detach()
+
+	var heartbeat <-chan time.Time
+	if s.cfg.Chat.StreamHeartbeat > 0 {
+		ticker := time.NewTicker(s.cfg.Chat.StreamHeartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		events, more, finished := gen.EventsAfter(lastEventID)
+		for _, evt := range events {
+			if err := writeSSEEvent(w, flusher, evt); err != nil {
+				log.Debug().Err(err).Str("messageId", gen.ResponseMessageID).Msg("client stream closed")
+				return
+			}
+			lastEventID = evt.ID
+		}
+		if finished {
+			return
+		}
+		select {
+		case <-more:
+		case <-heartbeat:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ctx.Done():
 			return
 		}
-		sendErrorEvent(w, flusher, conversationID, requestMessageID, parentMessageID, userText, err)
-		return
 	}
+}
 
-	finalEvent := buildFinalEvent(payload, conversationID, requestMessageID, parentMessageID, responseMessageID, userText, assistantText)
-	if err := writeSSEEvent(w, flusher, finalEvent); err != nil {
-		log.Warn().Err(err).Msg("failed to dispatch final event")
+// loadThread returns the stored branch that the new message continues, from
+// the root down to parentMessageID. A new conversation has no history.
+func (s *Server) loadThread(ctx context.Context, userID, conversationID, parentMessageID string) ([]store.Message, error) {
+	if parentMessageID == noParentMessageID {
+		return nil, nil
+	}
+	if _, err := s.store.GetConversation(ctx, userID, conversationID); err != nil {
+		return nil, err
 	}
+	return store.Thread(ctx, s.store, conversationID, parentMessageID)
 }
 
-func (s *Server) pipeUpstreamStream(body io.Reader, w http.ResponseWriter, flusher http.Flusher, conversationID, requestMessageID, responseMessageID string) (string, error) {
-	reader := bufio.NewReader(body)
-	var builder strings.Builder
-	deadline := time.Now()
+// saveAgentConversation creates the conversation on its first message and
+// keeps its endpoint, model and prompt prefix in sync with later requests.
+// Settings the request leaves out are filled in from the stored conversation.
+func (s *Server) saveAgentConversation(ctx context.Context, userID, conversationID string, payload *agentChatPayload) error {
+	conv, err := s.store.GetConversation(ctx, userID, conversationID)
+	if errors.Is(err, store.ErrNotFound) {
+		conv = &store.Conversation{ConversationID: conversationID, UserID: userID}
+	} else if err != nil {
+		return err
+	}
+
+	conv.Endpoint = payload.Endpoint
+	if payload.EndpointType != "" {
+		conv.EndpointType = payload.EndpointType
+	}
+	if payload.Model != "" {
+		conv.Model = payload.Model
+	}
+	if payload.PromptPrefix != "" {
+		conv.PromptPrefix = payload.PromptPrefix
+	}
+	if err := s.store.SaveConversation(ctx, conv); err != nil {
+		return err
+	}
+	payload.Model = conv.Model
+	payload.PromptPrefix = conv.PromptPrefix
+	return nil
+}
+
+func (s *Server) saveResponseMessage(ctx context.Context, payload agentChatPayload, conversationID, requestMessageID, responseMessageID, text string, isError bool) {
+	if payload.Ephemeral {
+		return
+	}
+	err := s.store.SaveMessage(ctx, &store.Message{
+		MessageID:       responseMessageID,
+		ConversationID:  conversationID,
+		ParentMessageID: requestMessageID,
+		Sender:          "Assistant",
+		Text:            text,
+		Endpoint:        payload.Endpoint,
+		Model:           payload.Model,
+		Error:           isError,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("conversationId", conversationID).Str("messageId", responseMessageID).Msg("failed to save response message")
+	}
+}
+
+type upstreamResult struct {
+	// Text is the text streamed by this response only.
+	Text         string
+	FinishReason string
+	Usage        *llmproxy.Usage
+	// ToolCalls are the calls the response asked for, in order.
+	ToolCalls []upstreamToolCall
+	// Model is the model llm-proxy routed the request to, if it said so.
+	Model string
+}
+
+// errStreamStalled ends an answer whose llm-proxy stream stopped producing
+// events.
+var errStreamStalled = errors.New("the model stopped responding, please try again")
+
+// pipeUpstreamStream forwards one llm-proxy stream into gen. The stream is
+// cut off with a *quota.ExceededError once the prompt and the completion,
+// estimated from the streamed text, would exceed the remaining budget, and with
+// errStreamStalled when no event arrives for the stall timeout; heartbeat
+// comments do not count as events.
+func (s *Server) pipeUpstreamStream(body io.ReadCloser, version int, gen *generation.Generation, budget quota.Status, promptTokens int) (upstreamResult, error) {
+	reader := llmproxy.NewStreamReader(body, version)
+	var result upstreamResult
+	var step strings.Builder
+
+	// The stall timer closes the body, which fails the pending read.
+	var stalled atomic.Bool
+	var stallTimer *time.Timer
+	stallTimeout := s.cfg.Chat.StreamStallTimeout
+	if stallTimeout > 0 {
+		stallTimer = time.AfterFunc(stallTimeout, func() {
+			stalled.Store(true)
+			_ = body.Close()
+		})
+		defer stallTimer.Stop()
+	}
 
 	for {
-		line, err := reader.ReadString('\n')
+		evt, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+			result.Text = step.String()
+			if stalled.Load() {
+				log.Warn().Str("messageId", gen.ResponseMessageID).Str("model", result.Model).Dur("stallTimeout", stallTimeout).Msg("llm stream stalled")
+				return result, errStreamStalled
 			}
-			return builder.String(), fmt.Errorf("llm stream error: %w", err)
+			return result, fmt.Errorf("llm stream error: %w", err)
+		}
+		if stallTimer != nil {
+			stallTimer.Reset(stallTimeout)
 		}
 
-		line = strings.TrimSpace(line)
-		if line == "" {
+		switch evt.Type {
+		case llmproxy.EventRoute:
+			result.Model = evt.Model
 			continue
-		}
-		if !strings.HasPrefix(line, "data:") {
+		case llmproxy.EventToolCall:
+			result.ToolCalls = appendToolCall(result.ToolCalls, evt.ToolCall)
 			continue
-		}
-		chunk := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
-		if chunk == "" {
+		case llmproxy.EventUsage:
+			result.Usage = evt.Usage
+			continue
+		case llmproxy.EventFinish:
+			result.FinishReason = evt.FinishReason
 			continue
+		case llmproxy.EventError:
+			result.Text = step.String()
+			return result, fmt.Errorf("llm proxy error: %s", evt.Error)
 		}
-		if chunk == "[DONE]" {
-			break
+		if evt.Text == "" {
+			continue
 		}
 
-		builder.WriteString(chunk)
+		chunk := evt.Text
+		if step.Len() == 0 {
+			// Text of a round after tool calls starts a new paragraph.
+			if previous := gen.Text(); previous != "" && !strings.HasSuffix(previous, "\n") {
+				chunk = "\n\n" + chunk
+			}
+		}
+		step.WriteString(evt.Text)
+		text := gen.Append(chunk)
+		if err := budget.Afford(promptTokens + estimateTokens(step.String())); err != nil {
+			result.Text = step.String()
+			return result, err
+		}
 		messageEvent := map[string]any{
-			"messageId":       responseMessageID,
-			"conversationId":  conversationID,
-			"parentMessageId": requestMessageID,
+			"messageId":       gen.ResponseMessageID,
+			"conversationId":  gen.ConversationID,
+			"parentMessageId": gen.RequestMessageID,
 			"text":            chunk,
 			"message": map[string]any{
-				"messageId":       responseMessageID,
-				"conversationId":  conversationID,
-				"parentMessageId": requestMessageID,
+				"messageId":       gen.ResponseMessageID,
+				"conversationId":  gen.ConversationID,
+				"parentMessageId": gen.RequestMessageID,
 				"sender":          "Assistant",
-				"text":            builder.String(),
+				"text":            text,
 			},
 		}
-		if err := writeSSEEvent(w, flusher, messageEvent); err != nil {
-			return builder.String(), fmt.Errorf("failed to forward chunk: %w", err)
+		if err := gen.Emit(messageEvent); err != nil {
+			result.Text = step.String()
+			return result, fmt.Errorf("failed to forward chunk: %w", err)
 		}
-		deadline = time.Now()
 	}
 
-	if builder.Len() == 0 && time.Since(deadline) > 0 {
-		return "", errors.New("upstream produced no content")
+	result.Text = step.String()
+	if result.Text == "" && len(result.ToolCalls) == 0 {
+		return result, errors.New("upstream produced no content")
 	}
+	return result, nil
+}
 
-	return builder.String(), nil
+// appendToolCall merges a streamed tool call fragment into calls: a
+// fragment with a new index starts a call, later ones extend its arguments.
+func appendToolCall(calls []upstreamToolCall, fragment *llmproxy.ToolCall) []upstreamToolCall {
+	if fragment == nil || fragment.Index < 0 {
+		return calls
+	}
+	for len(calls) <= fragment.Index {
+		calls = append(calls, upstreamToolCall{})
+	}
+	call := &calls[fragment.Index]
+	if fragment.ID != "" {
+		call.ID = fragment.ID
+	}
+	if fragment.Name != "" {
+		call.Name = fragment.Name
+	}
+	call.Arguments += fragment.Arguments
+	return calls
+}
+
+// buildSystemPrompt combines the user's custom instructions with the
+// additionalContext sent by the client, rendered as "key: value" lines.
+func buildSystemPrompt(promptPrefix string, additionalContext map[string]interface{}) string {
+	var builder strings.Builder
+	builder.WriteString(strings.TrimSpace(promptPrefix))
+
+	keys := make([]string, 0, len(additionalContext))
+	for key, value := range additionalContext {
+		if value != nil {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return builder.String()
+	}
+	sort.Strings(keys)
+
+	if builder.Len() > 0 {
+		builder.WriteString("\n\n")
+	}
+	builder.WriteString("Additional context:")
+	for _, key := range keys {
+		value, ok := additionalContext[key].(string)
+		if !ok {
+			encoded, err := json.Marshal(additionalContext[key])
+			if err != nil {
+				continue
+			}
+			value = string(encoded)
+		}
+		builder.WriteString("\n- " + key + ": " + value)
+	}
+	return builder.String()
 }
 
 func normalizeConversationID(input string) string {
@@ -400,6 +1200,25 @@ func buildUpstreamMessages(history []agentMessage, latestUser string) []upstream
 	return messages
 }
 
+// buildThreadMessages converts a stored branch into upstream messages. Failed
+// responses are skipped so error text is never replayed to the model.
+func buildThreadMessages(thread []store.Message, latestUser string) []upstreamChatMessage {
+	var messages []upstreamChatMessage
+	for _, msg := range thread {
+		text := strings.TrimSpace(msg.Text)
+		if msg.Error || text == "" {
+			continue
+		}
+		role := "assistant"
+		if msg.IsCreatedByUser {
+			role = "user"
+		}
+		messages = append(messages, upstreamChatMessage{Role: role, Content: text, messageID: msg.MessageID})
+	}
+	messages = append(messages, upstreamChatMessage{Role: "user", Content: latestUser})
+	return messages
+}
+
 func resolveRole(msg agentMessage) string {
 	if msg.Role != "" {
 		switch strings.ToLower(msg.Role) {
@@ -431,26 +1250,32 @@ func joinContent(parts []agentMessageContent) string {
 	return builder.String()
 }
 
-func writeSSEEvent(w http.ResponseWriter, flusher http.Flusher, payload any) error {
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-	if _, err = w.Write([]byte("data: ")); err != nil {
-		return err
-	}
-	if _, err = w.Write(data); err != nil {
-		return err
-	}
-	if _, err = w.Write([]byte("\n\n")); err != nil {
+// writeCircuitOpen answers a legacy stream request with an SSE error event
+// while the llm-proxy circuit breaker is open.
+func writeCircuitOpen(w http.ResponseWriter, flusher http.Flusher, err *llmproxy.CircuitOpenError) {
+	retryAfter := retryAfterSeconds(err)
+	data, _ := json.Marshal(map[string]any{"error": err.Error(), "retryAfter": retryAfter})
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	flusher.Flush()
+}
+
+// retryAfterSeconds is how long, in whole seconds, clients should wait for
+// the circuit breaker to let requests through again.
+func retryAfterSeconds(err *llmproxy.CircuitOpenError) int {
+	return max(1, int(math.Ceil(time.Until(err.RetryAt).Seconds())))
+}
+
+func writeSSEEvent(w http.ResponseWriter, flusher http.Flusher, evt generation.Event) error {
+	if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", evt.ID, evt.Data); err != nil {
 		return err
 	}
 	flusher.Flush()
 	return nil
 }
 
-func sendErrorEvent(w http.ResponseWriter, flusher http.Flusher, conversationID, requestMessageID, parentMessageID, userText string, err error) {
-	responseMessageID := generateID()
+func buildErrorEvent(conversationID, requestMessageID, parentMessageID, responseMessageID, userText string, err error) map[string]any {
 	requestMessage := map[string]any{
 		"messageId":       requestMessageID,
 		"conversationId":  conversationID,
@@ -467,7 +1292,7 @@ func sendErrorEvent(w http.ResponseWriter, flusher http.Flusher, conversationID,
 		"error":           true,
 		"text":            err.Error(),
 	}
-	finalEvent := map[string]any{
+	return map[string]any{
 		"final":           true,
 		"error":           err.Error(),
 		"conversationId":  conversationID,
@@ -479,9 +1304,6 @@ func sendErrorEvent(w http.ResponseWriter, flusher http.Flusher, conversationID,
 		"requestMessage":  requestMessage,
 		"responseMessage": responseMessage,
 	}
-	if writeErr := writeSSEEvent(w, flusher, finalEvent); writeErr != nil {
-		log.Warn().Err(writeErr).Msg("failed to send error event")
-	}
 }
 
 func buildFinalEvent(payload agentChatPayload, conversationID, requestMessageID, parentMessageID, responseMessageID, userText, assistantText string) map[string]any {
@@ -530,7 +1352,7 @@ func (s *Server) requireAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		claims, err := s.authValidator.Verify(parts[1])
+		claims, err := s.authValidator.Verify(r.Context(), parts[1])
 		if err != nil {
 			log.Warn().Err(err).Msg("token verification failed")
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
@@ -0,0 +1,1160 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/auth"
+	"github.com/shopmindai/orchestrator/internal/catalog"
+	"github.com/shopmindai/orchestrator/internal/config"
+	"github.com/shopmindai/orchestrator/internal/llmproxy"
+	"github.com/shopmindai/orchestrator/internal/prices"
+	"github.com/shopmindai/orchestrator/internal/store"
+	"github.com/shopmindai/orchestrator/internal/tools"
+)
+
+func newTestServer(t *testing.T, upstream http.HandlerFunc, options ...func(*config.Config)) *Server {
+	t.Helper()
+	llmProxy := httptest.NewServer(upstream)
+	t.Cleanup(llmProxy.Close)
+
+	cfg := config.Config{
+		AllowedOrigins: "*",
+		LLMProxyURL:    llmProxy.URL,
+		LLMProxyAuth:   config.LLMProxyAuthConfig{Mode: "token", Secret: "test-token"},
+		Store:          config.StoreConfig{Driver: "memory"},
+		Chat: config.ChatConfig{
+			StreamBufferEvents: 1024,
+			ResumeGrace:        time.Minute,
+			ResumeRetention:    time.Minute,
+		},
+	}
+	for _, option := range options {
+		option(&cfg)
+	}
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func serve(srv *Server, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	srv.Router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAgentChatPersistsConversation(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: Hello\n\ndata: World\n\ndata: [DONE]\n\n"))
+	})
+
+	rr := serve(srv, http.MethodPost, "/api/agents/chat/agents",
+		`{"conversationId":"c1","messageId":"m1","text":"hi","model":"gpt-4o","promptPrefix":"be brief"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("chat status %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"final":true`) {
+		t.Fatalf("missing final event: %s", rr.Body.String())
+	}
+
+	rr = serve(srv, http.MethodGet, "/api/messages/c1", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("messages status %d: %s", rr.Code, rr.Body.String())
+	}
+	var messages []store.Message
+	if err := json.Unmarshal(rr.Body.Bytes(), &messages); err != nil {
+		t.Fatalf("decode messages: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %+v", messages)
+	}
+	if messages[0].MessageID != "m1" || messages[0].ParentMessageID != noParentMessageID || !messages[0].IsCreatedByUser {
+		t.Errorf("unexpected request message %+v", messages[0])
+	}
+	if messages[1].ParentMessageID != "m1" || messages[1].Text != "HelloWorld" {
+		t.Errorf("unexpected response message %+v", messages[1])
+	}
+
+	rr = serve(srv, http.MethodGet, "/api/convos/c1", "")
+	var conv store.Conversation
+	if err := json.Unmarshal(rr.Body.Bytes(), &conv); err != nil {
+		t.Fatalf("decode conversation: %v", err)
+	}
+	if conv.Endpoint != "agents" || conv.Model != "gpt-4o" || conv.PromptPrefix != "be brief" {
+		t.Errorf("unexpected conversation %+v", conv)
+	}
+}
+
+func TestAgentChatSavesRoutedModel(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(llmproxy.ProtocolHeader, "1")
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"route","provider":"ollama","model":"llama3.1"}` + "\n\n" +
+			`data: {"v":1,"type":"delta","text":"Hi"}` + "\n\n" +
+			`data: {"v":1,"type":"finish","finish_reason":"stop"}` + "\n\n"))
+	})
+
+	serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","messageId":"m1","text":"hi","model":"shop-fast"}`)
+
+	var messages []store.Message
+	rr := serve(srv, http.MethodGet, "/api/messages/c1", "")
+	_ = json.Unmarshal(rr.Body.Bytes(), &messages)
+	if len(messages) != 2 || messages[0].Model != "shop-fast" || messages[1].Model != "llama3.1" {
+		t.Errorf("routed model not saved on the response: %+v", messages)
+	}
+	rr = serve(srv, http.MethodGet, "/api/convos/c1", "")
+	if !strings.Contains(rr.Body.String(), `"model":"shop-fast"`) {
+		t.Errorf("conversation should keep the requested alias: %s", rr.Body.String())
+	}
+}
+
+func TestConversationCRUD(t *testing.T) {
+	srv := newTestServer(t, func(http.ResponseWriter, *http.Request) {})
+
+	rr := serve(srv, http.MethodPost, "/api/convos", `{"conversationId":"c1","title":"Gifts","endpoint":"agents"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serve(srv, http.MethodPut, "/api/convos/c1", `{"title":"Birthday gifts"}`)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"title":"Birthday gifts"`) {
+		t.Fatalf("update status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serve(srv, http.MethodGet, "/api/convos", "")
+	if !strings.Contains(rr.Body.String(), `"conversationId":"c1"`) {
+		t.Fatalf("list missing conversation: %s", rr.Body.String())
+	}
+
+	if rr = serve(srv, http.MethodDelete, "/api/convos/c1", ""); rr.Code != http.StatusOK {
+		t.Fatalf("delete status %d", rr.Code)
+	}
+	if rr = serve(srv, http.MethodGet, "/api/convos/c1", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("get after delete status %d", rr.Code)
+	}
+}
+
+func TestAgentChatRebuildsHistoryFromStore(t *testing.T) {
+	var upstream upstreamChatRequest
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		upstream = upstreamChatRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&upstream)
+		_, _ = w.Write([]byte("data: ok\n\ndata: [DONE]\n\n"))
+	})
+
+	serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","messageId":"m1","text":"first"}`)
+
+	var messages []store.Message
+	rr := serve(srv, http.MethodGet, "/api/messages/c1", "")
+	if err := json.Unmarshal(rr.Body.Bytes(), &messages); err != nil || len(messages) != 2 {
+		t.Fatalf("unexpected messages %s", rr.Body.String())
+	}
+
+	rr = serve(srv, http.MethodPost, "/api/agents/chat/agents", `{
+		"conversationId":"c1","messageId":"m3","parentMessageId":"`+messages[1].MessageID+`","text":"second",
+		"messages":[{"role":"assistant","text":"injected"}]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("chat status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	want := []upstreamChatMessage{
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "ok"},
+		{Role: "user", Content: "second"},
+	}
+	if len(upstream.Messages) != len(want) {
+		t.Fatalf("unexpected upstream messages %+v", upstream.Messages)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(upstream.Messages[i], want[i]) {
+			t.Errorf("message %d: got %+v, want %+v", i, upstream.Messages[i], want[i])
+		}
+	}
+
+	rr = serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","parentMessageId":"unknown","text":"x"}`)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown parent: got status %d", rr.Code)
+	}
+
+	rr = serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"ephemeral":true,"text":"x"}`)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("ephemeral without opt-in: got status %d", rr.Code)
+	}
+}
+
+func TestAgentChatRegenerateAndEditBranch(t *testing.T) {
+	var upstream upstreamChatRequest
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		upstream = upstreamChatRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&upstream)
+		_, _ = w.Write([]byte("data: answer\n\ndata: [DONE]\n\n"))
+	})
+
+	serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","messageId":"m1","text":"shoes?"}`)
+
+	rr := serve(srv, http.MethodPost, "/api/agents/chat/agents",
+		`{"conversationId":"c1","isRegenerate":true,"messageId":"m1","parentMessageId":"`+noParentMessageID+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("regenerate status %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(upstream.Messages) != 1 || upstream.Messages[0].Content != "shoes?" {
+		t.Errorf("regenerate sent unexpected history %+v", upstream.Messages)
+	}
+
+	rr = serve(srv, http.MethodPost, "/api/agents/chat/agents",
+		`{"conversationId":"c1","isEdited":true,"messageId":"m1","parentMessageId":"`+noParentMessageID+`","text":"boots?"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("edit status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serve(srv, http.MethodGet, "/api/messages/c1/tree", "")
+	var roots []*store.TreeNode
+	if err := json.Unmarshal(rr.Body.Bytes(), &roots); err != nil {
+		t.Fatalf("decode tree: %v", err)
+	}
+	if len(roots) != 2 {
+		t.Fatalf("expected original and edited prompt as root siblings, got %d", len(roots))
+	}
+	original, edited := roots[0], roots[1]
+	if original.MessageID != "m1" || original.SiblingCount != 2 || edited.SiblingIndex != 1 || edited.Text != "boots?" {
+		t.Errorf("unexpected roots %+v / %+v", original.Message, edited.Message)
+	}
+	if len(original.Children) != 2 || original.Children[1].SiblingIndex != 1 {
+		t.Errorf("expected two sibling responses under m1, got %d", len(original.Children))
+	}
+	if len(edited.Children) != 1 {
+		t.Errorf("expected one response under the edited prompt, got %d", len(edited.Children))
+	}
+}
+
+func TestAgentChatForwardsPromptPrefixModelAndParams(t *testing.T) {
+	var upstream upstreamChatRequest
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		upstream = upstreamChatRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&upstream)
+		_, _ = w.Write([]byte("data: ok\n\ndata: [DONE]\n\n"))
+	})
+
+	serve(srv, http.MethodPost, "/api/agents/chat/agents", `{
+		"conversationId":"c1","messageId":"m1","text":"hi","model":"gpt-4o","promptPrefix":"Answer in French.",
+		"temperature":0.3,"max_tokens":128,"stop":["###"],"frequency_penalty":0.5,"seed":7,
+		"additionalContext":{"country":"FR","budget":50}}`)
+
+	if upstream.Model != "gpt-4o" {
+		t.Errorf("model not forwarded: %q", upstream.Model)
+	}
+	wantSystem := "Answer in French.\n\nAdditional context:\n- budget: 50\n- country: FR"
+	if upstream.System != wantSystem {
+		t.Errorf("system prompt:\n got %q\nwant %q", upstream.System, wantSystem)
+	}
+	if upstream.Params.Temperature == nil || *upstream.Params.Temperature != 0.3 || upstream.Params.MaxTokens == nil || *upstream.Params.MaxTokens != 128 {
+		t.Errorf("generation params not forwarded: %+v", upstream.Params)
+	}
+	if len(upstream.Params.Stop) != 1 || upstream.Params.FrequencyPenalty == nil || *upstream.Params.FrequencyPenalty != 0.5 ||
+		upstream.Params.Seed == nil || *upstream.Params.Seed != 7 || upstream.Params.PresencePenalty != nil {
+		t.Errorf("sampling params not forwarded: %+v", upstream.Params)
+	}
+
+	var messages []store.Message
+	rr := serve(srv, http.MethodGet, "/api/messages/c1", "")
+	_ = json.Unmarshal(rr.Body.Bytes(), &messages)
+
+	serve(srv, http.MethodPost, "/api/agents/chat/agents",
+		`{"conversationId":"c1","parentMessageId":"`+messages[1].MessageID+`","text":"again"}`)
+	if upstream.Model != "gpt-4o" || upstream.System != "Answer in French." {
+		t.Errorf("stored conversation settings not applied: model %q system %q", upstream.Model, upstream.System)
+	}
+}
+
+func TestAgentChatAbortSavesPartialAnswer(t *testing.T) {
+	upstreamCancelled := make(chan struct{})
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(llmproxy.ProtocolHeader, "1")
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"delta","text":"Partial"}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			close(upstreamCancelled)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	streamDone := make(chan *httptest.ResponseRecorder)
+	go func() {
+		streamDone <- serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","messageId":"m1","text":"hi"}`)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if gen := srv.generations.Lookup("", "c1", ""); gen != nil && gen.Text() != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("generation never started streaming")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rr := serve(srv, http.MethodPost, "/api/agents/chat/abort", `{"abortKey":"c1"}`)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"text":"Partial"`) {
+		t.Fatalf("abort status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("llm-proxy request was not cancelled")
+	}
+	stream := <-streamDone
+	if !strings.Contains(stream.Body.String(), `"aborted":true`) {
+		t.Errorf("stream did not end with an aborted final event: %s", stream.Body.String())
+	}
+
+	var messages []store.Message
+	rr = serve(srv, http.MethodGet, "/api/messages/c1", "")
+	_ = json.Unmarshal(rr.Body.Bytes(), &messages)
+	if len(messages) != 2 || messages[1].Text != "Partial" || messages[1].Error {
+		t.Errorf("partial answer not saved: %+v", messages)
+	}
+
+	if rr = serve(srv, http.MethodPost, "/api/agents/chat/abort", `{"abortKey":"c1"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("second abort: got status %d", rr.Code)
+	}
+}
+
+func TestAgentChatResumeReplaysMissedEvents(t *testing.T) {
+	release := make(chan struct{})
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(llmproxy.ProtocolHeader, "1")
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"delta","text":"Hel"}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"delta","text":"lo"}` + "\n\n" +
+			`data: {"v":1,"type":"finish","finish_reason":"stop"}` + "\n\n"))
+	})
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/api/agents/chat/agents", strings.NewReader(`{"conversationId":"c1","messageId":"m1","text":"hi"}`)).WithContext(ctx)
+	first := httptest.NewRecorder()
+	streamDone := make(chan struct{})
+	go func() {
+		srv.Router.ServeHTTP(first, req)
+		close(streamDone)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if gen := srv.generations.Lookup("", "c1", ""); gen != nil && gen.Text() != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("generation never started streaming")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	disconnect()
+	<-streamDone
+
+	// The client saw "created" and the first chunk before dropping.
+	if !strings.Contains(first.Body.String(), "id: 2\n") || strings.Contains(first.Body.String(), `"final":true`) {
+		t.Fatalf("unexpected first stream: %s", first.Body.String())
+	}
+	close(release)
+
+	resume := httptest.NewRequest(http.MethodGet, "/api/agents/chat/resume/c1", nil)
+	resume.Header.Set("Last-Event-ID", "2")
+	rr := httptest.NewRecorder()
+	srv.Router.ServeHTTP(rr, resume)
+
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || strings.Contains(body, "id: 2\n") || !strings.Contains(body, "id: 3\n") {
+		t.Fatalf("resume status %d: %s", rr.Code, body)
+	}
+	if strings.Contains(body, `"text":"Hel"`) || !strings.Contains(body, `"text":"lo"`) || !strings.Contains(body, `"final":true`) {
+		t.Errorf("resume did not replay only the missed events: %s", body)
+	}
+
+	var messages []store.Message
+	rr = serve(srv, http.MethodGet, "/api/messages/c1", "")
+	_ = json.Unmarshal(rr.Body.Bytes(), &messages)
+	if len(messages) != 2 || messages[1].Text != "Hello" {
+		t.Errorf("full answer not saved after reconnect: %+v", messages)
+	}
+
+	if rr = serve(srv, http.MethodGet, "/api/agents/chat/resume/c2", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("resume of unknown conversation: got status %d", rr.Code)
+	}
+}
+
+func TestPolicyGatesRoutesEndpointsAndModels(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyFile, []byte(`{
+		"routes": {"DELETE /api/convos/{conversationId}": {"roles": ["admin"]}},
+		"endpoints": {"assistants": {"roles": ["staff"]}},
+		"models": {"shop-smart": {"roles": ["premium", "shopmind-client:premium"]}}
+	}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("data: Hi\n\ndata: [DONE]\n\n"))
+	}, func(cfg *config.Config) { cfg.AuthPolicyFile = policyFile })
+
+	serveAs := func(claims *auth.Claims, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), claimsContextKey{}, claims))
+		rr := httptest.NewRecorder()
+		srv.Router.ServeHTTP(rr, req)
+		return rr
+	}
+	customer := &auth.Claims{Subject: "u1"}
+	premium := &auth.Claims{Subject: "u2", ClientRoles: map[string][]string{"shopmind-client": {"premium"}}}
+
+	rr := serveAs(customer, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","text":"hi","model":"shop-smart"}`)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for premium model, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var denial forbiddenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &denial); err != nil {
+		t.Fatalf("decode denial: %v", err)
+	}
+	if denial.Error != "forbidden" || denial.Resource != "model" || denial.Name != "shop-smart" || len(denial.RequiredRoles) != 2 {
+		t.Errorf("unexpected denial %+v", denial)
+	}
+
+	// A model inherited from the conversation is checked too.
+	rr = serveAs(premium, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c2","text":"hi","model":"shop-smart"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected premium user to be allowed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if err := srv.store.SaveConversation(context.Background(), &store.Conversation{ConversationID: "c3", UserID: "u1", Model: "shop-smart"}); err != nil {
+		t.Fatal(err)
+	}
+	rr = serveAs(customer, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c3","text":"hi"}`)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for inherited premium model, got %d", rr.Code)
+	}
+	// The legacy stream forwards its body as is, so its model is checked.
+	rr = serveAs(customer, http.MethodPost, "/orchestrator/v1/sessions/s1/messages/stream", `{"messages":[{"role":"user","content":"hi"}],"model":"shop-smart"}`)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), `"resource":"model"`) {
+		t.Fatalf("expected model denial on the legacy stream, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = serveAs(premium, http.MethodPost, "/orchestrator/v1/sessions/s1/messages/stream", `{"messages":[{"role":"user","content":"hi"}],"model":"shop-smart"}`)
+	if rr.Code != http.StatusOK || rr.Body.String() != "data: Hi\n\ndata: [DONE]\n\n" {
+		t.Fatalf("expected the legacy stream to be piped, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveAs(premium, http.MethodPost, "/api/agents/chat/assistants", `{"conversationId":"c4","text":"hi"}`)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), `"resource":"endpoint"`) {
+		t.Fatalf("expected endpoint denial, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveAs(customer, http.MethodDelete, "/api/convos/c3", "")
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), `"resource":"route"`) {
+		t.Fatalf("expected route denial, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr = serveAs(customer, http.MethodGet, "/api/convos/c3", ""); rr.Code != http.StatusOK {
+		t.Fatalf("ungated route should be allowed, got %d", rr.Code)
+	}
+}
+
+func TestAgentChatEnforcesTokenBudget(t *testing.T) {
+	var titleCalls atomic.Int32
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/chat/completions" {
+			titleCalls.Add(1)
+			_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"Greetings"}}`))
+			return
+		}
+		w.Header().Set(llmproxy.ProtocolHeader, "1")
+		var frames strings.Builder
+		for range 40 {
+			frames.WriteString(`data: {"v":1,"type":"delta","text":"twelve bytes"}` + "\n\n")
+		}
+		frames.WriteString(`data: {"v":1,"type":"usage","usage":{"prompt_tokens":20,"completion_tokens":120,"total_tokens":140}}` + "\n\n")
+		frames.WriteString(`data: {"v":1,"type":"finish","finish_reason":"stop"}` + "\n\n")
+		_, _ = w.Write([]byte(frames.String()))
+	}, func(cfg *config.Config) {
+		cfg.Quota.Default = config.TokenBudget{Daily: 200}
+		cfg.Chat.TitleGeneration = true
+	})
+
+	rr := serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","text":"hi"}`)
+	if !strings.Contains(rr.Body.String(), `"final":true`) || strings.Contains(rr.Body.String(), `"error"`) {
+		t.Fatalf("first turn should complete: %s", rr.Body.String())
+	}
+	// The answer left 60 tokens, too few for the title request: the turn's
+	// later calls are charged against what its answer left.
+	rr = serve(srv, http.MethodGet, "/api/convos/gen_title/c1", "")
+	if titleCalls.Load() != 0 || strings.TrimSpace(rr.Body.String()) != `{"title":"hi"}` {
+		t.Errorf("expected the heuristic title without a title request (%d calls): %s", titleCalls.Load(), rr.Body.String())
+	}
+
+	rr = serve(srv, http.MethodGet, "/api/balance", "")
+	var balance balanceResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &balance); err != nil {
+		t.Fatalf("decode balance: %v", err)
+	}
+	if balance.TokenCredits == nil || *balance.TokenCredits != 60 || balance.Daily.Used != 140 || balance.Monthly.Limit != 0 {
+		t.Fatalf("unexpected balance %s", rr.Body.String())
+	}
+
+	// The prompt and the 480-byte answer do not fit in 60 tokens: the
+	// answer is cut off mid-stream.
+	rr = serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c2","text":"again"}`)
+	if !strings.Contains(rr.Body.String(), `"text":"twelve bytes"`) || !strings.Contains(rr.Body.String(), `daily token budget of 200 exhausted`) {
+		t.Fatalf("expected budget error event mid-stream: %s", rr.Body.String())
+	}
+
+	rr = serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","text":"more"}`)
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), `"error":"quota_exceeded"`) {
+		t.Fatalf("expected 429 once the budget is spent, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestLegacyStreamEnforcesTokenBudget(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("data: " + strings.Repeat("x", 400) + "\n\ndata: [DONE]\n\n"))
+	}, func(cfg *config.Config) {
+		cfg.Quota.Default = config.TokenBudget{Daily: 110}
+	})
+	body := `{"messages":[{"role":"user","content":"hi"}]}`
+
+	rr := serve(srv, http.MethodPost, "/orchestrator/v1/sessions/s1/messages/stream", body)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "[DONE]") {
+		t.Fatalf("first stream: %d %s", rr.Code, rr.Body.String())
+	}
+	// Without reported usage the prompt and the 400-byte answer are estimated.
+	rr = serve(srv, http.MethodGet, "/api/balance", "")
+	var balance balanceResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &balance); err != nil {
+		t.Fatalf("decode balance: %v", err)
+	}
+	if want := estimateTokens(body) + 100; balance.Daily.Used != want {
+		t.Fatalf("expected %d tokens used, got %s", want, rr.Body.String())
+	}
+
+	rr = serve(srv, http.MethodPost, "/orchestrator/v1/sessions/s1/messages/stream", body)
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), `"error":"quota_exceeded"`) {
+		t.Fatalf("expected 429 once the budget is spent, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestChatRoutesAreRateLimited(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(llmproxy.ProtocolHeader, "1")
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"delta","text":"Hi"}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-release
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"finish","finish_reason":"stop"}` + "\n\n"))
+	}, func(cfg *config.Config) {
+		cfg.RateLimit = config.RateLimitConfig{RPS: 0.001, Burst: 3, MaxStreams: 1}
+	})
+
+	// The first stream holds the caller's only slot until released.
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","text":"hi"}`)
+	}()
+	<-started
+
+	rr := serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c2","text":"hi"}`)
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), "concurrent streams") {
+		t.Fatalf("expected stream cap, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("X-RateLimit-Limit") != "3" || rr.Header().Get("X-RateLimit-Remaining") != "1" {
+		t.Errorf("unexpected rate limit headers %v", rr.Header())
+	}
+	rr = serve(srv, http.MethodPost, "/orchestrator/v1/sessions/s1/messages/stream", `{"messages":[{"role":"user","content":"hi"}]}`)
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), "concurrent streams") {
+		t.Fatalf("expected stream cap on the legacy stream, got %d: %s", rr.Code, rr.Body.String())
+	}
+	close(release)
+	if first := <-done; first.Code != http.StatusOK {
+		t.Fatalf("first stream failed: %d", first.Code)
+	}
+
+	// All tokens are spent now.
+	rr = serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c3","text":"hi"}`)
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), `"error":"rate_limited"`) {
+		t.Fatalf("expected rate limit, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if retry := rr.Header().Get("Retry-After"); retry == "" || retry == "0" {
+		t.Errorf("missing Retry-After, got %q", retry)
+	}
+	rr = serve(srv, http.MethodPost, "/api/agents/chat/abort", `{"conversationId":"c1"}`)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the abort route to be limited, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Other routes are not limited.
+	if rr = serve(srv, http.MethodGet, "/api/convos", ""); rr.Code != http.StatusOK {
+		t.Fatalf("conversation list limited: %d", rr.Code)
+	}
+}
+
+func TestStreamSlotIsHeldUntilGenerationFinishes(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(llmproxy.ProtocolHeader, "1")
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"delta","text":"Hi"}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"finish","finish_reason":"stop"}` + "\n\n"))
+	}, func(cfg *config.Config) { cfg.RateLimit = config.RateLimitConfig{MaxStreams: 1} })
+
+	// The client drops its stream at once; the generation keeps running.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodPost, "/api/agents/chat/agents", strings.NewReader(`{"conversationId":"c1","text":"hi"}`)).WithContext(ctx)
+		srv.Router.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+	cancel()
+	<-done
+
+	rr := serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c2","text":"hi"}`)
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), "concurrent streams") {
+		t.Fatalf("a detached generation must keep its slot, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Once the generation is over, the slot is free again.
+	close(release)
+	serveWhenSlotFree := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			rr := serve(srv, http.MethodPost, "/api/agents/chat/agents", body)
+			if rr.Code != http.StatusTooManyRequests {
+				return rr
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("stream slot never released: %s", rr.Body.String())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if rr = serveWhenSlotFree(`{"conversationId":"c3","text":"hi"}`); rr.Code != http.StatusOK {
+		t.Fatalf("chat after release: %d %s", rr.Code, rr.Body.String())
+	}
+	// Requests rejected before their generation starts give the slot back.
+	if rr = serveWhenSlotFree(`{"conversationId":"c3","parentMessageId":"unknown","text":"hi"}`); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown parent: %d", rr.Code)
+	}
+	if rr = serveWhenSlotFree(`{"conversationId":"c4","text":"hi"}`); rr.Code != http.StatusOK {
+		t.Fatalf("chat after a rejected request: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAgentChatRunsToolsUntilAnswer(t *testing.T) {
+	var requests []upstreamChatRequest
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req upstreamChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		w.Header().Set(llmproxy.ProtocolHeader, "1")
+		if len(requests) == 1 {
+			_, _ = w.Write([]byte(`data: {"v":1,"type":"delta","text":"Checking."}` + "\n\n" +
+				`data: {"v":1,"type":"tool_call","tool_call":{"index":0,"id":"call_0","name":"lookup_price"}}` + "\n\n" +
+				`data: {"v":1,"type":"tool_call","tool_call":{"index":0,"arguments":"{\"sku\":\"A1\"}"}}` + "\n\n" +
+				`data: {"v":1,"type":"tool_call","tool_call":{"index":1,"id":"call_1","name":"missing","arguments":"{}"}}` + "\n\n" +
+				`data: {"v":1,"type":"finish","finish_reason":"tool_calls"}` + "\n\n"))
+			return
+		}
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"delta","text":"It costs 9.99."}` + "\n\n" +
+			`data: {"v":1,"type":"finish","finish_reason":"stop"}` + "\n\n"))
+	}, func(cfg *config.Config) { cfg.Chat.MaxToolSteps = 1 })
+
+	err := srv.tools.Register(tools.Tool{
+		Definition: tools.Definition{Name: "lookup_price", Description: "Look up a product price"},
+		Run: func(ctx context.Context, arguments json.RawMessage) (any, error) {
+			return map[string]any{"price": 9.99}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("register tool: %v", err)
+	}
+
+	rr := serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","text":"price of A1?"}`)
+	body := rr.Body.String()
+	for _, want := range []string{
+		`"toolCall":{"args":"{\"sku\":\"A1\"}","id":"call_0","name":"lookup_price","step":1}`,
+		`"toolResult":{"durationMs":`,
+		`"output":{"price":9.99}`,
+		`"toolResult":{"error":"unknown tool \"missing\"","id":"call_1"`,
+		`"final":true`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s in stream: %s", want, body)
+		}
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 upstream requests, got %d", len(requests))
+	}
+	if len(requests[0].Tools) != 1 || requests[0].Tools[0].Name != "lookup_price" {
+		t.Errorf("tools not offered: %+v", requests[0].Tools)
+	}
+	if requests[1].Tools != nil {
+		t.Errorf("the last allowed round must not offer tools: %+v", requests[1].Tools)
+	}
+	messages := requests[1].Messages
+	if len(messages) != 4 {
+		t.Fatalf("unexpected follow-up messages %+v", messages)
+	}
+	if call := messages[1]; call.Role != "assistant" || call.Content != "Checking." || len(call.ToolCalls) != 2 ||
+		call.ToolCalls[0] != (upstreamToolCall{ID: "call_0", Name: "lookup_price", Arguments: `{"sku":"A1"}`}) {
+		t.Errorf("unexpected assistant message %+v", call)
+	}
+	if result := messages[2]; result.Role != "tool" || result.ToolCallID != "call_0" || result.Name != "lookup_price" || result.Content != `{"price":9.99}` {
+		t.Errorf("unexpected tool message %+v", result)
+	}
+	if result := messages[3]; result.ToolCallID != "call_1" || !strings.Contains(result.Content, `"error"`) {
+		t.Errorf("unexpected tool error message %+v", result)
+	}
+
+	var stored []store.Message
+	rr = serve(srv, http.MethodGet, "/api/messages/c1", "")
+	if err := json.Unmarshal(rr.Body.Bytes(), &stored); err != nil || len(stored) != 2 {
+		t.Fatalf("unexpected messages %s", rr.Body.String())
+	}
+	if stored[1].Text != "Checking.\n\nIt costs 9.99." {
+		t.Errorf("unexpected answer %q", stored[1].Text)
+	}
+}
+
+func TestCatalogRoutes(t *testing.T) {
+	if rr := serve(newTestServer(t, nil), http.MethodGet, "/api/catalog/search?q=socks", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("catalog routes should be off without a feed directory, got %d", rr.Code)
+	}
+
+	srv := newTestServer(t, nil, func(cfg *config.Config) {
+		cfg.Catalog = config.CatalogConfig{FeedDir: "../catalog/testdata/feeds", Store: config.StoreConfig{Driver: "memory"}}
+		cfg.Chat.MaxToolSteps = 1
+	})
+	if defs := srv.tools.Definitions(); len(defs) != 1 || defs[0].Name != "search_products" {
+		t.Errorf("search tool not registered: %+v", defs)
+	}
+
+	rr := serve(srv, http.MethodGet, "/api/catalog/search?q=socks&brand=Trailwise&max_price=20", "")
+	var result struct {
+		Total    int
+		Products []struct{ Feed, ID string }
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil || result.Total != 1 || result.Products[0].ID != "SOCK-1" {
+		t.Errorf("unexpected search result %d %s", rr.Code, rr.Body.String())
+	}
+	for _, target := range []string{"/api/catalog/search?min_price=cheap", "/api/catalog/search?sort=popular", "/api/catalog/search?limit=-1"} {
+		if rr := serve(srv, http.MethodGet, target, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, rr.Code)
+		}
+	}
+
+	if rr := serve(srv, http.MethodGet, "/api/catalog/products/merchant/JKT-1", ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"title":"Insulated Down Jacket"`) {
+		t.Errorf("unexpected product %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve(srv, http.MethodGet, "/api/catalog/products/merchant/nope", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rr.Code)
+	}
+
+	rr = serve(srv, http.MethodGet, "/api/catalog/feeds", "")
+	if !strings.Contains(rr.Body.String(), `"feed":"outdoor"`) || !strings.Contains(rr.Body.String(), `"errorCount":1`) {
+		t.Errorf("unexpected feed reports %s", rr.Body.String())
+	}
+	rr = serve(srv, http.MethodPost, "/api/catalog/ingest", "")
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), `"unchanged":true`) != 3 {
+		t.Errorf("unexpected ingest response %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestPriceRoutes(t *testing.T) {
+	srv := newTestServer(t, nil, func(cfg *config.Config) {
+		cfg.Catalog = config.CatalogConfig{FeedDir: "../catalog/testdata/feeds", Store: config.StoreConfig{Driver: "memory"}}
+		cfg.Prices = config.PricesConfig{Store: config.StoreConfig{Driver: "memory"}}
+	})
+	serveAs := func(claims *auth.Claims, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), claimsContextKey{}, claims))
+		rr := httptest.NewRecorder()
+		srv.Router.ServeHTTP(rr, req)
+		return rr
+	}
+	user := &auth.Claims{Subject: "u1", Email: "ana@example.com"}
+
+	rr := serve(srv, http.MethodGet, "/api/prices/products/outdoor/SOCK-2/history?days=30", "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"price":7.99`) {
+		t.Errorf("unexpected history %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve(srv, http.MethodGet, "/api/prices/products/outdoor/SOCK-2/history?days=0", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid days, got %d", rr.Code)
+	}
+	rr = serve(srv, http.MethodGet, "/api/prices/products/merchant/JKT-1/offers", "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"cheapest":{"feed":"merchant","id":"JKT-1"`) {
+		t.Errorf("unexpected offers %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve(srv, http.MethodGet, "/api/prices/products/merchant/nope/offers", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rr.Code)
+	}
+
+	if rr := serve(srv, http.MethodPost, "/api/prices/subscriptions", `{"feed":"outdoor","productId":"SOCK-1","targetPrice":15}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous subscriptions must be rejected, got %d", rr.Code)
+	}
+	if rr := serveAs(user, http.MethodPost, "/api/prices/subscriptions", `{"feed":"outdoor","productId":"SOCK-1","targetPrice":15,"channel":"email"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a disabled channel, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = serveAs(user, http.MethodPost, "/api/prices/subscriptions", `{"feed":"outdoor","productId":"SOCK-1","targetPrice":15}`)
+	var sub prices.Subscription
+	if err := json.Unmarshal(rr.Body.Bytes(), &sub); err != nil || rr.Code != http.StatusCreated || sub.Channel != prices.ChannelInApp || sub.UserID != "u1" {
+		t.Fatalf("unexpected subscription %d %s", rr.Code, rr.Body.String())
+	}
+	rr = serveAs(user, http.MethodGet, "/api/prices/subscriptions", "")
+	if !strings.Contains(rr.Body.String(), `"productId":"SOCK-1"`) || !strings.Contains(rr.Body.String(), `"channels":["in_app"]`) {
+		t.Errorf("unexpected subscriptions %s", rr.Body.String())
+	}
+
+	// Deliver an alert and read it from the notification feed.
+	err := srv.prices.Observe(context.Background(), []catalog.Product{{Feed: "outdoor", ID: "SOCK-1", Title: "Merino Hiking Socks", Price: 14, Currency: "USD", Availability: catalog.InStock}})
+	if err != nil {
+		t.Fatalf("observe: %v", err)
+	}
+	srv.prices.Wait()
+	rr = serveAs(user, http.MethodGet, "/api/notifications?unread=true", "")
+	var feed struct{ Notifications []prices.Notification }
+	if err := json.Unmarshal(rr.Body.Bytes(), &feed); err != nil || len(feed.Notifications) != 1 || feed.Notifications[0].Alert.Price != 14 {
+		t.Fatalf("unexpected notifications %s", rr.Body.String())
+	}
+	target := "/api/notifications/" + strconv.FormatInt(feed.Notifications[0].ID, 10) + "/read"
+	if rr := serveAs(&auth.Claims{Subject: "u2"}, http.MethodPost, target, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("another user's notification must not be found, got %d", rr.Code)
+	}
+	if rr := serveAs(user, http.MethodPost, target, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rr.Code)
+	}
+	if rr := serveAs(user, http.MethodGet, "/api/notifications?unread=true", ""); !strings.Contains(rr.Body.String(), `"notifications":[]`) {
+		t.Errorf("expected no unread notifications, got %s", rr.Body.String())
+	}
+
+	if rr := serveAs(user, http.MethodDelete, "/api/prices/subscriptions/"+strconv.FormatInt(sub.ID, 10), ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rr.Code)
+	}
+}
+
+func TestAgentChatCitesRetrievedSources(t *testing.T) {
+	var mu sync.Mutex
+	var chats []upstreamChatRequest
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/embeddings" {
+			// Texts about boots point one way, everything else the other.
+			var req struct{ Input []string }
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			data := make([]map[string]any, len(req.Input))
+			for i, text := range req.Input {
+				vector := []float32{0, 1}
+				if strings.Contains(strings.ToLower(text), "boots") {
+					vector = []float32{1, 0}
+				}
+				data[i] = map[string]any{"index": i, "embedding": vector}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": data})
+			return
+		}
+		var req upstreamChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		chats = append(chats, req)
+		mu.Unlock()
+		w.Header().Set(llmproxy.ProtocolHeader, "1")
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"delta","text":"Yes, they are waterproof [1]."}` + "\n\n" +
+			`data: {"v":1,"type":"finish","finish_reason":"stop"}` + "\n\n"))
+	}, func(cfg *config.Config) {
+		cfg.Catalog = config.CatalogConfig{FeedDir: "../catalog/testdata/feeds", Store: config.StoreConfig{Driver: "memory"}}
+		cfg.RAG = config.RAGConfig{Endpoints: []string{"shopmind"}, TopK: 1, MinScore: 0.5}
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.rag.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("retrieval index was not built")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rr := serve(srv, http.MethodPost, "/api/agents/chat/shopmind", `{"conversationId":"c1","text":"Are the hiking boots waterproof?"}`)
+	body := rr.Body.String()
+	for _, want := range []string{
+		`"citation":{"index":1,"start":0,"end":24,"text":"Yes, they are waterproof","source":{"id":"product:footwear/BOOT-1","kind":"product","title":"Waterproof Hiking Boots"}}`,
+		`"final":true`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s in stream: %s", want, body)
+		}
+	}
+	if strings.Index(body, `"citation"`) > strings.Index(body, `"final":true`) {
+		t.Error("citations must precede the final event")
+	}
+
+	serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c2","text":"Are the hiking boots waterproof?"}`)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(chats) != 2 {
+		t.Fatalf("expected 2 chat requests, got %d", len(chats))
+	}
+	messages := chats[0].Messages
+	if len(messages) != 2 || messages[0].Role != "system" || messages[1].Role != "user" ||
+		!strings.Contains(messages[0].Content, "[1] Waterproof Hiking Boots") {
+		t.Errorf("sources not given to the model: %+v", messages)
+	}
+	if len(chats[1].Messages) != 1 {
+		t.Errorf("endpoint without retrieval got sources: %+v", chats[1].Messages)
+	}
+}
+
+func TestAgentChatFailsFastWhileLLMProxyIsDown(t *testing.T) {
+	var calls atomic.Int32
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "no provider", http.StatusBadGateway)
+	}, func(cfg *config.Config) {
+		cfg.LLMProxy = config.LLMProxyClientConfig{Retries: 1, RetryBackoff: time.Millisecond, BreakerThreshold: 2, BreakerCooldown: time.Minute}
+	})
+
+	rr := serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","text":"hi"}`)
+	if !strings.Contains(rr.Body.String(), "502 Bad Gateway") || calls.Load() != 2 {
+		t.Fatalf("expected the retried 502, got %d calls: %s", calls.Load(), rr.Body.String())
+	}
+
+	rr = serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","text":"again"}`)
+	if !strings.Contains(rr.Body.String(), `"error":"llm proxy is unavailable`) || !strings.Contains(rr.Body.String(), `"retryAfter":60`) || calls.Load() != 2 {
+		t.Fatalf("expected a circuit-open error event, got %d calls: %s", calls.Load(), rr.Body.String())
+	}
+
+	rr = serve(srv, http.MethodPost, "/orchestrator/v1/sessions/s1/messages/stream", `{}`)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "60" || !strings.HasPrefix(rr.Body.String(), "event: error\ndata: ") {
+		t.Fatalf("legacy stream: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serve(srv, http.MethodGet, "/orchestrator/v1/healthz", "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"circuit":{"state":"open","failures":2`) {
+		t.Fatalf("healthz: %s", rr.Body.String())
+	}
+}
+
+func TestLLMProxyTokenIsOnlyRequiredWithAProxy(t *testing.T) {
+	cfg := config.Config{
+		AllowedOrigins: "*",
+		LLMProxyAuth:   config.LLMProxyAuthConfig{Mode: "token"},
+		Store:          config.StoreConfig{Driver: "memory"},
+	}
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("without a proxy: %v", err)
+	}
+	srv.Close()
+
+	cfg.LLMProxyURL = "http://llm-proxy.invalid"
+	if srv, err := New(cfg); err == nil {
+		srv.Close()
+		t.Fatal("expected a proxy without LLM_PROXY_TOKEN to be rejected")
+	}
+}
+
+func TestAgentChatAbortsStalledStream(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(llmproxy.ProtocolHeader, "1")
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"delta","text":"Let me think"}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		// Heartbeats alone do not count as progress.
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(20 * time.Millisecond):
+				_, _ = w.Write([]byte(": keep-alive\n\n"))
+				w.(http.Flusher).Flush()
+			}
+		}
+	}, func(cfg *config.Config) {
+		cfg.Chat.StreamHeartbeat = 20 * time.Millisecond
+		cfg.Chat.StreamStallTimeout = 200 * time.Millisecond
+	})
+
+	rr := serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","text":"hi"}`)
+	body := rr.Body.String()
+	if !strings.Contains(body, ": keep-alive\n\n") {
+		t.Errorf("expected heartbeats while waiting: %s", body)
+	}
+	if !strings.Contains(body, `"error":"the model stopped responding, please try again"`) {
+		t.Fatalf("expected a stall error event: %s", body)
+	}
+}
+
+func TestAgentChatGeneratesTitle(t *testing.T) {
+	var titleRequest upstreamChatRequest
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/chat/completions" {
+			_ = json.NewDecoder(r.Body).Decode(&titleRequest)
+			_, _ = w.Write([]byte(`{"model":"gpt-4o-mini","message":{"role":"assistant","content":"Title: \"Trail running shoes.\""},"usage":{"prompt_tokens":40,"completion_tokens":5,"total_tokens":45}}`))
+			return
+		}
+		w.Header().Set(llmproxy.ProtocolHeader, "1")
+		_, _ = w.Write([]byte(`data: {"v":1,"type":"delta","text":"Try these trail shoes."}` + "\n\n" + `data: {"v":1,"type":"finish","finish_reason":"stop"}` + "\n\n"))
+	}, func(cfg *config.Config) {
+		cfg.Chat.TitleGeneration = true
+		cfg.Chat.TitleModel = "gpt-4o-mini"
+	})
+
+	// The stream ends with the answer; the title follows through gen_title.
+	rr := serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","text":"I need shoes for muddy trails"}`)
+	body := rr.Body.String()
+	if !strings.HasSuffix(strings.TrimSpace(body), "}") || !strings.Contains(body, `"final":true`) || strings.Contains(body, `"title":"Trail running shoes"`) {
+		t.Fatalf("expected the stream to end with the final event: %s", body)
+	}
+
+	rr = serve(srv, http.MethodGet, "/api/convos/gen_title/c1", "")
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != `{"title":"Trail running shoes"}` {
+		t.Fatalf("gen_title: %d %s", rr.Code, rr.Body.String())
+	}
+	if titleRequest.Model != "gpt-4o-mini" || !strings.Contains(titleRequest.Messages[0].Content, "muddy trails") || !strings.Contains(titleRequest.Messages[0].Content, "Try these trail shoes.") {
+		t.Errorf("unexpected title request %+v", titleRequest)
+	}
+
+	// Later exchanges keep the title.
+	rr = serve(srv, http.MethodGet, "/api/messages/c1", "")
+	var messages []map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &messages); err != nil || len(messages) != 2 {
+		t.Fatalf("messages: %v %s", err, rr.Body.String())
+	}
+	titleRequest = upstreamChatRequest{}
+	serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","parentMessageId":"`+messages[1]["messageId"].(string)+`","text":"And waterproof ones?"}`)
+	srv.titles.wait()
+	if titleRequest.Model != "" {
+		t.Errorf("only the first exchange is titled: %+v", titleRequest)
+	}
+	if rr = serve(srv, http.MethodGet, "/api/convos/gen_title/missing", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown conversation: %d", rr.Code)
+	}
+}
+
+func TestAgentChatFallsBackToHeuristicTitle(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/chat/completions" {
+			http.Error(w, "model not allowed", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("data: Sure\n\ndata: [DONE]\n\n"))
+	}, func(cfg *config.Config) { cfg.Chat.TitleGeneration = true })
+
+	serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","text":"  Which running shoes are best for wide feet and long distances?"}`)
+	rr := serve(srv, http.MethodGet, "/api/convos/gen_title/c1", "")
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != `{"title":"Which running shoes are best for wide feet…"}` {
+		t.Fatalf("gen_title: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAgentChatFitsHistoryIntoContextWindow(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		upstream  upstreamChatRequest
+		summaries []string
+	)
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req upstreamChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/tokens/count":
+			// Every message costs 100 tokens: a 1000-token window with
+			// 200 for the answer and 100 for the summary fits six.
+			counts := make([]int, len(req.Messages))
+			for i := range counts {
+				counts[i] = 100
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"prompt_tokens": 100*len(counts) + 50, "messages": counts,
+				"context_window": 1000, "max_output_tokens": 200,
+			})
+		case "/v1/chat/completions":
+			summaries = append(summaries, req.Messages[0].Content)
+			_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"summary ` + strconv.Itoa(len(summaries)) + `"}}`))
+		default:
+			upstream = req
+			_, _ = w.Write([]byte("data: ok\n\ndata: [DONE]\n\n"))
+		}
+	}, func(cfg *config.Config) {
+		cfg.Chat.ContextFitting = true
+		cfg.Chat.HistorySummary = true
+		cfg.Chat.SummaryMaxTokens = 100
+	})
+
+	parent := ""
+	for _, text := range []string{"first", "second", "third", "fourth", "fifth"} {
+		rr := serve(srv, http.MethodPost, "/api/agents/chat/agents", `{"conversationId":"c1","parentMessageId":"`+parent+`","text":"`+text+`","promptPrefix":"Be helpful."}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("chat %s: %d %s", text, rr.Code, rr.Body.String())
+		}
+		var messages []store.Message
+		rr = serve(srv, http.MethodGet, "/api/messages/c1", "")
+		if err := json.Unmarshal(rr.Body.Bytes(), &messages); err != nil {
+			t.Fatalf("messages: %v", err)
+		}
+		parent = messages[len(messages)-1].MessageID
+
+		mu.Lock()
+		switch text {
+		case "third":
+			if len(summaries) != 0 || len(upstream.Messages) != 5 {
+				t.Errorf("a history that fits is sent whole: %+v, summaries %q", upstream.Messages, summaries)
+			}
+		case "fourth":
+			if len(summaries) != 1 || !strings.Contains(summaries[0], "User: first\n\nAssistant: ok") {
+				t.Errorf("unexpected summary requests %q", summaries)
+			}
+			if len(upstream.Messages) != 5 || upstream.Messages[0].Content != "second" {
+				t.Errorf("the first turn should be dropped: %+v", upstream.Messages)
+			}
+			if upstream.System != "Be helpful.\n\nSummary of the earlier conversation:\nsummary 1" {
+				t.Errorf("unexpected system prompt %q", upstream.System)
+			}
+		case "fifth":
+			if len(summaries) != 2 || !strings.HasPrefix(summaries[1], "Summary so far:\nsummary 1") ||
+				!strings.Contains(summaries[1], "User: second") || strings.Contains(summaries[1], "first") {
+				t.Errorf("the cached summary should be extended with the second turn only: %q", summaries)
+			}
+			if len(upstream.Messages) != 5 || upstream.Messages[0].Content != "third" || upstream.Messages[4].Content != "fifth" {
+				t.Errorf("the first two turns should be dropped: %+v", upstream.Messages)
+			}
+			if !strings.HasSuffix(upstream.System, "summary 2") {
+				t.Errorf("unexpected system prompt %q", upstream.System)
+			}
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,223 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/orchestrator/internal/generation"
+)
+
+const (
+	titleInstructions = "Write a short title, at most seven words, for the conversation below. " +
+		"Use the language of the conversation. Reply with the title only, without quotes or a final period."
+	// titleExcerpt bounds each side of the exchange sent for titling.
+	titleExcerpt   = 1000
+	titleMaxTokens = 24
+	titleMaxLength = 80
+	// heuristicTitleWords is how much of the user's message names the
+	// conversation when the model cannot.
+	heuristicTitleWords = 8
+	defaultTitle        = "New Chat"
+)
+
+// pendingTitles tracks the conversations being titled, so that gen_title
+// requests can wait for the title instead of missing it.
+type pendingTitles struct {
+	mu      sync.Mutex
+	pending map[string]chan struct{}
+	running sync.WaitGroup
+}
+
+// start marks conversationID as being titled until done is called.
+func (p *pendingTitles) start(conversationID string) (done func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending == nil {
+		p.pending = make(map[string]chan struct{})
+	}
+	ch := make(chan struct{})
+	p.pending[conversationID] = ch
+	p.running.Add(1)
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.pending[conversationID] == ch {
+			delete(p.pending, conversationID)
+		}
+		close(ch)
+		p.running.Done()
+	}
+}
+
+// settled returns a channel closed once conversationID's title is settled,
+// or nil when none is being generated.
+func (p *pendingTitles) settled(conversationID string) <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pending[conversationID]
+}
+
+// wait blocks until every title being generated is settled.
+func (p *pendingTitles) wait() {
+	p.running.Wait()
+}
+
+// nameConversation titles a conversation after its first exchange, unless
+// it already has a title. The model's suggestion is used when it gives one
+// in time; otherwise the title is the start of the user's message.
+func (s *Server) nameConversation(ctx context.Context, gen *generation.Generation, turn agentTurn, answer string) {
+	conv, err := s.store.GetConversation(ctx, turn.userID, turn.conversationID)
+	if err != nil {
+		log.Warn().Err(err).Str("conversationId", turn.conversationID).Msg("failed to load conversation for titling")
+		return
+	}
+	if conv.Title != "" {
+		return
+	}
+
+	title, err := s.generateTitle(ctx, gen, turn, answer)
+	if err != nil {
+		log.Warn().Err(err).Str("conversationId", turn.conversationID).Msg("title generation failed, using the start of the message")
+		title = heuristicTitle(turn.userText)
+	}
+
+	// The user may have renamed the conversation in the meantime.
+	conv, err = s.store.GetConversation(ctx, turn.userID, turn.conversationID)
+	if err != nil || conv.Title != "" {
+		return
+	}
+	conv.Title = title
+	if err := s.store.SaveConversation(ctx, conv); err != nil {
+		log.Error().Err(err).Str("conversationId", turn.conversationID).Msg("failed to save conversation title")
+	}
+}
+
+// generateTitle asks llm-proxy's title model to name the exchange.
+func (s *Server) generateTitle(ctx context.Context, gen *generation.Generation, turn agentTurn, answer string) (string, error) {
+	if s.cfg.LLMProxyURL == "" {
+		return "", errors.New("LLM proxy not configured")
+	}
+	if s.cfg.Chat.TitleTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.Chat.TitleTimeout)
+		defer cancel()
+	}
+	maxTokens, temperature := titleMaxTokens, 0.2
+	reply, err := s.complete(ctx, gen, turn, upstreamChatRequest{
+		Messages: []upstreamChatMessage{{
+			Role:    "user",
+			Content: fmt.Sprintf("User: %s\n\nAssistant: %s", excerpt(turn.userText, titleExcerpt), excerpt(answer, titleExcerpt)),
+		}},
+		System: titleInstructions,
+		Model:  s.cfg.Chat.TitleModel,
+		Params: upstreamGenerationParams{MaxTokens: &maxTokens, Temperature: &temperature},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	title := cleanTitle(reply)
+	if title == "" {
+		return "", errors.New("model suggested an empty title")
+	}
+	return title, nil
+}
+
+// cleanTitle keeps the first line of a model's suggestion, without the
+// labels, quotes and trailing period models tend to add.
+func cleanTitle(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if label, rest, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(label), "title") {
+			line = rest
+		}
+		line = strings.Trim(line, " \t\"'`*#“”‘’")
+		line = strings.TrimSuffix(line, ".")
+		return truncateWords(strings.Fields(line), len(strings.Fields(line)), titleMaxLength)
+	}
+	return ""
+}
+
+// heuristicTitle names a conversation after the first words of its first
+// message.
+func heuristicTitle(userText string) string {
+	words := strings.Fields(userText)
+	if len(words) == 0 {
+		return defaultTitle
+	}
+	return truncateWords(words, heuristicTitleWords, titleMaxLength)
+}
+
+// truncateWords joins at most maxWords words within maxLength characters,
+// marking a cut with an ellipsis.
+func truncateWords(words []string, maxWords, maxLength int) string {
+	var b strings.Builder
+	length := 0
+	for i, word := range words {
+		if i > 0 {
+			length++
+		}
+		length += utf8.RuneCountInString(word)
+		if i == maxWords || length > maxLength {
+			if i == 0 {
+				// A single overlong word is cut mid-word.
+				return string([]rune(word)[:maxLength-1]) + "…"
+			}
+			return b.String() + "…"
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(word)
+	}
+	return b.String()
+}
+
+// excerpt returns the first n characters of text.
+func excerpt(text string, n int) string {
+	if runes := []rune(text); len(runes) > n {
+		return string(runes[:n]) + "…"
+	}
+	return text
+}
+
+// handleGenerateTitle returns a conversation's title, waiting for one that
+// is still being generated. Untitled conversations are not found, as in
+// LibreChat.
+func (s *Server) handleGenerateTitle(w http.ResponseWriter, r *http.Request) {
+	conversationID := chi.URLParam(r, "conversationId")
+	userID := subjectFromContext(r.Context())
+	if _, err := s.store.GetConversation(r.Context(), userID, conversationID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if pending := s.titles.settled(conversationID); pending != nil {
+		select {
+		case <-pending:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	conv, err := s.store.GetConversation(r.Context(), userID, conversationID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if conv.Title == "" {
+		http.Error(w, "title not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"title": conv.Title})
+}
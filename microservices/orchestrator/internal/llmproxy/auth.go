@@ -0,0 +1,140 @@
+package llmproxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+// Headers of an HMAC-signed request, as checked by llm-proxy.
+const (
+	HeaderCaller    = "X-Proxy-Caller"
+	HeaderTimestamp = "X-Proxy-Timestamp"
+	HeaderNonce     = "X-Proxy-Nonce"
+	HeaderSignature = "X-Proxy-Signature"
+)
+
+// tokenExpiryMargin renews client-credentials tokens this long before they
+// expire, so a token never expires in flight.
+const tokenExpiryMargin = 30 * time.Second
+
+// Credentials authenticate requests to llm-proxy. Authorize on a nil
+// *Credentials leaves requests unchanged.
+type Credentials struct {
+	cfg    config.LLMProxyAuthConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewCredentials returns the credentials for cfg.Mode.
+func NewCredentials(cfg config.LLMProxyAuthConfig) (*Credentials, error) {
+	switch cfg.Mode {
+	case "", "token":
+		if cfg.Secret == "" {
+			return nil, errors.New("LLM_PROXY_AUTH=token needs LLM_PROXY_TOKEN")
+		}
+	case "hmac":
+		if cfg.Secret == "" || cfg.Caller == "" {
+			return nil, errors.New("LLM_PROXY_AUTH=hmac needs LLM_PROXY_TOKEN and LLM_PROXY_CALLER")
+		}
+	case "jwt":
+		if cfg.ClientID == "" || cfg.ClientSecret == "" || cfg.TokenURL == "" {
+			return nil, errors.New("LLM_PROXY_AUTH=jwt needs LLM_PROXY_CLIENT_ID, LLM_PROXY_CLIENT_SECRET and a Keycloak realm or LLM_PROXY_TOKEN_URL")
+		}
+	default:
+		return nil, fmt.Errorf("LLM_PROXY_AUTH: unknown mode %q", cfg.Mode)
+	}
+	return &Credentials{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}, now: time.Now}, nil
+}
+
+// Authorize adds the credentials to req, whose body is body.
+func (c *Credentials) Authorize(req *http.Request, body []byte) error {
+	if c == nil {
+		return nil
+	}
+	switch c.cfg.Mode {
+	case "hmac":
+		timestamp := strconv.FormatInt(c.now().Unix(), 10)
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		bodyHash := sha256.Sum256(body)
+		mac := hmac.New(sha256.New, []byte(c.cfg.Secret))
+		fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", req.Method, req.URL.RequestURI(), timestamp, hex.EncodeToString(nonce), hex.EncodeToString(bodyHash[:]))
+		req.Header.Set(HeaderCaller, c.cfg.Caller)
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderNonce, hex.EncodeToString(nonce))
+		req.Header.Set(HeaderSignature, hex.EncodeToString(mac.Sum(nil)))
+	case "jwt":
+		token, err := c.accessToken(req.Context())
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	default:
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Secret)
+	}
+	return nil
+}
+
+// accessToken returns a cached client-credentials token, fetching a new one
+// when it is about to expire.
+func (c *Credentials) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && c.now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.cfg.ClientID},
+		"client_secret": {c.cfg.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetch llm proxy token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("fetch llm proxy token: %s %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("decode llm proxy token: %w", err)
+	}
+	if out.AccessToken == "" {
+		return "", errors.New("token endpoint returned no access token")
+	}
+	c.token = out.AccessToken
+	c.expires = c.now().Add(time.Duration(out.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return c.token, nil
+}
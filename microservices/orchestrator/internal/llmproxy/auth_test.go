@@ -0,0 +1,111 @@
+package llmproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+func TestCredentialsToken(t *testing.T) {
+	if _, err := NewCredentials(config.LLMProxyAuthConfig{Mode: "token"}); err == nil {
+		t.Error("token mode without a secret should be rejected")
+	}
+
+	creds, err := NewCredentials(config.LLMProxyAuthConfig{Mode: "token", Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/v1/chat/stream", nil)
+	if err := creds.Authorize(req, nil); err != nil || req.Header.Get("Authorization") != "Bearer s3cret" {
+		t.Errorf("Authorization = %q, %v", req.Header.Get("Authorization"), err)
+	}
+
+	if _, err := NewCredentials(config.LLMProxyAuthConfig{Mode: "jwt"}); err == nil {
+		t.Error("jwt mode without a client should be rejected")
+	}
+}
+
+func TestCredentialsHMAC(t *testing.T) {
+	creds, err := NewCredentials(config.LLMProxyAuthConfig{Mode: "hmac", Secret: "s3cret", Caller: "orchestrator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds.now = func() time.Time { return time.Unix(1760000000, 0) }
+
+	body := []byte(`{"messages":[]}`)
+	req := httptest.NewRequest("POST", "http://llm-proxy:9000/v1/chat/stream?trace=1", nil)
+	if err := creds.Authorize(req, body); err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get(HeaderCaller) != "orchestrator" || req.Header.Get(HeaderTimestamp) != "1760000000" {
+		t.Errorf("unexpected headers %v", req.Header)
+	}
+
+	// The signature llm-proxy expects.
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	fmt.Fprintf(mac, "POST\n/v1/chat/stream?trace=1\n1760000000\n%s\n%s", req.Header.Get(HeaderNonce), hex.EncodeToString(bodyHash[:]))
+	if got, want := req.Header.Get(HeaderSignature), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("signature %s, want %s", got, want)
+	}
+
+	nonce := req.Header.Get(HeaderNonce)
+	if err := creds.Authorize(req, body); err != nil || req.Header.Get(HeaderNonce) == nonce {
+		t.Errorf("nonce reused: %v", err)
+	}
+}
+
+func TestCredentialsJWT(t *testing.T) {
+	fetches := 0
+	keycloak := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "orchestrator" || r.Form.Get("client_secret") != "secret" {
+			http.Error(w, "bad request", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":300}`, fetches)
+	}))
+	defer keycloak.Close()
+
+	creds, err := NewCredentials(config.LLMProxyAuthConfig{Mode: "jwt", ClientID: "orchestrator", ClientSecret: "secret", TokenURL: keycloak.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1760000000, 0)
+	creds.now = func() time.Time { return now }
+
+	authorize := func() string {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/v1/embeddings", nil)
+		if err := creds.Authorize(req, nil); err != nil {
+			t.Fatal(err)
+		}
+		return req.Header.Get("Authorization")
+	}
+	if got := authorize(); got != "Bearer token-1" {
+		t.Errorf("Authorization = %q", got)
+	}
+	now = now.Add(4 * time.Minute)
+	if got := authorize(); got != "Bearer token-1" {
+		t.Errorf("cached token not reused: %q", got)
+	}
+	now = now.Add(45 * time.Second)
+	if got := authorize(); got != "Bearer token-2" {
+		t.Errorf("token not renewed before expiry: %q", got)
+	}
+
+	creds.cfg.ClientSecret = "wrong"
+	creds.token = ""
+	req := httptest.NewRequest("POST", "/v1/embeddings", nil)
+	if err := creds.Authorize(req, nil); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected the token endpoint's rejection, got %v", err)
+	}
+}
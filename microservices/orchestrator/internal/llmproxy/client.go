@@ -0,0 +1,358 @@
+package llmproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+var (
+	// ErrFirstByteTimeout means llm-proxy sent nothing within the configured
+	// time to first byte.
+	ErrFirstByteTimeout = errors.New("llm proxy sent no response in time")
+	// ErrIdleTimeout means a stream went quiet for longer than the configured
+	// idle time between chunks.
+	ErrIdleTimeout = errors.New("llm proxy stream went idle")
+)
+
+// CircuitOpenError is returned without contacting llm-proxy while the
+// circuit breaker is open.
+type CircuitOpenError struct {
+	// RetryAt is when the breaker lets a request through again.
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "llm proxy is unavailable, please try again shortly"
+}
+
+// StatusError is a non-200 answer from llm-proxy.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("llm proxy error: %s %s", e.Status, e.Body)
+}
+
+// Client calls llm-proxy's chat and embedding endpoints. Requests that fail
+// before the response has produced any bytes are retried with jittered
+// backoff; once a stream has started, failures are the caller's to handle.
+// A circuit breaker fails requests fast while llm-proxy keeps failing.
+type Client struct {
+	baseURL     string
+	credentials *Credentials
+	cfg         config.LLMProxyClientConfig
+	http        *http.Client
+	breaker     *Breaker
+}
+
+// NewClient returns a client for the llm-proxy at baseURL. Zero timeouts
+// disable the corresponding check.
+func NewClient(baseURL string, credentials *Credentials, cfg config.LLMProxyClientConfig) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = cfg.ConnectTimeout
+	return &Client{
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		credentials: credentials,
+		cfg:         cfg,
+		http:        &http.Client{Transport: transport},
+		breaker:     NewBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+	}
+}
+
+// Breaker returns the client's circuit breaker.
+func (c *Client) Breaker() *Breaker {
+	return c.breaker
+}
+
+// Stream posts body to path and returns the response once its first byte
+// has arrived. header is added to the request. The response body enforces
+// the idle timeout between chunks and must be closed.
+func (c *Client) Stream(ctx context.Context, path string, body []byte, header http.Header) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.breaker.Allow(); err != nil {
+			return nil, err
+		}
+		resp, retry, err := c.attempt(ctx, path, body, header)
+		switch {
+		case err == nil:
+			c.breaker.Success()
+			return resp, nil
+		case ctx.Err() != nil:
+			// The caller gave up; that says nothing about llm-proxy.
+			c.breaker.Abandon()
+			return nil, err
+		case !retry:
+			c.breaker.Success()
+			return nil, err
+		}
+		c.breaker.Failure()
+		if attempt >= c.cfg.Retries {
+			return nil, err
+		}
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// Post posts a JSON body to path and returns the JSON response, with the
+// same retries and circuit breaker as Stream.
+func (c *Client) Post(ctx context.Context, path string, body []byte) ([]byte, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "application/json")
+	resp, err := c.Stream(ctx, path, body, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// backoff is the wait before retry attempt+1: exponential, with half of it
+// jittered so callers failing together do not retry together.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.cfg.RetryBackoff << attempt
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// attempt sends one request. retry reports whether a failure may be a
+// passing llm-proxy problem: it could not be reached, did not answer in time
+// or said it is unavailable.
+func (c *Client) attempt(ctx context.Context, path string, body []byte, header http.Header) (resp *http.Response, retry bool, err error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	firstByte := afterFunc(c.cfg.FirstByteTimeout, func() { cancel(ErrFirstByteTimeout) })
+	fail := func(err error, retry bool) (*http.Response, bool, error) {
+		firstByte.Stop()
+		if cause := context.Cause(ctx); errors.Is(cause, ErrFirstByteTimeout) {
+			err = cause
+		}
+		cancel(nil)
+		return nil, retry, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fail(fmt.Errorf("failed to build upstream request: %w", err), false)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	// Credentials are applied per attempt: signed requests carry a
+	// single-use nonce.
+	if err := c.credentials.Authorize(req, body); err != nil {
+		return fail(fmt.Errorf("authorize upstream request: %w", err), false)
+	}
+
+	resp, err = c.http.Do(req)
+	if err != nil {
+		return fail(fmt.Errorf("upstream unavailable: %w", err), true)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			retry = true
+		}
+		return fail(&StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(msg))}, retry)
+	}
+	buffered := bufio.NewReader(resp.Body)
+	if _, err := buffered.Peek(1); err != nil && !errors.Is(err, io.EOF) {
+		resp.Body.Close()
+		return fail(fmt.Errorf("upstream unavailable: %w", err), true)
+	}
+	firstByte.Stop()
+
+	stream := &idleBody{r: buffered, body: resp.Body, ctx: ctx, cancel: cancel}
+	stream.idle = afterFunc(c.cfg.IdleTimeout, func() { cancel(ErrIdleTimeout) })
+	resp.Body = stream
+	return resp, false, nil
+}
+
+// idleBody cancels the stream when no bytes arrive for the idle timeout.
+type idleBody struct {
+	r      io.Reader
+	body   io.Closer
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	idle   *stoppableTimer
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if n > 0 {
+		b.idle.Reset()
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		if cause := context.Cause(b.ctx); errors.Is(cause, ErrIdleTimeout) {
+			err = cause
+		}
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.idle.Stop()
+	b.cancel(nil)
+	return b.body.Close()
+}
+
+// stoppableTimer is a time.AfterFunc that does nothing for a zero duration.
+type stoppableTimer struct {
+	d     time.Duration
+	timer *time.Timer
+}
+
+func afterFunc(d time.Duration, f func()) *stoppableTimer {
+	t := &stoppableTimer{d: d}
+	if d > 0 {
+		t.timer = time.AfterFunc(d, f)
+	}
+	return t
+}
+
+func (t *stoppableTimer) Reset() {
+	if t.timer != nil {
+		t.timer.Reset(t.d)
+	}
+}
+
+func (t *stoppableTimer) Stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// Circuit breaker states.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// Breaker opens after threshold consecutive failures and rejects requests
+// for the cooldown. After that it is half-open: a single trial request goes
+// through, and its outcome closes the breaker again or reopens it; the
+// others are rejected until then. A zero threshold disables it.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	// probing is set while the half-open trial request is in flight.
+	probing bool
+}
+
+// NewBreaker returns a closed breaker.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// BreakerState is a snapshot of a breaker for health reporting.
+type BreakerState struct {
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	RetryAt  *time.Time `json:"retryAt,omitempty"`
+}
+
+// Allow returns a *CircuitOpenError while the breaker is open, or while it
+// is half-open and the trial request is still in flight. Every allowed
+// request must be followed by Success, Failure or Abandon.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state() {
+	case CircuitOpen:
+		return &CircuitOpenError{RetryAt: b.openedAt.Add(b.cooldown)}
+	case CircuitHalfOpen:
+		if b.probing {
+			return &CircuitOpenError{RetryAt: b.now()}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Success records a request llm-proxy answered.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.probing = false
+}
+
+// Abandon records a request that ended without telling anything about
+// llm-proxy, such as one its caller cancelled, so that a half-open breaker
+// lets another trial through.
+func (b *Breaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Failure records a request llm-proxy failed, opening the breaker at the
+// threshold or when a half-open probe fails.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 {
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold || b.probing {
+		b.openedAt = b.now()
+	}
+	b.probing = false
+}
+
+// State returns a snapshot of the breaker.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := BreakerState{State: b.state(), Failures: b.failures}
+	if state.State == CircuitOpen {
+		retryAt := b.openedAt.Add(b.cooldown)
+		state.RetryAt = &retryAt
+	}
+	return state
+}
+
+func (b *Breaker) state() string {
+	switch {
+	case b.openedAt.IsZero():
+		return CircuitClosed
+	case b.now().Before(b.openedAt.Add(b.cooldown)):
+		return CircuitOpen
+	default:
+		return CircuitHalfOpen
+	}
+}
@@ -0,0 +1,186 @@
+package llmproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+func TestClientRetriesBeforeFirstByte(t *testing.T) {
+	var calls atomic.Int32
+	nonces := make(chan string, 3)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces <- r.Header.Get(HeaderNonce)
+		switch calls.Add(1) {
+		case 1:
+			http.Error(w, "provider down", http.StatusServiceUnavailable)
+		case 2:
+			// Headers arrive, but the body does not within the time to first byte.
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+		default:
+			_, _ = w.Write([]byte("data: hello\n\n"))
+		}
+	}))
+	defer proxy.Close()
+
+	creds, err := NewCredentials(config.LLMProxyAuthConfig{Mode: "hmac", Secret: "s3cret", Caller: "orchestrator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(proxy.URL, creds, config.LLMProxyClientConfig{
+		FirstByteTimeout: 50 * time.Millisecond,
+		Retries:          2,
+		RetryBackoff:     time.Millisecond,
+		BreakerThreshold: 5,
+	})
+	resp, err := client.Stream(context.Background(), "/v1/chat/stream", []byte("{}"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "data: hello\n\n" || calls.Load() != 3 {
+		t.Fatalf("got %q after %d calls", body, calls.Load())
+	}
+	if first, second := <-nonces, <-nonces; first == second {
+		t.Error("retries must be signed with a fresh nonce")
+	}
+	if state := client.Breaker().State(); state.State != CircuitClosed || state.Failures != 0 {
+		t.Errorf("a success should reset the breaker: %+v", state)
+	}
+}
+
+func TestClientDoesNotRetryRejectedRequests(t *testing.T) {
+	var calls atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "unknown model", http.StatusNotFound)
+	}))
+	defer proxy.Close()
+
+	client := NewClient(proxy.URL, nil, config.LLMProxyClientConfig{Retries: 3, BreakerThreshold: 1})
+	_, err := client.Stream(context.Background(), "/v1/chat/stream", []byte("{}"), nil)
+	var status *StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusNotFound || status.Body != "unknown model" {
+		t.Fatalf("expected the 404, got %v", err)
+	}
+	if calls.Load() != 1 || client.Breaker().State().State != CircuitClosed {
+		t.Errorf("%d calls, breaker %+v", calls.Load(), client.Breaker().State())
+	}
+}
+
+func TestEmbedderGoesThroughTheClient(t *testing.T) {
+	var calls atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer proxy.Close()
+	creds, err := NewCredentials(config.LLMProxyAuthConfig{Mode: "token", Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(proxy.URL, creds, config.LLMProxyClientConfig{Retries: 1, BreakerThreshold: 2, BreakerCooldown: time.Minute})
+	embedder := NewEmbedder(client, "")
+
+	// The 503 is retried.
+	vectors, err := embedder.Embed(context.Background(), []string{"socks", "boots"})
+	if err != nil || len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][1] != 1 || calls.Load() != 2 {
+		t.Fatalf("got %v, %v after %d calls", vectors, err, calls.Load())
+	}
+
+	// An open breaker fails embeddings fast too.
+	client.Breaker().Failure()
+	client.Breaker().Failure()
+	var open *CircuitOpenError
+	if _, err := embedder.Embed(context.Background(), []string{"socks"}); !errors.As(err, &open) || calls.Load() != 2 {
+		t.Fatalf("expected a circuit-open error without a call, got %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestClientIdleTimeout(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("data: hello\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer proxy.Close()
+
+	client := NewClient(proxy.URL, nil, config.LLMProxyClientConfig{IdleTimeout: 50 * time.Millisecond})
+	resp, err := client.Stream(context.Background(), "/v1/chat/stream", []byte("{}"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, ErrIdleTimeout) || string(body) != "data: hello\n\n" {
+		t.Fatalf("got %q, %v", body, err)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1760000000, 0)
+	b := NewBreaker(2, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("one failure should not open the breaker: %v", err)
+	}
+	b.Failure()
+	var open *CircuitOpenError
+	if err := b.Allow(); !errors.As(err, &open) || !open.RetryAt.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("expected an open breaker, got %v", err)
+	}
+	if state := b.State(); state.State != CircuitOpen || state.RetryAt == nil {
+		t.Errorf("state %+v", state)
+	}
+
+	// After the cooldown a single probe goes through: a failure reopens the
+	// breaker, a success closes it, and an abandoned one frees the slot.
+	now = now.Add(31 * time.Second)
+	if err := b.Allow(); err != nil || b.State().State != CircuitHalfOpen {
+		t.Fatalf("expected a half-open breaker: %v", err)
+	}
+	if err := b.Allow(); !errors.As(err, &open) {
+		t.Fatalf("a second request should wait for the probe, got %v", err)
+	}
+	b.Failure()
+	if err := b.Allow(); err == nil {
+		t.Fatal("a failed probe should reopen the breaker")
+	}
+	now = now.Add(31 * time.Second)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected a new probe: %v", err)
+	}
+	b.Abandon()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("an abandoned probe should free the slot: %v", err)
+	}
+	b.Success()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("a successful probe should close the breaker: %v", err)
+	}
+	if state := b.State(); state.State != CircuitClosed || state.Failures != 0 {
+		t.Errorf("state %+v", state)
+	}
+}
@@ -0,0 +1,62 @@
+package llmproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Embedder embeds text through llm-proxy's /v1/embeddings endpoint, with
+// the client's timeouts, retries and circuit breaker.
+type Embedder struct {
+	client *Client
+	model  string
+}
+
+// NewEmbedder returns an embedder calling llm-proxy through client. An
+// empty model uses the proxy's default embedding model.
+func NewEmbedder(client *Client, model string) *Embedder {
+	return &Embedder{client: client, model: model}
+}
+
+type embeddingRequest struct {
+	Input []string `json:"input"`
+	Model string   `json:"model,omitempty"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed returns one vector per text, in order.
+func (e *Embedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(embeddingRequest{Input: texts, Model: e.model})
+	if err != nil {
+		return nil, err
+	}
+	data, err := e.client.Post(ctx, "/v1/embeddings", body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out embeddingResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("decode embeddings: %w", err)
+	}
+	vectors := make([][]float32, len(texts))
+	for _, data := range out.Data {
+		if data.Index < 0 || data.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		vectors[data.Index] = data.Embedding
+	}
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, fmt.Errorf("missing embedding for input %d", i)
+		}
+	}
+	return vectors, nil
+}
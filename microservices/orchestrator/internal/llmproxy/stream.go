@@ -0,0 +1,160 @@
+// Package llmproxy holds the orchestrator's side of the llm-proxy contract.
+package llmproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ProtocolHeader negotiates the stream protocol: the orchestrator sends
+	// the highest version it understands and llm-proxy echoes the version it
+	// answers with. A missing header means legacy plain-text frames.
+	ProtocolHeader  = "X-Stream-Protocol"
+	ProtocolVersion = 1
+
+	legacyDone = "[DONE]"
+)
+
+type EventType string
+
+const (
+	// EventRoute names the provider and model that answered, which may
+	// differ from the requested model after alias routing or fallback.
+	EventRoute EventType = "route"
+	EventDelta EventType = "delta"
+	// EventToolCall carries a fragment of a tool call; fragments with the
+	// same index concatenate and the first names the call.
+	EventToolCall EventType = "tool_call"
+	EventUsage    EventType = "usage"
+	EventFinish   EventType = "finish"
+	EventError    EventType = "error"
+)
+
+type Event struct {
+	V            int       `json:"v"`
+	Type         EventType `json:"type"`
+	Text         string    `json:"text,omitempty"`
+	FinishReason string    `json:"finish_reason,omitempty"`
+	Usage        *Usage    `json:"usage,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Provider     string    `json:"provider,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	ToolCall     *ToolCall `json:"tool_call,omitempty"`
+}
+
+type ToolCall struct {
+	Index     int    `json:"index"`
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ResponseVersion returns the protocol version announced in an llm-proxy
+// response header value.
+func ResponseVersion(header string) int {
+	v, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
+
+// StreamReader decodes llm-proxy SSE frames into events. Legacy plain-text
+// frames are translated so callers only deal with Event values: each frame
+// becomes a delta and "[DONE]" becomes a finish event.
+type StreamReader struct {
+	r       *bufio.Reader
+	version int
+	done    bool
+}
+
+func NewStreamReader(r io.Reader, version int) *StreamReader {
+	return &StreamReader{r: bufio.NewReader(r), version: version}
+}
+
+// Next returns the next event. It returns io.EOF after a finish or error
+// event, and io.ErrUnexpectedEOF if the stream ends without either.
+func (sr *StreamReader) Next() (Event, error) {
+	for {
+		if sr.done {
+			return Event{}, io.EOF
+		}
+
+		data, err := sr.readFrame()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				sr.done = true
+				if sr.version == 0 {
+					// Legacy streams may end without [DONE].
+					return Event{Type: EventFinish, FinishReason: "stop"}, nil
+				}
+				return Event{}, io.ErrUnexpectedEOF
+			}
+			return Event{}, err
+		}
+
+		if sr.version == 0 {
+			if strings.TrimSpace(data) == legacyDone {
+				sr.done = true
+				return Event{Type: EventFinish, FinishReason: "stop"}, nil
+			}
+			if data == "" {
+				continue
+			}
+			return Event{Type: EventDelta, Text: data}, nil
+		}
+
+		var evt Event
+		if err := json.Unmarshal([]byte(data), &evt); err != nil {
+			return Event{}, fmt.Errorf("decode stream event: %w", err)
+		}
+		switch evt.Type {
+		case EventFinish, EventError:
+			sr.done = true
+		case EventRoute, EventDelta, EventToolCall, EventUsage:
+		default:
+			// Unknown event types from newer proxies are skipped.
+			continue
+		}
+		return evt, nil
+	}
+}
+
+// readFrame reads one SSE event and returns its data field. Multiple data
+// lines are joined with "\n"; comments and other fields are ignored.
+func (sr *StreamReader) readFrame() (string, error) {
+	var lines []string
+	hasData := false
+	for {
+		line, err := sr.r.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "" && err == nil:
+			if hasData {
+				return strings.Join(lines, "\n"), nil
+			}
+		case strings.HasPrefix(line, "data:"):
+			// Per the SSE spec only a single leading space is stripped.
+			lines = append(lines, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			hasData = true
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && hasData {
+				return strings.Join(lines, "\n"), nil
+			}
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package llmproxy
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, body string, version int) (string, []Event, error) {
+	t.Helper()
+	reader := NewStreamReader(strings.NewReader(body), version)
+	var text strings.Builder
+	var events []Event
+	for {
+		evt, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return text.String(), events, nil
+		}
+		if err != nil {
+			return text.String(), events, err
+		}
+		events = append(events, evt)
+		if evt.Type == EventDelta {
+			text.WriteString(evt.Text)
+		}
+	}
+}
+
+func TestStreamReaderStructured(t *testing.T) {
+	body := ": keep-alive\n\n" +
+		`data: {"v":1,"type":"route","provider":"ollama","model":"llama3.1"}` + "\n\n" +
+		`data: {"v":1,"type":"delta","text":"Deals:\n"}` + "\n\n" +
+		`data: {"v":1,"type":"delta","text":"  - socks"}` + "\r\n\r\n" +
+		`data: {"v":1,"type":"usage","usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}` + "\n\n" +
+		`data: {"v":1,"type":"finish","finish_reason":"length"}` + "\n\n" +
+		`data: {"v":1,"type":"delta","text":"ignored"}` + "\n\n"
+
+	text, events, err := readAll(t, body, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if text != "Deals:\n  - socks" {
+		t.Errorf("text not preserved: %q", text)
+	}
+	if len(events) != 5 || events[0].Model != "llama3.1" || events[3].Usage == nil || events[3].Usage.TotalTokens != 5 || events[4].FinishReason != "length" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestStreamReaderStructuredTruncated(t *testing.T) {
+	_, _, err := readAll(t, `data: {"v":1,"type":"delta","text":"Hi"}`+"\n\n", 1)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestStreamReaderLegacy(t *testing.T) {
+	text, events, err := readAll(t, "data: Hello\n\ndata:  World\n\ndata: [DONE]\n\n", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if text != "Hello World" {
+		t.Errorf("legacy text: %q", text)
+	}
+	if last := events[len(events)-1]; last.Type != EventFinish {
+		t.Errorf("expected finish event, got %+v", last)
+	}
+}
+
+func TestStreamReaderToolCalls(t *testing.T) {
+	body := `data: {"v":1,"type":"tool_call","tool_call":{"index":0,"id":"call_0","name":"search_products"}}` + "\n\n" +
+		`data: {"v":1,"type":"tool_call","tool_call":{"index":0,"arguments":"{}"}}` + "\n\n" +
+		`data: {"v":1,"type":"finish","finish_reason":"tool_calls"}` + "\n\n"
+
+	_, events, err := readAll(t, body, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 3 || events[0].ToolCall == nil || events[0].ToolCall.Name != "search_products" || events[1].ToolCall.Arguments != "{}" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
@@ -0,0 +1,207 @@
+package prices
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of a webhook body, keyed by
+// the webhook secret.
+const SignatureHeader = "X-ShopMind-Signature"
+
+// Notifier delivers price alerts.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// InApp adds alerts to the user's notification feed.
+type InApp struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewInApp(store Store) *InApp {
+	return &InApp{store: store, now: time.Now}
+}
+
+func (n *InApp) Notify(ctx context.Context, alert Alert) error {
+	return n.store.AddNotification(ctx, &Notification{
+		UserID:    alert.UserID,
+		Alert:     alert,
+		CreatedAt: n.now().UTC(),
+	})
+}
+
+// Webhook posts alerts as JSON to a fixed URL configured by the operator;
+// users cannot choose where their alerts are sent.
+type Webhook struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhook(url, secret string) *Webhook {
+	return &Webhook{url: url, secret: secret, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type webhookPayload struct {
+	Type  string `json:"type"`
+	Alert Alert  `json:"alert"`
+}
+
+func (n *Webhook) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(webhookPayload{Type: "price_drop", Alert: alert})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Email sends alerts through an SMTP relay to the address the user had
+// when subscribing.
+type Email struct {
+	addr string
+	from string
+	auth smtp.Auth
+	send func(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewEmail(cfg config.SMTPConfig) *Email {
+	n := &Email{addr: cfg.Addr, from: cfg.From, send: sendMail}
+	if cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		n.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return n
+}
+
+func (n *Email) Notify(ctx context.Context, alert Alert) error {
+	if alert.Email == "" {
+		return fmt.Errorf("no email address for user %q", alert.UserID)
+	}
+	return n.send(ctx, n.addr, n.auth, n.from, []string{alert.Email}, n.message(alert))
+}
+
+// sendMail is smtp.SendMail, abandoned when ctx is done: the connection is
+// closed, failing whatever exchange with the relay is under way.
+func sendMail(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer context.AfterFunc(ctx, func() { _ = conn.Close() })()
+	host, _, _ := net.SplitHostPort(addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (n *Email) message(alert Alert) []byte {
+	price := formatPrice(alert.Price, alert.Currency)
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s is now %s at %s", alert.Title, price, alert.Merchant)
+	if alert.PreviousPrice != nil {
+		fmt.Fprintf(&body, " (was %s)", formatPrice(*alert.PreviousPrice, alert.Currency))
+	}
+	fmt.Fprintf(&body, ", at or below your target of %s.\r\n", formatPrice(alert.TargetPrice, alert.Currency))
+	if alert.URL != "" {
+		fmt.Fprintf(&body, "\r\n%s\r\n", alert.URL)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", alert.Email)
+	fmt.Fprintf(&msg, "Subject: Price drop: %s\r\n", headerValue(alert.Title))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(body.String())
+	return []byte(msg.String())
+}
+
+func formatPrice(price float64, currency string) string {
+	s := strconv.FormatFloat(price, 'f', 2, 64)
+	if currency != "" {
+		s += " " + currency
+	}
+	return s
+}
+
+// headerValue keeps feed text from injecting mail headers.
+func headerValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,498 @@
+// Package prices records the price of every catalog offer over time and
+// alerts users when a product they watch drops to their target price.
+//
+// Offers of the same item by different merchants are grouped by GTIN, so
+// history, cheapest-offer queries and subscriptions cover every merchant
+// selling the item. Products without a GTIN are an item of their own.
+package prices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/orchestrator/internal/catalog"
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+var (
+	ErrNotFound = errors.New("prices: not found")
+	// ErrInvalid wraps the reasons a subscription is rejected.
+	ErrInvalid = errors.New("prices: invalid subscription")
+)
+
+// Observation is the price of an offer from the ingestion it was first seen
+// in. A new observation is recorded only when the price changes.
+type Observation struct {
+	Item       string    `json:"item"`
+	Feed       string    `json:"feed"`
+	ProductID  string    `json:"productId"`
+	Merchant   string    `json:"merchant"`
+	Price      float64   `json:"price"`
+	Currency   string    `json:"currency,omitempty"`
+	ObservedAt time.Time `json:"observedAt"`
+}
+
+// Channel is how a subscription's alerts are delivered.
+type Channel string
+
+const (
+	ChannelInApp   Channel = "in_app"
+	ChannelEmail   Channel = "email"
+	ChannelWebhook Channel = "webhook"
+)
+
+// Subscription watches an item for a price at or below TargetPrice. There
+// is at most one subscription per user and item.
+type Subscription struct {
+	ID          int64     `json:"id"`
+	UserID      string    `json:"user,omitempty"`
+	Email       string    `json:"email,omitempty"`
+	Item        string    `json:"item"`
+	Feed        string    `json:"feed"`
+	ProductID   string    `json:"productId"`
+	Title       string    `json:"title"`
+	TargetPrice float64   `json:"targetPrice"`
+	Currency    string    `json:"currency,omitempty"`
+	Channel     Channel   `json:"channel"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Alert is produced when an offer of a watched item drops from above the
+// target price to at or below it.
+type Alert struct {
+	SubscriptionID int64     `json:"subscriptionId"`
+	UserID         string    `json:"user,omitempty"`
+	Email          string    `json:"-"`
+	Feed           string    `json:"feed"`
+	ProductID      string    `json:"productId"`
+	Title          string    `json:"title"`
+	Merchant       string    `json:"merchant"`
+	URL            string    `json:"url,omitempty"`
+	Price          float64   `json:"price"`
+	PreviousPrice  *float64  `json:"previousPrice,omitempty"`
+	TargetPrice    float64   `json:"targetPrice"`
+	Currency       string    `json:"currency,omitempty"`
+	ObservedAt     time.Time `json:"observedAt"`
+}
+
+// ItemOf returns the item key grouping the offers of p.
+func ItemOf(p catalog.Product) string {
+	if p.GTIN != "" {
+		return "gtin:" + p.GTIN
+	}
+	return "offer:" + p.Feed + "/" + p.ID
+}
+
+func offerKey(feed, id string) string {
+	return feed + "\x00" + id
+}
+
+// alertTimeout bounds the delivery of one alert.
+const alertTimeout = 30 * time.Second
+
+// Tracker records price observations and raises alerts.
+type Tracker struct {
+	store     Store
+	catalog   *catalog.Catalog
+	notifiers map[Channel]Notifier
+	now       func() time.Time
+
+	// mu serializes observations; latest is the last observation per offer.
+	mu     sync.Mutex
+	latest map[string]Observation
+	// deliveries tracks the alerts being delivered in the background.
+	deliveries sync.WaitGroup
+}
+
+// Open opens the price store and starts tracking c: its current prices are
+// recorded and every later ingestion is observed.
+func Open(ctx context.Context, cfg config.PricesConfig, c *catalog.Catalog) (*Tracker, error) {
+	store, err := OpenStore(cfg.Store)
+	if err != nil {
+		return nil, fmt.Errorf("open price store: %w", err)
+	}
+	notifiers := map[Channel]Notifier{ChannelInApp: NewInApp(store)}
+	if cfg.WebhookURL != "" {
+		notifiers[ChannelWebhook] = NewWebhook(cfg.WebhookURL, cfg.WebhookSecret)
+	}
+	if cfg.SMTP.Addr != "" {
+		notifiers[ChannelEmail] = NewEmail(cfg.SMTP)
+	}
+	t, err := New(ctx, store, c, notifiers)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	t.Track(ctx)
+	return t, nil
+}
+
+// New loads the latest observations from store. It does not observe c until
+// Track is called.
+func New(ctx context.Context, store Store, c *catalog.Catalog, notifiers map[Channel]Notifier) (*Tracker, error) {
+	latest, err := store.LatestObservations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load latest prices: %w", err)
+	}
+	t := &Tracker{
+		store:     store,
+		catalog:   c,
+		notifiers: notifiers,
+		now:       time.Now,
+		latest:    make(map[string]Observation, len(latest)),
+	}
+	for _, obs := range latest {
+		t.latest[offerKey(obs.Feed, obs.ProductID)] = obs
+	}
+	return t, nil
+}
+
+// Track observes every later ingestion of the catalog, then catches up
+// with the prices ingested so far.
+func (t *Tracker) Track(ctx context.Context) {
+	t.catalog.OnChange(func(ctx context.Context, products []catalog.Product) {
+		if err := t.Observe(ctx, products); err != nil {
+			log.Error().Err(err).Msg("failed to record prices")
+		}
+	})
+	if err := t.Observe(ctx, t.catalog.Products(nil)); err != nil {
+		log.Error().Err(err).Msg("failed to record prices")
+	}
+}
+
+// Wait blocks until the alerts raised so far have been delivered.
+func (t *Tracker) Wait() {
+	t.deliveries.Wait()
+}
+
+// Close waits for pending alerts to be delivered and closes the store.
+func (t *Tracker) Close() error {
+	t.Wait()
+	return t.store.Close()
+}
+
+// Channels returns the channels alerts can be delivered through.
+func (t *Tracker) Channels() []Channel {
+	channels := make([]Channel, 0, len(t.notifiers))
+	for channel := range t.notifiers {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	return channels
+}
+
+// Observe records the offers whose price changed and alerts the
+// subscriptions whose target they crossed. Alerts are delivered in the
+// background, so that slow channels hold up neither ingestion nor other
+// observations.
+func (t *Tracker) Observe(ctx context.Context, products []catalog.Product) error {
+	alerts, err := t.observe(ctx, products)
+	if len(alerts) > 0 {
+		ctx := context.WithoutCancel(ctx)
+		t.deliveries.Add(1)
+		go func() {
+			defer t.deliveries.Done()
+			for _, a := range alerts {
+				t.notify(ctx, a.sub, a.alert)
+			}
+		}()
+	}
+	return err
+}
+
+type pendingAlert struct {
+	sub   Subscription
+	alert Alert
+}
+
+// observe records the price changes and returns the alerts they raise.
+func (t *Tracker) observe(ctx context.Context, products []catalog.Product) ([]pendingAlert, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	type change struct {
+		product  catalog.Product
+		obs      Observation
+		previous *Observation
+	}
+	var changes []change
+	var observations []Observation
+	for _, p := range products {
+		previous, seen := t.latest[offerKey(p.Feed, p.ID)]
+		if seen && previous.Price == p.Price && previous.Currency == p.Currency {
+			continue
+		}
+		observedAt := p.UpdatedAt
+		if observedAt.IsZero() {
+			observedAt = t.now().UTC()
+		}
+		c := change{product: p, obs: Observation{
+			Item:       ItemOf(p),
+			Feed:       p.Feed,
+			ProductID:  p.ID,
+			Merchant:   p.Merchant,
+			Price:      p.Price,
+			Currency:   p.Currency,
+			ObservedAt: observedAt,
+		}}
+		if seen {
+			c.previous = &previous
+		}
+		changes = append(changes, c)
+		observations = append(observations, c.obs)
+	}
+	if len(observations) == 0 {
+		return nil, nil
+	}
+	if err := t.store.AddObservations(ctx, observations); err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		t.latest[offerKey(c.obs.Feed, c.obs.ProductID)] = c.obs
+	}
+
+	var alerts []pendingAlert
+	subscriptions := make(map[string][]Subscription)
+	for _, c := range changes {
+		subs, ok := subscriptions[c.obs.Item]
+		if !ok {
+			var err error
+			if subs, err = t.store.ItemSubscriptions(ctx, c.obs.Item); err != nil {
+				return nil, fmt.Errorf("load subscriptions: %w", err)
+			}
+			subscriptions[c.obs.Item] = subs
+		}
+		for _, sub := range subs {
+			if !crossed(sub, c.product, c.previous) {
+				continue
+			}
+			alert := Alert{
+				SubscriptionID: sub.ID,
+				UserID:         sub.UserID,
+				Email:          sub.Email,
+				Feed:           c.product.Feed,
+				ProductID:      c.product.ID,
+				Title:          c.product.Title,
+				Merchant:       c.product.Merchant,
+				URL:            c.product.URL,
+				Price:          c.product.Price,
+				TargetPrice:    sub.TargetPrice,
+				Currency:       c.product.Currency,
+				ObservedAt:     c.obs.ObservedAt,
+			}
+			if c.previous != nil {
+				alert.PreviousPrice = &c.previous.Price
+			}
+			alerts = append(alerts, pendingAlert{sub: sub, alert: alert})
+		}
+	}
+	return alerts, nil
+}
+
+// crossed reports whether p, in stock and in the subscription's currency,
+// went from above the target price (or from not being offered) to at or
+// below it.
+func crossed(sub Subscription, p catalog.Product, previous *Observation) bool {
+	switch {
+	case sub.Currency != "" && p.Currency != sub.Currency:
+		return false
+	case p.Availability == catalog.OutOfStock || p.Price > sub.TargetPrice:
+		return false
+	case previous == nil || previous.Currency != p.Currency:
+		return true
+	default:
+		return previous.Price > sub.TargetPrice
+	}
+}
+
+func (t *Tracker) notify(ctx context.Context, sub Subscription, alert Alert) {
+	notifier, ok := t.notifiers[sub.Channel]
+	if !ok {
+		// The channel was disabled after the user subscribed.
+		notifier = t.notifiers[ChannelInApp]
+	}
+	ctx, cancel := context.WithTimeout(ctx, alertTimeout)
+	defer cancel()
+	logger := log.With().Int64("subscription", sub.ID).Str("channel", string(sub.Channel)).
+		Str("feed", alert.Feed).Str("product", alert.ProductID).Float64("price", alert.Price).Logger()
+	if err := notifier.Notify(ctx, alert); err != nil {
+		logger.Warn().Err(err).Msg("failed to deliver price alert")
+		return
+	}
+	logger.Info().Msg("price alert delivered")
+}
+
+// OfferHistory is the price history of one offer, oldest first.
+type OfferHistory struct {
+	Feed         string        `json:"feed"`
+	ProductID    string        `json:"productId"`
+	Merchant     string        `json:"merchant"`
+	Observations []Observation `json:"observations"`
+}
+
+type History struct {
+	Item   string         `json:"item"`
+	Offers []OfferHistory `json:"offers"`
+	// Lowest is the lowest price seen since the start of the history.
+	Lowest *Observation `json:"lowest,omitempty"`
+}
+
+// History returns the price history since the given time of every offer of
+// the item the product belongs to. The price in effect at since is
+// included as the first observation of each offer.
+func (t *Tracker) History(ctx context.Context, feed, id string, since time.Time) (History, error) {
+	item, err := t.itemOf(feed, id)
+	if err != nil {
+		return History{}, err
+	}
+	observations, err := t.store.History(ctx, item)
+	if err != nil {
+		return History{}, err
+	}
+
+	history := History{Item: item, Offers: []OfferHistory{}}
+	offers := make(map[string]int)
+	add := func(obs Observation) {
+		key := offerKey(obs.Feed, obs.ProductID)
+		n, ok := offers[key]
+		if !ok {
+			n = len(history.Offers)
+			offers[key] = n
+			history.Offers = append(history.Offers, OfferHistory{Feed: obs.Feed, ProductID: obs.ProductID})
+		}
+		history.Offers[n].Merchant = obs.Merchant
+		history.Offers[n].Observations = append(history.Offers[n].Observations, obs)
+		if history.Lowest == nil || obs.Price < history.Lowest.Price {
+			lowest := obs
+			history.Lowest = &lowest
+		}
+	}
+
+	// inEffect holds the last observation before since of each offer until
+	// the offer's first later observation.
+	inEffect := make(map[string]Observation)
+	var pending []string
+	for _, obs := range observations {
+		key := offerKey(obs.Feed, obs.ProductID)
+		if obs.ObservedAt.Before(since) {
+			if _, ok := inEffect[key]; !ok {
+				pending = append(pending, key)
+			}
+			inEffect[key] = obs
+			continue
+		}
+		if previous, ok := inEffect[key]; ok {
+			add(previous)
+			delete(inEffect, key)
+		}
+		add(obs)
+	}
+	for _, key := range pending {
+		if previous, ok := inEffect[key]; ok {
+			add(previous)
+		}
+	}
+	return history, nil
+}
+
+type Offers struct {
+	Item string `json:"item"`
+	// Offers are the catalog's current offers of the item, cheapest first
+	// with out-of-stock offers last.
+	Offers []catalog.Product `json:"offers"`
+	// Cheapest is the cheapest offer that is not out of stock.
+	Cheapest *catalog.Product `json:"cheapest,omitempty"`
+}
+
+// Offers compares the current offers of the item the product belongs to.
+func (t *Tracker) Offers(feed, id string) (Offers, error) {
+	p, ok := t.catalog.Get(feed, id)
+	if !ok {
+		return Offers{}, ErrNotFound
+	}
+	item := ItemOf(p)
+	offers := t.catalog.Products(func(other catalog.Product) bool { return ItemOf(other) == item })
+	sort.SliceStable(offers, func(i, j int) bool {
+		a, b := offers[i], offers[j]
+		if (a.Availability == catalog.OutOfStock) != (b.Availability == catalog.OutOfStock) {
+			return b.Availability == catalog.OutOfStock
+		}
+		return a.Price < b.Price
+	})
+	result := Offers{Item: item, Offers: offers}
+	if offers[0].Availability != catalog.OutOfStock {
+		result.Cheapest = &offers[0]
+	}
+	return result, nil
+}
+
+// itemOf finds the item of an offer in the catalog or, for offers no
+// longer listed, in the recorded prices.
+func (t *Tracker) itemOf(feed, id string) (string, error) {
+	if p, ok := t.catalog.Get(feed, id); ok {
+		return ItemOf(p), nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if obs, ok := t.latest[offerKey(feed, id)]; ok {
+		return obs.Item, nil
+	}
+	return "", ErrNotFound
+}
+
+// Subscribe saves sub for the product identified by sub.Feed and
+// sub.ProductID, replacing the user's previous subscription to the item.
+// Item, title and currency are taken from the catalog.
+func (t *Tracker) Subscribe(ctx context.Context, sub Subscription) (Subscription, error) {
+	if sub.UserID == "" {
+		return sub, fmt.Errorf("%w: subscriptions need an authenticated user", ErrInvalid)
+	}
+	if sub.TargetPrice <= 0 {
+		return sub, fmt.Errorf("%w: targetPrice must be positive", ErrInvalid)
+	}
+	if sub.Channel == "" {
+		sub.Channel = ChannelInApp
+	}
+	if _, ok := t.notifiers[sub.Channel]; !ok {
+		return sub, fmt.Errorf("%w: channel %q is not available", ErrInvalid, sub.Channel)
+	}
+	if sub.Channel == ChannelEmail && !strings.Contains(sub.Email, "@") {
+		return sub, fmt.Errorf("%w: email alerts need an email address", ErrInvalid)
+	}
+	p, ok := t.catalog.Get(sub.Feed, sub.ProductID)
+	if !ok {
+		return sub, ErrNotFound
+	}
+	sub.Item = ItemOf(p)
+	sub.Title = p.Title
+	sub.Currency = p.Currency
+	sub.CreatedAt = t.now().UTC()
+	if err := t.store.SaveSubscription(ctx, &sub); err != nil {
+		return sub, err
+	}
+	return sub, nil
+}
+
+func (t *Tracker) Subscriptions(ctx context.Context, userID string) ([]Subscription, error) {
+	return t.store.Subscriptions(ctx, userID)
+}
+
+func (t *Tracker) Unsubscribe(ctx context.Context, userID string, id int64) error {
+	return t.store.DeleteSubscription(ctx, userID, id)
+}
+
+// Notifications returns the user's in-app alerts, newest first.
+func (t *Tracker) Notifications(ctx context.Context, userID string, unreadOnly bool, limit int) ([]Notification, error) {
+	return t.store.Notifications(ctx, userID, unreadOnly, limit)
+}
+
+func (t *Tracker) MarkRead(ctx context.Context, userID string, id int64) error {
+	return t.store.MarkNotificationRead(ctx, userID, id, t.now().UTC())
+}
@@ -0,0 +1,401 @@
+package prices
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/catalog"
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+type recorder struct {
+	alerts []Alert
+}
+
+func (r *recorder) Notify(_ context.Context, alert Alert) error {
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+type fixture struct {
+	t       *testing.T
+	dir     string
+	catalog *catalog.Catalog
+	tracker *Tracker
+	webhook *recorder
+}
+
+// newFixture tracks a catalog of two merchants selling the same boots.
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := &fixture{t: t, dir: t.TempDir(), webhook: &recorder{}}
+	f.write("alpine.csv", "BOOT-A,Trail Boots,00012345600012,120.00 USD,in stock\nCAP-A,Wool Cap,,15.00 USD,in stock\n")
+	f.write("summit.csv", "BOOT-S,Trail Boots,00012345600012,135.00 USD,in stock\n")
+
+	c, err := catalog.New(context.Background(), catalog.NewMemoryStore(), f.dir)
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	f.catalog = c
+	f.ingest()
+
+	store := NewMemoryStore()
+	f.tracker, err = New(context.Background(), store, c, map[Channel]Notifier{
+		ChannelInApp:   NewInApp(store),
+		ChannelWebhook: f.webhook,
+	})
+	if err != nil {
+		t.Fatalf("new tracker: %v", err)
+	}
+	f.tracker.Track(context.Background())
+	return f
+}
+
+func (f *fixture) write(name, rows string) {
+	f.t.Helper()
+	data := "id,title,gtin,price,availability\n" + rows
+	if err := os.WriteFile(filepath.Join(f.dir, name), []byte(data), 0o600); err != nil {
+		f.t.Fatalf("write feed: %v", err)
+	}
+}
+
+func (f *fixture) ingest() {
+	f.t.Helper()
+	if _, err := f.catalog.Ingest(context.Background()); err != nil {
+		f.t.Fatalf("ingest: %v", err)
+	}
+	if f.tracker != nil {
+		f.tracker.Wait()
+	}
+}
+
+func (f *fixture) setBootPrice(feed, id, price, availability string) {
+	f.t.Helper()
+	rows := id + ",Trail Boots,00012345600012," + price + " USD," + availability + "\n"
+	if feed == "alpine" {
+		rows += "CAP-A,Wool Cap,,15.00 USD,in stock\n"
+	}
+	f.write(feed+".csv", rows)
+	f.ingest()
+}
+
+func TestTrackerAlertsWhenTargetIsCrossed(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	sub, err := f.tracker.Subscribe(ctx, Subscription{UserID: "user-1", Feed: "summit", ProductID: "BOOT-S", TargetPrice: 110, Channel: ChannelWebhook})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if sub.Item != "gtin:00012345600012" || sub.Currency != "USD" || sub.Title != "Trail Boots" {
+		t.Errorf("unexpected subscription %+v", sub)
+	}
+	if _, err := f.tracker.Subscribe(ctx, Subscription{UserID: "user-2", Feed: "alpine", ProductID: "BOOT-A", TargetPrice: 119}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	// Another merchant's offer of the same item crosses both targets.
+	f.setBootPrice("summit", "BOOT-S", "105.00", "in stock")
+	if len(f.webhook.alerts) != 1 {
+		t.Fatalf("expected one webhook alert, got %+v", f.webhook.alerts)
+	}
+	alert := f.webhook.alerts[0]
+	if alert.SubscriptionID != sub.ID || alert.Merchant != "summit" || alert.Price != 105 ||
+		alert.PreviousPrice == nil || *alert.PreviousPrice != 135 || alert.TargetPrice != 110 {
+		t.Errorf("unexpected alert %+v", alert)
+	}
+	notifications, err := f.tracker.Notifications(ctx, "user-2", false, 0)
+	if err != nil || len(notifications) != 1 || notifications[0].Alert.ProductID != "BOOT-S" {
+		t.Fatalf("expected an in-app alert for user-2, got %+v (%v)", notifications, err)
+	}
+
+	// Staying below the target, or dropping out of stock, is not a crossing.
+	f.setBootPrice("summit", "BOOT-S", "99.00", "in stock")
+	f.setBootPrice("summit", "BOOT-S", "150.00", "in stock")
+	f.setBootPrice("summit", "BOOT-S", "90.00", "out of stock")
+	if len(f.webhook.alerts) != 1 {
+		t.Errorf("unexpected alerts %+v", f.webhook.alerts[1:])
+	}
+	f.setBootPrice("summit", "BOOT-S", "150.00", "in stock")
+	f.setBootPrice("summit", "BOOT-S", "95.00", "in stock")
+	if len(f.webhook.alerts) != 2 {
+		t.Errorf("expected a second alert after the price rose and fell again, got %d", len(f.webhook.alerts))
+	}
+
+	if err := f.tracker.MarkRead(ctx, "user-2", notifications[0].ID); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	if err := f.tracker.MarkRead(ctx, "user-1", notifications[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for another user's notification, got %v", err)
+	}
+	unread, _ := f.tracker.Notifications(ctx, "user-2", true, 0)
+	if len(unread) != 1 || unread[0].Alert.Price != 95 {
+		t.Errorf("unexpected unread notifications %+v", unread)
+	}
+}
+
+// blockingNotifier holds every delivery until released.
+type blockingNotifier struct {
+	release   chan struct{}
+	delivered chan Alert
+}
+
+func (n *blockingNotifier) Notify(ctx context.Context, alert Alert) error {
+	select {
+	case <-n.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	n.delivered <- alert
+	return nil
+}
+
+func TestTrackerDeliversAlertsInTheBackground(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	slow := &blockingNotifier{release: make(chan struct{}), delivered: make(chan Alert, 1)}
+	f.tracker.notifiers[ChannelWebhook] = slow
+	if _, err := f.tracker.Subscribe(ctx, Subscription{UserID: "user-1", Feed: "summit", ProductID: "BOOT-S", TargetPrice: 110, Channel: ChannelWebhook}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	// Neither the ingestion raising the alert nor the next one wait for
+	// the slow channel.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, price := range []string{"105.00", "150.00"} {
+			f.write("summit.csv", "BOOT-S,Trail Boots,00012345600012,"+price+" USD,in stock\n")
+			if _, err := f.catalog.Ingest(ctx); err != nil {
+				t.Errorf("ingest: %v", err)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ingestion blocked by alert delivery")
+	}
+
+	close(slow.release)
+	f.tracker.Wait()
+	if alert := <-slow.delivered; alert.Price != 105 {
+		t.Errorf("unexpected alert %+v", alert)
+	}
+}
+
+func TestTrackerHistoryAndOffers(t *testing.T) {
+	f := newFixture(t)
+	f.setBootPrice("alpine", "BOOT-A", "125.00", "in stock")
+	f.setBootPrice("summit", "BOOT-S", "110.00", "out of stock")
+	f.setBootPrice("summit", "BOOT-S", "110.00", "in stock")
+	f.setBootPrice("summit", "BOOT-S", "115.00", "in stock")
+
+	history, err := f.tracker.History(context.Background(), "alpine", "BOOT-A", time.Time{})
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	prices := map[string][]float64{}
+	for _, offer := range history.Offers {
+		for _, obs := range offer.Observations {
+			prices[offer.ProductID] = append(prices[offer.ProductID], obs.Price)
+		}
+	}
+	if got := prices["BOOT-A"]; len(got) != 2 || got[0] != 120 || got[1] != 125 {
+		t.Errorf("unexpected BOOT-A history %v", got)
+	}
+	if got := prices["BOOT-S"]; len(got) != 3 || got[0] != 135 || got[1] != 110 || got[2] != 115 {
+		t.Errorf("unexpected BOOT-S history %v", got)
+	}
+	if history.Lowest == nil || history.Lowest.Price != 110 || history.Lowest.Merchant != "summit" {
+		t.Errorf("unexpected lowest price %+v", history.Lowest)
+	}
+
+	// The price in effect at the start of the window is kept.
+	recent, _ := f.tracker.History(context.Background(), "alpine", "BOOT-A", time.Now().Add(time.Hour))
+	if len(recent.Offers) != 2 || len(recent.Offers[0].Observations) != 1 || recent.Offers[0].Observations[0].Price != 125 {
+		t.Errorf("unexpected recent history %+v", recent)
+	}
+
+	offers, err := f.tracker.Offers("summit", "BOOT-S")
+	if err != nil {
+		t.Fatalf("offers: %v", err)
+	}
+	if len(offers.Offers) != 2 || offers.Cheapest == nil || offers.Cheapest.ID != "BOOT-S" || offers.Offers[1].ID != "BOOT-A" {
+		t.Errorf("unexpected offers %+v", offers)
+	}
+	if _, err := f.tracker.Offers("summit", "nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSubscribeValidates(t *testing.T) {
+	f := newFixture(t)
+	for name, sub := range map[string]Subscription{
+		"anonymous":       {Feed: "alpine", ProductID: "CAP-A", TargetPrice: 10},
+		"no target":       {UserID: "u", Feed: "alpine", ProductID: "CAP-A"},
+		"disabled":        {UserID: "u", Feed: "alpine", ProductID: "CAP-A", TargetPrice: 10, Channel: ChannelEmail},
+		"unknown channel": {UserID: "u", Feed: "alpine", ProductID: "CAP-A", TargetPrice: 10, Channel: "sms"},
+	} {
+		if _, err := f.tracker.Subscribe(context.Background(), sub); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+	if _, err := f.tracker.Subscribe(context.Background(), Subscription{UserID: "u", Feed: "alpine", ProductID: "nope", TargetPrice: 10}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLiteStore("file:" + filepath.Join(t.TempDir(), "prices.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer store.Close()
+
+	at := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	err = store.AddObservations(ctx, []Observation{
+		{Item: "gtin:1", Feed: "a", ProductID: "1", Price: 10, Currency: "USD", ObservedAt: at},
+		{Item: "gtin:1", Feed: "b", ProductID: "9", Price: 12, Currency: "USD", ObservedAt: at},
+		{Item: "gtin:1", Feed: "a", ProductID: "1", Price: 8, Currency: "USD", ObservedAt: at.Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("add observations: %v", err)
+	}
+	latest, _ := store.LatestObservations(ctx)
+	if len(latest) != 2 {
+		t.Errorf("unexpected latest observations %+v", latest)
+	}
+	for _, obs := range latest {
+		if obs.Feed == "a" && (obs.Price != 8 || !obs.ObservedAt.Equal(at.Add(time.Hour))) {
+			t.Errorf("unexpected latest observation %+v", obs)
+		}
+	}
+	if history, _ := store.History(ctx, "gtin:1"); len(history) != 3 || history[2].Price != 8 {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	sub := Subscription{UserID: "u1", Item: "gtin:1", Feed: "a", ProductID: "1", TargetPrice: 9, Channel: ChannelInApp, CreatedAt: at}
+	if err := store.SaveSubscription(ctx, &sub); err != nil {
+		t.Fatalf("save subscription: %v", err)
+	}
+	again := sub
+	again.ID, again.TargetPrice = 0, 7
+	if err := store.SaveSubscription(ctx, &again); err != nil || again.ID != sub.ID {
+		t.Fatalf("resubscribing should keep the ID: %d != %d (%v)", again.ID, sub.ID, err)
+	}
+	if subs, _ := store.ItemSubscriptions(ctx, "gtin:1"); len(subs) != 1 || subs[0].TargetPrice != 7 {
+		t.Errorf("unexpected subscriptions %+v", subs)
+	}
+	if err := store.DeleteSubscription(ctx, "u2", sub.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting another user's subscription, got %v", err)
+	}
+	if err := store.DeleteSubscription(ctx, "u1", sub.ID); err != nil {
+		t.Errorf("delete subscription: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := store.AddNotification(ctx, &Notification{UserID: "u1", Alert: Alert{Price: float64(i)}, CreatedAt: at}); err != nil {
+			t.Fatalf("add notification: %v", err)
+		}
+	}
+	list, _ := store.Notifications(ctx, "u1", false, 0)
+	if len(list) != 2 || list[0].Alert.Price != 1 {
+		t.Fatalf("unexpected notifications %+v", list)
+	}
+	if err := store.MarkNotificationRead(ctx, "u1", list[0].ID, at); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	if unread, _ := store.Notifications(ctx, "u1", true, 0); len(unread) != 1 || unread[0].ID != list[1].ID {
+		t.Errorf("unexpected unread notifications %+v", unread)
+	}
+}
+
+func TestWebhookSignsAlerts(t *testing.T) {
+	var body []byte
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer srv.Close()
+
+	if err := NewWebhook(srv.URL, "s3cret").Notify(context.Background(), Alert{ProductID: "BOOT-S", Price: 99}); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Type != "price_drop" || payload.Alert.ProductID != "BOOT-S" {
+		t.Errorf("unexpected payload %s", body)
+	}
+	if !strings.HasPrefix(signature, "sha256=") || len(signature) != len("sha256=")+64 {
+		t.Errorf("unexpected signature %q", signature)
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	var to []string
+	var msg string
+	n := NewEmail(config.SMTPConfig{Addr: "localhost:1025", From: "alerts@shopmind.local"})
+	n.send = func(_ context.Context, _ string, _ smtp.Auth, _ string, rcpt []string, data []byte) error {
+		to, msg = rcpt, string(data)
+		return nil
+	}
+	previous := 135.0
+	err := n.Notify(context.Background(), Alert{
+		Email: "ana@example.com", Title: "Trail Boots\r\nBcc: x@evil.test", Merchant: "summit",
+		Price: 105, PreviousPrice: &previous, TargetPrice: 110, Currency: "USD", URL: "https://summit.example/boot",
+	})
+	if err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if len(to) != 1 || to[0] != "ana@example.com" {
+		t.Errorf("unexpected recipients %v", to)
+	}
+	for _, want := range []string{"Subject: Price drop: Trail Boots  Bcc: x@evil.test\r\n", "105.00 USD at summit (was 135.00 USD)", "https://summit.example/boot"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing %q in %q", want, msg)
+		}
+	}
+	if err := n.Notify(context.Background(), Alert{UserID: "u"}); err == nil {
+		t.Error("expected an error without an email address")
+	}
+}
+
+func TestSendMailGivesUpOnStalledRelay(t *testing.T) {
+	// The relay accepts the connection but never greets.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if err := sendMail(ctx, ln.Addr().String(), nil, "alerts@shopmind.local", []string{"ana@example.com"}, []byte("hi")); err == nil {
+		t.Fatal("expected an error from a stalled relay")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("sendMail ignored its context for %v", elapsed)
+	}
+}
@@ -0,0 +1,248 @@
+package prices
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS price_observations (
+	id          INTEGER PRIMARY KEY,
+	item        TEXT NOT NULL,
+	feed        TEXT NOT NULL,
+	product_id  TEXT NOT NULL,
+	merchant    TEXT NOT NULL DEFAULT '',
+	price       REAL NOT NULL,
+	currency    TEXT NOT NULL DEFAULT '',
+	observed_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS price_observations_item ON price_observations (item, observed_at);
+CREATE INDEX IF NOT EXISTS price_observations_offer ON price_observations (feed, product_id, id);
+
+CREATE TABLE IF NOT EXISTS price_subscriptions (
+	id           INTEGER PRIMARY KEY,
+	user_id      TEXT NOT NULL,
+	email        TEXT NOT NULL DEFAULT '',
+	item         TEXT NOT NULL,
+	feed         TEXT NOT NULL,
+	product_id   TEXT NOT NULL,
+	title        TEXT NOT NULL DEFAULT '',
+	target_price REAL NOT NULL,
+	currency     TEXT NOT NULL DEFAULT '',
+	channel      TEXT NOT NULL,
+	created_at   INTEGER NOT NULL,
+	UNIQUE (user_id, item)
+);
+CREATE INDEX IF NOT EXISTS price_subscriptions_item ON price_subscriptions (item);
+
+CREATE TABLE IF NOT EXISTS notifications (
+	id         INTEGER PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	alert      TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	read_at    INTEGER
+);
+CREATE INDEX IF NOT EXISTS notifications_user ON notifications (user_id, id DESC);
+`
+
+const (
+	observationColumns  = `item, feed, product_id, merchant, price, currency, observed_at`
+	subscriptionColumns = `id, user_id, email, item, feed, product_id, title, target_price, currency, channel, created_at`
+)
+
+// SQLStore keeps prices in SQLite.
+type SQLStore struct {
+	db *sql.DB
+}
+
+func OpenSQLiteStore(dsn string) (*SQLStore, error) {
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqlSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate price schema: %w", err)
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) AddObservations(ctx context.Context, observations []Observation) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, obs := range observations {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO price_observations (`+observationColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			obs.Item, obs.Feed, obs.ProductID, obs.Merchant, obs.Price, obs.Currency, obs.ObservedAt.UnixNano(),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) LatestObservations(ctx context.Context) ([]Observation, error) {
+	return s.observations(ctx, `
+		SELECT `+observationColumns+` FROM price_observations
+		WHERE id IN (SELECT MAX(id) FROM price_observations GROUP BY feed, product_id)`)
+}
+
+func (s *SQLStore) History(ctx context.Context, item string) ([]Observation, error) {
+	return s.observations(ctx, `
+		SELECT `+observationColumns+` FROM price_observations
+		WHERE item = ? ORDER BY observed_at, id`, item)
+}
+
+func (s *SQLStore) observations(ctx context.Context, query string, args ...any) ([]Observation, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var observations []Observation
+	for rows.Next() {
+		var obs Observation
+		var observed int64
+		if err := rows.Scan(&obs.Item, &obs.Feed, &obs.ProductID, &obs.Merchant, &obs.Price, &obs.Currency, &observed); err != nil {
+			return nil, err
+		}
+		obs.ObservedAt = time.Unix(0, observed).UTC()
+		observations = append(observations, obs)
+	}
+	return observations, rows.Err()
+}
+
+func (s *SQLStore) SaveSubscription(ctx context.Context, sub *Subscription) error {
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO price_subscriptions (user_id, email, item, feed, product_id, title, target_price, currency, channel, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, item) DO UPDATE SET
+			email = excluded.email,
+			feed = excluded.feed,
+			product_id = excluded.product_id,
+			title = excluded.title,
+			target_price = excluded.target_price,
+			currency = excluded.currency,
+			channel = excluded.channel,
+			created_at = excluded.created_at
+		RETURNING id`,
+		sub.UserID, sub.Email, sub.Item, sub.Feed, sub.ProductID, sub.Title,
+		sub.TargetPrice, sub.Currency, string(sub.Channel), sub.CreatedAt.UnixNano(),
+	).Scan(&sub.ID)
+}
+
+func (s *SQLStore) Subscriptions(ctx context.Context, userID string) ([]Subscription, error) {
+	return s.subscriptions(ctx, `SELECT `+subscriptionColumns+` FROM price_subscriptions WHERE user_id = ? ORDER BY id`, userID)
+}
+
+func (s *SQLStore) ItemSubscriptions(ctx context.Context, item string) ([]Subscription, error) {
+	return s.subscriptions(ctx, `SELECT `+subscriptionColumns+` FROM price_subscriptions WHERE item = ? ORDER BY id`, item)
+}
+
+func (s *SQLStore) subscriptions(ctx context.Context, query string, args ...any) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []Subscription
+	for rows.Next() {
+		var sub Subscription
+		var channel string
+		var created int64
+		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.Email, &sub.Item, &sub.Feed, &sub.ProductID, &sub.Title,
+			&sub.TargetPrice, &sub.Currency, &channel, &created); err != nil {
+			return nil, err
+		}
+		sub.Channel = Channel(channel)
+		sub.CreatedAt = time.Unix(0, created).UTC()
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (s *SQLStore) DeleteSubscription(ctx context.Context, userID string, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM price_subscriptions WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+func (s *SQLStore) AddNotification(ctx context.Context, n *Notification) error {
+	alert, err := json.Marshal(n.Alert)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRowContext(ctx,
+		`INSERT INTO notifications (user_id, alert, created_at) VALUES (?, ?, ?) RETURNING id`,
+		n.UserID, string(alert), n.CreatedAt.UnixNano(),
+	).Scan(&n.ID)
+}
+
+func (s *SQLStore) Notifications(ctx context.Context, userID string, unreadOnly bool, limit int) ([]Notification, error) {
+	query := `SELECT id, user_id, alert, created_at, read_at FROM notifications WHERE user_id = ?`
+	if unreadOnly {
+		query += ` AND read_at IS NULL`
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id DESC LIMIT ?`, userID, normalizeLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		var alert string
+		var created int64
+		var read sql.NullInt64
+		if err := rows.Scan(&n.ID, &n.UserID, &alert, &created, &read); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(alert), &n.Alert); err != nil {
+			return nil, fmt.Errorf("decode alert: %w", err)
+		}
+		n.CreatedAt = time.Unix(0, created).UTC()
+		if read.Valid {
+			readAt := time.Unix(0, read.Int64).UTC()
+			n.ReadAt = &readAt
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *SQLStore) MarkNotificationRead(ctx context.Context, userID string, id int64, at time.Time) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE notifications SET read_at = COALESCE(read_at, ?) WHERE id = ? AND user_id = ?`,
+		at.UnixNano(), id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func expectRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
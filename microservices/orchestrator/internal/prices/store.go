@@ -0,0 +1,204 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+const (
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 200
+)
+
+// Notification is an alert in a user's in-app notification feed.
+type Notification struct {
+	ID        int64      `json:"id"`
+	UserID    string     `json:"user,omitempty"`
+	Alert     Alert      `json:"alert"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ReadAt    *time.Time `json:"readAt,omitempty"`
+}
+
+// Store persists price observations, subscriptions and in-app
+// notifications. Subscriptions and notifications are scoped by user ID.
+type Store interface {
+	AddObservations(ctx context.Context, observations []Observation) error
+	// LatestObservations returns the last observation of every offer.
+	LatestObservations(ctx context.Context) ([]Observation, error)
+	// History returns the observations of an item's offers, oldest first.
+	History(ctx context.Context, item string) ([]Observation, error)
+
+	// SaveSubscription creates the user's subscription to the item or
+	// replaces the existing one, keeping its ID.
+	SaveSubscription(ctx context.Context, sub *Subscription) error
+	Subscriptions(ctx context.Context, userID string) ([]Subscription, error)
+	ItemSubscriptions(ctx context.Context, item string) ([]Subscription, error)
+	DeleteSubscription(ctx context.Context, userID string, id int64) error
+
+	AddNotification(ctx context.Context, n *Notification) error
+	// Notifications returns the user's notifications, newest first.
+	Notifications(ctx context.Context, userID string, unreadOnly bool, limit int) ([]Notification, error)
+	MarkNotificationRead(ctx context.Context, userID string, id int64, at time.Time) error
+
+	Close() error
+}
+
+// OpenStore returns the store selected by cfg.Driver.
+func OpenStore(cfg config.StoreConfig) (Store, error) {
+	switch strings.ToLower(cfg.Driver) {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return OpenSQLiteStore(cfg.DSN)
+	default:
+		return nil, fmt.Errorf("unsupported price store driver %q", cfg.Driver)
+	}
+}
+
+func normalizeLimit(limit int) int {
+	if limit <= 0 || limit > maxNotificationLimit {
+		return defaultNotificationLimit
+	}
+	return limit
+}
+
+type MemoryStore struct {
+	mu            sync.Mutex
+	observations  []Observation
+	subscriptions []Subscription
+	notifications []Notification
+	nextID        int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (m *MemoryStore) AddObservations(_ context.Context, observations []Observation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observations = append(m.observations, observations...)
+	return nil
+}
+
+func (m *MemoryStore) LatestObservations(_ context.Context) ([]Observation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	latest := make(map[string]Observation)
+	for _, obs := range m.observations {
+		latest[offerKey(obs.Feed, obs.ProductID)] = obs
+	}
+	observations := make([]Observation, 0, len(latest))
+	for _, obs := range latest {
+		observations = append(observations, obs)
+	}
+	return observations, nil
+}
+
+func (m *MemoryStore) History(_ context.Context, item string) ([]Observation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var observations []Observation
+	for _, obs := range m.observations {
+		if obs.Item == item {
+			observations = append(observations, obs)
+		}
+	}
+	sort.SliceStable(observations, func(i, j int) bool {
+		return observations[i].ObservedAt.Before(observations[j].ObservedAt)
+	})
+	return observations, nil
+}
+
+func (m *MemoryStore) SaveSubscription(_ context.Context, sub *Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, existing := range m.subscriptions {
+		if existing.UserID == sub.UserID && existing.Item == sub.Item {
+			sub.ID = existing.ID
+			m.subscriptions[i] = *sub
+			return nil
+		}
+	}
+	m.nextID++
+	sub.ID = m.nextID
+	m.subscriptions = append(m.subscriptions, *sub)
+	return nil
+}
+
+func (m *MemoryStore) Subscriptions(_ context.Context, userID string) ([]Subscription, error) {
+	return m.subscriptionsWhere(func(sub Subscription) bool { return sub.UserID == userID }), nil
+}
+
+func (m *MemoryStore) ItemSubscriptions(_ context.Context, item string) ([]Subscription, error) {
+	return m.subscriptionsWhere(func(sub Subscription) bool { return sub.Item == item }), nil
+}
+
+func (m *MemoryStore) subscriptionsWhere(keep func(Subscription) bool) []Subscription {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var subs []Subscription
+	for _, sub := range m.subscriptions {
+		if keep(sub) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+func (m *MemoryStore) DeleteSubscription(_ context.Context, userID string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, sub := range m.subscriptions {
+		if sub.ID == id && sub.UserID == userID {
+			m.subscriptions = append(m.subscriptions[:i], m.subscriptions[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *MemoryStore) AddNotification(_ context.Context, n *Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	n.ID = m.nextID
+	m.notifications = append(m.notifications, *n)
+	return nil
+}
+
+func (m *MemoryStore) Notifications(_ context.Context, userID string, unreadOnly bool, limit int) ([]Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit = normalizeLimit(limit)
+	var notifications []Notification
+	for i := len(m.notifications) - 1; i >= 0 && len(notifications) < limit; i-- {
+		n := m.notifications[i]
+		if n.UserID == userID && (!unreadOnly || n.ReadAt == nil) {
+			notifications = append(notifications, n)
+		}
+	}
+	return notifications, nil
+}
+
+func (m *MemoryStore) MarkNotificationRead(_ context.Context, userID string, id int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, n := range m.notifications {
+		if n.ID == id && n.UserID == userID {
+			if n.ReadAt == nil {
+				m.notifications[i].ReadAt = &at
+			}
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *MemoryStore) Close() error { return nil }
@@ -0,0 +1,161 @@
+// Package quota enforces per-user token budgets on top of the usage ledger
+// kept in the conversation store.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/auth"
+	"github.com/shopmindai/orchestrator/internal/config"
+	"github.com/shopmindai/orchestrator/internal/store"
+)
+
+// Unlimited is the remaining budget of a user without limits.
+const Unlimited = -1
+
+// Period is the usage of one budget period.
+type Period struct {
+	Name     string    `json:"-"`
+	Limit    int       `json:"limit"` // 0 means unlimited
+	Used     int       `json:"used"`
+	ResetsAt time.Time `json:"resetsAt"`
+}
+
+// Remaining returns the tokens left in the period, or Unlimited.
+func (p Period) Remaining() int {
+	if p.Limit == 0 {
+		return Unlimited
+	}
+	return max(p.Limit-p.Used, 0)
+}
+
+func (p Period) exhausted() bool {
+	return p.Limit > 0 && p.Used >= p.Limit
+}
+
+type Status struct {
+	Daily   Period
+	Monthly Period
+}
+
+// Remaining returns the tokens left in the tightest period, or Unlimited.
+func (s Status) Remaining() int {
+	remaining := Unlimited
+	for _, p := range []Period{s.Daily, s.Monthly} {
+		if r := p.Remaining(); r != Unlimited && (remaining == Unlimited || r < remaining) {
+			remaining = r
+		}
+	}
+	return remaining
+}
+
+// Tightest returns the limited period with the fewest tokens left, or the
+// daily period if neither is limited.
+func (s Status) Tightest() Period {
+	if r := s.Monthly.Remaining(); r != Unlimited && (s.Daily.Limit == 0 || r < s.Daily.Remaining()) {
+		return s.Monthly
+	}
+	return s.Daily
+}
+
+// Exceeded returns an *ExceededError for the first exhausted period, or nil.
+func (s Status) Exceeded() error {
+	for _, p := range []Period{s.Daily, s.Monthly} {
+		if p.exhausted() {
+			return &ExceededError{Period: p}
+		}
+	}
+	return nil
+}
+
+// Afford returns an *ExceededError for the tightest period if spending
+// tokens more would go over the budget, or nil.
+func (s Status) Afford(tokens int) error {
+	if remaining := s.Remaining(); remaining != Unlimited && tokens > remaining {
+		return &ExceededError{Period: s.Tightest()}
+	}
+	return nil
+}
+
+// Charge counts tokens spent since the status was read, so that a status
+// shared by several requests keeps track of what is left.
+func (s *Status) Charge(tokens int) {
+	s.Daily.Used += tokens
+	s.Monthly.Used += tokens
+}
+
+type ExceededError struct {
+	Period Period
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s token budget of %d exhausted; resets at %s",
+		e.Period.Name, e.Period.Limit, e.Period.ResetsAt.Format(time.RFC3339))
+}
+
+type Tracker struct {
+	cfg  config.QuotaConfig
+	repo store.Repository
+	now  func() time.Time
+}
+
+func New(cfg config.QuotaConfig, repo store.Repository) *Tracker {
+	return &Tracker{cfg: cfg, repo: repo, now: time.Now}
+}
+
+// Budget returns the budget for claims: the most generous budget of the
+// user's listed roles per period, or the default if no role is listed.
+func (t *Tracker) Budget(claims *auth.Claims) config.TokenBudget {
+	var budget config.TokenBudget
+	matched := false
+	for role, b := range t.cfg.Roles {
+		if !claims.HasRole(role) {
+			continue
+		}
+		if !matched {
+			budget, matched = b, true
+			continue
+		}
+		budget.Daily = moreGenerous(budget.Daily, b.Daily)
+		budget.Monthly = moreGenerous(budget.Monthly, b.Monthly)
+	}
+	if !matched {
+		return t.cfg.Default
+	}
+	return budget
+}
+
+func moreGenerous(a, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return max(a, b)
+}
+
+// Status returns the user's usage against their budget.
+func (t *Tracker) Status(ctx context.Context, userID string, claims *auth.Claims) (Status, error) {
+	budget := t.Budget(claims)
+	now := t.now().UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	daily, err := t.repo.SumUsage(ctx, userID, dayStart)
+	if err != nil {
+		return Status{}, fmt.Errorf("sum daily usage: %w", err)
+	}
+	monthly, err := t.repo.SumUsage(ctx, userID, monthStart)
+	if err != nil {
+		return Status{}, fmt.Errorf("sum monthly usage: %w", err)
+	}
+	return Status{
+		Daily:   Period{Name: "daily", Limit: budget.Daily, Used: daily.Total(), ResetsAt: dayStart.AddDate(0, 0, 1)},
+		Monthly: Period{Name: "monthly", Limit: budget.Monthly, Used: monthly.Total(), ResetsAt: monthStart.AddDate(0, 1, 0)},
+	}, nil
+}
+
+// Record adds a generation's usage to the ledger.
+func (t *Tracker) Record(ctx context.Context, usage *store.Usage) error {
+	return t.repo.RecordUsage(ctx, usage)
+}
@@ -0,0 +1,99 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/auth"
+	"github.com/shopmindai/orchestrator/internal/config"
+	"github.com/shopmindai/orchestrator/internal/store"
+)
+
+func TestBudgetPicksMostGenerousRole(t *testing.T) {
+	tracker := New(config.QuotaConfig{
+		Default: config.TokenBudget{Daily: 1000, Monthly: 10000},
+		Roles: map[string]config.TokenBudget{
+			"premium": {Daily: 50000, Monthly: 500000},
+			"staff":   {Daily: 0, Monthly: 100000},
+		},
+	}, store.NewMemory())
+
+	if got := tracker.Budget(nil); got != (config.TokenBudget{Daily: 1000, Monthly: 10000}) {
+		t.Errorf("anonymous budget %+v", got)
+	}
+	if got := tracker.Budget(&auth.Claims{Roles: []string{"premium"}}); got.Daily != 50000 {
+		t.Errorf("premium budget %+v", got)
+	}
+	got := tracker.Budget(&auth.Claims{Roles: []string{"premium", "staff"}})
+	if got != (config.TokenBudget{Daily: 0, Monthly: 500000}) {
+		t.Errorf("combined budget %+v", got)
+	}
+}
+
+func TestStatusCountsPeriods(t *testing.T) {
+	repo := store.NewMemory()
+	tracker := New(config.QuotaConfig{Default: config.TokenBudget{Daily: 100, Monthly: 140}}, repo)
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for _, u := range []store.Usage{
+		{UserID: "u1", PromptTokens: 40, CompletionTokens: 20, CreatedAt: now.Add(-time.Hour)},
+		{UserID: "u1", PromptTokens: 50, CreatedAt: now.AddDate(0, 0, -3)},
+		{UserID: "u1", PromptTokens: 500, CreatedAt: now.AddDate(0, -1, 0)},
+	} {
+		if err := tracker.Record(ctx, &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := tracker.Status(ctx, "u1", nil)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if status.Daily.Used != 60 || status.Monthly.Used != 110 {
+		t.Fatalf("unexpected usage %+v", status)
+	}
+	if !status.Daily.ResetsAt.Equal(time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC)) || !status.Monthly.ResetsAt.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected resets %v, %v", status.Daily.ResetsAt, status.Monthly.ResetsAt)
+	}
+	if status.Remaining() != 30 || status.Tightest().Name != "monthly" || status.Exceeded() != nil {
+		t.Errorf("unexpected remaining %d / tightest %s", status.Remaining(), status.Tightest().Name)
+	}
+
+	if err := tracker.Record(ctx, &store.Usage{UserID: "u1", CompletionTokens: 45, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	status, _ = tracker.Status(ctx, "u1", nil)
+	var exceeded *ExceededError
+	if err := status.Exceeded(); !errors.As(err, &exceeded) || exceeded.Period.Name != "daily" {
+		t.Fatalf("expected daily budget exhausted, got %v", err)
+	}
+}
+
+func TestStatusCharge(t *testing.T) {
+	status := Status{
+		Daily:   Period{Name: "daily", Limit: 100, Used: 40},
+		Monthly: Period{Name: "monthly"},
+	}
+	if err := status.Afford(60); err != nil {
+		t.Fatalf("60 of 60 tokens left: %v", err)
+	}
+	status.Charge(50)
+	if status.Remaining() != 10 || status.Exceeded() != nil {
+		t.Fatalf("unexpected status after charge %+v", status)
+	}
+	var exceeded *ExceededError
+	if err := status.Afford(11); !errors.As(err, &exceeded) || exceeded.Period.Name != "daily" {
+		t.Fatalf("expected daily budget exceeded, got %v", err)
+	}
+	status.Charge(10)
+	if status.Exceeded() == nil {
+		t.Error("a fully charged budget is exhausted")
+	}
+	if err := (Status{}).Afford(1 << 30); err != nil {
+		t.Errorf("unlimited budgets afford anything: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package rag
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ContextMessage is the system message giving the model the retrieved
+// passages, numbered from 1 as they should be cited.
+func ContextMessage(results []Result) string {
+	var b strings.Builder
+	b.WriteString("The following sources from the ShopMind catalog and help center may help answer the user's latest message. ")
+	b.WriteString("Use them only when relevant. When you use a source, cite it right after the sentence that relies on it with its number in square brackets, such as [1] or [1, 3]. ")
+	b.WriteString("Do not cite sources you did not use, and do not make up sources.\n")
+	for i, result := range results {
+		fmt.Fprintf(&b, "\n[%d] %s", i+1, result.Title)
+		if result.URL != "" {
+			fmt.Fprintf(&b, " (%s)", result.URL)
+		}
+		fmt.Fprintf(&b, "\n%s\n", result.Text)
+	}
+	return b.String()
+}
+
+// Citation links a span of the answer to the source it cites. Start and End
+// are rune offsets into the answer text; the span runs from the start of
+// the sentence to the citation marker.
+type Citation struct {
+	// Index is the number the source was cited with, from 1.
+	Index  int    `json:"index"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	Text   string `json:"text"`
+	Source Source `json:"source"`
+}
+
+var citationMarker = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// Citations finds the citation markers in text that refer to results.
+// Markers for unknown sources are ignored; adjacent markers, as in
+// "[1][2]", cite the same span.
+func Citations(text string, results []Result) []Citation {
+	var citations []Citation
+	// floor is the end of the previous marker; spans never reach past it.
+	floor, spanStart, spanEnd := 0, 0, 0
+	for _, loc := range citationMarker.FindAllStringSubmatchIndex(text, -1) {
+		if adjacent := floor > 0 && strings.TrimSpace(text[floor:loc[0]]) == ""; !adjacent {
+			spanStart = sentenceStart(text, floor, loc[0])
+			spanEnd = loc[0]
+			for spanEnd > spanStart && isSpace(text[spanEnd-1]) {
+				spanEnd--
+			}
+		}
+		span := text[spanStart:spanEnd]
+		for _, field := range strings.Split(text[loc[2]:loc[3]], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n < 1 || n > len(results) {
+				continue
+			}
+			startRunes := utf8.RuneCountInString(text[:spanStart])
+			citations = append(citations, Citation{
+				Index:  n,
+				Start:  startRunes,
+				End:    startRunes + utf8.RuneCountInString(span),
+				Text:   span,
+				Source: results[n-1].Source(),
+			})
+		}
+		floor = loc[1]
+	}
+	return citations
+}
+
+// sentenceStart returns the byte offset where the sentence before end
+// begins, not before floor. The marker may follow the sentence's closing
+// punctuation; leading whitespace and list bullets are skipped.
+func sentenceStart(text string, floor, end int) int {
+	i := end - 1
+	for i >= floor && isSpace(text[i]) {
+		i--
+	}
+	for i >= floor && isTerminal(text[i]) {
+		i--
+	}
+	start := floor
+	for ; i >= floor; i-- {
+		if text[i] == '\n' || (isTerminal(text[i]) && isSpace(text[i+1])) {
+			start = i + 1
+			break
+		}
+	}
+	for start < end && isSpace(text[start]) {
+		start++
+	}
+	// Skip a list bullet.
+	if start+1 < end && (text[start] == '-' || text[start] == '*' || text[start] == '+') && text[start+1] == ' ' {
+		start += 2
+	}
+	return start
+}
+
+func isSpace(c byte) bool    { return c == ' ' || c == '\t' || c == '\n' || c == '\r' }
+func isTerminal(c byte) bool { return c == '.' || c == '!' || c == '?' }
@@ -0,0 +1,214 @@
+package rag
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/shopmindai/orchestrator/internal/catalog"
+)
+
+// maxPassageRunes bounds a help passage; longer sections are split at
+// paragraph breaks.
+const maxPassageRunes = 1500
+
+type Kind string
+
+const (
+	KindProduct Kind = "product"
+	KindHelp    Kind = "help"
+)
+
+// Passage is an indexed piece of text. Text is what gets embedded and shown
+// to the model.
+type Passage struct {
+	ID    string `json:"id"`
+	Kind  Kind   `json:"kind"`
+	Title string `json:"title"`
+	URL   string `json:"url,omitempty"`
+	Text  string `json:"text"`
+}
+
+// Source is how a cited passage is shown to the user.
+type Source struct {
+	ID    string `json:"id"`
+	Kind  Kind   `json:"kind"`
+	Title string `json:"title"`
+	URL   string `json:"url,omitempty"`
+}
+
+func (p Passage) Source() Source {
+	return Source{ID: p.ID, Kind: p.Kind, Title: p.Title, URL: p.URL}
+}
+
+// ProductPassage describes a catalog offer. Prices and availability are
+// left out: they change often, would force re-embedding, and the
+// search_products tool reports them live.
+func ProductPassage(p catalog.Product) Passage {
+	var text strings.Builder
+	text.WriteString(p.Title)
+	for _, field := range []struct{ label, value string }{
+		{"Brand", p.Brand},
+		{"Category", p.Category},
+		{"Sold by", p.Merchant},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&text, "\n%s: %s", field.label, field.value)
+		}
+	}
+	if p.Description != "" {
+		text.WriteString("\n" + p.Description)
+	}
+	return Passage{
+		ID:    "product:" + p.Feed + "/" + p.ID,
+		Kind:  KindProduct,
+		Title: p.Title,
+		URL:   p.URL,
+		Text:  text.String(),
+	}
+}
+
+// LoadHelp splits the markdown files under dir into one passage per
+// section. Passage IDs are "help:<path>#<n>"; URLs point at baseURL/<path
+// without .md>#<heading anchor> when baseURL is set.
+func LoadHelp(dir, baseURL string) ([]Passage, error) {
+	var passages []Passage
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(name), ".md") {
+			return nil
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		passages = append(passages, helpPassages(filepath.ToSlash(rel), string(data), baseURL)...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load help center: %w", err)
+	}
+	return passages, nil
+}
+
+type helpSection struct {
+	heading string
+	body    []string
+}
+
+func helpPassages(rel, content, baseURL string) []Passage {
+	// A leading H1 titles the page, which is otherwise named after the
+	// file; text before the first heading is a section of its own.
+	page := strings.TrimSuffix(path.Base(rel), path.Ext(rel))
+	sections := []helpSection{{}}
+	inFence := false
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inFence = !inFence
+		}
+		if heading, ok := markdownHeading(trimmed); ok && !inFence {
+			if len(sections) == 1 && strings.HasPrefix(trimmed, "# ") {
+				page = heading
+			}
+			sections = append(sections, helpSection{heading: heading})
+			continue
+		}
+		last := &sections[len(sections)-1]
+		last.body = append(last.body, line)
+	}
+
+	var passages []Passage
+	for _, section := range sections {
+		body := strings.TrimSpace(strings.Join(section.body, "\n"))
+		if body == "" {
+			continue
+		}
+		title := page
+		if section.heading != "" && section.heading != page {
+			title = page + " › " + section.heading
+		}
+		url := ""
+		if baseURL != "" {
+			url = strings.TrimRight(baseURL, "/") + "/" + strings.TrimSuffix(rel, path.Ext(rel))
+			if section.heading != "" {
+				url += "#" + anchor(section.heading)
+			}
+		}
+		for _, chunk := range splitParagraphs(body, maxPassageRunes) {
+			passages = append(passages, Passage{
+				ID:    fmt.Sprintf("help:%s#%d", rel, len(passages)+1),
+				Kind:  KindHelp,
+				Title: title,
+				URL:   url,
+				Text:  title + "\n" + chunk,
+			})
+		}
+	}
+	return passages
+}
+
+func markdownHeading(line string) (string, bool) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || level == len(line) || line[level] != ' ' {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimRight(line[level:], "#")), true
+}
+
+// anchor returns the GitHub-style anchor of a heading.
+func anchor(heading string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(heading) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// splitParagraphs packs the paragraphs of text into chunks of at most limit
+// runes. A single longer paragraph becomes its own chunk.
+func splitParagraphs(text string, limit int) []string {
+	var chunks []string
+	var current strings.Builder
+	size := 0
+	for _, para := range strings.Split(text, "\n\n") {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		n := len([]rune(para))
+		if size > 0 && size+2+n > limit {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			size = 0
+		}
+		if size > 0 {
+			current.WriteString("\n\n")
+			size += 2
+		}
+		current.WriteString(para)
+		size += n
+	}
+	if size > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
@@ -0,0 +1,363 @@
+// Package rag retrieves catalog and help-center passages relevant to a chat
+// turn so that answers can cite them.
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/shopmindai/orchestrator/internal/catalog"
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+// embedBatch bounds the passages embedded per llm-proxy call.
+const embedBatch = 64
+
+// Embedder turns texts into vectors, one per text.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Result is a retrieved passage and its cosine similarity to the query.
+type Result struct {
+	Passage
+	Score float64 `json:"score"`
+}
+
+// entry is an indexed passage. Hash identifies the embedded text and
+// model, so unchanged passages are not embedded again.
+type entry struct {
+	Passage Passage
+	Hash    string
+	Vector  []float32
+}
+
+// Retriever keeps a vector index of the catalog and the help center in sync
+// and searches it. The index is searched by brute force, which is fast
+// enough for catalogs of tens of thousands of products.
+type Retriever struct {
+	cfg      config.RAGConfig
+	catalog  *catalog.Catalog
+	embedder Embedder
+
+	mu      sync.RWMutex
+	entries map[string]entry
+
+	// syncing serializes index updates; entries is only replaced while it
+	// is held.
+	syncing sync.Mutex
+
+	running bool
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Open loads the persisted index and keeps it in sync in the background:
+// catalog ingestions are indexed as they happen and the help center every
+// cfg.Refresh. c may be nil. It returns nil when no endpoint retrieves.
+func Open(ctx context.Context, cfg config.RAGConfig, c *catalog.Catalog, embedder Embedder) (*Retriever, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, nil
+	}
+	r, err := New(cfg, c, embedder)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c.OnChange(func(context.Context, []catalog.Product) { r.Kick() })
+	}
+	r.running = true
+	go r.run()
+	r.Kick()
+	return r, nil
+}
+
+// New loads the index persisted at cfg.IndexPath, if any. It does not sync.
+func New(cfg config.RAGConfig, c *catalog.Catalog, embedder Embedder) (*Retriever, error) {
+	r := &Retriever{
+		cfg:      cfg,
+		catalog:  c,
+		embedder: embedder,
+		entries:  make(map[string]entry),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Kick schedules a background sync.
+func (r *Retriever) Kick() {
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Retriever) run() {
+	defer close(r.done)
+	// Closing stop also cancels a sync in progress.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-r.stop
+		cancel()
+	}()
+
+	var tick <-chan time.Time
+	if r.cfg.Refresh > 0 {
+		ticker := time.NewTicker(r.cfg.Refresh)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-r.kick:
+		case <-tick:
+		case <-ctx.Done():
+			return
+		}
+		if err := r.Sync(ctx); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("retrieval index sync failed")
+		}
+	}
+}
+
+// Close stops background syncing.
+func (r *Retriever) Close() error {
+	close(r.stop)
+	if r.running {
+		<-r.done
+	}
+	return nil
+}
+
+// Enabled reports whether endpoint answers with retrieved sources.
+func (r *Retriever) Enabled(endpoint string) bool {
+	return r != nil && r.cfg.RetrievalEnabled(endpoint)
+}
+
+// Len returns the number of indexed passages.
+func (r *Retriever) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.entries)
+}
+
+// Sync indexes the current catalog and help center: new and changed
+// passages are embedded, removed ones dropped, and the index is persisted.
+// Passages embedded before a failure are kept.
+func (r *Retriever) Sync(ctx context.Context) error {
+	r.syncing.Lock()
+	defer r.syncing.Unlock()
+
+	var passages []Passage
+	if r.catalog != nil {
+		for _, p := range r.catalog.Products(nil) {
+			passages = append(passages, ProductPassage(p))
+		}
+	}
+	if r.cfg.HelpDir != "" {
+		help, err := LoadHelp(r.cfg.HelpDir, r.cfg.HelpBaseURL)
+		if err != nil {
+			return err
+		}
+		passages = append(passages, help...)
+	}
+
+	r.mu.RLock()
+	next := make(map[string]entry, len(passages))
+	var pending []entry
+	for _, p := range passages {
+		hash := r.hash(p)
+		if old, ok := r.entries[p.ID]; ok && old.Hash == hash {
+			old.Passage = p
+			next[p.ID] = old
+			continue
+		}
+		pending = append(pending, entry{Passage: p, Hash: hash})
+	}
+	unchanged := len(pending) == 0 && len(next) == len(r.entries)
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	var syncErr error
+	embedded := 0
+	for start := 0; start < len(pending); start += embedBatch {
+		batch := pending[start:min(start+embedBatch, len(pending))]
+		texts := make([]string, len(batch))
+		for i, e := range batch {
+			texts[i] = e.Passage.Text
+		}
+		vectors, err := r.embedder.Embed(ctx, texts)
+		if err == nil && len(vectors) != len(batch) {
+			err = fmt.Errorf("got %d embeddings for %d passages", len(vectors), len(batch))
+		}
+		if err != nil {
+			syncErr = fmt.Errorf("embed passages: %w", err)
+			// Changed passages keep their stale vectors until the next sync.
+			for _, e := range pending[start:] {
+				if old, ok := r.entries[e.Passage.ID]; ok {
+					next[e.Passage.ID] = old
+				}
+			}
+			break
+		}
+		for i, e := range batch {
+			e.Vector = normalize(vectors[i])
+			next[e.Passage.ID] = e
+		}
+		embedded += len(batch)
+	}
+
+	r.mu.Lock()
+	r.entries = next
+	r.mu.Unlock()
+	log.Info().Int("passages", len(next)).Int("embedded", embedded).Msg("retrieval index synced")
+	if err := r.save(); err != nil {
+		return errors.Join(syncErr, err)
+	}
+	return syncErr
+}
+
+// Retrieve returns the passages most similar to query, best first.
+func (r *Retriever) Retrieve(ctx context.Context, query string) ([]Result, error) {
+	if r.Len() == 0 || r.cfg.TopK <= 0 {
+		return nil, nil
+	}
+	vectors, err := r.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("got %d embeddings for 1 query", len(vectors))
+	}
+	q := normalize(vectors[0])
+
+	r.mu.RLock()
+	results := make([]Result, 0, len(r.entries))
+	for _, e := range r.entries {
+		if len(e.Vector) != len(q) {
+			continue
+		}
+		score := dot(q, e.Vector)
+		if score >= r.cfg.MinScore {
+			results = append(results, Result{Passage: e.Passage, Score: score})
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > r.cfg.TopK {
+		results = results[:r.cfg.TopK]
+	}
+	return results, nil
+}
+
+// hash identifies what an embedding was computed from.
+func (r *Retriever) hash(p Passage) string {
+	sum := sha256.Sum256([]byte(r.cfg.EmbeddingModel + "\x00" + p.Text))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *Retriever) load() error {
+	if r.cfg.IndexPath == "" {
+		return nil
+	}
+	f, err := os.Open(r.cfg.IndexPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open retrieval index: %w", err)
+	}
+	defer f.Close()
+	var entries []entry
+	if err := gob.NewDecoder(f).Decode(&entries); err != nil {
+		// A corrupt index is rebuilt rather than blocking startup.
+		log.Warn().Err(err).Str("path", r.cfg.IndexPath).Msg("ignoring unreadable retrieval index")
+		return nil
+	}
+	for _, e := range entries {
+		r.entries[e.Passage.ID] = e
+	}
+	return nil
+}
+
+// save writes the index atomically so a crash never leaves it truncated.
+func (r *Retriever) save() error {
+	if r.cfg.IndexPath == "" {
+		return nil
+	}
+	r.mu.RLock()
+	entries := make([]entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	r.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Passage.ID < entries[j].Passage.ID })
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.cfg.IndexPath), ".rag-index-*")
+	if err != nil {
+		return fmt.Errorf("save retrieval index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(entries); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save retrieval index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save retrieval index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), r.cfg.IndexPath); err != nil {
+		return fmt.Errorf("save retrieval index: %w", err)
+	}
+	return nil
+}
+
+func normalize(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	if norm == 0 {
+		return out
+	}
+	scale := 1 / math.Sqrt(norm)
+	for i, x := range v {
+		out[i] = float32(float64(x) * scale)
+	}
+	return out
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,291 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"unicode"
+
+	"github.com/shopmindai/orchestrator/internal/catalog"
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+// wordEmbedder hashes words into a small vector so texts sharing words are
+// similar. It records every text it embeds.
+type wordEmbedder struct {
+	mu       sync.Mutex
+	embedded []string
+	err      error
+}
+
+func (e *wordEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return nil, e.err
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, 1024)
+		for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(word))
+			vector[h.Sum32()%1024]++
+		}
+		vectors[i] = vector
+	}
+	e.embedded = append(e.embedded, texts...)
+	return vectors, nil
+}
+
+func (e *wordEmbedder) reset() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := len(e.embedded)
+	e.embedded = nil
+	return n
+}
+
+const testFeed = `{"id":"BOOT-1","title":"Waterproof Hiking Boots","brand":"Trailwise","category":"Footwear > Boots","description":"Leather boots with a waterproof membrane.","price":129.5,"currency":"USD","url":"https://shop.example/boot-1"}
+{"id":"SOCK-1","title":"Merino Wool Socks","brand":"Woolly","category":"Apparel > Socks","price":19,"currency":"USD"}
+`
+
+const testHelp = `# Returns
+
+Our return policy in short.
+
+## Return window
+
+Unworn items can be returned within 30 days of delivery for a full refund.
+
+## Refund timing
+
+Refunds reach your card within 5 business days.
+`
+
+func newTestRetriever(t *testing.T, embedder Embedder, indexPath string) (*Retriever, *catalog.Catalog, string) {
+	t.Helper()
+	feedDir, helpDir := t.TempDir(), t.TempDir()
+	write(t, filepath.Join(feedDir, "outdoor.jsonl"), testFeed)
+	write(t, filepath.Join(helpDir, "returns.md"), testHelp)
+
+	c, err := catalog.New(context.Background(), catalog.NewMemoryStore(), feedDir)
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	if _, err := c.Ingest(context.Background()); err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+
+	r, err := New(config.RAGConfig{
+		Endpoints:   []string{"shopmind"},
+		HelpDir:     helpDir,
+		HelpBaseURL: "https://help.example/",
+		IndexPath:   indexPath,
+		TopK:        2,
+		MinScore:    0.1,
+	}, c, embedder)
+	if err != nil {
+		t.Fatalf("new retriever: %v", err)
+	}
+	return r, c, helpDir
+}
+
+func write(t *testing.T, name, data string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(data), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+func TestHelpPassages(t *testing.T) {
+	passages := helpPassages("orders/returns.md", testHelp, "https://help.example/")
+	want := []Passage{
+		{ID: "help:orders/returns.md#1", Kind: KindHelp, Title: "Returns", URL: "https://help.example/orders/returns#returns",
+			Text: "Returns\nOur return policy in short."},
+		{ID: "help:orders/returns.md#2", Kind: KindHelp, Title: "Returns › Return window", URL: "https://help.example/orders/returns#return-window",
+			Text: "Returns › Return window\nUnworn items can be returned within 30 days of delivery for a full refund."},
+		{ID: "help:orders/returns.md#3", Kind: KindHelp, Title: "Returns › Refund timing", URL: "https://help.example/orders/returns#refund-timing",
+			Text: "Returns › Refund timing\nRefunds reach your card within 5 business days."},
+	}
+	if !reflect.DeepEqual(passages, want) {
+		t.Errorf("unexpected passages:\n%+v\nwant\n%+v", passages, want)
+	}
+
+	long := strings.Repeat("word ", 200) + "\n\n" + strings.Repeat("more ", 200) + "\n\n```\n# not a heading\n```"
+	passages = helpPassages("faq.md", long, "")
+	if len(passages) != 2 || passages[0].Title != "faq" || passages[0].URL != "" || !strings.Contains(passages[1].Text, "# not a heading") {
+		t.Errorf("unexpected chunks %+v", passages)
+	}
+}
+
+func TestSyncIsIncremental(t *testing.T) {
+	embedder := &wordEmbedder{}
+	indexPath := filepath.Join(t.TempDir(), "index.gob")
+	r, c, helpDir := newTestRetriever(t, embedder, indexPath)
+	ctx := context.Background()
+
+	if err := r.Sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if n := embedder.reset(); n != 5 || r.Len() != 5 {
+		t.Fatalf("first sync embedded %d passages and indexed %d, want 5", n, r.Len())
+	}
+
+	if err := r.Sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if n := embedder.reset(); n != 0 {
+		t.Errorf("unchanged sync embedded %d passages", n)
+	}
+
+	write(t, filepath.Join(helpDir, "returns.md"), strings.Replace(testHelp, "5 business days", "10 business days", 1))
+	if err := r.Sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if embedded := embedder.embedded; len(embedded) != 1 || !strings.Contains(embedded[0], "10 business days") {
+		t.Errorf("expected only the changed section to be embedded, got %q", embedded)
+	}
+	embedder.reset()
+
+	// A restart reuses the persisted vectors.
+	reloaded, err := New(r.cfg, c, embedder)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if reloaded.Len() != 5 {
+		t.Fatalf("reloaded index has %d passages, want 5", reloaded.Len())
+	}
+	if err := reloaded.Sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if n := embedder.reset(); n != 0 {
+		t.Errorf("sync after reload embedded %d passages", n)
+	}
+
+	// Removed passages leave the index; a failed sync keeps stale vectors.
+	if err := os.Remove(filepath.Join(helpDir, "returns.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Sync(ctx); err != nil || r.Len() != 2 {
+		t.Errorf("after removing the help page: %d passages, err %v", r.Len(), err)
+	}
+	write(t, filepath.Join(helpDir, "returns.md"), testHelp)
+	embedder.err = errors.New("llm proxy down")
+	if err := r.Sync(ctx); err == nil || r.Len() != 2 {
+		t.Errorf("failed sync: %d passages, err %v", r.Len(), err)
+	}
+}
+
+func TestRetrieve(t *testing.T) {
+	r, _, _ := newTestRetriever(t, &wordEmbedder{}, "")
+	ctx := context.Background()
+
+	results, err := r.Retrieve(ctx, "anything")
+	if err != nil || results != nil {
+		t.Fatalf("empty index returned %v, %v", results, err)
+	}
+	if err := r.Sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	results, err = r.Retrieve(ctx, "Are these boots waterproof?")
+	if err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	if len(results) == 0 || results[0].ID != "product:outdoor/BOOT-1" || results[0].URL != "https://shop.example/boot-1" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if len(results) > 2 {
+		t.Errorf("got %d results, want at most TopK", len(results))
+	}
+
+	results, err = r.Retrieve(ctx, "How long do refunds take to reach my card?")
+	if err != nil || len(results) == 0 || results[0].ID != "help:returns.md#3" {
+		t.Errorf("unexpected help results %+v, %v", results, err)
+	}
+
+	results, err = r.Retrieve(ctx, "kayak paddle")
+	if err != nil || len(results) != 0 {
+		t.Errorf("unrelated query returned %+v, %v", results, err)
+	}
+
+	if !r.Enabled("shopmind") || r.Enabled("openAI") || (*Retriever)(nil).Enabled("shopmind") {
+		t.Error("unexpected endpoint toggle")
+	}
+}
+
+func TestCitations(t *testing.T) {
+	results := []Result{
+		{Passage: Passage{ID: "product:outdoor/BOOT-1", Kind: KindProduct, Title: "Waterproof Hiking Boots"}},
+		{Passage: Passage{ID: "help:returns.md#2", Kind: KindHelp, Title: "Returns › Return window", URL: "https://help.example/returns#return-window"}},
+	}
+	boots, window := results[0].Source(), results[1].Source()
+
+	tests := []struct {
+		name string
+		text string
+		want []Citation
+	}{
+		{
+			name: "marker before the period",
+			text: "These boots are waterproof [1]. You can return them within 30 days [2].",
+			want: []Citation{
+				{Index: 1, Start: 0, End: 26, Text: "These boots are waterproof", Source: boots},
+				{Index: 2, Start: 32, End: 66, Text: "You can return them within 30 days", Source: window},
+			},
+		},
+		{
+			name: "marker after the period and grouped",
+			text: "Héllo. Both apply here. [1, 2]",
+			want: []Citation{
+				{Index: 1, Start: 7, End: 23, Text: "Both apply here.", Source: boots},
+				{Index: 2, Start: 7, End: 23, Text: "Both apply here.", Source: window},
+			},
+		},
+		{
+			name: "adjacent markers and unknown sources",
+			text: "- Waterproof [1][2][7]\n- No source [0]",
+			want: []Citation{
+				{Index: 1, Start: 2, End: 12, Text: "Waterproof", Source: boots},
+				{Index: 2, Start: 2, End: 12, Text: "Waterproof", Source: window},
+			},
+		},
+		{
+			name: "no markers",
+			text: "Nothing to cite.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Citations(tt.text, results)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Citations(%q) =\n%+v\nwant\n%+v", tt.text, got, tt.want)
+			}
+			for _, c := range got {
+				if span := string([]rune(tt.text)[c.Start:c.End]); span != c.Text {
+					t.Errorf("span %d-%d is %q, want %q", c.Start, c.End, span, c.Text)
+				}
+			}
+		})
+	}
+}
+
+func TestContextMessage(t *testing.T) {
+	msg := ContextMessage([]Result{
+		{Passage: Passage{Title: "Waterproof Hiking Boots", URL: "https://shop.example/boot-1", Text: "Leather boots."}},
+		{Passage: Passage{Title: "Returns", Text: "30 days."}},
+	})
+	for _, want := range []string{"[1] Waterproof Hiking Boots (https://shop.example/boot-1)\nLeather boots.", "[2] Returns\n30 days.", "square brackets"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("context message lacks %q:\n%s", want, msg)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle, full buckets are dropped.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Memory keeps limiter state in process. Limits are per instance.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	streams   map[string]map[string]struct{}
+	nextSlot  uint64
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket),
+		streams: make(map[string]map[string]struct{}),
+		now:     time.Now,
+	}
+}
+
+func (m *Memory) Take(_ context.Context, key string, rate float64, burst int) (Decision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now, rate, burst)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return decide(allowed, b.tokens, rate, burst), nil
+}
+
+// sweep drops buckets that have refilled completely, which are
+// indistinguishable from new ones.
+func (m *Memory) sweep(now time.Time, rate float64, burst int) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// Acquire ignores ttl: slots are released by the process that holds them.
+func (m *Memory) Acquire(_ context.Context, key string, limit int, _ time.Duration) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	slots := m.streams[key]
+	if len(slots) >= limit {
+		return "", false, nil
+	}
+	if slots == nil {
+		slots = make(map[string]struct{})
+		m.streams[key] = slots
+	}
+	m.nextSlot++
+	slot := strconv.FormatUint(m.nextSlot, 10)
+	slots[slot] = struct{}{}
+	return slot, true, nil
+}
+
+func (m *Memory) Release(_ context.Context, key, slot string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.streams[key], slot)
+	if len(m.streams[key]) == 0 {
+		delete(m.streams, key)
+	}
+	return nil
+}
+
+func (m *Memory) Close() error { return nil }
@@ -0,0 +1,72 @@
+// Package ratelimit provides token-bucket request limits and concurrent
+// stream caps with pluggable storage: in-process memory for a single
+// orchestrator instance, or a Redis-protocol server shared by a cluster.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+// Decision is the outcome of taking a token from a bucket.
+type Decision struct {
+	Allowed   bool
+	Limit     int // bucket size
+	Remaining int // whole tokens left after this request
+	// RetryAfter is how long until the next token, when not allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps bucket and stream counter state.
+type Store interface {
+	// Take removes one token from key's bucket, refilled at rate tokens per
+	// second up to burst.
+	Take(ctx context.Context, key string, rate float64, burst int) (Decision, error)
+	// Acquire claims one of limit concurrent slots for key and returns its
+	// ID; ok is false when all are taken. Each slot expires on its own after
+	// ttl in case Release is never called.
+	Acquire(ctx context.Context, key string, limit int, ttl time.Duration) (slot string, ok bool, err error)
+	// Release frees a slot returned by Acquire.
+	Release(ctx context.Context, key, slot string) error
+	Close() error
+}
+
+// Open builds the store selected by cfg: Redis when RedisURL is set,
+// memory otherwise.
+func Open(cfg config.RateLimitConfig) (Store, error) {
+	if cfg.RedisURL == "" {
+		return NewMemory(), nil
+	}
+	if !strings.HasPrefix(cfg.RedisURL, "redis://") && !strings.HasPrefix(cfg.RedisURL, "rediss://") {
+		return nil, fmt.Errorf("unsupported rate limit store %q", cfg.RedisURL)
+	}
+	return NewRedis(cfg.RedisURL)
+}
+
+// decide turns the tokens left in a bucket into a Decision.
+func decide(allowed bool, tokens, rate float64, burst int) Decision {
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(burst) - tokens) / rate),
+	}
+	if !allowed {
+		d.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	return d
+}
+
+func seconds(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
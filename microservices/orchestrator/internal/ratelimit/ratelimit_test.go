@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestMemoryTokenBucket(t *testing.T) {
+	m := NewMemory()
+	now := time.Unix(1000, 0)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		d, _ := m.Take(ctx, "user:a", 0.5, 3)
+		if !d.Allowed || d.Remaining != i || d.Limit != 3 {
+			t.Fatalf("take %d: unexpected decision %+v", 3-i, d)
+		}
+	}
+	d, _ := m.Take(ctx, "user:a", 0.5, 3)
+	if d.Allowed || d.RetryAfter != 2*time.Second || d.Reset != 6*time.Second {
+		t.Fatalf("expected denial with a 2s retry, got %+v", d)
+	}
+	if d, _ := m.Take(ctx, "user:b", 0.5, 3); !d.Allowed {
+		t.Fatal("buckets must be per key")
+	}
+
+	now = now.Add(2 * time.Second)
+	if d, _ := m.Take(ctx, "user:a", 0.5, 3); !d.Allowed || d.Remaining != 0 {
+		t.Fatalf("expected a refilled token, got %+v", d)
+	}
+}
+
+func TestMemoryStreams(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	var slots []string
+	for range 2 {
+		slot, ok, _ := m.Acquire(ctx, "user:a", 2, time.Minute)
+		if !ok {
+			t.Fatal("expected a free slot")
+		}
+		slots = append(slots, slot)
+	}
+	if _, ok, _ := m.Acquire(ctx, "user:a", 2, time.Minute); ok {
+		t.Fatal("expected the cap to hold")
+	}
+	_ = m.Release(ctx, "user:a", slots[0])
+	_ = m.Release(ctx, "user:a", slots[0])
+	slot, ok, _ := m.Acquire(ctx, "user:a", 2, time.Minute)
+	if !ok {
+		t.Fatal("expected a released slot to be reusable")
+	}
+	if _, ok, _ := m.Acquire(ctx, "user:a", 2, time.Minute); ok {
+		t.Fatal("releasing a slot twice must not free another")
+	}
+	_ = m.Release(ctx, "user:a", slot)
+	_ = m.Release(ctx, "user:a", slots[1])
+	if len(m.streams) != 0 {
+		t.Errorf("released keys kept: %v", m.streams)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireUserAuth("limiter", "secret")
+	r, err := NewRedis("redis://limiter:secret@" + server.Addr() + "/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	for i := 1; i >= 0; i-- {
+		d, err := r.Take(ctx, "user:a", 0.5, 2)
+		if err != nil {
+			t.Fatalf("Take: %v", err)
+		}
+		if !d.Allowed || d.Remaining != i || d.Limit != 2 {
+			t.Fatalf("take %d: unexpected decision %+v", 2-i, d)
+		}
+	}
+	d, err := r.Take(ctx, "user:a", 0.5, 2)
+	if err != nil || d.Allowed || d.RetryAfter <= 0 || d.RetryAfter > 2*time.Second {
+		t.Fatalf("expected denial, got %+v, %v", d, err)
+	}
+	server.Select(2)
+	if !server.Exists(redisKeyPrefix + "rate:user:a") {
+		t.Errorf("bucket not stored in database 2: %v", server.Keys())
+	}
+
+	var slots []string
+	for range 2 {
+		slot, ok, err := r.Acquire(ctx, "user:a", 2, time.Minute)
+		if !ok || err != nil {
+			t.Fatalf("Acquire: %v, %v", ok, err)
+		}
+		slots = append(slots, slot)
+	}
+	if _, ok, _ := r.Acquire(ctx, "user:a", 2, time.Minute); ok {
+		t.Fatal("expected the cap to hold")
+	}
+	if err := r.Release(ctx, "user:a", slots[0]); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if _, ok, _ := r.Acquire(ctx, "user:a", 2, time.Minute); !ok {
+		t.Fatal("expected a released slot to be reusable")
+	}
+
+	// Slots that are never released, e.g. after a crash, expire one by one
+	// even while new streams keep the key alive.
+	server.SetTime(time.Now().Add(30 * time.Second))
+	if _, ok, _ := r.Acquire(ctx, "user:a", 2, time.Minute); ok {
+		t.Fatal("expected the cap to hold before the slots expire")
+	}
+	server.SetTime(time.Now().Add(61 * time.Second))
+	for range 2 {
+		if _, ok, err := r.Acquire(ctx, "user:a", 2, time.Minute); !ok || err != nil {
+			t.Fatalf("expected the expired slots to be pruned: %v, %v", ok, err)
+		}
+	}
+	if _, ok, _ := r.Acquire(ctx, "user:a", 2, time.Minute); ok {
+		t.Fatal("expected the cap to hold again")
+	}
+
+	server.Close()
+	if _, err := r.Take(ctx, "user:a", 0.5, 2); err == nil {
+		t.Fatal("expected an error from an unreachable server")
+	}
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisDialTimeout = 2 * time.Second
+	redisIOTimeout   = time.Second
+	redisPoolSize    = 8
+	redisKeyPrefix   = "shopmind:ratelimit:"
+)
+
+// takeScript refills and takes from a bucket stored as a hash. It uses the
+// server clock so instances with skewed clocks agree. The token count is
+// returned as a string because Lua numbers are truncated to integers in
+// replies.
+var takeScript = redis.NewScript(`
+local rate, burst = tonumber(ARGV[1]), tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// acquireScript keeps a stream counter's slots in a sorted set scored by
+// their deadline, in server time. Expired slots, e.g. those of a crashed
+// instance, are pruned before counting, so each expires on its own however
+// busy the key stays.
+var acquireScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[2]), ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// Redis keeps limiter state on a Redis-protocol server (Redis, Valkey,
+// KeyDB, ...) so limits hold across orchestrator instances. The server
+// needs Lua scripting.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis parses a redis:// or rediss://[user:password@]host:port[/db]
+// URL. Connections are dialled lazily.
+func NewRedis(rawURL string) (*Redis, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	opts.DialTimeout = redisDialTimeout
+	opts.ReadTimeout = redisIOTimeout
+	opts.WriteTimeout = redisIOTimeout
+	opts.ContextTimeoutEnabled = true
+	opts.PoolSize = redisPoolSize
+	// Limits fail open on errors, so retries would only delay requests.
+	opts.MaxRetries = -1
+	opts.DisableIdentity = true
+	return &Redis{client: redis.NewClient(opts)}, nil
+}
+
+func (r *Redis) Take(ctx context.Context, key string, rate float64, burst int) (Decision, error) {
+	values, err := takeScript.Run(ctx, r.client, []string{redisKeyPrefix + "rate:" + key},
+		strconv.FormatFloat(rate, 'f', -1, 64), burst).Slice()
+	if err != nil {
+		return Decision{}, fmt.Errorf("redis: %w", err)
+	}
+	if len(values) != 2 {
+		return Decision{}, fmt.Errorf("redis: unexpected reply %v", values)
+	}
+	allowed, _ := values[0].(int64)
+	tokensText, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(tokensText, 64)
+	if err != nil {
+		return Decision{}, fmt.Errorf("redis: unexpected token count %q", tokensText)
+	}
+	return decide(allowed == 1, tokens, rate, burst), nil
+}
+
+func (r *Redis) Acquire(ctx context.Context, key string, limit int, ttl time.Duration) (string, bool, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", false, err
+	}
+	slot := hex.EncodeToString(id)
+	n, err := acquireScript.Run(ctx, r.client, []string{redisKeyPrefix + "slots:" + key},
+		limit, ttl.Milliseconds(), slot).Int()
+	if err != nil {
+		return "", false, fmt.Errorf("redis: %w", err)
+	}
+	if n != 1 {
+		return "", false, nil
+	}
+	return slot, true, nil
+}
+
+func (r *Redis) Release(ctx context.Context, key, slot string) error {
+	if err := r.client.ZRem(ctx, redisKeyPrefix+"slots:"+key, slot).Err(); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Memory struct {
+	mu            sync.RWMutex
+	conversations map[string]Conversation
+	messages      map[string][]Message // keyed by conversation ID, creation order
+	summaries     map[string]Summary
+	usage         []Usage
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		conversations: make(map[string]Conversation),
+		messages:      make(map[string][]Message),
+		summaries:     make(map[string]Summary),
+	}
+}
+
+func (m *Memory) SaveConversation(_ context.Context, conv *Conversation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	if existing, ok := m.conversations[conv.ConversationID]; ok {
+		if existing.UserID != conv.UserID {
+			return ErrForbidden
+		}
+		conv.CreatedAt = existing.CreatedAt
+	} else if conv.CreatedAt.IsZero() {
+		conv.CreatedAt = now
+	}
+	conv.UpdatedAt = now
+	m.conversations[conv.ConversationID] = *conv
+	return nil
+}
+
+func (m *Memory) GetConversation(_ context.Context, userID, conversationID string) (*Conversation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	conv, ok := m.conversations[conversationID]
+	if !ok || conv.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return &conv, nil
+}
+
+func (m *Memory) ListConversations(_ context.Context, userID string, opts ListOptions) ([]Conversation, string, error) {
+	nanos, id, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	limit := normalizeLimit(opts.Limit)
+
+	m.mu.RLock()
+	var convs []Conversation
+	for _, conv := range m.conversations {
+		if conv.UserID != userID {
+			continue
+		}
+		if opts.Cursor != "" && !before(conv, nanos, id) {
+			continue
+		}
+		convs = append(convs, conv)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(convs, func(i, j int) bool {
+		return before(convs[j], convs[i].UpdatedAt.UnixNano(), convs[i].ConversationID)
+	})
+
+	if len(convs) <= limit {
+		return convs, "", nil
+	}
+	convs = convs[:limit]
+	return convs, encodeCursor(convs[limit-1]), nil
+}
+
+func (m *Memory) DeleteConversation(_ context.Context, userID, conversationID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conv, ok := m.conversations[conversationID]
+	if !ok || conv.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.conversations, conversationID)
+	delete(m.messages, conversationID)
+	delete(m.summaries, conversationID)
+	return nil
+}
+
+func (m *Memory) SaveMessage(_ context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.conversations[msg.ConversationID]; !ok {
+		return ErrNotFound
+	}
+
+	now := time.Now().UTC()
+	msg.UpdatedAt = now
+	list := m.messages[msg.ConversationID]
+	for i := range list {
+		if list[i].MessageID == msg.MessageID {
+			msg.CreatedAt = list[i].CreatedAt
+			list[i] = *msg
+			return nil
+		}
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = now
+	}
+	m.messages[msg.ConversationID] = append(list, *msg)
+	return nil
+}
+
+func (m *Memory) GetMessage(_ context.Context, conversationID, messageID string) (*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, msg := range m.messages[conversationID] {
+		if msg.MessageID == messageID {
+			return &msg, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) ListMessages(_ context.Context, conversationID string) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := m.messages[conversationID]
+	out := make([]Message, len(list))
+	copy(out, list)
+	return out, nil
+}
+
+func (m *Memory) SaveSummary(_ context.Context, summary *Summary) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.conversations[summary.ConversationID]; !ok {
+		return ErrNotFound
+	}
+	summary.UpdatedAt = time.Now().UTC()
+	m.summaries[summary.ConversationID] = *summary
+	return nil
+}
+
+func (m *Memory) GetSummary(_ context.Context, conversationID string) (*Summary, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	summary, ok := m.summaries[conversationID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &summary, nil
+}
+
+func (m *Memory) RecordUsage(_ context.Context, usage *Usage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if usage.CreatedAt.IsZero() {
+		usage.CreatedAt = time.Now().UTC()
+	}
+	m.usage = append(m.usage, *usage)
+	return nil
+}
+
+func (m *Memory) SumUsage(_ context.Context, userID string, since time.Time) (UsageTotals, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var totals UsageTotals
+	for _, u := range m.usage {
+		if u.UserID == userID && !u.CreatedAt.Before(since) {
+			totals.PromptTokens += u.PromptTokens
+			totals.CompletionTokens += u.CompletionTokens
+		}
+	}
+	return totals, nil
+}
+
+func (m *Memory) Close() error { return nil }
@@ -0,0 +1,404 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS conversations (
+	conversation_id TEXT PRIMARY KEY,
+	user_id         TEXT NOT NULL,
+	title           TEXT NOT NULL DEFAULT '',
+	endpoint        TEXT NOT NULL DEFAULT '',
+	endpoint_type   TEXT NOT NULL DEFAULT '',
+	model           TEXT NOT NULL DEFAULT '',
+	prompt_prefix   TEXT NOT NULL DEFAULT '',
+	created_at      INTEGER NOT NULL,
+	updated_at      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS conversations_user_updated ON conversations (user_id, updated_at DESC, conversation_id DESC);
+
+CREATE TABLE IF NOT EXISTS messages (
+	message_id         TEXT NOT NULL,
+	conversation_id    TEXT NOT NULL REFERENCES conversations (conversation_id) ON DELETE CASCADE,
+	parent_message_id  TEXT NOT NULL DEFAULT '',
+	sender             TEXT NOT NULL DEFAULT '',
+	text               TEXT NOT NULL DEFAULT '',
+	is_created_by_user INTEGER NOT NULL DEFAULT 0,
+	endpoint           TEXT NOT NULL DEFAULT '',
+	model              TEXT NOT NULL DEFAULT '',
+	error              INTEGER NOT NULL DEFAULT 0,
+	created_at         INTEGER NOT NULL,
+	updated_at         INTEGER NOT NULL,
+	PRIMARY KEY (conversation_id, message_id)
+);
+CREATE INDEX IF NOT EXISTS messages_conversation_created ON messages (conversation_id, created_at);
+
+CREATE TABLE IF NOT EXISTS summaries (
+	conversation_id    TEXT PRIMARY KEY REFERENCES conversations (conversation_id) ON DELETE CASCADE,
+	through_message_id TEXT NOT NULL,
+	text               TEXT NOT NULL,
+	updated_at         INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS token_usage (
+	user_id           TEXT NOT NULL,
+	conversation_id   TEXT NOT NULL DEFAULT '',
+	message_id        TEXT NOT NULL DEFAULT '',
+	model             TEXT NOT NULL DEFAULT '',
+	prompt_tokens     INTEGER NOT NULL DEFAULT 0,
+	completion_tokens INTEGER NOT NULL DEFAULT 0,
+	created_at        INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS token_usage_user_created ON token_usage (user_id, created_at);
+`
+
+// SQL is a Repository backed by database/sql. The statements target SQLite,
+// which is embedded through the pure-Go modernc.org/sqlite driver.
+type SQL struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens (and migrates) an SQLite database. The DSN is passed to
+// the driver unchanged, e.g. "file:orchestrator.db?_pragma=foreign_keys(1)".
+func OpenSQLite(dsn string) (*SQL, error) {
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	// SQLite serialises writers; a single connection avoids SQLITE_BUSY and
+	// keeps ":memory:" databases from being split across connections.
+	db.SetMaxOpenConns(1)
+
+	repo, err := NewSQL(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return repo, nil
+}
+
+func NewSQL(db *sql.DB) (*SQL, error) {
+	if _, err := db.Exec(`PRAGMA foreign_keys = ON`); err != nil {
+		return nil, fmt.Errorf("enable foreign keys: %w", err)
+	}
+	if _, err := db.Exec(sqlSchema); err != nil {
+		return nil, fmt.Errorf("migrate schema: %w", err)
+	}
+	return &SQL{db: db}, nil
+}
+
+func (s *SQL) SaveConversation(ctx context.Context, conv *Conversation) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now().UTC()
+	var owner string
+	var created int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id, created_at FROM conversations WHERE conversation_id = ?`,
+		conv.ConversationID,
+	).Scan(&owner, &created)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if conv.CreatedAt.IsZero() {
+			conv.CreatedAt = now
+		}
+	case err != nil:
+		return err
+	case owner != conv.UserID:
+		return ErrForbidden
+	default:
+		conv.CreatedAt = time.Unix(0, created).UTC()
+	}
+	conv.UpdatedAt = now
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO conversations (conversation_id, user_id, title, endpoint, endpoint_type, model, prompt_prefix, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (conversation_id) DO UPDATE SET
+			title = excluded.title,
+			endpoint = excluded.endpoint,
+			endpoint_type = excluded.endpoint_type,
+			model = excluded.model,
+			prompt_prefix = excluded.prompt_prefix,
+			updated_at = excluded.updated_at`,
+		conv.ConversationID, conv.UserID, conv.Title, conv.Endpoint, conv.EndpointType,
+		conv.Model, conv.PromptPrefix, conv.CreatedAt.UnixNano(), conv.UpdatedAt.UnixNano(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const conversationColumns = `conversation_id, user_id, title, endpoint, endpoint_type, model, prompt_prefix, created_at, updated_at`
+
+func (s *SQL) GetConversation(ctx context.Context, userID, conversationID string) (*Conversation, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+conversationColumns+` FROM conversations WHERE conversation_id = ? AND user_id = ?`,
+		conversationID, userID,
+	)
+	conv, err := scanConversation(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
+
+func (s *SQL) ListConversations(ctx context.Context, userID string, opts ListOptions) ([]Conversation, string, error) {
+	nanos, id, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	limit := normalizeLimit(opts.Limit)
+
+	query := `SELECT ` + conversationColumns + ` FROM conversations WHERE user_id = ?`
+	args := []any{userID}
+	if opts.Cursor != "" {
+		query += ` AND (updated_at < ? OR (updated_at = ? AND conversation_id < ?))`
+		args = append(args, nanos, nanos, id)
+	}
+	query += ` ORDER BY updated_at DESC, conversation_id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var convs []Conversation
+	for rows.Next() {
+		conv, err := scanConversation(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		convs = append(convs, conv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(convs) <= limit {
+		return convs, "", nil
+	}
+	convs = convs[:limit]
+	return convs, encodeCursor(convs[limit-1]), nil
+}
+
+func (s *SQL) DeleteConversation(ctx context.Context, userID, conversationID string) error {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM conversations WHERE conversation_id = ? AND user_id = ?`,
+		conversationID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQL) SaveMessage(ctx context.Context, msg *Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists int
+	err = tx.QueryRowContext(ctx,
+		`SELECT 1 FROM conversations WHERE conversation_id = ?`, msg.ConversationID,
+	).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	var created int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT created_at FROM messages WHERE conversation_id = ? AND message_id = ?`,
+		msg.ConversationID, msg.MessageID,
+	).Scan(&created)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if msg.CreatedAt.IsZero() {
+			msg.CreatedAt = now
+		}
+	case err != nil:
+		return err
+	default:
+		msg.CreatedAt = time.Unix(0, created).UTC()
+	}
+	msg.UpdatedAt = now
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO messages (message_id, conversation_id, parent_message_id, sender, text, is_created_by_user, endpoint, model, error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (conversation_id, message_id) DO UPDATE SET
+			parent_message_id = excluded.parent_message_id,
+			sender = excluded.sender,
+			text = excluded.text,
+			is_created_by_user = excluded.is_created_by_user,
+			endpoint = excluded.endpoint,
+			model = excluded.model,
+			error = excluded.error,
+			updated_at = excluded.updated_at`,
+		msg.MessageID, msg.ConversationID, msg.ParentMessageID, msg.Sender, msg.Text,
+		msg.IsCreatedByUser, msg.Endpoint, msg.Model, msg.Error,
+		msg.CreatedAt.UnixNano(), msg.UpdatedAt.UnixNano(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const messageColumns = `message_id, conversation_id, parent_message_id, sender, text, is_created_by_user, endpoint, model, error, created_at, updated_at`
+
+func (s *SQL) GetMessage(ctx context.Context, conversationID, messageID string) (*Message, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+messageColumns+` FROM messages WHERE conversation_id = ? AND message_id = ?`,
+		conversationID, messageID,
+	)
+	msg, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *SQL) ListMessages(ctx context.Context, conversationID string) ([]Message, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+messageColumns+` FROM messages WHERE conversation_id = ? ORDER BY created_at, rowid`,
+		conversationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func (s *SQL) SaveSummary(ctx context.Context, summary *Summary) error {
+	summary.UpdatedAt = time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO summaries (conversation_id, through_message_id, text, updated_at)
+		SELECT conversation_id, ?, ?, ? FROM conversations WHERE conversation_id = ?
+		ON CONFLICT (conversation_id) DO UPDATE SET
+			through_message_id = excluded.through_message_id,
+			text = excluded.text,
+			updated_at = excluded.updated_at`,
+		summary.ThroughMessageID, summary.Text, summary.UpdatedAt.UnixNano(), summary.ConversationID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQL) GetSummary(ctx context.Context, conversationID string) (*Summary, error) {
+	summary := Summary{ConversationID: conversationID}
+	var updated int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT through_message_id, text, updated_at FROM summaries WHERE conversation_id = ?`,
+		conversationID,
+	).Scan(&summary.ThroughMessageID, &summary.Text, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	summary.UpdatedAt = time.Unix(0, updated).UTC()
+	return &summary, nil
+}
+
+func (s *SQL) RecordUsage(ctx context.Context, usage *Usage) error {
+	if usage.CreatedAt.IsZero() {
+		usage.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO token_usage (user_id, conversation_id, message_id, model, prompt_tokens, completion_tokens, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		usage.UserID, usage.ConversationID, usage.MessageID, usage.Model,
+		usage.PromptTokens, usage.CompletionTokens, usage.CreatedAt.UnixNano(),
+	)
+	return err
+}
+
+func (s *SQL) SumUsage(ctx context.Context, userID string, since time.Time) (UsageTotals, error) {
+	var totals UsageTotals
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0)
+		FROM token_usage WHERE user_id = ? AND created_at >= ?`,
+		userID, since.UnixNano(),
+	).Scan(&totals.PromptTokens, &totals.CompletionTokens)
+	return totals, err
+}
+
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanConversation(row rowScanner) (Conversation, error) {
+	var conv Conversation
+	var created, updated int64
+	err := row.Scan(&conv.ConversationID, &conv.UserID, &conv.Title, &conv.Endpoint, &conv.EndpointType,
+		&conv.Model, &conv.PromptPrefix, &created, &updated)
+	if err != nil {
+		return Conversation{}, err
+	}
+	conv.CreatedAt = time.Unix(0, created).UTC()
+	conv.UpdatedAt = time.Unix(0, updated).UTC()
+	return conv, nil
+}
+
+func scanMessage(row rowScanner) (Message, error) {
+	var msg Message
+	var created, updated int64
+	err := row.Scan(&msg.MessageID, &msg.ConversationID, &msg.ParentMessageID, &msg.Sender, &msg.Text,
+		&msg.IsCreatedByUser, &msg.Endpoint, &msg.Model, &msg.Error, &created, &updated)
+	if err != nil {
+		return Message{}, err
+	}
+	msg.CreatedAt = time.Unix(0, created).UTC()
+	msg.UpdatedAt = time.Unix(0, updated).UTC()
+	return msg, nil
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopmindai/orchestrator/internal/config"
+)
+
+var (
+	ErrNotFound  = errors.New("store: not found")
+	ErrForbidden = errors.New("store: conversation belongs to another user")
+)
+
+const defaultListLimit = 25
+
+type Conversation struct {
+	ConversationID string    `json:"conversationId"`
+	UserID         string    `json:"user,omitempty"`
+	Title          string    `json:"title"`
+	Endpoint       string    `json:"endpoint"`
+	EndpointType   string    `json:"endpointType,omitempty"`
+	Model          string    `json:"model,omitempty"`
+	PromptPrefix   string    `json:"promptPrefix,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+type Message struct {
+	MessageID       string    `json:"messageId"`
+	ConversationID  string    `json:"conversationId"`
+	ParentMessageID string    `json:"parentMessageId"`
+	Sender          string    `json:"sender"`
+	Text            string    `json:"text"`
+	IsCreatedByUser bool      `json:"isCreatedByUser"`
+	Endpoint        string    `json:"endpoint,omitempty"`
+	Model           string    `json:"model,omitempty"`
+	Error           bool      `json:"error"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// Usage is the token usage of one generation. It outlives the conversation
+// it belongs to so deleting conversations does not reset a user's budget.
+type Usage struct {
+	UserID           string    `json:"user,omitempty"`
+	ConversationID   string    `json:"conversationId"`
+	MessageID        string    `json:"messageId"`
+	Model            string    `json:"model,omitempty"`
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// Summary is the running summary of a conversation's oldest messages, which
+// stand in for them once the history outgrows the model's context window.
+type Summary struct {
+	ConversationID string `json:"conversationId"`
+	// ThroughMessageID is the newest message the summary covers.
+	ThroughMessageID string    `json:"throughMessageId"`
+	Text             string    `json:"text"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// UsageTotals sums usage records.
+type UsageTotals struct {
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+}
+
+func (t UsageTotals) Total() int {
+	return t.PromptTokens + t.CompletionTokens
+}
+
+type ListOptions struct {
+	Limit  int
+	Cursor string
+}
+
+// Repository persists conversations and their messages. Conversations are
+// scoped by user ID; an empty user ID is used when authentication is disabled.
+type Repository interface {
+	// SaveConversation creates or updates a conversation. CreatedAt is kept
+	// from the stored record on update.
+	SaveConversation(ctx context.Context, conv *Conversation) error
+	GetConversation(ctx context.Context, userID, conversationID string) (*Conversation, error)
+	// ListConversations returns the user's conversations, most recently
+	// updated first, and the cursor of the next page ("" on the last page).
+	ListConversations(ctx context.Context, userID string, opts ListOptions) ([]Conversation, string, error)
+	// DeleteConversation removes the conversation and all of its messages.
+	DeleteConversation(ctx context.Context, userID, conversationID string) error
+
+	// SaveMessage creates or updates a message of an existing conversation.
+	SaveMessage(ctx context.Context, msg *Message) error
+	GetMessage(ctx context.Context, conversationID, messageID string) (*Message, error)
+	// ListMessages returns the conversation's messages in creation order.
+	ListMessages(ctx context.Context, conversationID string) ([]Message, error)
+
+	// SaveSummary replaces the conversation's summary. Summaries are deleted
+	// with their conversation.
+	SaveSummary(ctx context.Context, summary *Summary) error
+	GetSummary(ctx context.Context, conversationID string) (*Summary, error)
+
+	// RecordUsage appends a usage record.
+	RecordUsage(ctx context.Context, usage *Usage) error
+	// SumUsage totals the user's usage recorded at or after since.
+	SumUsage(ctx context.Context, userID string, since time.Time) (UsageTotals, error)
+
+	Close() error
+}
+
+// Open builds the repository selected by cfg.Driver.
+func Open(cfg config.StoreConfig) (Repository, error) {
+	switch strings.ToLower(cfg.Driver) {
+	case "", "memory":
+		return NewMemory(), nil
+	case "sqlite":
+		return OpenSQLite(cfg.DSN)
+	default:
+		return nil, fmt.Errorf("unsupported store driver %q", cfg.Driver)
+	}
+}
+
+func normalizeLimit(limit int) int {
+	if limit <= 0 || limit > 100 {
+		return defaultListLimit
+	}
+	return limit
+}
+
+// Cursors encode the sort key (updatedAt, conversationId) of the last item
+// returned so paging is stable while conversations keep being updated.
+func encodeCursor(conv Conversation) string {
+	return strconv.FormatInt(conv.UpdatedAt.UnixNano(), 10) + "_" + conv.ConversationID
+}
+
+func decodeCursor(cursor string) (int64, string, error) {
+	if cursor == "" {
+		return 0, "", nil
+	}
+	ts, id, ok := strings.Cut(cursor, "_")
+	if !ok {
+		return 0, "", fmt.Errorf("invalid cursor %q", cursor)
+	}
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return nanos, id, nil
+}
+
+func before(conv Conversation, nanos int64, id string) bool {
+	updated := conv.UpdatedAt.UnixNano()
+	if updated != nanos {
+		return updated < nanos
+	}
+	return conv.ConversationID < id
+}
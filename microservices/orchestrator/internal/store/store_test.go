@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRepositories(t *testing.T) {
+	repos := map[string]func(t *testing.T) Repository{
+		"memory": func(*testing.T) Repository { return NewMemory() },
+		"sqlite": func(t *testing.T) Repository {
+			repo, err := OpenSQLite("file::memory:")
+			if err != nil {
+				t.Fatalf("open sqlite: %v", err)
+			}
+			return repo
+		},
+	}
+
+	for name, open := range repos {
+		t.Run(name, func(t *testing.T) {
+			repo := open(t)
+			defer repo.Close()
+			testRepository(t, repo)
+		})
+	}
+}
+
+func testRepository(t *testing.T, repo Repository) {
+	ctx := context.Background()
+
+	conv := &Conversation{ConversationID: "c1", UserID: "alice", Endpoint: "agents", Model: "gpt"}
+	if err := repo.SaveConversation(ctx, conv); err != nil {
+		t.Fatalf("save conversation: %v", err)
+	}
+	created := conv.CreatedAt
+
+	conv.Title = "Running shoes"
+	if err := repo.SaveConversation(ctx, conv); err != nil {
+		t.Fatalf("update conversation: %v", err)
+	}
+	if !conv.CreatedAt.Equal(created) {
+		t.Errorf("createdAt changed on update: %v != %v", conv.CreatedAt, created)
+	}
+
+	if err := repo.SaveConversation(ctx, &Conversation{ConversationID: "c1", UserID: "mallory"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("saving another user's conversation: got %v, want ErrForbidden", err)
+	}
+	if _, err := repo.GetConversation(ctx, "mallory", "c1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("reading another user's conversation: got %v, want ErrNotFound", err)
+	}
+
+	got, err := repo.GetConversation(ctx, "alice", "c1")
+	if err != nil {
+		t.Fatalf("get conversation: %v", err)
+	}
+	if got.Title != "Running shoes" || got.Model != "gpt" {
+		t.Errorf("unexpected conversation %+v", got)
+	}
+
+	for _, msg := range []*Message{
+		{MessageID: "m1", ConversationID: "c1", ParentMessageID: "root", Sender: "User", Text: "hi", IsCreatedByUser: true},
+		{MessageID: "m2", ConversationID: "c1", ParentMessageID: "m1", Sender: "Assistant", Text: "hello"},
+	} {
+		if err := repo.SaveMessage(ctx, msg); err != nil {
+			t.Fatalf("save message %s: %v", msg.MessageID, err)
+		}
+	}
+	if err := repo.SaveMessage(ctx, &Message{MessageID: "m1", ConversationID: "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("saving message to missing conversation: got %v, want ErrNotFound", err)
+	}
+
+	msg, err := repo.GetMessage(ctx, "c1", "m2")
+	if err != nil {
+		t.Fatalf("get message: %v", err)
+	}
+	if msg.ParentMessageID != "m1" || msg.Text != "hello" || msg.IsCreatedByUser {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	messages, err := repo.ListMessages(ctx, "c1")
+	if err != nil {
+		t.Fatalf("list messages: %v", err)
+	}
+	if len(messages) != 2 || messages[0].MessageID != "m1" || messages[1].MessageID != "m2" {
+		t.Fatalf("unexpected messages %+v", messages)
+	}
+
+	if _, err := repo.GetSummary(ctx, "c1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("summary before any was saved: got %v, want ErrNotFound", err)
+	}
+	for _, text := range []string{"Greetings.", "Greetings exchanged."} {
+		if err := repo.SaveSummary(ctx, &Summary{ConversationID: "c1", ThroughMessageID: "m2", Text: text}); err != nil {
+			t.Fatalf("save summary: %v", err)
+		}
+	}
+	summary, err := repo.GetSummary(ctx, "c1")
+	if err != nil {
+		t.Fatalf("get summary: %v", err)
+	}
+	if summary.ThroughMessageID != "m2" || summary.Text != "Greetings exchanged." || summary.UpdatedAt.IsZero() {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if err := repo.SaveSummary(ctx, &Summary{ConversationID: "missing", ThroughMessageID: "m1"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("saving summary of missing conversation: got %v, want ErrNotFound", err)
+	}
+
+	for _, id := range []string{"c2", "c3"} {
+		if err := repo.SaveConversation(ctx, &Conversation{ConversationID: id, UserID: "alice"}); err != nil {
+			t.Fatalf("save conversation %s: %v", id, err)
+		}
+	}
+	page, next, err := repo.ListConversations(ctx, "alice", ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("list conversations: %v", err)
+	}
+	if len(page) != 2 || page[0].ConversationID != "c3" || next == "" {
+		t.Fatalf("unexpected first page %+v (next %q)", page, next)
+	}
+	page, next, err = repo.ListConversations(ctx, "alice", ListOptions{Limit: 2, Cursor: next})
+	if err != nil {
+		t.Fatalf("list second page: %v", err)
+	}
+	if len(page) != 1 || page[0].ConversationID != "c1" || next != "" {
+		t.Fatalf("unexpected second page %+v (next %q)", page, next)
+	}
+
+	if err := repo.DeleteConversation(ctx, "alice", "c1"); err != nil {
+		t.Fatalf("delete conversation: %v", err)
+	}
+	if _, err := repo.GetMessage(ctx, "c1", "m1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("messages survived conversation delete: %v", err)
+	}
+	if _, err := repo.GetSummary(ctx, "c1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("summary survived conversation delete: %v", err)
+	}
+	if err := repo.DeleteConversation(ctx, "alice", "c1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second delete: got %v, want ErrNotFound", err)
+	}
+
+	now := time.Now().UTC()
+	for _, u := range []Usage{
+		{UserID: "alice", ConversationID: "c1", PromptTokens: 100, CompletionTokens: 20, CreatedAt: now.Add(-48 * time.Hour)},
+		{UserID: "alice", ConversationID: "c1", PromptTokens: 30, CompletionTokens: 5, CreatedAt: now},
+		{UserID: "mallory", ConversationID: "c9", PromptTokens: 1000, CreatedAt: now},
+	} {
+		if err := repo.RecordUsage(ctx, &u); err != nil {
+			t.Fatalf("record usage: %v", err)
+		}
+	}
+	totals, err := repo.SumUsage(ctx, "alice", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("sum usage: %v", err)
+	}
+	if totals != (UsageTotals{PromptTokens: 30, CompletionTokens: 5}) {
+		t.Errorf("unexpected recent usage %+v", totals)
+	}
+	if totals, _ = repo.SumUsage(ctx, "alice", now.AddDate(0, 0, -7)); totals.Total() != 155 {
+		t.Errorf("usage of deleted conversations must still count, got %+v", totals)
+	}
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// maxThreadDepth bounds the parent walk so a corrupted parent chain cannot
+// loop forever.
+const maxThreadDepth = 10000
+
+// Thread returns the branch ending at leafID, ordered from the root message
+// down to the leaf, by following ParentMessageID links. It returns
+// ErrNotFound when the leaf is not part of the conversation.
+func Thread(ctx context.Context, repo Repository, conversationID, leafID string) ([]Message, error) {
+	var reversed []Message
+	seen := make(map[string]bool)
+
+	for id := leafID; id != ""; {
+		if seen[id] {
+			return nil, fmt.Errorf("message %s: parent cycle detected", id)
+		}
+		if len(reversed) >= maxThreadDepth {
+			return nil, fmt.Errorf("message %s: thread deeper than %d messages", leafID, maxThreadDepth)
+		}
+		seen[id] = true
+
+		msg, err := repo.GetMessage(ctx, conversationID, id)
+		if err != nil {
+			if len(reversed) > 0 && errors.Is(err, ErrNotFound) {
+				// Reached the synthetic root (or a parent that was never
+				// stored); the branch starts here.
+				break
+			}
+			return nil, err
+		}
+		reversed = append(reversed, *msg)
+		id = msg.ParentMessageID
+	}
+
+	thread := make([]Message, len(reversed))
+	for i, msg := range reversed {
+		thread[len(reversed)-1-i] = msg
+	}
+	return thread, nil
+}
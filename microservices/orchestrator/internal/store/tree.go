@@ -0,0 +1,42 @@
+package store
+
+// TreeNode is a message with its replies. Siblings are messages sharing a
+// parent (regenerated responses or edited prompts), ordered by creation.
+type TreeNode struct {
+	Message
+	SiblingIndex int         `json:"siblingIndex"`
+	SiblingCount int         `json:"siblingCount"`
+	Children     []*TreeNode `json:"children"`
+}
+
+// BuildTree arranges a conversation's messages, in creation order, into a
+// forest. Messages whose parent is not part of the conversation are roots.
+func BuildTree(messages []Message) []*TreeNode {
+	nodes := make(map[string]*TreeNode, len(messages))
+	for _, msg := range messages {
+		nodes[msg.MessageID] = &TreeNode{Message: msg, Children: []*TreeNode{}}
+	}
+
+	roots := []*TreeNode{}
+	for _, msg := range messages {
+		node := nodes[msg.MessageID]
+		if parent, ok := nodes[msg.ParentMessageID]; ok && parent != node {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	indexSiblings(roots)
+	for _, node := range nodes {
+		indexSiblings(node.Children)
+	}
+	return roots
+}
+
+func indexSiblings(siblings []*TreeNode) {
+	for i, node := range siblings {
+		node.SiblingIndex = i
+		node.SiblingCount = len(siblings)
+	}
+}
@@ -0,0 +1,107 @@
+// Package tools holds the Go functions the agent chat lets the model call.
+// Their definitions are sent to llm-proxy with each request; calls the model
+// makes are run here and their results fed back as "tool" messages.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Definition is what the model sees of a tool. Parameters is a JSON Schema
+// object describing the arguments.
+type Definition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// Tool is a function the model may call. Run receives the arguments as the
+// model produced them and returns a JSON-encodable result; errors are
+// reported back to the model rather than failing the chat.
+type Tool struct {
+	Definition
+	Run func(ctx context.Context, arguments json.RawMessage) (any, error)
+}
+
+// ErrUnknownTool is returned for calls to tools that are not registered.
+var ErrUnknownTool = errors.New("unknown tool")
+
+// validName matches the tool names every supported provider accepts.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Registry is a concurrency-safe set of tools keyed by name.
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{tools: make(map[string]Tool)}
+}
+
+// Register adds a tool, replacing any tool of the same name.
+func (r *Registry) Register(tool Tool) error {
+	if !validName.MatchString(tool.Name) {
+		return fmt.Errorf("invalid tool name %q", tool.Name)
+	}
+	if tool.Run == nil {
+		return fmt.Errorf("tool %q has no Run function", tool.Name)
+	}
+	if len(tool.Parameters) > 0 && !json.Valid(tool.Parameters) {
+		return fmt.Errorf("tool %q has an invalid parameter schema", tool.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tools[tool.Name] = tool
+	return nil
+}
+
+// Definitions returns the registered tools sorted by name. A nil registry
+// has no tools.
+func (r *Registry) Definitions() []Definition {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	defs := make([]Definition, 0, len(r.tools))
+	for _, tool := range r.tools {
+		defs = append(defs, tool.Definition)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// Call runs the named tool and returns its result encoded as JSON.
+// Missing arguments are passed as an empty object.
+func (r *Registry) Call(ctx context.Context, name, arguments string) (string, error) {
+	r.mu.RLock()
+	tool, ok := r.tools[name]
+	r.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownTool, name)
+	}
+
+	args := json.RawMessage(arguments)
+	if len(args) == 0 {
+		args = json.RawMessage(`{}`)
+	}
+	if !json.Valid(args) {
+		return "", fmt.Errorf("tool %q: arguments are not valid JSON", name)
+	}
+	result, err := tool.Run(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("tool %q: encode result: %w", name, err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,72 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestRegistryCall(t *testing.T) {
+	reg := NewRegistry()
+	err := reg.Register(Tool{
+		Definition: Definition{Name: "echo", Description: "Echoes its input"},
+		Run: func(ctx context.Context, arguments json.RawMessage) (any, error) {
+			var args struct {
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return nil, err
+			}
+			if args.Text == "" {
+				return nil, errors.New("text is required")
+			}
+			return map[string]string{"echo": args.Text}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	got, err := reg.Call(context.Background(), "echo", `{"text":"hi"}`)
+	if err != nil || got != `{"echo":"hi"}` {
+		t.Errorf("call = %q, %v", got, err)
+	}
+	if _, err := reg.Call(context.Background(), "echo", ""); err == nil || err.Error() != "text is required" {
+		t.Errorf("expected the tool's error for empty arguments, got %v", err)
+	}
+	if _, err := reg.Call(context.Background(), "echo", `{"text":`); err == nil {
+		t.Error("expected an error for invalid arguments")
+	}
+	if _, err := reg.Call(context.Background(), "search", `{}`); !errors.Is(err, ErrUnknownTool) {
+		t.Errorf("expected ErrUnknownTool, got %v", err)
+	}
+}
+
+func TestRegistryRegister(t *testing.T) {
+	reg := NewRegistry()
+	run := func(context.Context, json.RawMessage) (any, error) { return nil, nil }
+
+	for _, tool := range []Tool{
+		{Definition: Definition{Name: "has space"}, Run: run},
+		{Definition: Definition{Name: "no_run"}},
+		{Definition: Definition{Name: "bad_schema", Parameters: json.RawMessage(`{`)}, Run: run},
+	} {
+		if err := reg.Register(tool); err == nil {
+			t.Errorf("expected %q to be rejected", tool.Name)
+		}
+	}
+
+	for _, name := range []string{"search", "compare"} {
+		if err := reg.Register(Tool{Definition: Definition{Name: name}, Run: run}); err != nil {
+			t.Fatalf("register %s: %v", name, err)
+		}
+	}
+	defs := reg.Definitions()
+	if len(defs) != 2 || defs[0].Name != "compare" || defs[1].Name != "search" {
+		t.Errorf("unexpected definitions %+v", defs)
+	}
+	if (*Registry)(nil).Definitions() != nil {
+		t.Error("nil registry should have no definitions")
+	}
+}